@@ -0,0 +1,113 @@
+package indigo
+
+import (
+	"context"
+)
+
+// ChildrenKey is the name under which the outcomes of a rule's child rules are
+// available to the rule's expression, so that a rule can express policies such as
+// "at least two of the following", as in `children.passed_count >= 2`.
+//
+// If the expression of a rule with child rules refers to ChildrenKey, the child rules
+// in Rules are evaluated before the rule's expression, which is given a map with:
+//
+//     count         the number of child rules evaluated (int)
+//     passed_count  the number of child rules that passed (int)
+//     failed_count  the number of child rules that failed, or could not be evaluated (int)
+//     passed        whether each child rule passed, by rule ID (map of string to bool)
+//
+// Child rules that do not apply (see Rule.Guard) are counted, but neither passed nor
+// failed. ChildrenKey is available to the rule's expression only, not its guard, and
+// the StopIfParentNegative option does not apply to the rule, since its children are
+// evaluated first. The engine's evaluator must implement ExpressionAnalyzer, and the
+// rule's schema must not declare ChildrenKey.
+const ChildrenKey = "children"
+
+// childOutcome is the output of evaluating a child rule before its parent's expression
+type childOutcome struct {
+	result *Result
+	err    error
+}
+
+// aggregates reports whether the expression of the rule r, compiled with the schema
+// exprSchema, refers to the outcomes of its children. s is the rule's own schema.
+func (e *DefaultEngine) aggregates(r *Rule, s, exprSchema Schema) bool {
+	a, ok := e.e.(ExpressionAnalyzer)
+	if _, declared := s.element(ChildrenKey); !ok || declared || len(r.Rules) == 0 || r.Expr == "" {
+		return false
+	}
+
+	info, err := a.Analyze(r.Expr, exprSchema, defaultResultType(r))
+	if err != nil {
+		return false
+	}
+	return contains(info.ReferencedVariables, ChildrenKey)
+}
+
+// childrenSchema returns s, with ChildrenKey declared if the rule has child rules
+// and s does not declare it
+func childrenSchema(r *Rule, s Schema) Schema {
+	if _, ok := s.element(ChildrenKey); ok || len(r.Rules) == 0 {
+		return s
+	}
+
+	s.Elements = append(append([]DataElement{}, s.Elements...),
+		DataElement{Name: ChildrenKey, Type: Map{KeyType: String{}, ValueType: Any{}}})
+	return s
+}
+
+// evalChildrenFirst evaluates the child rules of r selected for the data d, before
+// the rule's expression, returning the outcome of each child, and the summary of
+// the outcomes available to the expression under ChildrenKey
+func (e *DefaultEngine) evalChildrenFirst(ctx context.Context, r *Rule, d map[string]interface{}, depth int,
+	c *exprCache, childIn *EvalOptions, o EvalOptions, opts ...EvalOption) (map[*Rule]childOutcome, map[string]interface{}, error) {
+
+	children := r.selectChildren(d, o)
+	switch {
+	case o.MaxDepth > 0 && depth >= o.MaxDepth:
+		// The rule is truncated, or fails, after its expression is evaluated
+		children = nil
+	case r.Experiment != nil:
+		children = nil
+		if cr, ok := r.Rules[r.Experiment.variant(r, d)]; ok {
+			children = []*Rule{cr}
+		}
+	}
+
+	outcomes := make(map[*Rule]childOutcome, len(children))
+	passed := make(map[string]bool, len(children))
+	var count, passedCount, failedCount int
+	for _, cr := range children {
+		if cr == nil || !cr.active(d, o) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		result, err := e.eval(ctx, cr, d, depth+1, c, childIn, opts...)
+		if err != nil && (!o.ContinueOnError || ctx.Err() != nil) {
+			return nil, nil, err
+		}
+		outcomes[cr] = childOutcome{result: result, err: err}
+
+		count++
+		switch {
+		case err != nil:
+			failedCount++
+		case result.Pass:
+			passedCount++
+		case result.Status != StatusNotApplicable:
+			failedCount++
+		}
+		passed[cr.ID] = err == nil && result.Pass
+	}
+
+	summary := map[string]interface{}{
+		"count":        count,
+		"passed_count": passedCount,
+		"failed_count": failedCount,
+		"passed":       passed,
+	}
+	return outcomes, summary, nil
+}
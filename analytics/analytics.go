@@ -0,0 +1,346 @@
+// Package analytics accumulates statistics of rule evaluations, so that product
+// owners can see how often each rule fires: for each rule, the number of
+// evaluations, passes (hits) and errors, the distribution of verdicts, and the
+// latency, over consecutive time windows.
+//
+// The Aggregator collects the statistics as engine middleware:
+//
+//     a := analytics.NewAggregator(analytics.Interval(time.Minute))
+//     e.Use(a.Middleware())
+//     http.Handle("/metrics", a.Handler())
+//
+// The handler serves the cumulative statistics in the Prometheus text format, to be
+// scraped by Prometheus and charted in Grafana, or the statistics of each window
+// as JSON. To push the statistics elsewhere instead, such as to a time series
+// database, use the OnWindow option.
+//
+// The latency of the root rule is measured for each evaluation. The latency of
+// child rules is only known for evaluations that return timing (see
+// indigo.ReturnTiming).
+//
+// The Monitor raises alerts when the pass rate of a rule shifts from its baseline.
+package analytics
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ezachrisen/indigo"
+)
+
+// LatencyBuckets are the upper bounds of the latency histogram buckets.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// NoVerdict is the verdict of a rule that neither passed nor has a descendant that
+// passed with a severity, in RuleStats.Verdicts.
+const NoVerdict = "none"
+
+// Window holds the statistics of the evaluations in a time window.
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// The statistics of each rule evaluated in the window, by rule ID
+	Rules map[string]*RuleStats `json:"rules"`
+}
+
+// RuleStats are the statistics of the evaluations of a rule.
+type RuleStats struct {
+	Evaluations int `json:"evaluations"`
+	Passes      int `json:"passes"`
+	Errors      int `json:"errors"`
+
+	// The number of evaluations by verdict (see indigo.Result.Verdict), or by
+	// NoVerdict
+	Verdicts map[string]int `json:"verdicts"`
+
+	Latency Latency `json:"latency"`
+}
+
+// HitRate returns the fraction of the evaluations that passed.
+func (s *RuleStats) HitRate() float64 {
+	if s.Evaluations == 0 {
+		return 0
+	}
+	return float64(s.Passes) / float64(s.Evaluations)
+}
+
+// Latency is a histogram of the time taken by evaluations.
+type Latency struct {
+	// The number of evaluations timed, and their total and longest duration
+	Count int           `json:"count"`
+	Sum   time.Duration `json:"sum"`
+	Max   time.Duration `json:"max"`
+
+	// The number of evaluations that took at most each of LatencyBuckets, and
+	// not as long as the previous bucket, followed by the number that took longer
+	// than all the buckets
+	Buckets []int `json:"buckets"`
+}
+
+// Mean returns the mean duration of the evaluations timed.
+func (l *Latency) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Sum / time.Duration(l.Count)
+}
+
+// Aggregator accumulates the statistics of rule evaluations.
+// Aggregator is safe for concurrent use.
+type Aggregator struct {
+	mu       sync.Mutex
+	interval time.Duration
+	retain   int
+	now      func() time.Time
+	onWindow func(Window)
+
+	current *Window
+	windows []Window // the completed windows, oldest first
+	totals  map[string]*RuleStats
+}
+
+// Option is a functional option to specify the behavior of an aggregator.
+type Option func(a *Aggregator)
+
+// Interval specifies the length of the windows. Windows are aligned to
+// multiples of the interval.
+// Default: 1 minute
+func Interval(d time.Duration) Option {
+	return func(a *Aggregator) {
+		a.interval = d
+	}
+}
+
+// Retain specifies the number of completed windows kept.
+// Default: 60
+func Retain(n int) Option {
+	return func(a *Aggregator) {
+		a.retain = n
+	}
+}
+
+// Clock specifies the function used to obtain the current time.
+// Default: time.Now
+func Clock(now func() time.Time) Option {
+	return func(a *Aggregator) {
+		a.now = now
+	}
+}
+
+// OnWindow specifies a function called with the statistics of each window when
+// it is complete, to push them to a monitoring system. A window is completed
+// by the first evaluation, or call to Windows, after its end. The function is
+// called synchronously, and must not call the aggregator.
+func OnWindow(f func(Window)) Option {
+	return func(a *Aggregator) {
+		a.onWindow = f
+	}
+}
+
+// NewAggregator returns an aggregator with no statistics.
+func NewAggregator(opts ...Option) *Aggregator {
+	a := &Aggregator{
+		interval: time.Minute,
+		retain:   60,
+		now:      time.Now,
+		totals:   map[string]*RuleStats{},
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+	return a
+}
+
+// Middleware returns middleware (see indigo.DefaultEngine.Use) that records the
+// statistics of each evaluation in the aggregator.
+func (a *Aggregator) Middleware() indigo.Middleware {
+	return func(next indigo.Evaluator) indigo.Evaluator {
+		return indigo.EvaluatorFunc(func(ctx context.Context, r *indigo.Rule, d map[string]interface{}, opts ...indigo.EvalOption) (*indigo.Result, error) {
+			start := time.Now()
+			u, err := next.Eval(ctx, r, d, opts...)
+			a.Record(r, u, err, time.Since(start))
+			return u, err
+		})
+	}
+}
+
+// Record records the result u of evaluating the rule r, or the error err, which
+// took the duration d. Use Record when evaluations are not made with an engine
+// using the aggregator's middleware.
+func (a *Aggregator) Record(r *indigo.Rule, u *indigo.Result, err error, d time.Duration) {
+	a.mu.Lock()
+	done := a.rotate()
+	if err != nil || u == nil {
+		a.add(r.ID, func(s *RuleStats) {
+			s.Evaluations++
+			s.Errors++
+			s.Latency.add(d)
+		})
+	} else {
+		a.addResult(u, d)
+	}
+	a.mu.Unlock()
+	a.completed(done)
+}
+
+// addResult adds the result u and its children to the statistics; d is the
+// duration of the evaluation of u, if known
+func (a *Aggregator) addResult(u *indigo.Result, d time.Duration) {
+	if u.Skipped || u.Status == indigo.StatusSkipped || u.Status == indigo.StatusNotApplicable {
+		return
+	}
+	if d == 0 {
+		d = u.Duration
+	}
+
+	a.add(u.Rule.ID, func(s *RuleStats) {
+		s.Evaluations++
+		if u.Pass {
+			s.Passes++
+		}
+		if u.Status == indigo.StatusError {
+			s.Errors++
+		}
+		v := string(u.Verdict)
+		if v == "" {
+			v = NoVerdict
+		}
+		s.Verdicts[v]++
+		if d > 0 {
+			s.Latency.add(d)
+		}
+	})
+
+	for _, c := range u.OrderedResults {
+		a.addResult(c, 0)
+	}
+}
+
+// add updates the statistics of the rule with the id in the current window and
+// in the totals
+func (a *Aggregator) add(id string, f func(s *RuleStats)) {
+	for _, m := range []map[string]*RuleStats{a.current.Rules, a.totals} {
+		s, ok := m[id]
+		if !ok {
+			s = newRuleStats()
+			m[id] = s
+		}
+		f(s)
+	}
+}
+
+// rotate completes the current window if it has ended, and starts the window
+// of the current time, returning the windows completed
+func (a *Aggregator) rotate() []Window {
+	now := a.now()
+	if a.current != nil && now.Before(a.current.End) {
+		return nil
+	}
+
+	var done []Window
+	if a.current != nil {
+		done = append(done, *a.current)
+		a.windows = append(a.windows, *a.current)
+		if len(a.windows) > a.retain {
+			a.windows = a.windows[len(a.windows)-a.retain:]
+		}
+	}
+
+	start := now.Truncate(a.interval)
+	a.current = &Window{Start: start, End: start.Add(a.interval), Rules: map[string]*RuleStats{}}
+	return done
+}
+
+// completed calls the OnWindow function with the windows completed
+func (a *Aggregator) completed(done []Window) {
+	if a.onWindow == nil {
+		return
+	}
+	for _, w := range done {
+		a.onWindow(w.clone())
+	}
+}
+
+// Windows returns the statistics of the completed windows kept, oldest first,
+// followed by the statistics of the current window so far.
+func (a *Aggregator) Windows() []Window {
+	a.mu.Lock()
+	done := a.rotate()
+	ws := make([]Window, 0, len(a.windows)+1)
+	for _, w := range a.windows {
+		ws = append(ws, w.clone())
+	}
+	ws = append(ws, a.current.clone())
+	a.mu.Unlock()
+
+	a.completed(done)
+	return ws
+}
+
+// Totals returns the statistics of all the evaluations recorded, by rule ID.
+func (a *Aggregator) Totals() map[string]*RuleStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return cloneStats(a.totals)
+}
+
+// ruleIDs returns the IDs of the rules in the statistics, sorted
+func ruleIDs(m map[string]*RuleStats) []string {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func newRuleStats() *RuleStats {
+	return &RuleStats{
+		Verdicts: map[string]int{},
+		Latency:  Latency{Buckets: make([]int, len(LatencyBuckets)+1)},
+	}
+}
+
+// add adds an evaluation that took the duration d to the histogram
+func (l *Latency) add(d time.Duration) {
+	l.Count++
+	l.Sum += d
+	if d > l.Max {
+		l.Max = d
+	}
+	i := sort.Search(len(LatencyBuckets), func(i int) bool { return d <= LatencyBuckets[i] })
+	l.Buckets[i]++
+}
+
+func (w Window) clone() Window {
+	w.Rules = cloneStats(w.Rules)
+	return w
+}
+
+func cloneStats(m map[string]*RuleStats) map[string]*RuleStats {
+	c := make(map[string]*RuleStats, len(m))
+	for id, s := range m {
+		x := *s
+		x.Verdicts = make(map[string]int, len(s.Verdicts))
+		for v, n := range s.Verdicts {
+			x.Verdicts[v] = n
+		}
+		x.Latency.Buckets = append([]int(nil), s.Latency.Buckets...)
+		c[id] = &x
+	}
+	return c
+}
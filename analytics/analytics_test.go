@@ -0,0 +1,135 @@
+package analytics_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/analytics"
+	"github.com/ezachrisen/indigo/cel"
+	"github.com/matryer/is"
+)
+
+func TestAggregator(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{{Name: "amount", Type: indigo.Int{}}},
+	}
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"large": {ID: "large", Schema: schema, Expr: "amount > 1000", Metadata: indigo.RuleMetadata{Severity: indigo.SeverityDeny}},
+			"ratio": {ID: "ratio", Schema: schema, Expr: "100 / amount > 1"},
+		},
+	}
+
+	now := time.Date(2021, 1, 1, 10, 0, 30, 0, time.UTC)
+	var pushed []analytics.Window
+	a := analytics.NewAggregator(
+		analytics.Clock(func() time.Time { return now }),
+		analytics.OnWindow(func(w analytics.Window) { pushed = append(pushed, w) }))
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	e.Use(a.Middleware())
+	is.NoErr(e.Compile(r))
+
+	for _, amount := range []int{5000, 5, 2000} {
+		_, err := e.Eval(context.Background(), r, map[string]interface{}{"amount": amount})
+		is.NoErr(err)
+	}
+	_, err := e.Eval(context.Background(), r, map[string]interface{}{"amount": 0})
+	is.True(err != nil)
+
+	now = now.Add(time.Minute)
+	_, err = e.Eval(context.Background(), r, map[string]interface{}{"amount": 5000}, indigo.ReturnTiming(true))
+	is.NoErr(err)
+
+	// The first window is complete
+	is.Equal(len(pushed), 1)
+	w := pushed[0]
+	is.Equal(w.Start, time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+	is.Equal(w.Rules["root"].Evaluations, 4)
+	is.Equal(w.Rules["root"].Errors, 1)
+	is.Equal(w.Rules["root"].Latency.Count, 4)
+	is.Equal(w.Rules["large"].Evaluations, 3)
+	is.Equal(w.Rules["large"].Passes, 2)
+	is.Equal(w.Rules["large"].Verdicts, map[string]int{"deny": 2, analytics.NoVerdict: 1})
+	is.Equal(w.Rules["large"].Latency.Count, 0) // timing was not returned
+
+	ws := a.Windows()
+	is.Equal(len(ws), 2)
+	is.Equal(ws[1].Rules["large"].HitRate(), 1.0)
+	is.Equal(ws[1].Rules["large"].Latency.Count, 1)
+
+	srv := httptest.NewServer(a.Handler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	is.NoErr(err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	is.NoErr(err)
+
+	for _, line := range []string{
+		`indigo_rule_evaluations_total{rule="large"} 4`,
+		`indigo_rule_passes_total{rule="large"} 3`,
+		`indigo_rule_errors_total{rule="root"} 1`,
+		`indigo_rule_verdicts_total{rule="large",verdict="deny"} 3`,
+		`indigo_rule_duration_seconds_count{rule="root"} 5`,
+		`indigo_rule_duration_seconds_bucket{rule="root",le="+Inf"} 5`,
+	} {
+		is.True(strings.Contains(string(b), line+"\n"))
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{{Name: "amount", Type: indigo.Int{}}},
+	}
+	v, err := indigo.NewVault(indigo.NewEngine(cel.NewEvaluator()), &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules:  map[string]*indigo.Rule{"large": {ID: "large", Schema: schema, Expr: "amount > 1000"}},
+	})
+	is.NoErr(err)
+
+	var alerts []analytics.Alert
+	m := analytics.NewMonitor(func(a analytics.Alert) { alerts = append(alerts, a) }, analytics.MinEvaluations(10))
+	m.Watch(v)
+
+	start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	window := func(i, evaluations, passes int) analytics.Window {
+		return analytics.Window{
+			Start: start.Add(time.Duration(i) * time.Minute),
+			End:   start.Add(time.Duration(i+1) * time.Minute),
+			Rules: map[string]*analytics.RuleStats{"large": {Evaluations: evaluations, Passes: passes}},
+		}
+	}
+
+	// Establish the baseline; the window with too few evaluations is ignored
+	m.Observe(window(0, 100, 10))
+	m.Observe(window(1, 100, 12))
+	m.Observe(window(2, 5, 5))
+	m.Observe(window(3, 100, 8))
+	is.Equal(len(alerts), 0)
+
+	is.NoErr(v.Replace(&indigo.Rule{ID: "large", Schema: schema, Expr: "amount > 10"}))
+	m.Observe(window(4, 100, 90))
+	is.Equal(len(alerts), 1)
+	is.Equal(alerts[0].RuleID, "large")
+	is.Equal(alerts[0].Rate, 0.9)
+	is.True(alerts[0].Baseline < 0.15)
+	is.Equal(len(alerts[0].Changes), 1)
+	is.Equal(alerts[0].Changes[0].RuleID, "large")
+
+	// The alert is not repeated while the rate stays shifted
+	m.Observe(window(5, 100, 90))
+	is.Equal(len(alerts), 1)
+}
@@ -0,0 +1,93 @@
+package analytics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Handler returns an HTTP handler that serves the cumulative statistics of each
+// rule in the Prometheus text exposition format:
+//
+//     indigo_rule_evaluations_total{rule="..."}
+//     indigo_rule_passes_total{rule="..."}
+//     indigo_rule_errors_total{rule="..."}
+//     indigo_rule_verdicts_total{rule="...",verdict="..."}
+//     indigo_rule_duration_seconds{rule="..."} (a histogram)
+//
+// Hit rates over time are calculated in the queries, such as
+// rate(indigo_rule_passes_total[5m]) / rate(indigo_rule_evaluations_total[5m]).
+// With the query parameter format=json, the handler serves the statistics of each
+// window (see Windows) as JSON instead.
+func (a *Aggregator) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(a.Windows())
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		bw := bufio.NewWriter(w)
+		writePrometheus(bw, a.Totals())
+		_ = bw.Flush()
+	})
+}
+
+// writePrometheus writes the statistics in the Prometheus text exposition format
+func writePrometheus(w *bufio.Writer, totals map[string]*RuleStats) {
+	ids := ruleIDs(totals)
+
+	counter := func(name, help string, value func(s *RuleStats) int) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, id := range ids {
+			fmt.Fprintf(w, "%s{rule=%s} %d\n", name, quote(id), value(totals[id]))
+		}
+	}
+	counter("indigo_rule_evaluations_total", "Evaluations of the rule.", func(s *RuleStats) int { return s.Evaluations })
+	counter("indigo_rule_passes_total", "Evaluations of the rule that passed.", func(s *RuleStats) int { return s.Passes })
+	counter("indigo_rule_errors_total", "Evaluations of the rule that failed with an error.", func(s *RuleStats) int { return s.Errors })
+
+	name := "indigo_rule_verdicts_total"
+	fmt.Fprintf(w, "# HELP %s Evaluations of the rule by verdict.\n# TYPE %s counter\n", name, name)
+	for _, id := range ids {
+		verdicts := make([]string, 0, len(totals[id].Verdicts))
+		for v := range totals[id].Verdicts {
+			verdicts = append(verdicts, v)
+		}
+		sort.Strings(verdicts)
+		for _, v := range verdicts {
+			fmt.Fprintf(w, "%s{rule=%s,verdict=%s} %d\n", name, quote(id), quote(v), totals[id].Verdicts[v])
+		}
+	}
+
+	name = "indigo_rule_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Time taken to evaluate the rule.\n# TYPE %s histogram\n", name, name)
+	for _, id := range ids {
+		l := totals[id].Latency
+		n := 0
+		for i, b := range LatencyBuckets {
+			n += l.Buckets[i]
+			fmt.Fprintf(w, "%s_bucket{rule=%s,le=\"%s\"} %d\n", name, quote(id), seconds(b.Seconds()), n)
+		}
+		fmt.Fprintf(w, "%s_bucket{rule=%s,le=\"+Inf\"} %d\n", name, quote(id), l.Count)
+		fmt.Fprintf(w, "%s_sum{rule=%s} %s\n", name, quote(id), seconds(l.Sum.Seconds()))
+		fmt.Fprintf(w, "%s_count{rule=%s} %d\n", name, quote(id), l.Count)
+	}
+}
+
+// labelEscaper escapes the characters not allowed in Prometheus label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote returns the label value s, quoted
+func quote(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+func seconds(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
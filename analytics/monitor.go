@@ -0,0 +1,195 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ezachrisen/indigo"
+)
+
+// Alert reports that the pass rate of a rule shifted from its baseline in a window.
+type Alert struct {
+	RuleID string
+
+	// The window in which the pass rate shifted
+	Start time.Time
+	End   time.Time
+
+	// The baseline pass rate of the rule, and its pass rate in the window
+	Baseline float64
+	Rate     float64
+
+	// The number of evaluations of the rule in the window
+	Evaluations int
+
+	// The changes made to the rules in the watched vault (see Monitor.Watch)
+	// since the previous window, which may have caused the shift. Empty if the
+	// rules did not change, in which case the data evaluated may have.
+	Changes []indigo.ChangeEvent
+}
+
+// String describes the alert.
+func (a Alert) String() string {
+	s := fmt.Sprintf("rule %s: pass rate %.1f%% in the window starting %s, baseline %.1f%% (%d evaluations)",
+		a.RuleID, a.Rate*100, a.Start.Format(time.RFC3339), a.Baseline*100, a.Evaluations)
+	if len(a.Changes) > 0 {
+		s += fmt.Sprintf(", after %d rule changes", len(a.Changes))
+	}
+	return s
+}
+
+// Monitor tracks the baseline pass rate of each rule over the windows of an
+// aggregator, and raises an alert when the pass rate in a window shifts
+// dramatically from the baseline, such as when a rule change breaks a rule, or when
+// the data evaluated changes. Use the monitor's Observe method as the aggregator's
+// OnWindow function:
+//
+//     m := analytics.NewMonitor(func(a analytics.Alert) { log.Print(a) })
+//     m.Watch(v)
+//     agg := analytics.NewAggregator(analytics.OnWindow(m.Observe))
+//
+// The baseline of a rule is the exponentially weighted moving average of its pass
+// rate in the windows observed. An alert is raised once when the rate shifts from
+// the baseline, and again only after the rate returns to the baseline.
+// Monitor is safe for concurrent use.
+type Monitor struct {
+	mu             sync.Mutex
+	alert          func(Alert)
+	threshold      float64
+	minEvaluations int
+	warmup         int
+	smoothing      float64
+
+	rules   map[string]*baseline
+	changes []indigo.ChangeEvent
+}
+
+// baseline is the baseline pass rate of a rule
+type baseline struct {
+	rate     float64
+	windows  int  // the number of windows observed
+	alerting bool // whether the rate has shifted from the baseline
+}
+
+// MonitorOption is a functional option to specify the behavior of a monitor.
+type MonitorOption func(m *Monitor)
+
+// Threshold specifies the difference between the pass rate of a rule in a window
+// and its baseline, between 0 and 1, at which an alert is raised.
+// Default: 0.25
+func Threshold(t float64) MonitorOption {
+	return func(m *Monitor) {
+		m.threshold = t
+	}
+}
+
+// MinEvaluations specifies the number of evaluations of a rule in a window
+// required to compare its pass rate with the baseline; windows with fewer
+// evaluations are ignored, since their pass rates are not significant.
+// Default: 100
+func MinEvaluations(n int) MonitorOption {
+	return func(m *Monitor) {
+		m.minEvaluations = n
+	}
+}
+
+// Warmup specifies the number of windows observed for a rule before its baseline
+// is established and alerts are raised.
+// Default: 3
+func Warmup(n int) MonitorOption {
+	return func(m *Monitor) {
+		m.warmup = n
+	}
+}
+
+// Smoothing specifies the weight, between 0 and 1, of the pass rate in each window
+// in the baseline. Higher weights adapt the baseline to shifts faster.
+// Default: 0.2
+func Smoothing(w float64) MonitorOption {
+	return func(m *Monitor) {
+		m.smoothing = w
+	}
+}
+
+// NewMonitor returns a monitor that calls alert with each alert raised.
+// The function is called synchronously by Observe.
+func NewMonitor(alert func(Alert), opts ...MonitorOption) *Monitor {
+	m := &Monitor{
+		alert:          alert,
+		threshold:      0.25,
+		minEvaluations: 100,
+		warmup:         3,
+		smoothing:      0.2,
+		rules:          map[string]*baseline{},
+	}
+
+	for _, o := range opts {
+		o(m)
+	}
+	return m
+}
+
+// Watch records the changes made to the rules in the vault v, to report them
+// in the alerts raised after the changes.
+func (m *Monitor) Watch(v *indigo.Vault) {
+	v.Subscribe(func(ev indigo.ChangeEvent) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.changes = append(m.changes, ev)
+	})
+}
+
+// Observe compares the pass rates of the rules in the completed window w with
+// their baselines, raising alerts, and updates the baselines.
+func (m *Monitor) Observe(w Window) {
+	m.mu.Lock()
+	changes := m.changes
+	m.changes = nil
+
+	var alerts []Alert
+	for _, id := range ruleIDs(w.Rules) {
+		s := w.Rules[id]
+		if s.Evaluations < m.minEvaluations {
+			continue
+		}
+		rate := s.HitRate()
+
+		b, ok := m.rules[id]
+		if !ok {
+			m.rules[id] = &baseline{rate: rate, windows: 1}
+			continue
+		}
+
+		shifted := math.Abs(rate-b.rate) >= m.threshold
+		if shifted && !b.alerting && b.windows >= m.warmup {
+			alerts = append(alerts, Alert{
+				RuleID:      id,
+				Start:       w.Start,
+				End:         w.End,
+				Baseline:    b.rate,
+				Rate:        rate,
+				Evaluations: s.Evaluations,
+				Changes:     changes,
+			})
+		}
+		b.alerting = shifted
+		b.rate += m.smoothing * (rate - b.rate)
+		b.windows++
+	}
+	m.mu.Unlock()
+
+	for _, a := range alerts {
+		m.alert(a)
+	}
+}
+
+// Reset forgets the baseline of the rule with the id, such as after a deliberate
+// change to the rule that changes its pass rate; a new baseline is established
+// from the following windows.
+func (m *Monitor) Reset(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.rules, id)
+}
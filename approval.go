@@ -0,0 +1,80 @@
+package indigo
+
+import (
+	"fmt"
+)
+
+// ApprovalStatus is the stage of a rule in a change-management workflow.
+type ApprovalStatus string
+
+const (
+	// The rule is being written
+	ApprovalDraft ApprovalStatus = "draft"
+
+	// The rule is waiting to be reviewed
+	ApprovalPending ApprovalStatus = "pending"
+
+	// The rule has been reviewed and approved
+	ApprovalApproved ApprovalStatus = "approved"
+)
+
+// ApprovalPolicy limits the evaluation of rules to approved rules (see
+// DefaultEngine.SetApprovalPolicy).
+type ApprovalPolicy struct {
+	// The number of people, other than the rule's Owner, who must have approved
+	// the rule, as listed in RuleMetadata.ApprovedBy. Use 1 for two-person review.
+	// Ignored if Check is set.
+	MinApprovers int
+
+	// Returns the approval status of the rule from an external system, such as a
+	// change-management service, instead of the status in RuleMetadata.Approval.
+	// An error fails the compilation of the rule. Optional.
+	Check func(r *Rule) (ApprovalStatus, error)
+}
+
+// SetApprovalPolicy sets the policy that decides which rules the engine evaluates.
+// A rule that is not approved when it is compiled is not evaluated: as a child rule,
+// it is skipped, as if it were disabled, and as the rule passed to Eval, Eval returns
+// an error wrapping ErrNotApproved.
+//
+// Without Check, a rule is approved if its RuleMetadata.Approval is ApprovalApproved,
+// and at least MinApprovers people other than its owner are listed in ApprovedBy.
+// The policy applies to rules compiled after it is set; pass nil to remove it.
+// Default: all rules are evaluated, regardless of their approval status
+func (e *DefaultEngine) SetApprovalPolicy(p *ApprovalPolicy) {
+	e.approval.Store(approvalPolicy{p: p})
+}
+
+// approvalPolicy wraps the policy in the engine's atomic.Value, which cannot store nil
+type approvalPolicy struct {
+	p *ApprovalPolicy
+}
+
+// approved reports whether the rule r is approved under the engine's approval policy
+func (e *DefaultEngine) approved(r *Rule) (bool, error) {
+	ap, _ := e.approval.Load().(approvalPolicy)
+	p := ap.p
+	if p == nil {
+		return true, nil
+	}
+
+	if p.Check != nil {
+		status, err := p.Check(r)
+		if err != nil {
+			return false, fmt.Errorf("checking approval: %w", err)
+		}
+		return status == ApprovalApproved, nil
+	}
+
+	if r.Metadata.Approval != ApprovalApproved {
+		return false, nil
+	}
+
+	approvers := map[string]bool{}
+	for _, a := range r.Metadata.ApprovedBy {
+		if a != "" && a != r.Metadata.Owner {
+			approvers[a] = true
+		}
+	}
+	return len(approvers) >= p.MinApprovers, nil
+}
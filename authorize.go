@@ -0,0 +1,107 @@
+package indigo
+
+import (
+	"sort"
+)
+
+// ChangeRequest describes a change to the rules in a vault, for an Authorizer.
+type ChangeRequest struct {
+	// The principal making the change, such as a user name (see Vault.As);
+	// blank for changes made with the methods of the vault
+	Principal string
+
+	// The kind of change
+	Type ChangeType
+
+	// The ID of the parent of the rule changed; blank for the root rule
+	ParentID string
+
+	// The IDs of the rules affected, sorted: the rule added or removed and its
+	// children, or the rule replaced and the children of both the rule and its
+	// replacement
+	RuleIDs []string
+}
+
+// Authorizer decides whether a change to the rules in a vault is allowed, returning
+// an error if it is not, such as when the principal may not change rules outside
+// a namespace of rule IDs.
+type Authorizer func(req ChangeRequest) error
+
+// Authorize sets the function called before each change to the rules in the vault.
+// If it returns an error, the change is not made, and the error is returned to the
+// caller. The function is called while the vault is locked for changes, so it must
+// not change the vault. Pass nil to allow all changes.
+// Default: all changes are allowed
+func (v *Vault) Authorize(f Authorizer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.authorizer = f
+}
+
+// As returns an editor that makes changes to the vault on behalf of the principal,
+// which is passed to the vault's Authorizer.
+func (v *Vault) As(principal string) *Editor {
+	return &Editor{v: v, principal: principal}
+}
+
+// Editor makes changes to the rules in a vault on behalf of a principal (see Vault.As).
+type Editor struct {
+	v         *Vault
+	principal string
+}
+
+// Add adds the rule r as a child of the rule with parentID, like Vault.Add.
+func (ed *Editor) Add(parentID string, r *Rule) error {
+	return ed.v.add(ed.principal, parentID, r)
+}
+
+// Replace replaces the rule with the same ID as r, like Vault.Replace.
+func (ed *Editor) Replace(r *Rule) error {
+	return ed.v.replace(ed.principal, r)
+}
+
+// Update replaces the rule with the same ID as r, like Vault.Update.
+func (ed *Editor) Update(r *Rule) (*RuleChanges, error) {
+	return ed.v.update(ed.principal, r)
+}
+
+// Remove removes the rule with the id, and its children, like Vault.Remove.
+func (ed *Editor) Remove(id string) error {
+	return ed.v.remove(ed.principal, id)
+}
+
+// RemoveRules removes the rules with the ids, and their children, like Vault.RemoveRules.
+func (ed *Editor) RemoveRules(ids ...string) error {
+	return ed.v.removeIDs(ed.principal, ids)
+}
+
+// RemoveByTag removes the rules with the tag, and their children, like Vault.RemoveByTag.
+func (ed *Editor) RemoveByTag(tag string) ([]string, error) {
+	return ed.v.removeByTag(ed.principal, tag)
+}
+
+// authorize returns the error of the vault's Authorizer for the change, if any.
+// The caller must hold v.mu.
+func (v *Vault) authorize(req ChangeRequest) error {
+	if v.authorizer == nil {
+		return nil
+	}
+	return v.authorizer(req)
+}
+
+// sortedIDs returns the sorted IDs of the rules and their children
+func sortedIDs(rules ...*Rule) []string {
+	ids := map[string]bool{}
+	for _, r := range rules {
+		for id := range ruleIDs(r) {
+			ids[id] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,192 @@
+// Package bindings validates and evaluates rule bundles encoded as JSON, for the
+// bindings of Indigo to other languages, such as the WebAssembly build used by
+// rule-authoring tools in the browser (see cmd/indigo-wasm), and the C library
+// used by services written in other languages (see cmd/indigo-capi), so that rules are
+// checked and evaluated with the same semantics as in Go services.
+//
+// Bundles are encoded as produced by indigo.Export. Rules are compiled with the CEL
+// evaluator returned by cel.NewEvaluator(), without options; functions added with
+// evaluator options are not available. The most recently used bundles are kept
+// compiled, so that evaluating the same bundle repeatedly is cheap.
+package bindings
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/cel"
+)
+
+// Result is the result of evaluating a rule, encoded as JSON.
+type Result struct {
+	RuleID  string                 `json:"rule_id"`
+	Pass    bool                   `json:"pass"`
+	Status  string                 `json:"status"`
+	Verdict indigo.Severity        `json:"verdict,omitempty"`
+	Value   interface{}            `json:"value,omitempty"`
+	Message string                 `json:"message,omitempty"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+
+	// The child results, in the order they were evaluated
+	Results []*Result `json:"results,omitempty"`
+}
+
+// Problem is the reason a bundle is not valid.
+type Problem struct {
+	// The ID of the rule that could not be compiled; blank if the bundle
+	// could not be decoded
+	RuleID string `json:"rule_id,omitempty"`
+
+	Message string `json:"message"`
+}
+
+// Error returns the message of the problem.
+func (p *Problem) Error() string {
+	if p.RuleID == "" {
+		return p.Message
+	}
+	return fmt.Sprintf("rule %s: %s", p.RuleID, p.Message)
+}
+
+// Validate decodes and compiles the rules in the bundle, returning the problem
+// that makes the bundle invalid, or nil if it is valid.
+func Validate(bundle []byte) *Problem {
+	if _, _, err := compile(bundle); err != nil {
+		return problem(err)
+	}
+	return nil
+}
+
+// Evaluate compiles the rules in the bundle, and evaluates the root rule with the
+// input data, a JSON object converted to the types of the rules' schemas (see
+// indigo.Schema.Coerce). It returns the result, encoded as JSON.
+func Evaluate(ctx context.Context, bundle, data []byte, opts ...indigo.EvalOption) ([]byte, error) {
+	e, r, err := compile(bundle)
+	if err != nil {
+		return nil, problem(err)
+	}
+
+	d := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&d); err != nil {
+		return nil, fmt.Errorf("decoding data: %w", err)
+	}
+
+	u, err := e.Eval(ctx, r, d, append([]indigo.EvalOption{indigo.CoerceData(true)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encodeResult(u))
+}
+
+// maxCompiled is the number of compiled bundles kept, so that evaluating the same
+// bundle repeatedly does not compile it each time
+const maxCompiled = 16
+
+// compiled holds the recently compiled bundles, by the hash of their encoding.
+// When it is full, the least recently used bundle is removed.
+var compiled = struct {
+	sync.Mutex
+	order   *list.List // of *compiledBundle, most recently used first
+	entries map[[sha256.Size]byte]*list.Element
+}{order: list.New(), entries: map[[sha256.Size]byte]*list.Element{}}
+
+// compiledBundle is a compiled bundle: the engine, and the root rule
+type compiledBundle struct {
+	key [sha256.Size]byte
+	e   *indigo.DefaultEngine
+	r   *indigo.Rule
+}
+
+// compile returns the engine and root rule of the bundle, compiling it unless
+// it was compiled recently
+func compile(bundle []byte) (*indigo.DefaultEngine, *indigo.Rule, error) {
+	key := sha256.Sum256(bundle)
+	compiled.Lock()
+	if el, ok := compiled.entries[key]; ok {
+		compiled.order.MoveToFront(el)
+		c := el.Value.(*compiledBundle)
+		compiled.Unlock()
+		return c.e, c.r, nil
+	}
+	compiled.Unlock()
+
+	e, r, err := compileBundle(bundle)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	compiled.Lock()
+	defer compiled.Unlock()
+	if el, ok := compiled.entries[key]; ok {
+		// Compiled concurrently; keep the first
+		compiled.order.MoveToFront(el)
+		c := el.Value.(*compiledBundle)
+		return c.e, c.r, nil
+	}
+
+	compiled.entries[key] = compiled.order.PushFront(&compiledBundle{key: key, e: e, r: r})
+	for compiled.order.Len() > maxCompiled {
+		last := compiled.order.Back()
+		compiled.order.Remove(last)
+		delete(compiled.entries, last.Value.(*compiledBundle).key)
+	}
+	return e, r, nil
+}
+
+// compileBundle decodes the bundle and compiles its rules with a new engine
+func compileBundle(bundle []byte) (*indigo.DefaultEngine, *indigo.Rule, error) {
+	b := indigo.RuleBundle{}
+	if err := json.Unmarshal(bundle, &b); err != nil {
+		return nil, nil, fmt.Errorf("decoding bundle: %w", err)
+	}
+
+	r, err := indigo.Import(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	if err := e.Compile(r); err != nil {
+		return nil, nil, err
+	}
+	return e, r, nil
+}
+
+// problem returns the problem reported by err
+func problem(err error) *Problem {
+	var ce *indigo.CompileError
+	if errors.As(err, &ce) {
+		return &Problem{RuleID: ce.RuleID, Message: ce.Err.Error()}
+	}
+	return &Problem{Message: err.Error()}
+}
+
+// encodeResult returns the result u and its children, for encoding as JSON
+func encodeResult(u *indigo.Result) *Result {
+	x := &Result{
+		RuleID:  u.Rule.ID,
+		Pass:    u.Pass,
+		Status:  u.Status.String(),
+		Verdict: u.Verdict,
+		Value:   u.Value,
+		Message: u.Message,
+		Outputs: u.Outputs,
+	}
+	if u.Error != nil {
+		x.Error = u.Error.Error()
+	}
+	for _, c := range u.OrderedResults {
+		x.Results = append(x.Results, encodeResult(c))
+	}
+	return x
+}
@@ -0,0 +1,60 @@
+package bindings_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/bindings"
+	"github.com/matryer/is"
+)
+
+func makeBundle(t *testing.T, expr string) []byte {
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "at", Type: indigo.Timestamp{}},
+		},
+	}
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"large": {ID: "large", Schema: schema, Expr: expr, Metadata: indigo.RuleMetadata{Severity: indigo.SeverityDeny}},
+		},
+	}
+
+	b, err := indigo.Export(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func TestBindings(t *testing.T) {
+	is := is.New(t)
+
+	bundle := makeBundle(t, `amount > 1000 && at > timestamp("2020-01-01T00:00:00Z")`)
+	is.True(bindings.Validate(bundle) == nil)
+
+	b, err := bindings.Evaluate(context.Background(), bundle, []byte(`{"amount": 5000, "at": "2021-06-01T00:00:00Z"}`))
+	is.NoErr(err)
+	u := bindings.Result{}
+	is.NoErr(json.Unmarshal(b, &u))
+	is.Equal(u.Verdict, indigo.SeverityDeny)
+	is.Equal(len(u.Results), 1)
+	is.True(u.Results[0].Pass)
+
+	p := bindings.Validate(makeBundle(t, `amount > "x"`))
+	is.True(p != nil)
+	is.Equal(p.RuleID, "large")
+
+	p = bindings.Validate([]byte(`{`))
+	is.True(p != nil && strings.HasPrefix(p.Message, "decoding bundle"))
+}
@@ -0,0 +1,68 @@
+package bindings
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/matryer/is"
+)
+
+// bundle returns a bundle with a rule with the expression
+func bundle(t *testing.T, expr string) []byte {
+	t.Helper()
+	b, err := indigo.Export(&indigo.Rule{
+		ID:     "root",
+		Schema: indigo.Schema{Elements: []indigo.DataElement{{Name: "amount", Type: indigo.Int{}}}},
+		Expr:   expr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCompiledCache(t *testing.T) {
+	is := is.New(t)
+
+	hot := bundle(t, "amount > 0")
+	e, r, err := compile(hot)
+	is.NoErr(err)
+	e2, r2, err := compile(hot)
+	is.NoErr(err)
+	is.True(e == e2 && r == r2) // reused
+
+	// The hot bundle is used between the others, and is not removed
+	for i := 0; i < 3*maxCompiled; i++ {
+		_, _, err := compile(bundle(t, fmt.Sprintf("amount > %d", i+1)))
+		is.NoErr(err)
+		e2, _, err = compile(hot)
+		is.NoErr(err)
+		is.True(e == e2)
+	}
+
+	compiled.Lock()
+	n, m := compiled.order.Len(), len(compiled.entries)
+	compiled.Unlock()
+	is.Equal(n, maxCompiled)
+	is.Equal(m, maxCompiled)
+
+	// The least recently used bundle is removed
+	first := bundle(t, "amount > 1")
+	_, _, err = compile(first)
+	is.NoErr(err)
+	for i := 0; i < maxCompiled; i++ {
+		_, _, err := compile(bundle(t, fmt.Sprintf("amount < %d", i)))
+		is.NoErr(err)
+	}
+	compiled.Lock()
+	_, ok := compiled.entries[sha256.Sum256(first)]
+	compiled.Unlock()
+	is.True(!ok)
+}
@@ -0,0 +1,135 @@
+package indigo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BreakerPolicy sets when the circuit breaker of a rule opens (see
+// DefaultEngine.SetCircuitBreaker).
+type BreakerPolicy struct {
+	// The number of consecutive failed evaluations of a rule that open its
+	// breaker. At least 1.
+	Failures int
+
+	// An evaluation of a rule (including its children) that takes longer than
+	// Slow counts as failed; no limit if 0
+	Slow time.Duration
+
+	// How long the breaker stays open, skipping the rule, before the rule is
+	// evaluated again
+	Cooldown time.Duration
+
+	// Called when the breaker of a rule opens or closes, such as to alert the
+	// rule's owner. Called synchronously by the evaluation. Optional.
+	OnChange func(ruleID string, open bool)
+}
+
+// SetCircuitBreaker sets the policy of the circuit breakers of the child rules
+// evaluated by the engine. A child rule whose evaluations fail consistently, such as
+// because an enricher's external lookup is down, is marked degraded: its breaker
+// opens, and for the cool-down period the rule is skipped (its result has
+// StatusSkipped) instead of failing the evaluation of the whole tree.
+//
+// After the cool-down the rule is evaluated again: the breaker closes if the
+// evaluation succeeds, and opens again at once if it fails. An evaluation fails if
+// it returns an error, other than because the context passed to Eval is done, or
+// takes longer than Slow. The rule passed to Eval has no breaker.
+//
+// Setting the policy closes all breakers; pass nil to remove it.
+// Default: no circuit breakers
+func (e *DefaultEngine) SetCircuitBreaker(p *BreakerPolicy) {
+	var b *breakers
+	if p != nil {
+		b = &breakers{p: *p, rules: map[string]*breaker{}}
+		if b.p.Failures < 1 {
+			b.p.Failures = 1
+		}
+	}
+	e.breakers.Store(breakerPolicy{b: b})
+}
+
+// breakerPolicy wraps the breakers in the engine's atomic.Value, which cannot store nil
+type breakerPolicy struct {
+	b *breakers
+}
+
+// breakers holds the state of the circuit breaker of each rule, by rule ID
+type breakers struct {
+	p     BreakerPolicy
+	mu    sync.Mutex
+	rules map[string]*breaker
+}
+
+// breaker is the state of the circuit breaker of a rule
+type breaker struct {
+	failures  int       // the number of consecutive failed evaluations
+	openUntil time.Time // the end of the cool-down, if the breaker is open
+}
+
+// circuitBreakers returns the engine's circuit breakers, or nil if there are none
+func (e *DefaultEngine) circuitBreakers() *breakers {
+	bp, _ := e.breakers.Load().(breakerPolicy)
+	return bp.b
+}
+
+// allows reports whether the rule with the id may be evaluated, because its
+// breaker is closed, or its cool-down is over
+func (b *breakers) allows(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.rules[id]
+	return !ok || !time.Now().Before(s.openUntil)
+}
+
+// record records an evaluation of the rule with the id, which took the duration d,
+// and returned err
+func (b *breakers) record(ctx context.Context, id string, d time.Duration, err error) {
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	failed := err != nil || (b.p.Slow > 0 && d > b.p.Slow)
+
+	b.mu.Lock()
+	s, ok := b.rules[id]
+	if !ok {
+		if !failed {
+			b.mu.Unlock()
+			return
+		}
+		s = &breaker{}
+		b.rules[id] = s
+	}
+
+	wasOpen := s.failures >= b.p.Failures
+	if failed {
+		s.failures++
+	} else {
+		s.failures = 0
+	}
+	open := s.failures >= b.p.Failures
+	if open {
+		s.openUntil = time.Now().Add(b.p.Cooldown)
+	}
+	if !failed {
+		delete(b.rules, id)
+	}
+	b.mu.Unlock()
+
+	if open != wasOpen && b.p.OnChange != nil {
+		b.p.OnChange(id, open)
+	}
+}
+
+// skippedResult returns the result of the rule r, skipped because its circuit
+// breaker is open
+func skippedResult(r *Rule, o EvalOptions) *Result {
+	return &Result{
+		Rule:        r,
+		Metadata:    &r.Metadata,
+		Skipped:     true,
+		Status:      StatusSkipped,
+		EvalOptions: o,
+	}
+}
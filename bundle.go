@@ -0,0 +1,111 @@
+package indigo
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+)
+
+// BundleVersion is the version of the RuleBundle format produced by Export.
+const BundleVersion = 1
+
+// RuleBundle is a versioned, serializable copy of a rule tree, including the
+// expressions, schemas, result types, metadata and evaluation options of every rule.
+// Use it to back up rules, to promote rules between environments, or
+// to inspect rules in support tooling.
+//
+// A RuleBundle is encoded with encoding/json. Values that cannot be
+// serialized (Self, Program, Meta and the SortFunc evaluation option) are
+// not included in the bundle.
+type RuleBundle struct {
+	// Version of the bundle format; see BundleVersion
+	Version int `json:"version"`
+
+	// The root of the rule tree
+	Rule *Rule `json:"rule"`
+}
+
+// Export returns a bundle containing a copy of the rule r and its children.
+// The copy is produced by encoding and decoding the rule, which guarantees
+// that the bundle can be serialized.
+func Export(r *Rule) (RuleBundle, error) {
+	if r == nil {
+		return RuleBundle{}, ErrNilRule
+	}
+
+	if err := checkCycles(r, nil); err != nil {
+		return RuleBundle{}, err
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return RuleBundle{}, fmt.Errorf("encoding rule %s: %w", r.ID, err)
+	}
+
+	c := &Rule{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return RuleBundle{}, fmt.Errorf("decoding rule %s: %w", r.ID, err)
+	}
+
+	return RuleBundle{
+		Version: BundleVersion,
+		Rule:    c,
+	}, nil
+}
+
+// Import returns the rule tree in the bundle, after checking that
+// the bundle version is supported.
+// The rules are not compiled; you must compile the rule before evaluating it.
+func Import(b RuleBundle) (*Rule, error) {
+	if b.Version != BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d, want %d", b.Version, BundleVersion)
+	}
+
+	if b.Rule == nil {
+		return nil, ErrNilRule
+	}
+
+	if err := checkCycles(b.Rule, nil); err != nil {
+		return nil, err
+	}
+
+	return b.Rule, nil
+}
+
+// EncodeBundle serializes the bundle, returning the encoded bytes and a detached
+// Ed25519 signature of those bytes made with the private key.
+// Store the signature alongside the encoded bundle, and use DecodeBundle
+// with the corresponding public key to verify the bundle before it is used.
+func EncodeBundle(b RuleBundle, key ed25519.PrivateKey) (data []byte, signature []byte, err error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, nil, fmt.Errorf("invalid private key length %d", len(key))
+	}
+
+	data, err = json.Marshal(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding bundle: %w", err)
+	}
+
+	return data, ed25519.Sign(key, data), nil
+}
+
+// DecodeBundle verifies the detached Ed25519 signature of the encoded bundle data
+// with the public key, and if the signature is valid, decodes the bundle.
+// If the signature does not match, DecodeBundle returns ErrInvalidSignature
+// without decoding the data; this prevents rules from a tampered bundle
+// from being loaded.
+func DecodeBundle(data, signature []byte, key ed25519.PublicKey) (RuleBundle, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return RuleBundle{}, fmt.Errorf("invalid public key length %d", len(key))
+	}
+
+	if !ed25519.Verify(key, data, signature) {
+		return RuleBundle{}, ErrInvalidSignature
+	}
+
+	b := RuleBundle{}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return RuleBundle{}, fmt.Errorf("decoding bundle: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,103 @@
+package indigo_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/testdata/school"
+	"github.com/matryer/is"
+)
+
+// Test that a rule tree survives a round-trip through a serialized bundle
+func TestBundleRoundTrip(t *testing.T) {
+	is := is.New(t)
+
+	r := makeRule()
+	r.Schema = indigo.Schema{
+		ID: "s1",
+		Elements: []indigo.DataElement{
+			{Name: "a", Type: indigo.Int{}, Description: "an int"},
+			{Name: "b", Type: indigo.Map{KeyType: indigo.String{}, ValueType: indigo.Float{}}},
+			{Name: "c", Type: indigo.List{ValueType: indigo.Duration{}}},
+			{Name: "s", Type: indigo.Proto{Message: &school.Student{}}},
+		},
+	}
+	r.ResultType = indigo.Float{}
+	r.Rules["B"].EvalOptions.StopIfParentNegative = true
+	r.Rules["D"].EvalOptions.MaxDepth = 4
+	created := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	r.Rules["D"].Metadata = indigo.RuleMetadata{
+		Owner:    "risk-team",
+		Severity: "deny",
+		Created:  &created,
+		Extra:    map[string]interface{}{"reviewed": true},
+	}
+
+	b, err := indigo.Export(r)
+	is.NoErr(err)
+	is.Equal(b.Version, indigo.BundleVersion)
+	is.True(b.Rule != r) // the bundle holds a copy
+
+	data, err := json.Marshal(b)
+	is.NoErr(err)
+
+	var b2 indigo.RuleBundle
+	is.NoErr(json.Unmarshal(data, &b2))
+
+	r2, err := indigo.Import(b2)
+	is.NoErr(err)
+	is.Equal(r2.Schema.ID, "s1")
+	is.Equal(r2.Schema.Elements[0].Type, indigo.Int{})
+	is.Equal(r2.Schema.Elements[0].Description, "an int")
+	is.Equal(r2.Schema.Elements[1].Type.String(), "map[string]float")
+	is.Equal(r2.Schema.Elements[2].Type.String(), "[]duration")
+	is.Equal(r2.Schema.Elements[3].Type.String(), "proto(testdata.school.Student)")
+	is.Equal(r2.ResultType, indigo.Float{})
+	is.Equal(r2.Rules["B"].ResultType, nil)
+	is.True(r2.Rules["B"].EvalOptions.StopIfParentNegative)
+	is.Equal(r2.Rules["D"].EvalOptions.MaxDepth, 4)
+	is.Equal(r2.Rules["D"].Metadata.Owner, "risk-team")
+	is.Equal(r2.Rules["D"].Metadata.Severity, indigo.Severity("deny"))
+	is.True(r2.Rules["D"].Metadata.Created.Equal(created))
+	is.Equal(r2.Rules["D"].Metadata.Extra["reviewed"], true)
+	is.Equal(r2.Rules["B"].Metadata.Created, nil) // not set
+	is.True(!strings.Contains(string(data), "updated"))
+	is.Equal(r2.Rules["B"].Rules["b4"].Rules["b4-2"].Expr, "false")
+
+	_, err = indigo.Import(indigo.RuleBundle{Version: 99, Rule: r2})
+	is.True(err != nil) // unsupported version
+}
+
+// Test that a signed bundle is verified before it is decoded
+func TestSignedBundle(t *testing.T) {
+	is := is.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	is.NoErr(err)
+
+	b, err := indigo.Export(makeRule())
+	is.NoErr(err)
+
+	data, sig, err := indigo.EncodeBundle(b, priv)
+	is.NoErr(err)
+
+	b2, err := indigo.DecodeBundle(data, sig, pub)
+	is.NoErr(err)
+	is.Equal(b2.Rule.Rules["D"].Expr, "true")
+
+	tampered := bytes.Replace(data, []byte(`"expr":"false"`), []byte(`"expr":"true"`), 1)
+	is.True(!bytes.Equal(tampered, data))
+	_, err = indigo.DecodeBundle(tampered, sig, pub)
+	is.True(errors.Is(err, indigo.ErrInvalidSignature))
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	is.NoErr(err)
+	_, err = indigo.DecodeBundle(data, sig, otherPub)
+	is.True(errors.Is(err, indigo.ErrInvalidSignature))
+}
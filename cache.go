@@ -0,0 +1,286 @@
+package indigo
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DecisionCache is the interface implemented by stores of evaluation results,
+// such as LRUCache. Implementations must be safe for concurrent use.
+//
+// Get returns the result stored under the key, if it exists and has not expired.
+//
+// Add stores the result under the key, to expire after the ttl.
+type DecisionCache interface {
+	Get(key string) (*Result, bool)
+	Add(key string, u *Result, ttl time.Duration)
+}
+
+// Cached evaluates a rule tree, reusing the result of an earlier evaluation
+// if the data the rules refer to, and the evaluation options, are the same.
+// Use it when many evaluations repeat the same input, and the rules are deterministic:
+// do not use it with expressions whose values depend on external state.
+//
+// The cache key is a hash of the rule's ID, the evaluation options (except functions,
+// such as SortFunc and OnResult) and the values, encoded as JSON, of the data elements
+// referenced by the rules. To find the elements, the engine's evaluator must implement
+// ExpressionAnalyzer; otherwise, and if any rule renders messages, has a Self value
+// or has enrichers, all the data is part of the key.
+//
+// Results returned from the cache are shared between callers; they must not be modified.
+// The PoolResults option is ignored. After changing or recompiling the rules, create a
+// new Cached, and clear the cache or use different rule IDs.
+type Cached struct {
+	engine *DefaultEngine
+	rule   *Rule
+	cache  DecisionCache
+	ttl    time.Duration
+
+	// The data elements the rules refer to; nil if unknown
+	vars []string
+}
+
+// Cached returns an evaluator for the rule r that stores results in the cache c
+// for the duration ttl. The rules must be compiled.
+func (e *DefaultEngine) Cached(r *Rule, c DecisionCache, ttl time.Duration) (*Cached, error) {
+	if err := validateCompileArguments(r, e); err != nil {
+		return nil, err
+	}
+
+	if c == nil {
+		return nil, fmt.Errorf("decision cache is nil")
+	}
+
+	vars, err := referencedData(e.e, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cached{
+		engine: e,
+		rule:   r,
+		cache:  c,
+		ttl:    ttl,
+		vars:   vars,
+	}, nil
+}
+
+// referencedData returns the sorted names of the data elements referred to by
+// the rule r and its descendants, or nil if they cannot be determined
+func referencedData(ev ExpressionEvaluator, r *Rule) ([]string, error) {
+	a, ok := ev.(ExpressionAnalyzer)
+	if !ok {
+		return nil, nil
+	}
+
+	known := true
+	seen := map[string]bool{}
+	err := ApplyToRule(r, func(cr *Rule) error {
+		if cr == nil {
+			return ErrNilRule
+		}
+
+		// Enrichers add values derived from data that the expressions need not refer to
+		if cr.Self != nil || len(cr.Messages) > 0 || len(cr.Enrich) > 0 {
+			known = false
+			return nil
+		}
+
+		schema, err := reservedSchema(cr, cr.Schema)
+		if err != nil {
+			return &CompileError{RuleID: cr.ID, Err: err}
+		}
+
+		for _, x := range ruleExprs(cr) {
+			info, err := a.Analyze(x.expr, schema, x.t)
+			if err != nil {
+				return &CompileError{RuleID: cr.ID, Err: err}
+			}
+			for _, v := range info.ReferencedVariables {
+				seen[v] = true
+			}
+		}
+
+		// Data elements used by the engine to select rules
+		seen[strings.Split(cr.IndexBy, ".")[0]] = true
+		seen[cr.ForEach] = true
+		if cr.Rollout != nil {
+			seen[cr.Rollout.Key] = true
+		}
+		if cr.Experiment != nil {
+			seen[cr.Experiment.Key] = true
+		}
+		return nil
+	})
+
+	if err != nil || !known {
+		return nil, err
+	}
+
+	vars := make([]string, 0, len(seen))
+	for v := range seen {
+		if v != "" {
+			vars = append(vars, v)
+		}
+	}
+	sort.Strings(vars)
+	return vars, nil
+}
+
+// Eval returns the cached result of evaluating the rule against the data, if
+// there is one; otherwise it evaluates the rule and caches the result.
+// Errors are not cached.
+func (c *Cached) Eval(ctx context.Context, d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+	if d == nil {
+		return nil, ErrNilData
+	}
+
+	key, err := c.key(ctx, d, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if u, ok := c.cache.Get(key); ok {
+		return u, nil
+	}
+
+	u, err := c.engine.Eval(ctx, c.rule, d, append(opts[:len(opts):len(opts)], PoolResults(false))...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.cache.Add(key, u, c.ttl)
+	return u, nil
+}
+
+// key returns the cache key for evaluating the rule against the data with the options
+// and the context values of ctx
+func (c *Cached) key(ctx context.Context, d map[string]interface{}, opts []EvalOption) (string, error) {
+	o := c.rule.EvalOptions
+	applyEvaluatorOptions(&o, opts...)
+	ob, err := json.Marshal(o)
+	if err != nil {
+		return "", fmt.Errorf("encoding options: %w", err)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s", c.rule.ID, ob)
+	d = c.engine.inputData(ctx, d)
+
+	vars := c.vars
+	if _, ok := d[ContextValuesKey]; ok && vars != nil {
+		vars = append(vars[:len(vars):len(vars)], ContextValuesKey)
+	}
+	if vars == nil {
+		vars = make([]string, 0, len(d))
+		for k := range d {
+			vars = append(vars, k)
+		}
+		sort.Strings(vars)
+	}
+
+	for _, v := range vars {
+		val, ok := d[v]
+		if !ok {
+			fmt.Fprintf(h, "\x00%s!", v)
+			continue
+		}
+
+		b, err := json.Marshal(val)
+		if err != nil {
+			return "", fmt.Errorf("encoding data element %s: %w", v, err)
+		}
+		fmt.Fprintf(h, "\x00%s=%s", v, b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// LRUCache is an in-memory DecisionCache holding a limited number of results.
+// When it is full, the least recently used result is removed.
+type LRUCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *lruEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+// lruEntry is a result stored in an LRUCache
+type lruEntry struct {
+	key     string
+	result  *Result
+	expires time.Time
+}
+
+// NewLRUCache returns a cache holding up to size results.
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// Get returns the result stored under the key, if it has not expired.
+func (c *LRUCache) Get(key string) (*Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	en := el.Value.(*lruEntry)
+	if time.Now().After(en.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return en.result, true
+}
+
+// Add stores the result under the key, to expire after the ttl.
+func (c *LRUCache) Add(key string, u *Result, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	en := &lruEntry{key: key, result: u, expires: time.Now().Add(ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = en
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(en)
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*lruEntry).key)
+	}
+}
+
+// Len returns the number of results in the cache, including expired
+// results that have not been removed yet.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Clear removes all results from the cache.
+func (c *LRUCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+}
@@ -0,0 +1,116 @@
+package cel
+
+// This file contains functions that analyze compiled CEL expressions,
+// reporting the cost, size, complexity and variables used.
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ezachrisen/indigo"
+	celgo "github.com/google/cel-go/cel"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Analyze compiles the expression and returns information about the compiled program:
+// the estimated evaluation cost (as calculated by CEL), the schema elements the
+// expression refers to, the size and complexity of the checked expression, and
+// whether a boolean expression is always true or always false.
+// Complexity is measured after macros, such as all and exists, have been expanded.
+// Analyze implements the indigo.ExpressionAnalyzer interface.
+func (e *Evaluator) Analyze(expr string, s indigo.Schema, resultType indigo.Type) (indigo.ExpressionInfo, error) {
+	info := indigo.ExpressionInfo{}
+
+	if expr == "" {
+		return info, nil
+	}
+
+	if resultType == nil {
+		resultType = indigo.Bool{}
+	}
+
+	prog, ast, err := e.compile(expr, s, resultType, false)
+	if err != nil {
+		return info, err
+	}
+
+	info.MinCost, info.MaxCost = celgo.EstimateCost(prog.program)
+
+	checked, err := celgo.AstToCheckedExpr(ast)
+	if err != nil {
+		return info, fmt.Errorf("converting AST: %w", err)
+	}
+
+	info.Size = proto.Size(checked)
+	info.ReferencedVariables = referencedVariables(checked.GetReferenceMap(), s)
+	addComplexity(checked.GetExpr(), 0, &info.Complexity)
+
+	if _, ok := resultType.(indigo.Bool); ok && checked.GetExpr().GetConstExpr() == nil {
+		v, known := alwaysValue(checked.GetExpr())
+		info.AlwaysTrue, info.AlwaysFalse = known && v, known && !v
+	}
+	return info, nil
+}
+
+// addComplexity adds the nodes and calls of the expression x, nested in depth
+// comprehensions, to c
+func addComplexity(x *gexpr.Expr, depth int, c *indigo.Complexity) {
+	if x == nil {
+		return
+	}
+
+	c.Nodes++
+	switch k := x.GetExprKind().(type) {
+	case *gexpr.Expr_SelectExpr:
+		addComplexity(k.SelectExpr.GetOperand(), depth, c)
+	case *gexpr.Expr_CallExpr:
+		c.Calls++
+		addComplexity(k.CallExpr.GetTarget(), depth, c)
+		for _, a := range k.CallExpr.GetArgs() {
+			addComplexity(a, depth, c)
+		}
+	case *gexpr.Expr_ListExpr:
+		for _, el := range k.ListExpr.GetElements() {
+			addComplexity(el, depth, c)
+		}
+	case *gexpr.Expr_StructExpr:
+		for _, en := range k.StructExpr.GetEntries() {
+			addComplexity(en.GetMapKey(), depth, c)
+			addComplexity(en.GetValue(), depth, c)
+		}
+	case *gexpr.Expr_ComprehensionExpr:
+		cx := k.ComprehensionExpr
+		addComplexity(cx.GetIterRange(), depth, c)
+
+		depth++
+		if depth > c.ComprehensionDepth {
+			c.ComprehensionDepth = depth
+		}
+		for _, e := range []*gexpr.Expr{cx.GetAccuInit(), cx.GetLoopCondition(), cx.GetLoopStep(), cx.GetResult()} {
+			addComplexity(e, depth, c)
+		}
+	}
+}
+
+// referencedVariables returns the sorted names of the schema elements, and the
+// context values, that appear in the reference map of a checked expression.
+func referencedVariables(refs map[int64]*gexpr.Reference, s indigo.Schema) []string {
+	declared := map[string]bool{indigo.ContextValuesKey: true}
+	for _, e := range s.Elements {
+		declared[e.Name] = true
+	}
+
+	seen := map[string]bool{}
+	vars := []string{}
+	for _, r := range refs {
+		n := r.GetName()
+		if n == "" || !declared[n] || seen[n] {
+			continue
+		}
+		seen[n] = true
+		vars = append(vars, n)
+	}
+	sort.Strings(vars)
+	return vars
+}
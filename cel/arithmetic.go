@@ -0,0 +1,233 @@
+package cel
+
+// This file contains the arithmetic policy, which controls the outcome of
+// integer overflow and division by zero, and the safe arithmetic functions.
+
+import (
+	"math"
+
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+	"github.com/google/cel-go/interpreter/functions"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// ArithmeticPolicy determines the outcome of integer arithmetic that overflows,
+// or divides by zero (see Arithmetic).
+type ArithmeticPolicy int
+
+const (
+	// ArithmeticError makes the evaluation of the rule fail. This is the default.
+	ArithmeticError ArithmeticPolicy = iota
+
+	// ArithmeticSaturate yields the largest or smallest value of the type, in the
+	// direction of the overflow: for example, the maximum int for a positive number
+	// divided by zero, and 0 for an unsigned subtraction below zero.
+	// The remainder of a division by zero is 0.
+	ArithmeticSaturate
+
+	// ArithmeticDefault yields 0.
+	ArithmeticDefault
+)
+
+// Arithmetic sets the outcome of integer arithmetic (+, -, *, / and %) that overflows,
+// or divides by zero, and makes these functions available to rule expressions:
+//
+//     safe_div(a, b, default)
+//     safe_mod(a, b, default)
+//
+// safe_div and safe_mod return a / b and a % b, or the default if the operation overflows
+// or divides by zero, regardless of the policy. The arguments are ints, uints or (for safe_div)
+// doubles. For example, `safe_div(errors, requests, 0) > 5`.
+// Floating-point arithmetic does not fail: division by zero yields infinity.
+func Arithmetic(p ArithmeticPolicy) Option {
+	return library(arithmeticLib{policy: p})
+}
+
+// arithmeticLib is a CEL library with the arithmetic policy and the safe arithmetic functions
+type arithmeticLib struct {
+	policy ArithmeticPolicy
+}
+
+// arithmeticErrors are the messages of the errors handled by the arithmetic policy
+var arithmeticErrors = map[string]bool{
+	"integer overflow":          true,
+	"unsigned integer overflow": true,
+	"divide by zero":            true,
+	"modulus by zero":           true,
+}
+
+func (l arithmeticLib) CompileOptions() []celgo.EnvOption {
+	return []celgo.EnvOption{celgo.Declarations(l.declarations()...)}
+}
+
+// declarations returns the declarations of the functions of the library
+func (arithmeticLib) declarations() []*gexpr.Decl {
+	overload := func(name, typeName string, t *gexpr.Type) *gexpr.Decl_FunctionDecl_Overload {
+		return decls.NewOverload(name+"_"+typeName, []*gexpr.Type{t, t, t}, t)
+	}
+
+	return []*gexpr.Decl{
+		decls.NewFunction("safe_div",
+			overload("safe_div", "int", decls.Int),
+			overload("safe_div", "uint", decls.Uint),
+			overload("safe_div", "double", decls.Double)),
+		decls.NewFunction("safe_mod",
+			overload("safe_mod", "int", decls.Int),
+			overload("safe_mod", "uint", decls.Uint)),
+	}
+}
+
+func (l arithmeticLib) ProgramOptions() []celgo.ProgramOption {
+	opts := []celgo.ProgramOption{
+		celgo.Functions(
+			&functions.Overload{
+				Operator: "safe_div",
+				Function: safeFunc(operators.Divide),
+			},
+			&functions.Overload{
+				Operator: "safe_mod",
+				Function: safeFunc(operators.Modulo),
+			},
+		),
+	}
+
+	if l.policy != ArithmeticError {
+		opts = append(opts, celgo.CustomDecorator(l.decorate))
+	}
+	return opts
+}
+
+// safeFunc returns a safe arithmetic function performing the operator op
+func safeFunc(op string) functions.FunctionOp {
+	return func(args ...ref.Val) ref.Val {
+		if len(args) != 3 {
+			return types.NewErr("safe arithmetic functions take 3 arguments, got %d", len(args))
+		}
+
+		v := divideOrModulo(op, args[0], args[1])
+		if isArithmeticError(v) || args[1] == types.Double(0) {
+			return args[2]
+		}
+		return v
+	}
+}
+
+// divideOrModulo performs the operator op, / or %, on the values
+func divideOrModulo(op string, a, b ref.Val) ref.Val {
+	switch op {
+	case operators.Divide:
+		if x, ok := a.(interface{ Divide(ref.Val) ref.Val }); ok {
+			return x.Divide(b)
+		}
+	case operators.Modulo:
+		if x, ok := a.(interface{ Modulo(ref.Val) ref.Val }); ok {
+			return x.Modulo(b)
+		}
+	}
+	return types.ValOrErr(a, "no such overload")
+}
+
+// isArithmeticError reports whether v is an error handled by the arithmetic policy
+func isArithmeticError(v ref.Val) bool {
+	err, ok := v.(*types.Err)
+	return ok && arithmeticErrors[err.Error()]
+}
+
+// decorate applies the arithmetic policy to calls of the arithmetic operators
+func (l arithmeticLib) decorate(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+	call, ok := i.(interpreter.InterpretableCall)
+	if !ok {
+		return i, nil
+	}
+
+	switch call.Function() {
+	case operators.Add, operators.Subtract, operators.Multiply, operators.Divide, operators.Modulo, operators.Negate:
+		return &evalArithmetic{InterpretableCall: call, policy: l.policy}, nil
+	}
+	return i, nil
+}
+
+// evalArithmetic applies the arithmetic policy to the result of an arithmetic operator
+type evalArithmetic struct {
+	interpreter.InterpretableCall
+	policy ArithmeticPolicy
+}
+
+// Eval implements the interpreter.Interpretable interface.
+func (e *evalArithmetic) Eval(a interpreter.Activation) ref.Val {
+	v := e.InterpretableCall.Eval(a)
+	if !isArithmeticError(v) {
+		return v
+	}
+
+	// Evaluate the operands again to determine the outcome; this is only
+	// done when the operation fails
+	args := make([]ref.Val, len(e.Args()))
+	for i, arg := range e.Args() {
+		args[i] = arg.Eval(a)
+	}
+
+	if e.policy == ArithmeticDefault {
+		if _, ok := args[0].(types.Uint); ok {
+			return types.Uint(0)
+		}
+		return types.Int(0)
+	}
+	return saturate(e.Function(), args)
+}
+
+// saturate returns the largest or smallest value of the type, in the direction
+// of the failed operation
+func saturate(op string, args []ref.Val) ref.Val {
+	switch a := args[0].(type) {
+	case types.Int:
+		if op == operators.Negate {
+			return types.Int(math.MaxInt64)
+		}
+		b, ok := args[1].(types.Int)
+		if !ok {
+			return types.ValOrErr(args[1], "no such overload")
+		}
+
+		// The sign of the result of the operation
+		positive := true
+		switch op {
+		case operators.Add:
+			positive = b > 0
+		case operators.Subtract:
+			positive = b < 0
+		case operators.Multiply, operators.Divide:
+			positive = (a < 0) == (b < 0)
+			if op == operators.Divide && b == 0 {
+				if a == 0 {
+					return types.Int(0)
+				}
+				positive = a > 0
+			}
+		case operators.Modulo:
+			return types.Int(0)
+		}
+
+		if positive {
+			return types.Int(math.MaxInt64)
+		}
+		return types.Int(math.MinInt64)
+
+	case types.Uint:
+		switch op {
+		case operators.Subtract, operators.Modulo:
+			return types.Uint(0)
+		case operators.Divide:
+			if a == 0 {
+				return types.Uint(0)
+			}
+		}
+		return types.Uint(math.MaxUint64)
+	}
+	return types.ValOrErr(args[0], "no such overload")
+}
@@ -0,0 +1,244 @@
+package cel
+
+// This file contains the capabilities of functions, the sandbox refusing
+// expressions that call functions with capabilities that are not allowed,
+// and the deterministic mode.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Capability is a set of sensitive abilities that a function needs, such as access
+// to the network. Combine capabilities with |, as in CapNetwork|CapNondeterministic.
+type Capability uint
+
+const (
+	// The function accesses the network
+	CapNetwork Capability = 1 << iota
+
+	// The function accesses the file system
+	CapFilesystem
+
+	// The function may return different values for the same arguments, such as
+	// a function that depends on the current time or on external data
+	CapNondeterministic
+)
+
+// capabilityNames are the names of the capabilities, in order
+var capabilityNames = []struct {
+	c    Capability
+	name string
+}{
+	{CapNetwork, "network"},
+	{CapFilesystem, "filesystem"},
+	{CapNondeterministic, "nondeterministic"},
+}
+
+// String returns the names of the capabilities, as in "network|nondeterministic",
+// or "none".
+func (c Capability) String() string {
+	var names []string
+	for _, n := range capabilityNames {
+		if c&n.c != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// ErrCapabilityNotAllowed is returned when an expression calls a function whose
+// capabilities are not allowed by the evaluator's sandbox (see Sandbox), or whose
+// capabilities are not declared.
+var ErrCapabilityNotAllowed = errors.New("function capability not allowed")
+
+// builtinCapabilities are the capabilities of the overloads of the functions of this
+// package that have any, by overload ID
+var builtinCapabilities = map[string]Capability{
+	"age_timestamp":                          CapNondeterministic, // age(ts) depends on the current time
+	"lookup_string_string":                   CapNondeterministic,
+	"in_table_string_string":                 CapNondeterministic,
+	"window_count_string_string_duration":    CapNondeterministic,
+	"window_sum_string_string_duration":      CapNondeterministic,
+	"window_distinct_string_string_duration": CapNondeterministic,
+}
+
+// builtinFunctions are the functions of this package
+var builtinFunctions = []string{
+	"matches_any", "get", "default", "safe_div", "safe_mod", "parse_number",
+	"parse_date", "age", "days_between", "start_of_day", "is_business_day",
+	"levenshtein", "jaro_winkler", "similarity",
+	"geo_distance", "geo_in_polygon", "geohash", "geohash_contains",
+	"ip_in_cidr", "ip_in_any_cidr", "is_ip", "is_private_ip", "is_loopback_ip",
+	"lookup", "in_table", "window_count", "window_sum", "window_distinct",
+}
+
+// Functions adds custom functions to the environment used to compile every rule,
+// like EnvOptions, declaring the capabilities they need. names are the names of
+// the functions declared and implemented by opts, such as a celgo.Lib. Expressions
+// calling the functions are refused if the evaluator's sandbox does not allow
+// all the capabilities (see Sandbox); use 0 for functions that need none.
+func Functions(caps Capability, names []string, opts ...celgo.EnvOption) Option {
+	return func(e *Evaluator) {
+		if e.capabilities == nil {
+			e.capabilities = map[string]Capability{}
+		}
+		for _, name := range names {
+			e.capabilities[name] |= caps
+		}
+		e.envOpts = append(e.envOpts, opts...)
+	}
+}
+
+// Sandbox refuses to compile expressions that call functions needing capabilities
+// other than allowed, with an error wrapping ErrCapabilityNotAllowed. Functions added
+// with EnvOptions, rather than Functions, have no declared capabilities, and are refused.
+// The standard CEL functions need no capabilities; the functions of this package
+// need none, except:
+//
+//     age(ts), but not age(ts, at)                        nondeterministic
+//     lookup, in_table                                    nondeterministic
+//     window_count, window_sum, window_distinct           nondeterministic
+//
+// Without Sandbox, all functions are allowed, unless evaluations are deterministic
+// (see Deterministic).
+func Sandbox(allowed Capability) Option {
+	return func(e *Evaluator) {
+		e.sandboxed = true
+		e.allowed = allowed
+	}
+}
+
+// standardFunctions are the names of the standard CEL functions and operators
+var standardFunctions = func() map[string]bool {
+	m := map[string]bool{}
+	for _, d := range checker.StandardDeclarations() {
+		if d.GetFunction() != nil {
+			m[d.GetName()] = true
+		}
+	}
+	return m
+}()
+
+// capabilitiesOf returns the capabilities of the overloads with the IDs of the function
+// with the name, and false if they are not declared. The capabilities of custom
+// functions are declared for all their overloads.
+func (e *Evaluator) capabilitiesOf(name string, overloads ...string) (Capability, bool) {
+	if c, ok := e.capabilities[name]; ok {
+		return c, true
+	}
+	if standardFunctions[name] {
+		return 0, true
+	}
+	for _, f := range builtinFunctions {
+		if f == name {
+			var c Capability
+			for _, id := range overloads {
+				c |= builtinCapabilities[id]
+			}
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// Deterministic makes evaluations deterministic, so that the same input data always
+// yields the same result, as required to replay or backtest decisions, and to cache
+// them. The current time, used by age(ts), is fixed at now. Expressions calling
+// other functions that need CapNondeterministic, such as lookup, in_table and the
+// window functions, are refused with an error wrapping ErrCapabilityNotAllowed.
+func Deterministic(now time.Time) Option {
+	return func(e *Evaluator) {
+		e.deterministic = true
+		e.fixedNow = now
+	}
+}
+
+// stubbedOverloads are the overloads of nondeterministic functions made deterministic
+// by the Deterministic option, by overload ID
+var stubbedOverloads = map[string]bool{
+	"age_timestamp": true,
+}
+
+// stubbed reports whether all the overloads with the IDs are made deterministic
+func stubbed(overloads []string) bool {
+	for _, id := range overloads {
+		if !stubbedOverloads[id] {
+			return false
+		}
+	}
+	return len(overloads) > 0
+}
+
+// now returns the current time, or the fixed time if evaluations are deterministic
+func (e *Evaluator) now() time.Time {
+	if e.deterministic {
+		return e.fixedNow
+	}
+	return time.Now()
+}
+
+// checkCapabilities returns an error if the expression x calls a function
+// whose capabilities are not allowed, or not declared. refs are the references
+// of the checked expression, with the overloads that calls resolve to.
+func (e *Evaluator) checkCapabilities(x *gexpr.Expr, refs map[int64]*gexpr.Reference) error {
+	if x == nil {
+		return nil
+	}
+
+	switch k := x.GetExprKind().(type) {
+	case *gexpr.Expr_SelectExpr:
+		return e.checkCapabilities(k.SelectExpr.GetOperand(), refs)
+	case *gexpr.Expr_CallExpr:
+		name := k.CallExpr.GetFunction()
+		overloads := refs[x.GetId()].GetOverloadId()
+		caps, declared := e.capabilitiesOf(name, overloads...)
+		if !declared && e.sandboxed {
+			return fmt.Errorf("%w: %s has no declared capabilities", ErrCapabilityNotAllowed, name)
+		}
+
+		allowed := ^Capability(0)
+		if e.sandboxed {
+			allowed = e.allowed
+		}
+		if e.deterministic && !stubbed(overloads) {
+			allowed &^= CapNondeterministic
+		}
+		if denied := caps &^ allowed; denied != 0 {
+			return fmt.Errorf("%w: %s needs %s", ErrCapabilityNotAllowed, name, denied)
+		}
+		args := append([]*gexpr.Expr{k.CallExpr.GetTarget()}, k.CallExpr.GetArgs()...)
+		return e.checkAll(refs, args...)
+	case *gexpr.Expr_ListExpr:
+		return e.checkAll(refs, k.ListExpr.GetElements()...)
+	case *gexpr.Expr_StructExpr:
+		for _, en := range k.StructExpr.GetEntries() {
+			if err := e.checkAll(refs, en.GetMapKey(), en.GetValue()); err != nil {
+				return err
+			}
+		}
+	case *gexpr.Expr_ComprehensionExpr:
+		c := k.ComprehensionExpr
+		return e.checkAll(refs, c.GetIterRange(), c.GetAccuInit(), c.GetLoopCondition(), c.GetLoopStep(), c.GetResult())
+	}
+	return nil
+}
+
+// checkAll checks the capabilities of the functions called by the expressions
+func (e *Evaluator) checkAll(refs map[int64]*gexpr.Reference, xs ...*gexpr.Expr) error {
+	for _, x := range xs {
+		if err := e.checkCapabilities(x, refs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
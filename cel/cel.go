@@ -3,16 +3,69 @@ package cel
 import (
 	"fmt" // required by CEL to construct a proto from an expression
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ezachrisen/indigo"
 
 	celgo "github.com/google/cel-go/cel"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 // Evaluator implements the indigo.ExpressionEvaluator and indigo.ExpressionCompiler interfaces.
 // It uses the CEL-Go package to compile and evaluate rules.
-type Evaluator struct{}
+type Evaluator struct {
+	// Additional CEL environment options, such as function libraries,
+	// used when compiling every rule
+	envOpts []celgo.EnvOption
+
+	// The libraries of this package added to the environment (see library)
+	libraries []declaredLibrary
+
+	// The outcome of reading missing map keys and variables
+	missingKeys MissingKeyPolicy
+
+	// The declared capabilities of custom functions, by name, and the
+	// capabilities allowed by the sandbox, if any (see Sandbox)
+	capabilities map[string]Capability
+	sandboxed    bool
+	allowed      Capability
+
+	// Whether evaluations are deterministic, and the fixed current time
+	// if they are (see Deterministic)
+	deterministic bool
+	fixedNow      time.Time
+
+	// The CEL environments of the schemas compiled, by schema (see env)
+	envs *envCache
+}
+
+// Option is a functional option to specify the behavior of the evaluator.
+type Option func(e *Evaluator)
+
+// EnvOptions adds CEL environment options, such as declarations of custom functions
+// and celgo.Lib libraries, to the environment used to compile every rule.
+func EnvOptions(opts ...celgo.EnvOption) Option {
+	return func(e *Evaluator) {
+		e.envOpts = append(e.envOpts, opts...)
+	}
+}
+
+// declaredLibrary is a CEL library of this package, which describes its functions
+// (see Completions)
+type declaredLibrary interface {
+	celgo.Library
+	declarations() []*gexpr.Decl
+}
+
+// library adds the library l to the environment used to compile every rule
+func library(l declaredLibrary) Option {
+	return func(e *Evaluator) {
+		e.envOpts = append(e.envOpts, celgo.Lib(l))
+		e.libraries = append(e.libraries, l)
+	}
+}
 
 // celProgram holds a compiled CEL Program and
 // optionally an AST. The AST is used if we're collecting diagnostics
@@ -24,8 +77,11 @@ type celProgram struct {
 
 // NewEvaluator creates a new CEL Evaluator.
 // The evaluator contains internal data used to facilitate CEL expression evaluation.
-func NewEvaluator() *Evaluator {
-	e := Evaluator{}
+func NewEvaluator(opts ...Option) *Evaluator {
+	e := Evaluator{envs: &envCache{}}
+	for _, o := range opts {
+		o(&e)
+	}
 	return &e
 }
 
@@ -35,41 +91,60 @@ func NewEvaluator() *Evaluator {
 // type and symbol information in diagnostics.
 //
 // Any errors in compilation are returned with a nil program
-func (*Evaluator) Compile(expr string, s indigo.Schema, resultType indigo.Type, collectDiagnostics bool, _ bool) (interface{}, error) {
+func (e *Evaluator) Compile(expr string, s indigo.Schema, resultType indigo.Type, collectDiagnostics bool, _ bool) (interface{}, error) {
 
 	// A blank expression is ok, but it won't pass through the compilation
 	if expr == "" {
 		return nil, nil
 	}
 
-	prog := celProgram{}
-
-	// Convert from an Indigo schema to a set of CEL declarations (schema)
-	opts, err := convertIndigoSchemaToDeclarations(s)
+	prog, _, err := e.compile(expr, s, resultType, collectDiagnostics)
 	if err != nil {
 		return nil, err
 	}
+	return prog, nil
+}
 
-	env, err := celgo.NewEnv(opts...)
+// compile parses and checks the expression, and generates a CEL program.
+// Returns the program and the checked AST.
+func (e *Evaluator) compile(expr string, s indigo.Schema, resultType indigo.Type, collectDiagnostics bool) (celProgram, *celgo.Ast, error) {
+
+	prog := celProgram{}
+
+	env, err := e.env(s)
 	if err != nil {
-		return nil, err
+		return prog, nil, err
 	}
 
 	// Parse the rule expression to an AST
 	ast, iss := env.Parse(expr)
 	if iss != nil && iss.Err() != nil {
 		// Remove some wonky formatting from CEL's error message.
-		return nil, fmt.Errorf("parsing rule:\n%s", strings.ReplaceAll(fmt.Sprintf("%s", iss.Err()), "<input>:", ""))
+		return prog, nil, fmt.Errorf("parsing rule:\n%s", strings.ReplaceAll(fmt.Sprintf("%s", iss.Err()), "<input>:", ""))
 	}
 
 	// Type-check the parsed AST against the declarations
 	c, iss := env.Check(ast)
 	if iss != nil && iss.Err() != nil {
-		return nil, fmt.Errorf("checking rule:\n%w", iss.Err())
+		return prog, nil, fmt.Errorf("checking rule:\n%w", iss.Err())
+	}
+
+	if e.sandboxed || e.deterministic {
+		checked, err := celgo.AstToCheckedExpr(c)
+		if err != nil {
+			return prog, nil, fmt.Errorf("converting AST: %w", err)
+		}
+		if err := e.checkCapabilities(checked.GetExpr(), checked.GetReferenceMap()); err != nil {
+			return prog, nil, fmt.Errorf("checking rule: %w", err)
+		}
 	}
 
 	if err = doTypesMatch(c.ResultType(), resultType); err != nil {
-		return nil, fmt.Errorf("compiling: %w", err)
+		return prog, nil, fmt.Errorf("compiling: %w", err)
+	}
+
+	if err = precompileLists(c.Expr()); err != nil {
+		return prog, nil, fmt.Errorf("compiling: %w", err)
 	}
 
 	if collectDiagnostics {
@@ -81,12 +156,76 @@ func (*Evaluator) Compile(expr string, s indigo.Schema, resultType indigo.Type,
 		options = celgo.EvalOptions(celgo.OptTrackState)
 	}
 
-	prog.program, err = env.Program(c, options)
+	programOpts := []celgo.ProgramOption{options}
+	if e.missingKeys == MissingKeyZero {
+		checked, err := celgo.AstToCheckedExpr(c)
+		if err != nil {
+			return prog, nil, fmt.Errorf("generating program: %w", err)
+		}
+		programOpts = append(programOpts, celgo.CustomDecorator(missingKeyDecorator(checked.TypeMap)))
+	}
+
+	prog.program, err = env.Program(c, programOpts...)
 	if err != nil {
-		return nil, fmt.Errorf("generating program: %w", err)
+		return prog, nil, fmt.Errorf("generating program: %w", err)
 	}
 
-	return prog, nil
+	return prog, c, nil
+}
+
+// maxEnvs is the number of CEL environments cached by an evaluator
+const maxEnvs = 256
+
+// envCache holds the CEL environments of schemas, so that expressions compiled or
+// checked with the same schema share an environment, which is safe for concurrent use
+type envCache struct {
+	mu   sync.Mutex
+	envs map[string]*celgo.Env
+}
+
+// env returns the CEL environment of the schema s, declaring its elements and
+// the evaluator's functions
+func (e *Evaluator) env(s indigo.Schema) (*celgo.Env, error) {
+	key := schemaKey(s)
+	if e.envs != nil {
+		e.envs.mu.Lock()
+		env, ok := e.envs.envs[key]
+		e.envs.mu.Unlock()
+		if ok {
+			return env, nil
+		}
+	}
+
+	// Convert from an Indigo schema to a set of CEL declarations (schema)
+	opts, err := convertIndigoSchemaToDeclarations(s)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, celgo.Lib(regexLib{}), celgo.Lib(nullSafeLib{}))
+	env, err := celgo.NewEnv(append(opts, e.envOpts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.envs != nil {
+		e.envs.mu.Lock()
+		if e.envs.envs == nil || len(e.envs.envs) >= maxEnvs {
+			e.envs.envs = map[string]*celgo.Env{}
+		}
+		e.envs.envs[key] = env
+		e.envs.mu.Unlock()
+	}
+	return env, nil
+}
+
+// schemaKey returns a key identifying the declarations of the schema s
+func schemaKey(s indigo.Schema) string {
+	var b strings.Builder
+	for _, el := range s.Elements {
+		fmt.Fprintf(&b, "%s:%s\x00", el.Name, el.Type)
+	}
+	return b.String()
 }
 
 // Evaluate a rule against the input data.
@@ -2,16 +2,24 @@ package cel_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/ezachrisen/indigo"
 	"github.com/ezachrisen/indigo/cel"
 	"github.com/ezachrisen/indigo/testdata/school"
+	"github.com/ezachrisen/indigo/window"
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
 	"github.com/google/cel-go/common/types/pb"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
 
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/matryer/is"
@@ -502,6 +510,50 @@ func TestDiagnosticGeneration(t *testing.T) {
 
 }
 
+// errorLogger records the arguments of the errors logged
+type errorLogger struct {
+	errs []string
+}
+
+func (l *errorLogger) Debug(msg string, _ ...interface{}) {}
+func (l *errorLogger) Info(msg string, _ ...interface{})  {}
+func (l *errorLogger) Warn(msg string, _ ...interface{})  {}
+func (l *errorLogger) Error(msg string, args ...interface{}) {
+	l.errs = append(l.errs, fmt.Sprint(args...))
+}
+
+func TestSensitiveRedaction(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{Elements: []indigo.DataElement{
+		{Name: "amount", Type: indigo.Int{}},
+		{Name: "pin", Type: indigo.String{}, Sensitivity: indigo.SensitivitySecret},
+	}}
+	e := indigo.NewEngine(cel.NewEvaluator(cel.Network()))
+	l := &errorLogger{}
+	e.SetLogger(l, 0)
+
+	r := &indigo.Rule{ID: "r", Schema: schema, Expr: `amount > 10 && pin != "0000"`}
+	is.NoErr(e.Compile(r, indigo.CollectDiagnostics(true)))
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{"amount": 50, "pin": "8675309"}, indigo.ReturnDiagnostics(true))
+	is.NoErr(err)
+	is.True(u.Pass)
+	diag := u.Diagnostics.String()
+	is.True(!strings.Contains(diag, "8675309"))
+	is.True(strings.Contains(diag, indigo.Redacted))
+	is.True(strings.Contains(diag, "50"))
+
+	// The value of the pin is in the error
+	bad := &indigo.Rule{ID: "bad", Schema: schema, Expr: `is_private_ip(pin)`}
+	is.NoErr(e.Compile(bad))
+	_, err = e.Eval(context.Background(), bad, map[string]interface{}{"amount": 50, "pin": "12x45"})
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "12x45")) // returned as is
+	is.Equal(len(l.errs), 1)
+	is.True(!strings.Contains(l.errs[0], "12x45"))
+	is.True(strings.Contains(l.errs[0], indigo.Redacted))
+}
+
 // ------------------------------------------------------------------------------------------
 // BENCHMARKS
 //
@@ -540,6 +592,30 @@ func BenchmarkSimpleRule(b *testing.B) {
 	}
 }
 
+func BenchmarkLeafRule(b *testing.B) {
+	education := makeEducationSchema()
+	data := makeStudentData()
+
+	r := &indigo.Rule{
+		ID:     "at_risk",
+		Schema: education,
+		Expr:   `student.GPA < 2.5 || student.Status == "Probation"`,
+	}
+	e := indigo.NewEngine(cel.NewEvaluator())
+	if err := e.Compile(r); err != nil {
+		b.Fatalf("Error compiling rule: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := e.Eval(context.Background(), r, data)
+		if err != nil {
+			b.Error(err)
+		}
+	}
+}
+
 func BenchmarkSimpleRuleWithDiagnostics(b *testing.B) {
 
 	e := indigo.NewEngine(cel.NewEvaluator())
@@ -762,6 +838,14 @@ func BenchmarkProtoCreation(b *testing.B) {
 }
 
 func BenchmarkEval2000Rules(b *testing.B) {
+	benchmarkEval2000Rules(b, false)
+}
+
+func BenchmarkEval2000RulesPooled(b *testing.B) {
+	benchmarkEval2000Rules(b, true)
+}
+
+func benchmarkEval2000Rules(b *testing.B, pooled bool) {
 	b.StopTimer()
 	_, err := pb.DefaultDb.RegisterMessage(&school.Student{})
 	if err != nil {
@@ -813,13 +897,16 @@ func BenchmarkEval2000Rules(b *testing.B) {
 		"student": &s,
 		"now":     &timestamp.Timestamp{Seconds: time.Now().Unix()},
 	}
+	b.ReportAllocs()
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := e.Eval(context.Background(), r, data)
+		u, err := e.Eval(context.Background(), r, data, indigo.PoolResults(pooled))
 		if err != nil {
 			b.Error(err)
 		}
-
+		if pooled {
+			u.Release()
+		}
 	}
 }
 
@@ -833,3 +920,2047 @@ func BenchmarkCompileRule(b *testing.B) {
 		is.NoErr(err)
 	}
 }
+
+// Test that the analyzer reports the variables used and the cost of an expression,
+// and that a bundle report can be produced with the CEL evaluator
+func TestAnalyze(t *testing.T) {
+	is := is.New(t)
+
+	e := cel.NewEvaluator()
+	info, err := e.Analyze(`student.GPA >= 3.6 && !("C" in student.Grades)`, makeEducationSchema(), nil)
+	is.NoErr(err)
+	is.Equal(info.ReferencedVariables, []string{"student.GPA", "student.Grades"})
+	is.True(info.MaxCost > 0)
+	is.True(info.Size > 0)
+
+	_, err = e.Analyze(`student.GPA + 1`, makeEducationSchema(), indigo.Bool{})
+	is.True(err != nil) // wrong result type
+
+	b, err := indigo.Export(makeEducationRules1())
+	is.NoErr(err)
+	rep, err := indigo.NewEngine(e).CheckBundle(b)
+	is.NoErr(err)
+	is.Equal(rep.Failed, 0)
+	is.Equal(len(rep.Rules), 22)
+	is.True(rep.TotalSize > 0)
+}
+
+// Test generating plain-English explanations of expressions
+func TestExplain(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "order.amount", Type: indigo.Float{}, Description: "Order Amount"},
+			{Name: "order.count", Type: indigo.Int{}},
+			{Name: "country", Type: indigo.String{}, Description: "Country"},
+			{Name: "eu", Type: indigo.List{ValueType: indigo.String{}}, Description: "the EU list"},
+			{Name: "vip", Type: indigo.Bool{}, Description: "VIP"},
+		},
+	}
+
+	cases := []struct {
+		expr       string
+		resultType indigo.Type
+		want       string
+	}{
+		{`order.amount > 1000.0 && country in eu`, nil, "Passes when Order Amount is greater than 1,000 and Country is in the EU list"},
+		{`vip || (order.count >= 10 && country != "SE")`, nil, `Passes when VIP is true or (order.count is at least 10 and Country is not "SE")`},
+		{`!(country in ["SE", "NO"])`, nil, `Passes when it is not the case that Country is in ["SE", "NO"]`},
+		{`eu.exists(c, c.startsWith("S"))`, nil, `Passes when some c in the EU list satisfies: c starts with "S"`},
+		{`size(eu) <= 27`, nil, "Passes when the size of the EU list is at most 27"},
+		{`order.amount * 1.25`, indigo.Float{}, "Returns Order Amount times 1.25"},
+		{``, nil, "Always passes"},
+	}
+
+	e := cel.NewEvaluator()
+	for _, c := range cases {
+		got, err := e.Explain(c.expr, schema, c.resultType)
+		is.NoErr(err)
+		is.Equal(got, c.want)
+	}
+
+	_, err := e.Explain(`nope > 1`, schema, nil)
+	is.True(err != nil)
+}
+
+func TestWindows(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := window.NewStore(time.Hour, window.Clock(func() time.Time { return now }))
+
+	for i := 0; i < 12; i++ {
+		store.Record("logins", "u1", now.Add(-time.Duration(i)*time.Minute), fmt.Sprintf("10.0.0.%d", i%3))
+	}
+	store.Record("logins", "u2", now, "10.0.0.1")
+	store.Record("payments", "u1", now, 250.5)
+	store.Record("payments", "u1", now.Add(-2*time.Hour), 1000)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "user_id", Type: indigo.String{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "fraud",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"many_logins": {
+				ID:     "many_logins",
+				Schema: schema,
+				Expr:   `window_count("logins", user_id, duration("5m")) > 3`,
+			},
+			"many_ips": {
+				ID:     "many_ips",
+				Schema: schema,
+				Expr:   `window_distinct("logins", user_id, duration("1h")) >= 3`,
+			},
+			"large_total": {
+				ID:     "large_total",
+				Schema: schema,
+				Expr:   `window_sum("payments", user_id, duration("24h")) > 1000.0`,
+			},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator(cel.Windows(store)))
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{"user_id": "u1"})
+	is.NoErr(err)
+	is.True(u.Results["many_logins"].Pass)
+	is.True(u.Results["many_ips"].Pass)
+	is.True(!u.Results["large_total"].Pass) // the 1000 payment is older than the store's maximum age
+
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{"user_id": "u2"})
+	is.NoErr(err)
+	is.True(!u.Results["many_logins"].Pass)
+	is.True(!u.Results["many_ips"].Pass)
+
+	// Without the window functions, the rules don't compile
+	e = indigo.NewEngine(cel.NewEvaluator())
+	is.True(e.Compile(r) != nil)
+}
+
+func TestChain(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "score", Type: indigo.Int{}},
+			{Name: "high_risk", Type: indigo.Bool{}},
+			{Name: "eligible", Type: indigo.Bool{}},
+		},
+	}
+
+	// Rules are evaluated alphabetically, so the facts derived by
+	// later rules are only available to earlier rules in the next iteration
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"eligible": {
+				ID:     "eligible",
+				Schema: schema,
+				Output: "eligible",
+				Expr:   `!high_risk && score > 600`,
+			},
+			"offer": {
+				ID:     "offer",
+				Schema: schema,
+				Expr:   `eligible`,
+			},
+			"risk": {
+				ID:     "risk",
+				Schema: schema,
+				Output: "high_risk",
+				Expr:   `amount > 1000`,
+			},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r))
+
+	d := map[string]interface{}{"amount": 5000, "score": 700}
+	u, facts, err := indigo.Chain(context.Background(), e, r, d, 10)
+	is.NoErr(err)
+	is.Equal(facts, map[string]interface{}{"high_risk": true, "eligible": false})
+	is.True(!u.Results["offer"].Pass)
+	is.Equal(len(d), 2) // the input data is not modified
+
+	d = map[string]interface{}{"amount": 50, "score": 700}
+	u, facts, err = indigo.Chain(context.Background(), e, r, d, 10)
+	is.NoErr(err)
+	is.Equal(facts, map[string]interface{}{"high_risk": false, "eligible": true})
+	is.True(u.Results["offer"].Pass)
+
+	// A rule that contradicts itself never reaches a fixpoint
+	r.Rules["flip"] = &indigo.Rule{
+		ID:     "flip",
+		Schema: schema,
+		Output: "eligible",
+		Expr:   `!eligible`,
+	}
+	delete(r.Rules, "eligible")
+	is.NoErr(e.Compile(r))
+	_, _, err = indigo.Chain(context.Background(), e, r, d, 10)
+	is.True(errors.Is(err, indigo.ErrNoFixpoint))
+}
+
+func TestPipeline(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "country", Type: indigo.String{}},
+			{Name: "valid", Type: indigo.Bool{}},
+			{Name: "fraud_verdict", Type: indigo.String{}},
+			{Name: "discount", Type: indigo.Float{}},
+		},
+	}
+
+	validation := &indigo.Rule{
+		ID:     "validation",
+		Schema: schema,
+		Expr:   `amount > 0`,
+	}
+
+	fraud := &indigo.Rule{
+		ID:     "fraud",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"large": {
+				ID:       "large",
+				Schema:   schema,
+				Expr:     `valid && amount > 10000`,
+				Metadata: indigo.RuleMetadata{Severity: indigo.SeverityDeny},
+			},
+			"foreign": {
+				ID:       "foreign",
+				Schema:   schema,
+				Expr:     `country != "SE"`,
+				Metadata: indigo.RuleMetadata{Severity: indigo.SeverityWarn},
+			},
+		},
+	}
+
+	pricing := &indigo.Rule{
+		ID:     "pricing",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"discount": {
+				ID:         "discount",
+				Schema:     schema,
+				Output:     "discount",
+				ResultType: indigo.Float{},
+				Expr:       `fraud_verdict == "warn" ? 0.0 : 0.1`,
+			},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	for _, r := range []*indigo.Rule{validation, fraud, pricing} {
+		is.NoErr(e.Compile(r))
+	}
+
+	p, err := indigo.NewPipeline(e,
+		indigo.Stage{Name: "validation", Rule: validation, PassOutput: "valid", StopIf: indigo.StopIfFail},
+		indigo.Stage{Name: "fraud", Rule: fraud, VerdictOutput: "fraud_verdict", StopIf: indigo.StopAtSeverity(indigo.SeverityDeny)},
+		indigo.Stage{Name: "pricing", Rule: pricing},
+	)
+	is.NoErr(err)
+
+	pr, err := p.Eval(context.Background(), map[string]interface{}{"amount": 100, "country": "NO"})
+	is.NoErr(err)
+	is.Equal(pr.StoppedAt, "")
+	is.Equal(len(pr.Stages), 3)
+	is.Equal(pr.Verdict, indigo.SeverityWarn)
+	is.Equal(pr.Facts, map[string]interface{}{"valid": true, "fraud_verdict": "warn", "discount": 0.0})
+
+	pr, err = p.Eval(context.Background(), map[string]interface{}{"amount": 100, "country": "SE"})
+	is.NoErr(err)
+	is.Equal(pr.Facts["discount"], 0.1)
+
+	pr, err = p.Eval(context.Background(), map[string]interface{}{"amount": 20000, "country": "SE"})
+	is.NoErr(err)
+	is.Equal(pr.StoppedAt, "fraud")
+	is.Equal(pr.Verdict, indigo.SeverityDeny)
+	is.Equal(len(pr.Stages), 2)
+
+	pr, err = p.Eval(context.Background(), map[string]interface{}{"amount": -1, "country": "SE"})
+	is.NoErr(err)
+	is.Equal(pr.StoppedAt, "validation")
+	is.Equal(len(pr.Stages), 1)
+}
+
+func TestCached(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "country", Type: indigo.String{}},
+			{Name: "request_id", Type: indigo.String{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"large": {ID: "large", Schema: schema, Expr: `amount > 1000`},
+			"local": {ID: "local", Schema: schema, Expr: `country == "SE"`},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r))
+
+	lru := indigo.NewLRUCache(2)
+	c, err := e.Cached(r, lru, time.Minute)
+	is.NoErr(err)
+
+	// request_id is not referenced by the rules, so it is not part of the key
+	u1, err := c.Eval(context.Background(), map[string]interface{}{"amount": 5000, "country": "SE", "request_id": "a"})
+	is.NoErr(err)
+	u2, err := c.Eval(context.Background(), map[string]interface{}{"amount": 5000, "country": "SE", "request_id": "b"})
+	is.NoErr(err)
+	is.True(u1 == u2)
+	is.Equal(lru.Len(), 1)
+
+	u3, err := c.Eval(context.Background(), map[string]interface{}{"amount": 50, "country": "SE"})
+	is.NoErr(err)
+	is.True(u3 != u1)
+	is.True(!u3.Results["large"].Pass)
+
+	// Options are part of the key
+	u4, err := c.Eval(context.Background(), map[string]interface{}{"amount": 50, "country": "SE"}, indigo.DiscardFail(true))
+	is.NoErr(err)
+	is.Equal(len(u4.Results), 1)
+
+	// The least recently used result was removed
+	is.Equal(lru.Len(), 2)
+	u5, err := c.Eval(context.Background(), map[string]interface{}{"amount": 5000, "country": "SE"})
+	is.NoErr(err)
+	is.True(u5 != u1)
+
+	lru.Clear()
+	is.Equal(lru.Len(), 0)
+}
+
+func TestIncremental(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "x", Type: indigo.Int{}},
+			{Name: "y", Type: indigo.Int{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"a":   {ID: "a", Schema: schema, Expr: `x > 1`},
+			"b":   {ID: "b", Schema: schema, Expr: `y > 1`},
+			"sum": {ID: "sum", Schema: schema, Expr: `x + y > 5`},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r))
+
+	inc, err := e.Incremental(r)
+	is.NoErr(err)
+
+	u, err := inc.Eval(context.Background(), map[string]interface{}{"x": 1, "y": 1})
+	is.NoErr(err)
+	is.Equal(inc.Evaluated(), 4)
+	is.Equal(flatten(u), map[string]bool{"root": true, "a": false, "b": false, "sum": false})
+
+	// Only the rules referring to y are evaluated
+	u, err = inc.Eval(context.Background(), map[string]interface{}{"x": 1, "y": 5})
+	is.NoErr(err)
+	is.Equal(inc.Evaluated(), 2)
+	is.Equal(flatten(u), map[string]bool{"root": true, "a": false, "b": true, "sum": true})
+
+	// Nothing changed
+	u, err = inc.Eval(context.Background(), map[string]interface{}{"x": 1, "y": 5})
+	is.NoErr(err)
+	is.Equal(inc.Evaluated(), 0)
+	is.Equal(flatten(u), map[string]bool{"root": true, "a": false, "b": true, "sum": true})
+
+	// The results are the same as evaluating everything
+	d := map[string]interface{}{"x": 3, "y": 5}
+	u, err = inc.Eval(context.Background(), d)
+	is.NoErr(err)
+	is.Equal(inc.Evaluated(), 2)
+	u2, err := e.Eval(context.Background(), r, d)
+	is.NoErr(err)
+	is.Equal(flatten(u), flatten(u2))
+}
+
+// flatten returns a map of rule ID to pass/fail for the result and its children
+func flatten(u *indigo.Result) map[string]bool {
+	m := map[string]bool{u.Rule.ID: u.Pass}
+	for _, c := range u.Results {
+		for k, v := range flatten(c) {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+func TestDiscriminant(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "country", Type: indigo.String{}},
+			{Name: "amount", Type: indigo.Int{}},
+		},
+	}
+
+	e := cel.NewEvaluator()
+	cases := []struct {
+		expr  string
+		value string
+		ok    bool
+	}{
+		{`country == "SE"`, "SE", true},
+		{`amount > 100 && "NO" == country`, "NO", true},
+		{`amount > 100 && (country == "DK" && amount < 500)`, "DK", true},
+		{`country == "SE" || country == "NO"`, "", false},
+		{`amount == 10`, "", false},
+		{`country != "SE"`, "", false},
+	}
+
+	for _, c := range cases {
+		v, ok, err := e.Discriminant(c.expr, schema, "country")
+		is.NoErr(err)
+		is.Equal(ok, c.ok)
+		is.Equal(v, c.value)
+	}
+
+	v, ok, err := e.Discriminant(`amount == 10`, schema, "amount")
+	is.NoErr(err)
+	is.True(ok)
+	is.Equal(v, "10")
+
+	// Child rules are indexed automatically
+	r := &indigo.Rule{
+		ID:      "root",
+		IndexBy: "country",
+		Rules:   map[string]*indigo.Rule{},
+	}
+	for _, c := range []string{"SE", "NO", "DK"} {
+		r.Rules[c] = &indigo.Rule{ID: c, Schema: schema, Expr: fmt.Sprintf(`country == %q && amount > 10`, c)}
+	}
+
+	engine := indigo.NewEngine(e)
+	is.NoErr(engine.Compile(r))
+	u, err := engine.Eval(context.Background(), r, map[string]interface{}{"country": "NO", "amount": 50})
+	is.NoErr(err)
+	is.Equal(len(u.Results), 1)
+	is.True(u.Results["NO"].Pass)
+
+	// Child rules are indexed by a key of a map, or a field of a message
+	nested := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "customer", Type: indigo.Map{KeyType: indigo.String{}, ValueType: indigo.String{}}},
+			{Name: "student", Type: indigo.Proto{Message: &school.Student{}}},
+		},
+	}
+	r = &indigo.Rule{
+		ID:      "root",
+		IndexBy: "customer.country",
+		Rules:   map[string]*indigo.Rule{},
+	}
+	for _, c := range []string{"SE", "NO", "DK"} {
+		r.Rules[c] = &indigo.Rule{ID: c, Schema: nested, Expr: fmt.Sprintf(`customer.country == %q`, c)}
+	}
+	is.NoErr(engine.Compile(r))
+	u, err = engine.Eval(context.Background(), r, map[string]interface{}{"customer": map[string]interface{}{"country": "NO"}})
+	is.NoErr(err)
+	is.Equal(len(u.Results), 1)
+	is.True(u.Results["NO"].Pass)
+
+	r = &indigo.Rule{
+		ID:      "root",
+		IndexBy: "student.gpa",
+		Rules: map[string]*indigo.Rule{
+			"good": {ID: "good", Schema: nested, Expr: `student.gpa == 3.5`},
+			"poor": {ID: "poor", Schema: nested, Expr: `student.gpa == 1.5`},
+		},
+	}
+	is.NoErr(engine.Compile(r))
+	u, err = engine.Eval(context.Background(), r, map[string]interface{}{"student": &school.Student{Gpa: 3.5}})
+	is.NoErr(err)
+	is.Equal(len(u.Results), 1)
+	is.True(u.Results["good"].Pass)
+}
+
+// makeSiblingRules makes a rule with n simple child rules
+func makeSiblingRules(n int) *indigo.Rule {
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "x", Type: indigo.Int{}},
+			{Name: "zero", Type: indigo.Int{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules:  map[string]*indigo.Rule{},
+	}
+
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("r%04d", i)
+		r.Rules[id] = &indigo.Rule{ID: id, Schema: schema, Expr: fmt.Sprintf("x > %d", i)}
+	}
+	return r
+}
+
+func TestCombineSiblings(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	r := makeSiblingRules(10)
+	r.Rules["parent"] = &indigo.Rule{
+		ID:     "parent",
+		Schema: r.Schema,
+		Expr:   `x > 0`,
+		Rules: map[string]*indigo.Rule{
+			"c1": {ID: "c1", Schema: r.Schema, Expr: `x > 4`},
+			"c2": {ID: "c2", Schema: r.Schema, Expr: `x < 4`},
+		},
+	}
+
+	d := map[string]interface{}{"x": 5, "zero": 0}
+
+	is.NoErr(e.Compile(r))
+	want, err := e.Eval(context.Background(), r, d)
+	is.NoErr(err)
+
+	is.NoErr(e.Compile(r, indigo.CombineSiblings(true)))
+	got, err := e.Eval(context.Background(), r, d)
+	is.NoErr(err)
+	is.Equal(flatten(got), flatten(want))
+	is.Equal(got.EvaluationCount, want.EvaluationCount)
+
+	// An error in one rule fails the combined program; the error is
+	// reported for the rule that caused it
+	r.Rules["r0003"].Expr = `10 / zero == 1`
+	is.NoErr(e.Compile(r, indigo.CombineSiblings(true)))
+	got, err = e.Eval(context.Background(), r, d, indigo.ContinueOnError(true))
+	is.NoErr(err)
+	is.Equal(got.Results["r0003"].Status, indigo.StatusError)
+	is.Equal(got.Results["r0004"].Pass, true)
+	is.Equal(got.Results["r0005"].Pass, false)
+}
+
+func BenchmarkEval2000SiblingRules(b *testing.B) {
+	e := indigo.NewEngine(cel.NewEvaluator())
+	r := makeSiblingRules(2000)
+	if err := e.Compile(r); err != nil {
+		b.Fatal(err)
+	}
+
+	d := map[string]interface{}{"x": 1000, "zero": 0}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.Eval(context.Background(), r, d); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEval2000SiblingRulesCombined(b *testing.B) {
+	e := indigo.NewEngine(cel.NewEvaluator())
+	r := makeSiblingRules(2000)
+	if err := e.Compile(r, indigo.CombineSiblings(true)); err != nil {
+		b.Fatal(err)
+	}
+
+	d := map[string]interface{}{"x": 1000, "zero": 0}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.Eval(context.Background(), r, d); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStatsAndSharedPrograms(t *testing.T) {
+	is := is.New(t)
+
+	r := makeSiblingRules(3)
+	for i := 0; i < 3; i++ {
+		c := makeSiblingRules(3)
+		c.ID = fmt.Sprintf("copy%d", i)
+		r.Rules[c.ID] = c
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r))
+
+	st, err := e.Stats(r)
+	is.NoErr(err)
+	is.Equal(st.Rules, 16)
+	is.Equal(st.Compiled, 12) // the root rules have no expressions
+	is.Equal(st.Programs, 12)
+	is.True(st.ProgramBytes > 0)
+	is.Equal(st.SharedPrograms, 0)
+
+	is.NoErr(e.Compile(r, indigo.SharePrograms(true)))
+	shared, err := e.Stats(r)
+	is.NoErr(err)
+	is.Equal(shared.Compiled, 12)
+	is.Equal(shared.Programs, 3)
+	is.Equal(shared.SharedPrograms, 3)
+	is.Equal(shared.ProgramBytes*4, st.ProgramBytes)
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{"x": 2, "zero": 0})
+	is.NoErr(err)
+	is.True(u.Results["copy1"].Results["r0001"].Pass)
+	is.True(!u.Results["copy1"].Results["r0002"].Pass)
+
+	e.ClearSharedPrograms()
+	shared, err = e.Stats(r)
+	is.NoErr(err)
+	is.Equal(shared.SharedPrograms, 0)
+	is.Equal(shared.String(), fmt.Sprintf("rules: 16, compiled: 12, programs: 3 (~%d bytes)", shared.ProgramBytes))
+}
+
+// Test converting JSON-decoded data to the types of the schema
+func TestCoerceData(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "rate", Type: indigo.Float{}},
+			{Name: "placed", Type: indigo.Timestamp{}},
+			{Name: "wait", Type: indigo.Duration{}},
+			{Name: "counts", Type: indigo.List{ValueType: indigo.Int{}}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Expr: `amount > 1000 && rate > 0.5 && placed < timestamp("2021-01-01T00:00:00Z") &&
+		       wait > duration("1h") && counts[0] == 3`,
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r))
+
+	d := map[string]interface{}{}
+	is.NoErr(json.Unmarshal([]byte(`{"amount":5000,"rate":1,"placed":"2020-06-01T12:00:00Z","wait":"1h30m","counts":[3,4]}`), &d))
+
+	_, err := e.Eval(context.Background(), r, d)
+	is.True(err != nil) // amount is a float64
+
+	u, err := e.Eval(context.Background(), r, d, indigo.CoerceData(true))
+	is.NoErr(err)
+	is.True(u.Pass)
+	is.Equal(d["amount"], 5000.0) // not modified
+
+	d["amount"] = 1000.5
+	_, err = e.Eval(context.Background(), r, d, indigo.CoerceData(true))
+	is.True(err != nil)
+}
+
+// Test finding the rules broken by a new version of a schema
+func TestCheckSchema(t *testing.T) {
+	is := is.New(t)
+
+	v1 := indigo.Schema{
+		ID:      "orders",
+		Version: "v1",
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "country", Type: indigo.String{}},
+			{Name: "placed", Type: indigo.Timestamp{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: v1,
+		Rules: map[string]*indigo.Rule{
+			"large":  {ID: "large", Schema: v1, Expr: `amount > 1000`},
+			"local":  {ID: "local", Schema: v1, Expr: `country == "SE"`},
+			"recent": {ID: "recent", Schema: v1, Expr: `placed > timestamp("2021-01-01T00:00:00Z")`},
+			"other":  {ID: "other", Expr: `true`},
+		},
+	}
+
+	v2 := indigo.Schema{
+		ID:      "orders",
+		Version: "v2",
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Float{}},
+			{Name: "country_code", Type: indigo.String{}},
+			{Name: "placed", Type: indigo.Timestamp{}},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	rep, err := e.CheckSchema(r, v2)
+	is.NoErr(err)
+	is.Equal(rep.From, "v1")
+	is.Equal(rep.To, "v2")
+	is.Equal(rep.Checked, 4)
+
+	changes := []string{}
+	for _, c := range rep.Changes {
+		changes = append(changes, c.String())
+	}
+	is.Equal(changes, []string{
+		"amount changed from int to float",
+		"country removed (string), renamed to country_code?",
+		"country_code added (string)",
+	})
+
+	is.Equal(len(rep.Broken), 2)
+	is.Equal(rep.Broken[0].RuleID, "large")
+	is.Equal(rep.Broken[0].Elements, []string{"amount"})
+	is.Equal(rep.Broken[1].RuleID, "local")
+	is.Equal(rep.Broken[1].Elements, []string{"country"})
+	is.True(strings.Contains(rep.String(), "2 broken"))
+}
+
+// Test rules referring to schemas registered with the engine
+func TestRegisterSchema(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.True(e.RegisterSchema(indigo.Schema{}) != nil)
+	is.NoErr(e.RegisterSchema(indigo.Schema{
+		ID:       "orders",
+		Elements: []indigo.DataElement{{Name: "amount", Type: indigo.Int{}}},
+	}))
+
+	r := &indigo.Rule{
+		ID:       "root",
+		SchemaID: "orders",
+		Rules: map[string]*indigo.Rule{
+			"large": {ID: "large", SchemaID: "orders", Expr: `amount > 1000`},
+		},
+	}
+	is.NoErr(e.Compile(r))
+	is.Equal(r.Rules["large"].Schema.ID, "orders")
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{"amount": 5000})
+	is.NoErr(err)
+	is.True(u.Results["large"].Pass)
+
+	// A new version of the schema is used when the rules are recompiled
+	is.NoErr(e.RegisterSchema(indigo.Schema{
+		ID:       "orders",
+		Elements: []indigo.DataElement{{Name: "amount", Type: indigo.Float{}}},
+	}))
+	err = e.Compile(r)
+	is.True(err != nil) // amount > 1000 compares a double to an int
+
+	r.Rules["large"].SchemaID = "missing"
+	err = e.Compile(r)
+	is.True(errors.Is(err, indigo.ErrSchemaNotFound))
+}
+
+// Test reference data added to every evaluation
+func TestConstants(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "country", Type: indigo.String{}},
+			{Name: "blocked", Type: indigo.List{ValueType: indigo.String{}}},
+		},
+	}
+	r := &indigo.Rule{ID: "blocked", Schema: schema, Expr: `country in blocked`}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r))
+	e.SetConstants(map[string]interface{}{"blocked": []string{"XX", "YY"}})
+
+	d := map[string]interface{}{"country": "XX"}
+	u, err := e.Eval(context.Background(), r, d)
+	is.NoErr(err)
+	is.True(u.Pass)
+	is.Equal(len(d), 1) // not modified
+
+	// Input data takes precedence
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{"country": "XX", "blocked": []string{}})
+	is.NoErr(err)
+	is.True(!u.Pass)
+
+	e.SetConstants(map[string]interface{}{"blocked": []string{"YY"}})
+	u, err = e.Eval(context.Background(), r, d)
+	is.NoErr(err)
+	is.True(!u.Pass)
+
+	e.SetConstants(nil)
+	is.Equal(len(e.Constants()), 0)
+	_, err = e.Eval(context.Background(), r, d)
+	is.True(err != nil)
+}
+
+// countingProvider counts the lookups made in its tables
+type countingProvider struct {
+	cel.Tables
+	calls int32
+}
+
+func (p *countingProvider) Lookup(table, key string) (interface{}, bool, error) {
+	atomic.AddInt32(&p.calls, 1)
+	if table == "broken" {
+		return nil, false, fmt.Errorf("table unavailable")
+	}
+	return p.Tables.Lookup(table, key)
+}
+
+// Test looking up reference data from rules
+func TestLookup(t *testing.T) {
+	is := is.New(t)
+
+	p := &countingProvider{Tables: cel.Tables{
+		"sanctions": {"Mallory": true},
+		"catalog":   {"sku-1": map[string]interface{}{"price": 150.0}},
+	}}
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "name", Type: indigo.String{}},
+			{Name: "sku", Type: indigo.String{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"sanctioned": {ID: "sanctioned", Schema: schema, Expr: `in_table("sanctions", name)`},
+			"expensive":  {ID: "expensive", Schema: schema, Expr: `lookup("catalog", sku).price > 100.0`},
+			"missing":    {ID: "missing", Schema: schema, Expr: `lookup("catalog", name) == null`},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator(cel.Lookup(p, time.Minute)))
+	is.NoErr(e.Compile(r))
+
+	for _, name := range []string{"Mallory", "Alice", "Mallory"} {
+		u, err := e.Eval(context.Background(), r, map[string]interface{}{"name": name, "sku": "sku-1"})
+		is.NoErr(err)
+		is.Equal(u.Results["sanctioned"].Pass, name == "Mallory")
+		is.True(u.Results["expensive"].Pass)
+		is.True(u.Results["missing"].Pass)
+	}
+	is.Equal(atomic.LoadInt32(&p.calls), int32(5)) // 2 names in 2 tables, and sku-1; the rest are cached
+
+	broken := &indigo.Rule{ID: "broken", Schema: schema, Expr: `in_table("broken", name)`}
+	is.NoErr(e.Compile(broken))
+	_, err := e.Eval(context.Background(), broken, map[string]interface{}{"name": "Alice"})
+	is.True(err != nil)
+}
+
+// Test matching regular expressions, with constant and variable patterns
+func TestRegex(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "email", Type: indigo.String{}},
+			{Name: "patterns", Type: indigo.List{ValueType: indigo.String{}}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"matches":     {ID: "matches", Schema: schema, Expr: `email.matches("@example\\.com$")`},
+			"any":         {ID: "any", Schema: schema, Expr: `email.matches_any(["^admin@", "@test\\.org$"])`},
+			"any_global":  {ID: "any_global", Schema: schema, Expr: `matches_any(email, ["^alice@"])`},
+			"any_dynamic": {ID: "any_dynamic", Schema: schema, Expr: `email.matches_any(patterns)`},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r, indigo.CollectDiagnostics(true)))
+
+	cases := []struct {
+		email string
+		pass  map[string]bool
+	}{
+		{"alice@example.com", map[string]bool{"matches": true, "any": false, "any_global": true, "any_dynamic": true}},
+		{"admin@test.org", map[string]bool{"matches": false, "any": true, "any_global": false, "any_dynamic": false}},
+	}
+
+	for _, c := range cases {
+		d := map[string]interface{}{"email": c.email, "patterns": []string{"example"}}
+		u, err := e.Eval(context.Background(), r, d, indigo.ReturnDiagnostics(true))
+		is.NoErr(err)
+		for id, pass := range c.pass {
+			is.Equal(u.Results[id].Pass, pass)
+		}
+	}
+
+	// Invalid patterns are reported when the rule is compiled...
+	bad := &indigo.Rule{ID: "bad", Schema: schema, Expr: `email.matches("(")`}
+	is.True(e.Compile(bad) != nil)
+	bad.Expr = `email.matches_any(["a", "("])`
+	is.True(e.Compile(bad) != nil)
+
+	// ... unless they come from the data
+	_, err := e.Eval(context.Background(), r, map[string]interface{}{"email": "x", "patterns": []string{"("}})
+	is.True(err != nil)
+}
+
+func BenchmarkRegex(b *testing.B) {
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{{Name: "email", Type: indigo.String{}}},
+	}
+	r := &indigo.Rule{
+		ID:     "email",
+		Schema: schema,
+		Expr:   `email.matches("^[a-z0-9._%+-]+@[a-z0-9.-]+\\.[a-z]{2,}$")`,
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	if err := e.Compile(r); err != nil {
+		b.Fatalf("Error compiling rule: %v", err)
+	}
+	data := map[string]interface{}{"email": "alice@example.com"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := e.Eval(context.Background(), r, data)
+		if err != nil {
+			b.Error(err)
+		}
+	}
+}
+
+// Test the geospatial functions
+func TestGeo(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "lat", Type: indigo.Float{}},
+			{Name: "lon", Type: indigo.Float{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			// Stockholm to Gothenburg is about 400 km
+			"near_stockholm": {ID: "near_stockholm", Schema: schema, Expr: `geo_distance(lat, lon, 59.3293, 18.0686) < 50.0`},
+			"in_area": {ID: "in_area", Schema: schema,
+				Expr: `geo_in_polygon(lat, lon, [[57.0, 11.0], [58.0, 11.0], [58.0, 13.0], [57.0, 13.0]])`},
+			"geohash":   {ID: "geohash", Schema: schema, Expr: `geohash(lat, lon, 11) == "u4pruydqqvj"`},
+			"in_hash":   {ID: "in_hash", Schema: schema, Expr: `geohash_contains("u4pr", lat, lon)`},
+			"in_hash_2": {ID: "in_hash_2", Schema: schema, Expr: `geohash_contains("u6sc", lat, lon)`},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator(cel.Geo()))
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{"lat": 57.64911, "lon": 10.40744})
+	is.NoErr(err)
+	is.True(!u.Results["near_stockholm"].Pass)
+	is.True(!u.Results["in_area"].Pass)
+	is.True(u.Results["geohash"].Pass)
+	is.True(u.Results["in_hash"].Pass)
+	is.True(!u.Results["in_hash_2"].Pass)
+
+	// Gothenburg
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{"lat": 57.7089, "lon": 11.9746})
+	is.NoErr(err)
+	is.True(!u.Results["near_stockholm"].Pass)
+	is.True(u.Results["in_area"].Pass)
+	is.True(!u.Results["in_hash"].Pass)
+
+	// Stockholm
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{"lat": 59.33, "lon": 18.1})
+	is.NoErr(err)
+	is.True(u.Results["near_stockholm"].Pass)
+	is.True(u.Results["in_hash_2"].Pass)
+}
+
+// Test the network functions
+func TestNetwork(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{{Name: "ip", Type: indigo.String{}}},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator(cel.Network()))
+	cases := []struct {
+		expr string
+		ip   string
+		want bool
+	}{
+		{`ip_in_cidr("10.0.0.0/8", ip)`, "10.1.2.3", true},
+		{`ip_in_cidr("10.0.0.0/8", ip)`, "11.1.2.3", false},
+		{`ip_in_cidr("2001:db8::/32", ip)`, "2001:db8::1", true},
+		{`ip_in_any_cidr(["192.0.2.0/24", "198.51.100.0/24"], ip)`, "198.51.100.7", true},
+		{`ip_in_any_cidr(["192.0.2.0/24", "198.51.100.0/24"], ip)`, "203.0.113.1", false},
+		{`is_private_ip(ip)`, "172.16.5.4", true},
+		{`is_private_ip(ip)`, "fd00::1", true},
+		{`is_private_ip(ip)`, "8.8.8.8", false},
+		{`is_loopback_ip(ip)`, "::1", true},
+		{`is_ip(ip)`, "300.1.1.1", false},
+	}
+
+	for _, c := range cases {
+		r := &indigo.Rule{ID: "r", Schema: schema, Expr: c.expr}
+		is.NoErr(e.Compile(r))
+		u, err := e.Eval(context.Background(), r, map[string]interface{}{"ip": c.ip})
+		is.NoErr(err)
+		is.Equal(u.Pass, c.want)
+	}
+
+	// Invalid addresses and networks are errors
+	for _, expr := range []string{`is_private_ip(ip)`, `ip_in_cidr("10.0.0.0", "10.0.0.1")`} {
+		r := &indigo.Rule{ID: "r", Schema: schema, Expr: expr}
+		is.NoErr(e.Compile(r))
+		_, err := e.Eval(context.Background(), r, map[string]interface{}{"ip": "not an ip"})
+		is.True(err != nil)
+	}
+}
+
+// Test the fuzzy string matching functions
+func TestFuzzy(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(cel.NewEvaluator(cel.Fuzzy()))
+	cases := []struct {
+		expr       string
+		resultType indigo.Type
+		want       interface{}
+	}{
+		{`levenshtein("kitten", "sitting")`, indigo.Int{}, int64(3)},
+		{`levenshtein("", "abc")`, indigo.Int{}, int64(3)},
+		{`levenshtein("Zoë", "Zoe")`, indigo.Int{}, int64(1)},
+		{`jaro_winkler("MARTHA", "MARHTA")`, indigo.Float{}, 0.961},
+		{`jaro_winkler("DIXON", "DICKSONX")`, indigo.Float{}, 0.813},
+		{`jaro_winkler("abc", "xyz")`, indigo.Float{}, 0.0},
+		{`similarity("  Jon   SMITH ", "jon smith")`, indigo.Float{}, 1.0},
+		{`similarity("Jon Smith", "John Smith")`, indigo.Float{}, 0.9},
+	}
+
+	for _, c := range cases {
+		r := &indigo.Rule{ID: "r", Expr: c.expr, ResultType: c.resultType}
+		is.NoErr(e.Compile(r))
+		u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+		is.NoErr(err)
+		if f, ok := u.Value.(float64); ok {
+			is.Equal(math.Round(f*1000)/1000, c.want) // c.expr
+			continue
+		}
+		is.Equal(u.Value, c.want) // c.expr
+	}
+}
+
+// Test the arithmetic policies and safe arithmetic functions
+func TestArithmetic(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "a", Type: indigo.Int{}},
+			{Name: "b", Type: indigo.Int{}},
+		},
+	}
+
+	cases := []struct {
+		policy cel.ArithmeticPolicy
+		expr   string
+		a, b   int64
+		want   interface{} // nil for an error
+	}{
+		{cel.ArithmeticError, `a / b`, 1, 0, nil},
+		{cel.ArithmeticError, `safe_div(a, b, -1)`, 1, 0, int64(-1)},
+		{cel.ArithmeticError, `safe_div(a, b, -1)`, 10, 3, int64(3)},
+		{cel.ArithmeticError, `safe_mod(a, b, -1)`, 10, 0, int64(-1)},
+		{cel.ArithmeticError, `safe_div(double(a), double(b), 0.5)`, 1, 0, 0.5},
+		{cel.ArithmeticSaturate, `a / b`, 1, 0, int64(math.MaxInt64)},
+		{cel.ArithmeticSaturate, `a / b`, -1, 0, int64(math.MinInt64)},
+		{cel.ArithmeticSaturate, `a + b`, math.MaxInt64, 1, int64(math.MaxInt64)},
+		{cel.ArithmeticSaturate, `a - b`, math.MinInt64, 1, int64(math.MinInt64)},
+		{cel.ArithmeticSaturate, `a * b`, math.MaxInt64, -2, int64(math.MinInt64)},
+		{cel.ArithmeticSaturate, `-a`, math.MinInt64, 0, int64(math.MaxInt64)},
+		{cel.ArithmeticSaturate, `int(uint(a) - uint(b))`, 1, 2, int64(0)},
+		{cel.ArithmeticSaturate, `a % b`, 5, 0, int64(0)},
+		{cel.ArithmeticSaturate, `a + b`, 1, 2, int64(3)},
+		{cel.ArithmeticDefault, `a / b + 7`, 1, 0, int64(7)},
+	}
+
+	for _, c := range cases {
+		e := indigo.NewEngine(cel.NewEvaluator(cel.Arithmetic(c.policy)))
+		r := &indigo.Rule{ID: "r", Schema: schema, Expr: c.expr, ResultType: indigo.Int{}}
+		if _, ok := c.want.(float64); ok {
+			r.ResultType = indigo.Float{}
+		}
+		is.NoErr(e.Compile(r))
+		u, err := e.Eval(context.Background(), r, map[string]interface{}{"a": c.a, "b": c.b})
+		if c.want == nil {
+			is.True(err != nil) // c.expr
+			continue
+		}
+		is.NoErr(err)
+		is.Equal(u.Value, c.want) // c.expr
+	}
+
+	// The policy applies when diagnostics are collected
+	e := indigo.NewEngine(cel.NewEvaluator(cel.Arithmetic(cel.ArithmeticSaturate)))
+	r := &indigo.Rule{ID: "r", Schema: schema, Expr: `a / b`, ResultType: indigo.Int{}}
+	is.NoErr(e.Compile(r, indigo.CollectDiagnostics(true)))
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{"a": int64(1), "b": int64(0)}, indigo.ReturnDiagnostics(true))
+	is.NoErr(err)
+	is.Equal(u.Value, int64(math.MaxInt64))
+	is.True(u.Diagnostics != nil)
+}
+
+// Test parsing localized numbers and dates
+func TestLocaleParsing(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(cel.NewEvaluator(cel.LocaleParsing()))
+	cases := []struct {
+		expr string
+		ok   bool // false if evaluation fails
+	}{
+		{`parse_number("1.234,56", "de") == 1234.56`, true},
+		{`parse_number("1,234.56", "en-US") == 1234.56`, true},
+		{`parse_number("-1 234,5", "fr") == -1234.5`, true},
+		{"parse_number(\"1 234,5\", \"fr\") == 1234.5", true},
+		{`parse_number("1'234.5", "de-CH") == 1234.5`, true},
+		{`parse_number("1,5", "en") > 0.0`, false},
+		{`parse_number("1,00,000.5", "en-IN") == 100000.5`, true},
+		{`parse_number("12,34,56,789", "en-IN") == 123456789.0`, true},
+		{`parse_number("-1,234", "en-IN") == -1234.0`, true},
+		{`parse_number("1234", "en-IN") == 1234.0`, true},
+		{`parse_number("100,000", "en-IN") > 0.0`, false},
+		{`parse_number("1,00,00", "en-IN") > 0.0`, false},
+		{`parse_number("1,000,000", "en-IN") > 0.0`, false},
+		{`parse_number("1,00,000", "en") > 0.0`, false},
+		{`parse_number("1", "xx") > 0.0`, false},
+		{`parse_date("31.12.2021", "de") == timestamp("2021-12-31T00:00:00Z")`, true},
+		{`parse_date("12/31/2021", "en") == timestamp("2021-12-31T00:00:00Z")`, true},
+		{`parse_date("31/12/21", "en-GB") == timestamp("2021-12-31T00:00:00Z")`, true},
+		{`parse_date("2021-12-31", "de") == timestamp("2021-12-31T00:00:00Z")`, true},
+		{`parse_date("31/12/2021", "en") > timestamp("2021-01-01T00:00:00Z")`, false},
+	}
+
+	for _, c := range cases {
+		r := &indigo.Rule{ID: "r", Expr: c.expr}
+		is.NoErr(e.Compile(r))
+
+		u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+		if !c.ok {
+			is.True(err != nil) // c.expr
+			continue
+		}
+		is.NoErr(err)
+		is.True(u.Pass) // c.expr
+	}
+}
+
+func TestDates(t *testing.T) {
+	is := is.New(t)
+
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	is.NoErr(err)
+
+	e := indigo.NewEngine(cel.NewEvaluator(cel.Dates(map[string]cel.HolidayCalendar{
+		"SE": cel.Holidays{Location: stockholm, Dates: []string{"2021-12-24", "2021-12-31"}},
+	})))
+
+	cases := []struct {
+		expr string
+		ok   bool // false if evaluation fails
+	}{
+		{`age(timestamp("2000-06-15T00:00:00Z"), timestamp("2021-06-14T23:00:00Z")) == 20`, true},
+		{`age(timestamp("2000-06-15T00:00:00Z"), timestamp("2021-06-15T00:00:00Z")) == 21`, true},
+		{`age(timestamp("2000-02-29T00:00:00Z"), timestamp("2021-02-28T00:00:00Z")) == 20`, true},
+		{`age(timestamp("2000-02-29T00:00:00Z"), timestamp("2021-03-01T00:00:00Z")) == 21`, true},
+		{`age(timestamp("2000-01-01T00:00:00Z")) >= 21`, true},
+		{`days_between(timestamp("2021-01-01T00:00:00Z"), timestamp("2021-03-01T12:00:00Z")) == 59`, true},
+		{`days_between(timestamp("2021-03-01T00:00:00Z"), timestamp("2021-01-01T00:00:00Z")) == -59`, true},
+		{`start_of_day(timestamp("2021-06-15T13:45:00Z")) == timestamp("2021-06-15T00:00:00Z")`, true},
+		{`start_of_day(timestamp("2021-06-15T23:30:00Z"), "Europe/Stockholm") == timestamp("2021-06-15T22:00:00Z")`, true},
+		{`start_of_day(timestamp("2021-06-15T23:30:00Z"), "Mars/Olympus") == timestamp("2021-06-15T22:00:00Z")`, false},
+		{`is_business_day(timestamp("2021-12-23T12:00:00Z"), "SE")`, true},
+		{`!is_business_day(timestamp("2021-12-24T12:00:00Z"), "SE")`, true},
+		{`!is_business_day(timestamp("2021-12-23T23:30:00Z"), "SE")`, true}, // the 24th in Stockholm
+		{`!is_business_day(timestamp("2021-12-25T12:00:00Z"))`, true},
+		{`is_business_day(timestamp("2021-12-24T12:00:00Z"))`, true},
+		{`is_business_day(timestamp("2021-12-24T12:00:00Z"), "US")`, false},
+	}
+
+	for _, c := range cases {
+		r := &indigo.Rule{ID: "r", Expr: c.expr}
+		is.NoErr(e.Compile(r))
+
+		u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+		if !c.ok {
+			is.True(err != nil) // c.expr
+			continue
+		}
+		is.NoErr(err)
+		is.True(u.Pass) // c.expr
+	}
+}
+
+func TestMissingKeys(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "customer", Type: indigo.Any{}},
+			{Name: "tags", Type: indigo.Map{KeyType: indigo.String{}, ValueType: indigo.Bool{}}},
+			{Name: "score", Type: indigo.Int{}},
+		},
+	}
+
+	data := map[string]interface{}{
+		"customer": map[string]interface{}{
+			"address": map[string]interface{}{"city": "Stockholm"},
+		},
+		"tags": map[string]bool{"new": true},
+	}
+
+	cases := []struct {
+		expr    string
+		policy  cel.MissingKeyPolicy
+		want    bool
+		wantErr bool
+	}{
+		{`get(customer, "address.city", "unknown") == "Stockholm"`, cel.MissingKeyError, true, false},
+		{`get(customer, "address.zip", "unknown") == "unknown"`, cel.MissingKeyError, true, false},
+		{`get(customer, "address.city.name", "unknown") == "unknown"`, cel.MissingKeyError, true, false},
+		{`default(customer.phone.mobile, "none") == "none"`, cel.MissingKeyError, true, false},
+		{`default(customer.address.city, "none") == "Stockholm"`, cel.MissingKeyError, true, false},
+		{`tags["vip"]`, cel.MissingKeyError, false, true},
+		{`tags["vip"]`, cel.MissingKeyZero, false, false},
+		{`tags.vip || tags.new`, cel.MissingKeyZero, true, false},
+		{`score == 0`, cel.MissingKeyZero, true, false},
+		{`score == 0`, cel.MissingKeyError, false, true},
+	}
+
+	for _, c := range cases {
+		e := indigo.NewEngine(cel.NewEvaluator(cel.MissingKeys(c.policy)))
+		r := &indigo.Rule{ID: "r", Schema: schema, Expr: c.expr}
+		is.NoErr(e.Compile(r))
+
+		u, err := e.Eval(context.Background(), r, data)
+		if c.wantErr {
+			is.True(err != nil) // c.expr
+			continue
+		}
+		is.NoErr(err)
+		is.Equal(u.Pass, c.want) // c.expr
+	}
+}
+
+func TestComplexity(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "orders", Type: indigo.List{ValueType: indigo.List{ValueType: indigo.Int{}}}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"simple": {ID: "simple", Schema: schema, Expr: `amount > 10`},
+			"nested": {ID: "nested", Schema: schema, Expr: `orders.all(o, o.exists(x, x > amount))`},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	m, err := e.Complexity(r)
+	is.NoErr(err)
+	is.Equal(len(m), 2)
+	is.Equal(m["simple"], indigo.Complexity{Nodes: 3, Calls: 1})
+	is.Equal(m["simple"].Score(), 5)
+	is.Equal(m["nested"].ComprehensionDepth, 2)
+	is.True(m["nested"].Score() > 30)
+
+	is.NoErr(e.Compile(r, indigo.MaxComplexity(m["nested"].Score())))
+
+	err = e.Compile(r, indigo.MaxComplexity(10))
+	is.True(errors.Is(err, indigo.ErrTooComplex))
+	var ce *indigo.CompileError
+	is.True(errors.As(err, &ce))
+	is.Equal(ce.RuleID, "nested")
+}
+
+func TestConstantRules(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "country", Type: indigo.String{}},
+			{Name: "vip", Type: indigo.Bool{}},
+		},
+	}
+
+	cases := []struct {
+		expr     string
+		constant string // "true", "false" or blank
+	}{
+		{`amount > 10 && amount < 5`, "false"},
+		{`amount > 10 && vip && 5 > amount`, "false"},
+		{`amount >= 10 && amount <= 10`, ""},
+		{`amount >= 10 && amount <= 10 && amount != 10`, "false"},
+		{`amount > 10 && amount <= 10`, "false"},
+		{`amount < 5 || amount >= 5`, "true"},
+		{`amount < 5 || !(amount < 5)`, "true"},
+		{`amount < 5 || amount > 5`, ""},
+		{`country == "SE" && country == "NO"`, "false"},
+		{`country == "SE" || country != "SE"`, "true"},
+		{`vip && 1 > 2`, "false"},
+		{`vip || "a" < "b"`, "true"},
+		{`(vip ? amount > 10 : amount > 20) && country == "SE"`, ""},
+		{`true`, ""},
+		{`amount > 10 && country == "SE"`, ""},
+	}
+
+	ev := cel.NewEvaluator()
+	e := indigo.NewEngine(ev)
+	for _, c := range cases {
+		info, err := ev.Analyze(c.expr, schema, indigo.Bool{})
+		is.NoErr(err)
+		is.Equal(info.AlwaysTrue, c.constant == "true")   // c.expr
+		is.Equal(info.AlwaysFalse, c.constant == "false") // c.expr
+
+		r := &indigo.Rule{ID: "r", Schema: schema, Expr: c.expr}
+		err = e.Compile(r, indigo.RejectConstantRules(true))
+		is.Equal(errors.Is(err, indigo.ErrConstantRule), c.constant != "") // c.expr
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "country", Type: indigo.String{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "pricing",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"small": {ID: "small", Schema: schema, Expr: `amount <= 10`, Self: "standard"},
+			"medium": {ID: "medium", Schema: schema, Expr: `amount > 5 && amount <= 20 && country == "SE"`,
+				Self: "discount"},
+			"large":    {ID: "large", Schema: schema, Expr: `amount > 20`, Self: "discount"},
+			"large_se": {ID: "large_se", Schema: schema, Expr: `amount >= 100 && country == "SE"`, Self: "discount"},
+			"disabled": {ID: "disabled", Schema: schema, Expr: `amount > 0`, Disabled: true},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	overlaps, err := e.Overlaps(r)
+	is.NoErr(err)
+	is.Equal(overlaps, []indigo.Overlap{
+		{ParentID: "pricing", RuleIDs: [2]string{"large", "large_se"},
+			Region: `amount >= 100 && country == "SE"`},
+		{ParentID: "pricing", RuleIDs: [2]string{"medium", "small"},
+			Region: `amount > 5 && amount <= 10 && country == "SE"`, Conflict: true},
+	})
+	is.Equal(overlaps[1].String(),
+		`conflict: rules medium and small (children of pricing) both pass when amount > 5 && amount <= 10 && country == "SE"`)
+
+	// Siblings are compared with the elements of both their schemas
+	tiered := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "tier", Type: indigo.String{}},
+		},
+	}
+	r = &indigo.Rule{
+		ID: "shipping",
+		Rules: map[string]*indigo.Rule{
+			"free":     {ID: "free", Schema: schema, Expr: `amount > 50`},
+			"priority": {ID: "priority", Schema: tiered, Expr: `tier == "gold"`},
+		},
+	}
+	overlaps, err = e.Overlaps(r)
+	is.NoErr(err)
+	is.Equal(len(overlaps), 1)
+
+	r.Rules["priority"].Schema = indigo.Schema{
+		Elements: []indigo.DataElement{{Name: "amount", Type: indigo.String{}}},
+	}
+	r.Rules["priority"].Expr = `amount == "50"`
+	_, err = e.Overlaps(r)
+	is.True(err != nil) // amount has different types
+}
+
+func TestGenerateExamples(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "country", Type: indigo.String{}},
+			{Name: "vip", Type: indigo.Bool{}},
+			{Name: "email", Type: indigo.String{}},
+			{Name: "tags", Type: indigo.List{ValueType: indigo.String{}}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "r",
+		Schema: schema,
+		Expr:   `amount > 100 && country in ["SE", "NO"] && (vip || email.endsWith("@example.com"))`,
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	ex, err := e.GenerateExamples(r, 3)
+	is.NoErr(err)
+	is.Equal(len(ex.Pass), 3)
+	is.Equal(len(ex.Fail), 3)
+
+	is.NoErr(e.Compile(r))
+	for _, d := range append(ex.Pass, ex.Fail...) {
+		_, ok := d["tags"]
+		is.True(!ok) // lists are left out
+	}
+	for _, d := range ex.Pass {
+		u, err := e.Eval(context.Background(), r, d)
+		is.NoErr(err)
+		is.True(u.Pass)
+		is.True(d["amount"].(int64) == 101)
+	}
+	for _, d := range ex.Fail {
+		u, err := e.Eval(context.Background(), r, d)
+		is.NoErr(err)
+		is.True(!u.Pass)
+	}
+}
+
+func FuzzEval(f *testing.F) {
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Float{}},
+			{Name: "count", Type: indigo.Int{}},
+			{Name: "name", Type: indigo.String{}},
+			{Name: "placed", Type: indigo.Timestamp{}},
+			{Name: "tags", Type: indigo.List{ValueType: indigo.String{}}},
+			{Name: "limits", Type: indigo.Map{KeyType: indigo.String{}, ValueType: indigo.Int{}}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"a": {ID: "a", Schema: schema, Expr: `amount / double(count) > 10.0 && name.startsWith("x")`},
+			"b": {ID: "b", Schema: schema, Expr: `tags.exists(t, t in limits && limits[t] > count)`},
+			"c": {ID: "c", Schema: schema, Expr: `placed.getFullYear() > 2000 && count % 7 == 3`},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	if err := e.Compile(r); err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add([]byte{})
+	f.Add([]byte("0123456789abcdefghijklmnopqrstuvwxyz"))
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0xf8, 0x7f, 0, 7, 0, 0, 0, 0, 0, 0, 0, 0, 3, 'x', 'y', 'z'})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if err := e.FuzzEval(r, b, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestCheckInvariants(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "country", Type: indigo.String{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"fraud":   {ID: "fraud", Schema: schema, Expr: `amount > 1000 && country != "SE"`},
+			"blocked": {ID: "blocked", Schema: schema, Expr: `amount > 5000`},
+			"review":  {ID: "review", Schema: schema, Expr: `amount > 500`},
+			"fast":    {ID: "fast", Schema: schema, Expr: `amount < 100`},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r))
+
+	invariants := []indigo.Invariant{
+		{If: "fraud", Then: "blocked"},
+		{If: "fraud", Then: "review"},
+		{If: "fraud", Then: "fast", ThenFails: true},
+	}
+
+	cx, err := e.CheckInvariants(context.Background(), r, invariants, 100)
+	is.NoErr(err)
+	is.Equal(len(cx), 1)
+	is.Equal(cx[0].Invariant, invariants[0])
+
+	amount := cx[0].Data["amount"].(int64)
+	is.True(amount > 1000 && amount <= 5000)
+	is.True(cx[0].Data["country"] != "SE")
+	is.True(strings.HasPrefix(cx[0].String(), "if fraud passes, blocked must pass: violated by"))
+
+	_, err = e.CheckInvariants(context.Background(), r, []indigo.Invariant{{If: "fraud", Then: "nope"}}, 0)
+	is.True(errors.Is(err, indigo.ErrRuleNotFound))
+}
+
+func TestEnrich(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Float{}},
+			{Name: "currency", Type: indigo.String{}},
+			{Name: "amount_usd", Type: indigo.Float{}},
+		},
+	}
+
+	rates := map[string]float64{"USD": 1.0, "SEK": 0.1}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.RegisterEnricher("usd", func(_ context.Context, _ *indigo.Rule, d map[string]interface{}) error {
+		rate, ok := rates[d["currency"].(string)]
+		if !ok {
+			return fmt.Errorf("unknown currency %v", d["currency"])
+		}
+		d["amount_usd"] = d["amount"].(float64) * rate
+		return nil
+	}))
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Enrich: []string{"usd"},
+		Rules: map[string]*indigo.Rule{
+			"large": {ID: "large", Schema: schema, Expr: `amount_usd > 1000.0`},
+		},
+	}
+	is.NoErr(e.Compile(r))
+
+	d := map[string]interface{}{"amount": 20000.0, "currency": "SEK"}
+	u, err := e.Eval(context.Background(), r, d)
+	is.NoErr(err)
+	is.True(u.Results["large"].Pass)
+	_, ok := d["amount_usd"]
+	is.True(!ok) // the input data is not modified
+
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{"amount": 2000.0, "currency": "SEK"})
+	is.NoErr(err)
+	is.True(!u.Results["large"].Pass)
+
+	_, err = e.Eval(context.Background(), r, map[string]interface{}{"amount": 2000.0, "currency": "XXX"})
+	is.True(err != nil)
+
+	// Cached results depend on the values the enrichers derive from
+	c, err := e.Cached(r, indigo.NewLRUCache(10), time.Minute)
+	is.NoErr(err)
+	u, err = c.Eval(context.Background(), map[string]interface{}{"amount": 1.0, "currency": "USD"})
+	is.NoErr(err)
+	is.True(!u.Results["large"].Pass)
+	u, err = c.Eval(context.Background(), map[string]interface{}{"amount": 2000.0, "currency": "USD"})
+	is.NoErr(err)
+	is.True(u.Results["large"].Pass)
+
+	r.Rules["large"].Enrich = []string{"eur"}
+	is.True(errors.Is(e.Compile(r), indigo.ErrEnricherNotFound))
+}
+
+func TestEnrichCombinedSiblings(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "x", Type: indigo.Int{}},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.RegisterEnricher("double", func(_ context.Context, _ *indigo.Rule, d map[string]interface{}) error {
+		d["x"] = d["x"].(int) * 2
+		return nil
+	}))
+
+	r := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"enriched": {ID: "enriched", Schema: schema, Expr: `x > 10`, Enrich: []string{"double"}},
+			"a":        {ID: "a", Schema: schema, Expr: `x > 5`},
+			"b":        {ID: "b", Schema: schema, Expr: `x > 10`},
+		},
+	}
+	d := map[string]interface{}{"x": 6}
+
+	for _, combine := range []bool{false, true} {
+		is.NoErr(e.Compile(r, indigo.CombineSiblings(combine)))
+		u, err := e.Eval(context.Background(), r, d)
+		is.NoErr(err)
+		is.True(u.Results["enriched"].Pass) // evaluated with the enriched data
+		is.True(u.Results["a"].Pass)
+		is.True(!u.Results["b"].Pass)
+	}
+}
+
+func TestContextValues(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "r",
+		Schema: schema,
+		Expr:   `amount > 100 && ctx.environment == "production" && ctx.user_id != "test"`,
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r))
+
+	ctx := indigo.WithContextValues(context.Background(), map[string]interface{}{"environment": "production"})
+	ctx = indigo.WithContextValues(ctx, map[string]interface{}{"user_id": "u1"})
+	is.Equal(indigo.ContextValues(ctx), map[string]interface{}{"environment": "production", "user_id": "u1"})
+
+	d := map[string]interface{}{"amount": 200}
+	u, err := e.Eval(ctx, r, d)
+	is.NoErr(err)
+	is.True(u.Pass)
+	_, ok := d[indigo.ContextValuesKey]
+	is.True(!ok) // the input data is not modified
+
+	u, err = e.Eval(indigo.WithContextValues(ctx, map[string]interface{}{"user_id": "test"}), r, d)
+	is.NoErr(err)
+	is.True(!u.Pass)
+
+	_, err = e.Eval(context.Background(), r, d)
+	is.True(err != nil)
+
+	// Cached results depend on the context values
+	c, err := e.Cached(r, indigo.NewLRUCache(10), 0)
+	is.NoErr(err)
+	u, err = c.Eval(ctx, d)
+	is.NoErr(err)
+	is.True(u.Pass)
+	u, err = c.Eval(indigo.WithContextValues(ctx, map[string]interface{}{"environment": "test"}), d)
+	is.NoErr(err)
+	is.True(!u.Pass)
+}
+
+func TestSelfScope(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+		},
+	}
+	typed := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: indigo.SelfKey, Type: indigo.Int{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "r",
+		Schema: schema,
+		Expr:   `amount > self.limit`,
+		Self:   map[string]interface{}{"limit": 100},
+		Rules: map[string]*indigo.Rule{
+			"low":  {ID: "low", Schema: typed, Expr: `amount > self`, Self: 10},
+			"high": {ID: "high", Schema: typed, Expr: `amount > self`, Self: 1000},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r))
+
+	d := map[string]interface{}{"amount": 200}
+	u, err := e.Eval(context.Background(), r, d)
+	is.NoErr(err)
+	is.True(u.Pass)
+	is.True(u.Results["low"].Pass)   // each child sees its own self
+	is.True(!u.Results["high"].Pass) // and not its sibling's
+	_, ok := d[indigo.SelfKey]
+	is.True(!ok) // the input data is not modified
+
+	r.Rules["high"].Self = "1000"
+	err = e.Compile(r)
+	is.True(errors.Is(err, indigo.ErrSelfType))
+}
+
+func TestForEach(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "limit", Type: indigo.Int{}},
+			{Name: "amounts", Type: indigo.List{ValueType: indigo.Int{}}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:      "under_limit",
+		Schema:  schema,
+		ForEach: "amounts",
+		Expr:    `item <= limit`,
+		Rules: map[string]*indigo.Rule{
+			"even": {
+				ID: "even",
+				Schema: indigo.Schema{
+					Elements: []indigo.DataElement{{Name: indigo.ItemKey, Type: indigo.Int{}}},
+				},
+				Expr: `item % 2 == 0`,
+			},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{"limit": 10, "amounts": []int{4, 7, 12}})
+	is.NoErr(err)
+	is.True(!u.Pass)
+	is.Equal(len(u.Results), 3)
+	is.True(u.Results["0"].Pass)
+	is.True(u.Results["0"].Results["even"].Pass)
+	is.True(u.Results["1"].Pass)
+	is.True(!u.Results["1"].Results["even"].Pass) // the children see the element
+	is.True(!u.Results["2"].Pass)
+	is.Equal(u.Results["2"].Item, 12)
+	is.Equal(u.EvaluationCount, 6)
+
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{"limit": 10, "amounts": []int{}})
+	is.NoErr(err)
+	is.True(u.Pass)
+
+	_, err = e.Eval(context.Background(), r, map[string]interface{}{"limit": 10})
+	is.True(err != nil) // the list is missing
+}
+
+func TestChildrenOutcomes(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "age", Type: indigo.Int{}},
+			{Name: "income", Type: indigo.Int{}},
+			{Name: "country", Type: indigo.String{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "eligible",
+		Schema: schema,
+		Expr:   `children.passed_count >= 2 || children.passed["resident"]`,
+		Rules: map[string]*indigo.Rule{
+			"adult":    {ID: "adult", Schema: schema, Expr: `age >= 18`},
+			"income":   {ID: "income", Schema: schema, Expr: `income > 50000`},
+			"resident": {ID: "resident", Schema: schema, Expr: `country == "SE"`},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r))
+
+	cases := []struct {
+		data map[string]interface{}
+		pass bool
+	}{
+		{map[string]interface{}{"age": 20, "income": 60000, "country": "US"}, true},
+		{map[string]interface{}{"age": 20, "income": 10000, "country": "US"}, false},
+		{map[string]interface{}{"age": 10, "income": 10000, "country": "SE"}, true},
+	}
+
+	for _, c := range cases {
+		u, err := e.Eval(context.Background(), r, c.data, indigo.StopIfParentNegative(true))
+		is.NoErr(err)
+		is.Equal(u.Pass, c.pass)
+		is.Equal(len(u.Results), 3)    // the children are evaluated once, and reported
+		is.Equal(u.EvaluationCount, 4) // even though the parent failed
+	}
+
+	// The children would be split across the shards of a sharded vault
+	newEngine := func() indigo.Engine { return indigo.NewEngine(cel.NewEvaluator()) }
+	_, err := indigo.NewShardedVault(newEngine, r, 2, nil)
+	is.True(err != nil)
+}
+
+func TestOutputs(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Float{}},
+			{Name: "country", Type: indigo.String{}},
+		},
+	}
+
+	r := &indigo.Rule{
+		ID:     "international",
+		Schema: schema,
+		Expr:   `country != "US"`,
+		Outputs: map[string]string{
+			"fee":    `amount * 0.03`,
+			"tier":   `amount > 1000.0 ? "gold" : "standard"`,
+			"reason": `"international transfer to " + country`,
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{"amount": 2000.0, "country": "SE"})
+	is.NoErr(err)
+	is.True(u.Pass)
+	is.Equal(u.Outputs, map[string]interface{}{"fee": 60.0, "tier": "gold", "reason": "international transfer to SE"})
+
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{"amount": 2000.0, "country": "US"})
+	is.NoErr(err)
+	is.True(!u.Pass)
+	is.Equal(u.Outputs, nil) // outputs are only computed when the rule passes
+
+	r.Outputs["bad"] = `amount + country`
+	var ce *indigo.CompileError
+	is.True(errors.As(e.Compile(r), &ce))
+}
+
+func TestSandbox(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "ip", Type: indigo.String{}},
+			{Name: "born", Type: indigo.Timestamp{}},
+		},
+	}
+	declare := func(name string) celgo.EnvOption {
+		return celgo.Declarations(decls.NewFunction(name,
+			decls.NewOverload(name+"_string", []*gexpr.Type{decls.String}, decls.String)))
+	}
+
+	opts := []cel.Option{
+		cel.Functions(cel.CapNetwork, []string{"geoip_country"}, declare("geoip_country")),
+		cel.Functions(0, []string{"normalize"}, declare("normalize")),
+		cel.EnvOptions(declare("undeclared")),
+		cel.Dates(nil),
+	}
+
+	cases := []struct {
+		expr    string
+		allowed bool
+	}{
+		{`ip.startsWith("10.") && size(ip) > 3`, true},
+		{`normalize(ip) == "x"`, true},
+		{`[ip].exists(x, geoip_country(x) == "SE")`, false},
+		{`undeclared(ip) == "x"`, false},
+		{`days_between(born, born) == 0`, true},
+		{`age(born) > 18`, true}, // nondeterministic is allowed
+	}
+
+	sandboxed := indigo.NewEngine(cel.NewEvaluator(append(opts, cel.Sandbox(cel.CapNondeterministic))...))
+	open := indigo.NewEngine(cel.NewEvaluator(opts...))
+	for _, c := range cases {
+		r := &indigo.Rule{ID: "r", Schema: schema, Expr: c.expr}
+		err := sandboxed.Compile(r)
+		is.Equal(err == nil, c.allowed)                                   // sandboxed
+		is.Equal(errors.Is(err, cel.ErrCapabilityNotAllowed), !c.allowed) // the reason
+		is.NoErr(open.Compile(r))                                         // without a sandbox, all functions are allowed
+	}
+
+	// Capabilities are those of the overload called
+	strict := indigo.NewEngine(cel.NewEvaluator(append(opts, cel.Sandbox(0))...))
+	is.NoErr(strict.Compile(&indigo.Rule{ID: "r", Schema: schema, Expr: `age(born, born) == 0`}))
+	err := strict.Compile(&indigo.Rule{ID: "r", Schema: schema, Expr: `age(born) > 18`})
+	is.True(errors.Is(err, cel.ErrCapabilityNotAllowed))
+
+	is.Equal((cel.CapNetwork | cel.CapNondeterministic).String(), "network|nondeterministic")
+}
+
+func TestDeterministic(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "name", Type: indigo.String{}},
+			{Name: "born", Type: indigo.Timestamp{}},
+		},
+	}
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	tables := cel.Tables{"sanctions": {"Mallory": true}}
+
+	e := indigo.NewEngine(cel.NewEvaluator(cel.Deterministic(now), cel.Dates(nil), cel.Lookup(tables, time.Minute)))
+
+	adult := &indigo.Rule{ID: "adult", Schema: schema, Expr: `age(born) >= 18`}
+	is.NoErr(e.Compile(adult))
+
+	d := map[string]interface{}{"born": time.Date(2002, 6, 2, 0, 0, 0, 0, time.UTC)}
+	for i := 0; i < 2; i++ {
+		u, err := e.Eval(context.Background(), adult, d)
+		is.NoErr(err)
+		is.True(!u.Pass) // 17 years old at the fixed time, not at the current time
+	}
+
+	sanctioned := &indigo.Rule{ID: "sanctioned", Schema: schema, Expr: `in_table("sanctions", name)`}
+	err := e.Compile(sanctioned)
+	is.True(errors.Is(err, cel.ErrCapabilityNotAllowed)) // external data is refused
+
+	// age(ts, at) needs no capabilities, unlike age(ts), which the sandbox refuses
+	strict := indigo.NewEngine(cel.NewEvaluator(cel.Deterministic(now), cel.Dates(nil), cel.Sandbox(0)))
+	at := &indigo.Rule{ID: "at", Schema: schema, Expr: `age(born, timestamp("2021-06-02T00:00:00Z")) >= 18`}
+	is.NoErr(strict.Compile(at))
+	u, err := strict.Eval(context.Background(), at, d)
+	is.NoErr(err)
+	is.True(u.Pass)
+	is.True(errors.Is(strict.Compile(adult), cel.ErrCapabilityNotAllowed))
+}
+
+func TestCompletions(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Name: "s",
+		Elements: []indigo.DataElement{
+			{Name: "ip", Type: indigo.String{}, Description: "the client's IP address"},
+			{Name: "tags", Type: indigo.List{ValueType: indigo.String{}}},
+			{Name: "student", Type: indigo.Proto{Message: &school.Student{}}},
+		},
+	}
+	e := cel.NewEvaluator(
+		cel.Network(),
+		cel.Functions(cel.CapNetwork, []string{"geoip_country"}),
+	)
+
+	c, err := e.Completions(schema)
+	is.NoErr(err)
+
+	ids := map[string]cel.Identifier{}
+	for _, id := range c.Identifiers {
+		ids[id.Name] = id
+	}
+	is.Equal(len(ids), 4)
+	is.Equal(ids["ip"].Type, "string")
+	is.Equal(ids["ip"].Description, "the client's IP address")
+	is.Equal(ids["tags"].Type, "list(string)")
+	is.Equal(ids[indigo.ContextValuesKey].Type, "map(string, dyn)")
+	is.Equal(ids["student"].Type, "testdata.school.Student")
+
+	fields := map[string]cel.Identifier{}
+	for _, f := range ids["student"].Fields {
+		fields[f.Name] = f
+	}
+	is.Equal(fields["age"].Type, "int")
+	is.Equal(fields["grades"].Type, "list(double)")
+	is.Equal(fields["attrs"].Type, "map(string, string)")
+	is.Equal(fields["enrollment_date"].Type, "google.protobuf.Timestamp")
+
+	functions := map[string]cel.Function{}
+	for _, f := range c.Functions {
+		functions[f.Name] = f
+	}
+	is.True(functions["size"].Overloads != nil)            // standard functions
+	is.Equal(functions["_+_"].Name, "")                    // operators are not included
+	is.Equal(functions["ip_in_cidr"].Capabilities, "none") // enabled libraries
+	is.Equal(functions["ip_in_cidr"].Overloads[0].Params, []string{"string", "string"})
+	is.Equal(functions["ip_in_cidr"].Overloads[0].Result, "bool")
+	is.True(functions["matches_any"].Overloads != nil)           // always available
+	is.Equal(functions["levenshtein"].Name, "")                  // libraries not enabled
+	is.Equal(functions["geoip_country"].Capabilities, "network") // custom functions
+
+	var startsWith cel.Overload
+	for _, o := range functions["startsWith"].Overloads {
+		startsWith = o
+	}
+	is.Equal(startsWith.Receiver, "string")
+	is.Equal(startsWith.Params, []string{"string"})
+}
+
+func TestCheck(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "student", Type: indigo.Proto{Message: &school.Student{}}},
+			{Name: "tags", Type: indigo.List{ValueType: indigo.String{}}},
+		},
+	}
+	e := cel.NewEvaluator()
+
+	res, err := e.Check(`student.gpa > 3.0 && "x" in tags`, schema, indigo.Bool{})
+	is.NoErr(err)
+	is.Equal(res.Type, "bool")
+	is.Equal(len(res.Diagnostics), 0)
+
+	res, err = e.Check("", schema, indigo.Bool{})
+	is.NoErr(err)
+	is.Equal(len(res.Diagnostics), 0) // a blank expression is valid
+
+	res, err = e.Check("student.gpa > ", schema, indigo.Bool{}) // incomplete
+	is.NoErr(err)
+	is.Equal(res.Type, "")
+	is.True(len(res.Diagnostics) > 0)
+	is.Equal(res.Diagnostics[0].Line, 1)
+	is.Equal(res.Diagnostics[0].Offset, res.Diagnostics[0].Column)
+
+	res, err = e.Check("student.gpa > 3.0 &&\n  student.name == 'x'", schema, indigo.Bool{})
+	is.NoErr(err)
+	is.Equal(len(res.Diagnostics), 1)
+	is.Equal(res.Diagnostics[0].Line, 2)
+	is.Equal(res.Diagnostics[0].Column, 9)
+	is.Equal(res.Diagnostics[0].Offset, 30)
+	is.True(strings.Contains(res.Diagnostics[0].Message, "name"))
+
+	res, err = e.Check("student.gpa", schema, indigo.Bool{})
+	is.NoErr(err)
+	is.Equal(res.Type, "double")
+	is.Equal(len(res.Diagnostics), 1)
+	is.Equal(res.Diagnostics[0].Offset, -1)
+	is.Equal(res.Diagnostics[0].Expected, "bool")
+	is.Equal(res.Diagnostics[0].Found, "double")
+}
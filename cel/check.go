@@ -0,0 +1,122 @@
+package cel
+
+// This file contains the checking of expressions as they are edited, reporting
+// problems with their positions, for editors and language servers.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ezachrisen/indigo"
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/common"
+)
+
+// CheckResult is the outcome of checking an expression (see Evaluator.Check).
+type CheckResult struct {
+	// The CEL type of the expression, if it is valid, as in "bool" or "list(string)"
+	Type string `json:"type,omitempty"`
+
+	// The problems found, in the order of their positions; empty if the
+	// expression is valid
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// Diagnostic is a problem found in an expression.
+type Diagnostic struct {
+	Message string `json:"message"`
+
+	// The position of the problem: the line (starting at 1) and column (starting
+	// at 0), and the offset of the character in the expression, in runes.
+	// Line is 0, and Offset is -1, for a problem with the whole expression.
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+
+	// For an expression whose type is not the result type of the rule, the CEL
+	// type expected, and the type of the expression
+	Expected string `json:"expected,omitempty"`
+	Found    string `json:"found,omitempty"`
+}
+
+// Check checks the expression expr, which may be incomplete because it is being
+// typed, against the schema s, as Compile would for a rule with the result type,
+// and returns the problems found with their positions. A rule with a blank
+// expression is valid.
+//
+// Check does not generate a program, and uses the CEL environment of the schema
+// kept by the evaluator, so that it is cheap enough to call on every change to an
+// expression in an editor. An error is returned only if the schema is invalid.
+func (e *Evaluator) Check(expr string, s indigo.Schema, resultType indigo.Type) (*CheckResult, error) {
+	res := &CheckResult{Diagnostics: []Diagnostic{}}
+	if expr == "" {
+		return res, nil
+	}
+
+	env, err := e.env(s)
+	if err != nil {
+		return nil, err
+	}
+
+	ast, iss := env.Parse(expr)
+	if iss != nil && iss.Err() != nil {
+		res.Diagnostics = issues(expr, iss)
+		return res, nil
+	}
+
+	c, iss := env.Check(ast)
+	if iss != nil && iss.Err() != nil {
+		res.Diagnostics = issues(expr, iss)
+		return res, nil
+	}
+	res.Type = checker.FormatCheckedType(c.ResultType())
+
+	if e.sandboxed || e.deterministic {
+		checked, err := celgo.AstToCheckedExpr(c)
+		if err != nil {
+			return nil, fmt.Errorf("converting AST: %w", err)
+		}
+		if err := e.checkCapabilities(checked.GetExpr(), checked.GetReferenceMap()); err != nil {
+			res.Diagnostics = append(res.Diagnostics, Diagnostic{Message: err.Error(), Offset: -1})
+		}
+	}
+
+	if err := doTypesMatch(c.ResultType(), resultType); err != nil {
+		d := Diagnostic{Message: err.Error(), Offset: -1, Found: res.Type}
+		if t, err := convertIndigoToExprType(resultType); err == nil {
+			d.Expected = checker.FormatCheckedType(t)
+		}
+		res.Diagnostics = append(res.Diagnostics, d)
+	}
+
+	if err := precompileLists(c.Expr()); err != nil {
+		res.Diagnostics = append(res.Diagnostics, Diagnostic{Message: err.Error(), Offset: -1})
+	}
+	return res, nil
+}
+
+// issues returns the diagnostics of the CEL parse or check issues of the expression
+func issues(expr string, iss *celgo.Issues) []Diagnostic {
+	src := common.NewTextSource(expr)
+	var ds []Diagnostic
+	for _, e := range iss.Errors() {
+		d := Diagnostic{
+			Message: strings.TrimSpace(e.Message),
+			Line:    e.Location.Line(),
+			Column:  e.Location.Column(),
+			Offset:  -1,
+		}
+		if d.Line > 0 {
+			if o, ok := src.LocationOffset(e.Location); ok {
+				d.Offset = int(o)
+			}
+		}
+		ds = append(ds, d)
+	}
+	sort.SliceStable(ds, func(i, j int) bool {
+		return ds[i].Line < ds[j].Line || (ds[i].Line == ds[j].Line && ds[i].Column < ds[j].Column)
+	})
+	return ds
+}
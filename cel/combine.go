@@ -0,0 +1,53 @@
+package cel
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/traits"
+)
+
+// CompileCombined compiles the boolean expressions into a single CEL program
+// that produces a list of the expressions' values.
+// CompileCombined implements the indigo.ExpressionCombiner interface.
+func (e *Evaluator) CompileCombined(exprs []string, s indigo.Schema) (interface{}, error) {
+	parts := make([]string, 0, len(exprs))
+	for _, x := range exprs {
+		parts = append(parts, "("+x+")")
+	}
+
+	prog, _, err := e.compile("["+strings.Join(parts, ",\n")+"]", s, indigo.List{ValueType: indigo.Bool{}}, false)
+	if err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// EvaluateCombined evaluates a program compiled by CompileCombined, returning
+// the value of each expression.
+// EvaluateCombined implements the indigo.ExpressionCombiner interface.
+func (*Evaluator) EvaluateCombined(data map[string]interface{}, program interface{}) ([]interface{}, error) {
+	prog, ok := program.(celProgram)
+	if !ok {
+		return nil, fmt.Errorf("missing program")
+	}
+
+	val, _, err := prog.program.Eval(data)
+	if err != nil {
+		return nil, fmt.Errorf("evaluating combined rules: %w", err)
+	}
+
+	list, ok := val.(traits.Lister)
+	if !ok {
+		return nil, fmt.Errorf("evaluating combined rules: got %T, wanted a list", val)
+	}
+
+	n := int(list.Size().(types.Int))
+	vals := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		vals = append(vals, list.Get(types.Int(i)).Value())
+	}
+	return vals, nil
+}
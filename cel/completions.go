@@ -0,0 +1,210 @@
+package cel
+
+// This file contains the completion metadata of a schema: the identifiers and
+// functions available to expressions, used by editors to offer auto-completion
+// and type hints to rule authors.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/checker/decls"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// maxFieldDepth is the depth of nested proto message fields described in completions
+const maxFieldDepth = 3
+
+// Completions describes the identifiers and functions available to the expressions
+// of rules using a schema, in a form suited to JSON.
+type Completions struct {
+	Identifiers []Identifier `json:"identifiers"`
+	Functions   []Function   `json:"functions"`
+}
+
+// Identifier is a variable declared by the schema, or a field of a proto message.
+type Identifier struct {
+	Name string `json:"name"`
+
+	// The CEL type, as in "int", "list(string)" or "school.Student"
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+
+	// The fields of a proto message, nested up to three levels deep
+	Fields []Identifier `json:"fields,omitempty"`
+}
+
+// Function is a function that expressions can call.
+type Function struct {
+	Name      string     `json:"name"`
+	Overloads []Overload `json:"overloads,omitempty"`
+
+	// The capabilities needed by any overload of the function (see Capability), if declared
+	Capabilities string `json:"capabilities,omitempty"`
+}
+
+// Overload is a signature of a function.
+type Overload struct {
+	ID string `json:"id"`
+
+	// The type of the receiver of a function called as a method, as in s.size()
+	Receiver string   `json:"receiver,omitempty"`
+	Params   []string `json:"params"`
+	Result   string   `json:"result"`
+}
+
+// Completions returns the identifiers declared by the schema s, including the
+// context values (see indigo.WithContextValues), and the functions available to
+// expressions compiled by the evaluator: the standard CEL functions, the functions
+// of this package enabled by options, and the custom functions added with
+// Functions. Operators are not included, nor are the overloads of custom functions,
+// which are only known to CEL.
+func (e *Evaluator) Completions(s indigo.Schema) (*Completions, error) {
+	c := &Completions{}
+
+	for _, d := range s.Elements {
+		id, err := identifier(d.Name, d.Type)
+		if err != nil {
+			return nil, fmt.Errorf("element %s in schema %s: %w", d.Name, s.Name, err)
+		}
+		id.Description = d.Description
+		c.Identifiers = append(c.Identifiers, id)
+	}
+	if !declared(s, indigo.ContextValuesKey) {
+		c.Identifiers = append(c.Identifiers, Identifier{
+			Name: indigo.ContextValuesKey,
+			Type: checker.FormatCheckedType(decls.NewMapType(decls.String, decls.Dyn)),
+		})
+	}
+
+	ds := checker.StandardDeclarations()
+	for _, l := range append([]declaredLibrary{regexLib{}, nullSafeLib{}}, e.libraries...) {
+		ds = append(ds, l.declarations()...)
+	}
+
+	functions := map[string]*Function{}
+	for _, d := range ds {
+		if d.GetFunction() == nil || isOperator(d.GetName()) {
+			continue
+		}
+		f, ok := functions[d.GetName()]
+		if !ok {
+			f = &Function{Name: d.GetName()}
+			functions[f.Name] = f
+		}
+		for _, o := range d.GetFunction().GetOverloads() {
+			f.Overloads = append(f.Overloads, overload(o))
+		}
+	}
+	for name := range e.capabilities {
+		if _, ok := functions[name]; !ok {
+			functions[name] = &Function{Name: name}
+		}
+	}
+
+	for _, f := range functions {
+		ids := make([]string, len(f.Overloads))
+		for i, o := range f.Overloads {
+			ids[i] = o.ID
+		}
+		if caps, ok := e.capabilitiesOf(f.Name, ids...); ok {
+			f.Capabilities = caps.String()
+		}
+		c.Functions = append(c.Functions, *f)
+	}
+	sort.Slice(c.Functions, func(i, j int) bool { return c.Functions[i].Name < c.Functions[j].Name })
+	return c, nil
+}
+
+// identifier returns the identifier of a variable with the name and type t
+func identifier(name string, t indigo.Type) (Identifier, error) {
+	typ, err := convertIndigoToExprType(t)
+	if err != nil {
+		return Identifier{}, err
+	}
+	id := Identifier{Name: name, Type: checker.FormatCheckedType(typ)}
+	if p, ok := t.(indigo.Proto); ok && p.Message != nil {
+		id.Fields = fields(p.Message.ProtoReflect().Descriptor(), 1, map[protoreflect.FullName]bool{})
+	}
+	return id, nil
+}
+
+// fields returns the fields of the proto message m, at the depth; seen holds the
+// messages being described, to stop at recursive messages
+func fields(m protoreflect.MessageDescriptor, depth int, seen map[protoreflect.FullName]bool) []Identifier {
+	seen[m.FullName()] = true
+	defer delete(seen, m.FullName())
+
+	var ids []Identifier
+	fs := m.Fields()
+	for i := 0; i < fs.Len(); i++ {
+		f := fs.Get(i)
+		id := Identifier{Name: string(f.Name()), Type: fieldType(f)}
+		if f.Kind() == protoreflect.MessageKind && !f.IsList() && !f.IsMap() &&
+			depth < maxFieldDepth && !seen[f.Message().FullName()] {
+			id.Fields = fields(f.Message(), depth+1, seen)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// fieldType returns the CEL type of the proto field f
+func fieldType(f protoreflect.FieldDescriptor) string {
+	switch {
+	case f.IsMap():
+		return fmt.Sprintf("map(%s, %s)", kindType(f.MapKey()), kindType(f.MapValue()))
+	case f.IsList():
+		return fmt.Sprintf("list(%s)", kindType(f))
+	default:
+		return kindType(f)
+	}
+}
+
+// kindType returns the CEL type of a single value of the proto field f
+func kindType(f protoreflect.FieldDescriptor) string {
+	switch f.Kind() {
+	case protoreflect.BoolKind:
+		return "bool"
+	case protoreflect.StringKind:
+		return "string"
+	case protoreflect.BytesKind:
+		return "bytes"
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return "double"
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return "uint"
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return string(f.Message().FullName())
+	default: // the signed integer kinds and enums
+		return "int"
+	}
+}
+
+// overload returns the signature of the function overload o
+func overload(o *gexpr.Decl_FunctionDecl_Overload) Overload {
+	v := Overload{
+		ID:     o.GetOverloadId(),
+		Params: []string{},
+		Result: checker.FormatCheckedType(o.GetResultType()),
+	}
+	params := o.GetParams()
+	if o.GetIsInstanceFunction() && len(params) > 0 {
+		v.Receiver = checker.FormatCheckedType(params[0])
+		params = params[1:]
+	}
+	for _, p := range params {
+		v.Params = append(v.Params, checker.FormatCheckedType(p))
+	}
+	return v
+}
+
+// isOperator reports whether the standard function with the name is an operator,
+// such as _+_, !_ or @in, rather than a function called by name
+func isOperator(name string) bool {
+	return strings.HasPrefix(name, "_") || strings.HasPrefix(name, "!") || strings.Contains(name, "@")
+}
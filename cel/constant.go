@@ -0,0 +1,329 @@
+package cel
+
+// This file contains functions that detect boolean expressions that are always
+// true or always false, regardless of the input data, such as
+// `amount > 10 && amount < 5`.
+
+import (
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// alwaysValue returns the value that the boolean expression e always yields,
+// and false if the value depends on the input data, or cannot be determined.
+// Literals are folded, and the comparisons of a variable with constants in a
+// chain of && (or ||) are checked for contradictions (or tautologies).
+func alwaysValue(e *gexpr.Expr) (value bool, known bool) {
+	if c := e.GetConstExpr(); c != nil {
+		b, ok := c.GetConstantKind().(*gexpr.Constant_BoolValue)
+		return ok && b.BoolValue, ok
+	}
+
+	c := e.GetCallExpr()
+	if c == nil {
+		return false, false
+	}
+	args := c.GetArgs()
+
+	switch c.GetFunction() {
+	case "!_":
+		if len(args) == 1 {
+			v, ok := alwaysValue(args[0])
+			return !v, ok
+		}
+
+	case "_&&_", "_||_":
+		if len(args) != 2 {
+			return false, false
+		}
+		or := c.GetFunction() == "_||_"
+
+		// false && x is false, and true || x is true
+		l, lok := alwaysValue(args[0])
+		r, rok := alwaysValue(args[1])
+		if (lok && l == or) || (rok && r == or) {
+			return or, true
+		}
+		if lok && rok {
+			return l, true
+		}
+
+		// x < 5 || x >= 5 is true because x >= 5 && x < 5 is false
+		var atoms []comparison
+		for _, a := range junction(e, c.GetFunction()) {
+			if cmp, ok := comparisonOf(a); ok {
+				if or {
+					cmp = cmp.negate()
+				}
+				atoms = append(atoms, cmp)
+			}
+		}
+		if contradictory(atoms) {
+			return or, true
+		}
+
+	case "_?_:_":
+		if len(args) != 3 {
+			return false, false
+		}
+		if cond, ok := alwaysValue(args[0]); ok {
+			if cond {
+				return alwaysValue(args[1])
+			}
+			return alwaysValue(args[2])
+		}
+		l, lok := alwaysValue(args[1])
+		r, rok := alwaysValue(args[2])
+		return l, lok && rok && l == r
+
+	default:
+		if cmp, ok := comparisonOf(e); ok && cmp.variable == "" {
+			return cmp.holds()
+		}
+	}
+	return false, false
+}
+
+// junction returns the operands of a chain of calls of the function fn, such as
+// the conditions a, b and c in a && (b && c)
+func junction(e *gexpr.Expr, fn string) []*gexpr.Expr {
+	c := e.GetCallExpr()
+	if c == nil || c.GetFunction() != fn || len(c.GetArgs()) != 2 {
+		return []*gexpr.Expr{e}
+	}
+	return append(junction(c.GetArgs()[0], fn), junction(c.GetArgs()[1], fn)...)
+}
+
+// comparison is the comparison of a variable (or a constant) with a constant
+type comparison struct {
+	variable string // blank if the left side is a constant
+	left     scalar
+	op       string
+	right    scalar
+}
+
+// scalar is a number or string constant
+type scalar struct {
+	isString bool
+	num      float64
+	str      string
+}
+
+// compare returns -1, 0 or 1 if s is less than, equal to or greater than t
+func (s scalar) compare(t scalar) int {
+	switch {
+	case s.isString && (s.str < t.str), !s.isString && s.num < t.num:
+		return -1
+	case s.isString && (s.str > t.str), !s.isString && s.num > t.num:
+		return 1
+	}
+	return 0
+}
+
+// negations are the negated comparison operators
+var negations = map[string]string{
+	"_==_": "_!=_", "_!=_": "_==_",
+	"_<_": "_>=_", "_>=_": "_<_",
+	"_>_": "_<=_", "_<=_": "_>_",
+}
+
+// flips are the comparison operators with the operands swapped
+var flips = map[string]string{
+	"_==_": "_==_", "_!=_": "_!=_",
+	"_<_": "_>_", "_>_": "_<_",
+	"_<=_": "_>=_", "_>=_": "_<=_",
+}
+
+// comparisonOf returns the comparison e of a variable, or a constant, with a
+// constant, which may be negated with !
+func comparisonOf(e *gexpr.Expr) (comparison, bool) {
+	c := e.GetCallExpr()
+	if c == nil {
+		return comparison{}, false
+	}
+	args := c.GetArgs()
+
+	if c.GetFunction() == "!_" && len(args) == 1 {
+		cmp, ok := comparisonOf(args[0])
+		return cmp.negate(), ok
+	}
+
+	op := c.GetFunction()
+	if _, ok := flips[op]; !ok || len(args) != 2 {
+		return comparison{}, false
+	}
+
+	right, ok := scalarOf(args[1])
+	if !ok {
+		// Put the constant on the right: 5 < x is x > 5
+		right, ok = scalarOf(args[0])
+		args = []*gexpr.Expr{args[1], args[0]}
+		op = flips[op]
+		if !ok {
+			return comparison{}, false
+		}
+	}
+
+	if left, ok := scalarOf(args[0]); ok {
+		if left.isString != right.isString {
+			return comparison{}, false
+		}
+		return comparison{left: left, op: op, right: right}, true
+	}
+
+	if s := args[0].GetSelectExpr(); s != nil && s.GetTestOnly() {
+		return comparison{}, false
+	}
+	v, ok := selectName(args[0])
+	return comparison{variable: v, op: op, right: right}, ok
+}
+
+// scalarOf returns the value of a number or string literal
+func scalarOf(e *gexpr.Expr) (scalar, bool) {
+	switch v, _ := constantValue(e.GetConstExpr()); v := v.(type) {
+	case int64:
+		return scalar{num: float64(v)}, true
+	case uint64:
+		return scalar{num: float64(v)}, true
+	case float64:
+		return scalar{num: v}, v == v
+	case string:
+		return scalar{isString: true, str: v}, true
+	}
+	return scalar{}, false
+}
+
+// negate returns the comparison that is true when cmp is false
+func (cmp comparison) negate() comparison {
+	cmp.op = negations[cmp.op]
+	return cmp
+}
+
+// holds returns the outcome of a comparison of two constants
+func (cmp comparison) holds() (bool, bool) {
+	d := cmp.left.compare(cmp.right)
+	switch cmp.op {
+	case "_==_":
+		return d == 0, true
+	case "_!=_":
+		return d != 0, true
+	case "_<_":
+		return d < 0, true
+	case "_<=_":
+		return d <= 0, true
+	case "_>_":
+		return d > 0, true
+	case "_>=_":
+		return d >= 0, true
+	}
+	return false, false
+}
+
+// interval is the set of values allowed by comparisons of a variable
+type interval struct {
+	lo, hi         *scalar
+	loOpen, hiOpen bool
+	excluded       []scalar
+	isString       bool
+}
+
+// contradictory reports whether the comparisons cannot all be true at the same time
+func contradictory(atoms []comparison) bool {
+	vars, ok := intervals(atoms)
+	if !ok {
+		return true
+	}
+	for _, iv := range vars {
+		if iv.empty() {
+			return true
+		}
+	}
+	return false
+}
+
+// intervals returns the intervals of values allowed by the comparisons, by variable,
+// and false if a comparison of two constants is false
+func intervals(atoms []comparison) (map[string]*interval, bool) {
+	vars := map[string]*interval{}
+	for _, cmp := range atoms {
+		if cmp.variable == "" {
+			if ok, _ := cmp.holds(); !ok {
+				return nil, false
+			}
+			continue
+		}
+
+		iv, seen := vars[cmp.variable]
+		if !seen {
+			iv = &interval{isString: cmp.right.isString}
+			vars[cmp.variable] = iv
+		}
+		if iv.isString != cmp.right.isString {
+			// Strings and numbers cannot be compared
+			continue
+		}
+		iv.add(cmp.op, cmp.right)
+	}
+	return vars, true
+}
+
+// add narrows the interval to the values v for which "v op c" is true
+func (iv *interval) add(op string, c scalar) {
+	switch op {
+	case "_==_":
+		iv.raiseLo(c, false)
+		iv.lowerHi(c, false)
+	case "_!=_":
+		iv.excluded = append(iv.excluded, c)
+	case "_<_":
+		iv.lowerHi(c, true)
+	case "_<=_":
+		iv.lowerHi(c, false)
+	case "_>_":
+		iv.raiseLo(c, true)
+	case "_>=_":
+		iv.raiseLo(c, false)
+	}
+}
+
+// raiseLo sets the lower bound to c, if it is higher than the current bound
+func (iv *interval) raiseLo(c scalar, open bool) {
+	if iv.lo == nil || c.compare(*iv.lo) > 0 {
+		iv.lo, iv.loOpen = &c, open
+	} else if c.compare(*iv.lo) == 0 {
+		iv.loOpen = iv.loOpen || open
+	}
+}
+
+// lowerHi sets the upper bound to c, if it is lower than the current bound
+func (iv *interval) lowerHi(c scalar, open bool) {
+	if iv.hi == nil || c.compare(*iv.hi) < 0 {
+		iv.hi, iv.hiOpen = &c, open
+	} else if c.compare(*iv.hi) == 0 {
+		iv.hiOpen = iv.hiOpen || open
+	}
+}
+
+// empty reports whether no value is in the interval
+func (iv *interval) empty() bool {
+	if iv.lo == nil || iv.hi == nil {
+		return false
+	}
+
+	switch d := iv.lo.compare(*iv.hi); {
+	case d > 0:
+		return true
+	case d < 0:
+		return false
+	}
+
+	// The interval is a single value
+	if iv.loOpen || iv.hiOpen {
+		return true
+	}
+	for _, x := range iv.excluded {
+		if x.compare(*iv.lo) == 0 {
+			return true
+		}
+	}
+	return false
+}
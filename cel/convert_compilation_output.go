@@ -29,6 +29,11 @@ func doTypesMatch(cel *gexpr.Type, igo indigo.Type) error {
 		return fmt.Errorf("attempt to compare a nil indigo type with a CEL type %T", cel)
 	}
 
+	// Any accepts values of every type
+	if _, ok := igo.(indigo.Any); ok {
+		return nil
+	}
+
 	celConverted, err := indigoType(cel)
 	if err != nil {
 		return err
@@ -40,6 +40,12 @@ func convertIndigoSchemaToDeclarations(s indigo.Schema) ([]celgo.EnvOption, erro
 		}
 	}
 
+	// The context values (see indigo.WithContextValues) are declared unless
+	// the schema declares an element with the same name
+	if !declared(s, indigo.ContextValuesKey) {
+		declarations = append(declarations, decls.NewVar(indigo.ContextValuesKey, decls.NewMapType(decls.String, decls.Dyn)))
+	}
+
 	opts := []celgo.EnvOption{}
 	opts = append(opts, celgo.Declarations(declarations...))
 	opts = append(opts, celgo.Types(types...))
@@ -50,6 +56,16 @@ func convertIndigoSchemaToDeclarations(s indigo.Schema) ([]celgo.EnvOption, erro
 	return opts, nil
 }
 
+// declared reports whether the schema has an element with the name
+func declared(s indigo.Schema, name string) bool {
+	for _, el := range s.Elements {
+		if el.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // convertIndigoToExprType converts from an indigo type to a expr.Type,
 // which is used by CEL to represent types in its schema.
 func convertIndigoToExprType(t indigo.Type) (*gexpr.Type, error) {
@@ -67,6 +83,8 @@ func convertIndigoToExprType(t indigo.Type) (*gexpr.Type, error) {
 		return decls.Duration, nil
 	case indigo.Timestamp:
 		return decls.Timestamp, nil
+	case indigo.Any:
+		return decls.Dyn, nil
 	case indigo.Map:
 		key, err := convertIndigoToExprType(v.KeyType)
 		if err != nil {
@@ -0,0 +1,219 @@
+package cel
+
+// This file contains CEL functions for date arithmetic.
+
+import (
+	"time"
+
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// HolidayCalendar is the interface that wraps the IsBusinessDay method.
+//
+// IsBusinessDay reports whether t falls on a business day. Implementations
+// must be safe for concurrent use.
+type HolidayCalendar interface {
+	IsBusinessDay(t time.Time) bool
+}
+
+// Holidays is a HolidayCalendar in which all days are business days, except
+// weekends and holidays.
+type Holidays struct {
+	// The time zone in which the calendar's days start and end; if nil, UTC
+	Location *time.Location
+
+	// The days of the week that are not business days; if nil, Saturday and Sunday
+	Weekend []time.Weekday
+
+	// The holidays, in the format "2006-01-02"
+	Dates []string
+}
+
+// IsBusinessDay reports whether t falls on a day that is neither a weekend day
+// nor a holiday.
+func (h Holidays) IsBusinessDay(t time.Time) bool {
+	if h.Location != nil {
+		t = t.In(h.Location)
+	} else {
+		t = t.UTC()
+	}
+
+	weekend := h.Weekend
+	if weekend == nil {
+		weekend = []time.Weekday{time.Saturday, time.Sunday}
+	}
+	for _, d := range weekend {
+		if t.Weekday() == d {
+			return false
+		}
+	}
+
+	date := t.Format("2006-01-02")
+	for _, d := range h.Dates {
+		if d == date {
+			return false
+		}
+	}
+	return true
+}
+
+// Dates makes these date functions available to rule expressions:
+//
+//     age(birthdate) int
+//     age(birthdate, at) int
+//     days_between(a, b) int
+//     start_of_day(ts) timestamp
+//     start_of_day(ts, tz) timestamp
+//     is_business_day(ts) bool
+//     is_business_day(ts, calendar) bool
+//
+// age returns the number of whole years between the birthdate and the current time,
+// or the timestamp at; people born on February 29 become a year older on March 1
+// in common years. days_between returns the number of whole days (24 hour periods)
+// from a to b, which is negative if b is before a. start_of_day returns midnight
+// of the day of the timestamp in the IANA time zone tz, such as "Europe/Stockholm",
+// or in UTC. is_business_day reports whether the timestamp falls on a business day
+// of the named calendar, or on a weekday in UTC if no calendar is given.
+//
+// For example, `age(customer.birthdate) >= 18`, or
+// `is_business_day(order.placed, "SE") && days_between(order.placed, order.shipped) < 3`.
+func Dates(calendars map[string]HolidayCalendar) Option {
+	return func(e *Evaluator) {
+		library(&datesLib{
+			calendars: calendars,
+			now:       e.now,
+		})(e)
+	}
+}
+
+// datesLib is a CEL library with the date functions
+type datesLib struct {
+	calendars map[string]HolidayCalendar
+	now       func() time.Time
+}
+
+func (l *datesLib) CompileOptions() []celgo.EnvOption {
+	return []celgo.EnvOption{celgo.Declarations(l.declarations()...)}
+}
+
+// declarations returns the declarations of the functions of the library
+func (*datesLib) declarations() []*gexpr.Decl {
+	ts := []*gexpr.Type{decls.Timestamp}
+	tsString := []*gexpr.Type{decls.Timestamp, decls.String}
+
+	return []*gexpr.Decl{
+		decls.NewFunction("age",
+			decls.NewOverload("age_timestamp", ts, decls.Int),
+			decls.NewOverload("age_timestamp_timestamp",
+				[]*gexpr.Type{decls.Timestamp, decls.Timestamp}, decls.Int)),
+		decls.NewFunction("days_between",
+			decls.NewOverload("days_between_timestamp_timestamp",
+				[]*gexpr.Type{decls.Timestamp, decls.Timestamp}, decls.Int)),
+		decls.NewFunction("start_of_day",
+			decls.NewOverload("start_of_day_timestamp", ts, decls.Timestamp),
+			decls.NewOverload("start_of_day_timestamp_string", tsString, decls.Timestamp)),
+		decls.NewFunction("is_business_day",
+			decls.NewOverload("is_business_day_timestamp", ts, decls.Bool),
+			decls.NewOverload("is_business_day_timestamp_string", tsString, decls.Bool)),
+	}
+}
+
+func (l *datesLib) ProgramOptions() []celgo.ProgramOption {
+	return []celgo.ProgramOption{
+		celgo.Functions(
+			&functions.Overload{
+				Operator: "age",
+				Unary: func(v ref.Val) ref.Val {
+					return l.age(v, types.Timestamp{Time: l.now()})
+				},
+				Binary: l.age,
+			},
+			&functions.Overload{
+				Operator: "days_between",
+				Binary: func(lhs, rhs ref.Val) ref.Val {
+					a, ok1 := lhs.(types.Timestamp)
+					b, ok2 := rhs.(types.Timestamp)
+					if !ok1 || !ok2 {
+						return types.NewErr("days_between takes (timestamp, timestamp) arguments")
+					}
+					return types.Int(b.Time.Sub(a.Time) / (24 * time.Hour))
+				},
+			},
+			&functions.Overload{
+				Operator: "start_of_day",
+				Unary: func(v ref.Val) ref.Val {
+					return startOfDay(v, types.String("UTC"))
+				},
+				Binary: startOfDay,
+			},
+			&functions.Overload{
+				Operator: "is_business_day",
+				Unary: func(v ref.Val) ref.Val {
+					t, ok := v.(types.Timestamp)
+					if !ok {
+						return types.NewErr("is_business_day takes a timestamp argument")
+					}
+					return types.Bool(Holidays{}.IsBusinessDay(t.Time))
+				},
+				Binary: l.isBusinessDay,
+			},
+		),
+	}
+}
+
+// age returns the number of whole years from the birthdate to at
+func (l *datesLib) age(birthdate, at ref.Val) ref.Val {
+	b, ok1 := birthdate.(types.Timestamp)
+	a, ok2 := at.(types.Timestamp)
+	if !ok1 || !ok2 {
+		return types.NewErr("age takes timestamp arguments")
+	}
+	return types.Int(years(b.Time.UTC(), a.Time.UTC()))
+}
+
+// years returns the number of whole years from b to a
+func years(b, a time.Time) int {
+	n := a.Year() - b.Year()
+	if a.Month() < b.Month() || (a.Month() == b.Month() && a.Day() < b.Day()) {
+		n--
+	}
+	return n
+}
+
+// startOfDay returns midnight of the day of the timestamp in the time zone
+func startOfDay(ts, tz ref.Val) ref.Val {
+	t, ok1 := ts.(types.Timestamp)
+	name, ok2 := tz.(types.String)
+	if !ok1 || !ok2 {
+		return types.NewErr("start_of_day takes (timestamp, string) arguments")
+	}
+
+	loc, err := time.LoadLocation(string(name))
+	if err != nil {
+		return types.NewErr("start_of_day: %v", err)
+	}
+
+	local := t.Time.In(loc)
+	y, m, d := local.Date()
+	return types.Timestamp{Time: time.Date(y, m, d, 0, 0, 0, 0, loc)}
+}
+
+// isBusinessDay reports whether the timestamp falls on a business day of the calendar
+func (l *datesLib) isBusinessDay(ts, calendar ref.Val) ref.Val {
+	t, ok1 := ts.(types.Timestamp)
+	name, ok2 := calendar.(types.String)
+	if !ok1 || !ok2 {
+		return types.NewErr("is_business_day takes (timestamp, string) arguments")
+	}
+
+	c, ok := l.calendars[string(name)]
+	if !ok {
+		return types.NewErr("is_business_day: unknown calendar %q", string(name))
+	}
+	return types.Bool(c.IsBusinessDay(t.Time))
+}
@@ -0,0 +1,255 @@
+package cel
+
+// This file contains functions that translate a CEL expression
+// into a plain-English explanation, for display to business users.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ezachrisen/indigo"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Explain returns a plain-English explanation of the expression, such as
+// "Passes when Order Amount is greater than 1,000 and Country is in the EU list".
+// Variables are referred to by the Description of their schema element, if it has one,
+// otherwise by their name. Parts of the expression that cannot be explained are
+// shown in a function-call notation.
+// Explain implements the indigo.ExpressionExplainer interface.
+func (e *Evaluator) Explain(expr string, s indigo.Schema, resultType indigo.Type) (string, error) {
+	if expr == "" {
+		return "Always passes", nil
+	}
+
+	if resultType == nil {
+		resultType = indigo.Bool{}
+	}
+
+	_, ast, err := e.compile(expr, s, resultType, false)
+	if err != nil {
+		return "", err
+	}
+
+	x := explainer{names: map[string]string{}}
+	for _, el := range s.Elements {
+		x.names[el.Name] = el.Name
+		if el.Description != "" {
+			x.names[el.Name] = el.Description
+		}
+	}
+
+	if _, ok := resultType.(indigo.Bool); ok {
+		return "Passes when " + x.condition(ast.Expr()), nil
+	}
+	return "Returns " + x.value(ast.Expr()), nil
+}
+
+// explainer holds the friendly names of the schema elements, keyed by element name
+type explainer struct {
+	names map[string]string
+}
+
+// comparisons maps CEL comparison operators to their English phrase
+var comparisons = map[string]string{
+	"_==_": "is",
+	"_!=_": "is not",
+	"_<_":  "is less than",
+	"_<=_": "is at most",
+	"_>_":  "is greater than",
+	"_>=_": "is at least",
+	"@in":  "is in",
+}
+
+// arithmetic maps CEL arithmetic operators to their English phrase
+var arithmetic = map[string]string{
+	"_+_": "plus",
+	"_-_": "minus",
+	"_*_": "times",
+	"_/_": "divided by",
+	"_%_": "modulo",
+}
+
+// methods maps CEL string and list methods to their English phrase
+var methods = map[string]string{
+	"contains":   "contains",
+	"startsWith": "starts with",
+	"endsWith":   "ends with",
+	"matches":    "matches the pattern",
+}
+
+// condition explains an expression that produces a boolean
+func (x explainer) condition(e *gexpr.Expr) string {
+	switch k := e.GetExprKind().(type) {
+	case *gexpr.Expr_CallExpr:
+		c := k.CallExpr
+		args := c.GetArgs()
+		switch {
+		case c.GetFunction() == "_&&_" && len(args) == 2:
+			return x.junction(args, "and", "_||_")
+		case c.GetFunction() == "_||_" && len(args) == 2:
+			return x.junction(args, "or", "_&&_")
+		case c.GetFunction() == "!_" && len(args) == 1:
+			return "it is not the case that " + x.condition(args[0])
+		case comparisons[c.GetFunction()] != "" && len(args) == 2:
+			return x.value(args[0]) + " " + comparisons[c.GetFunction()] + " " + x.value(args[1])
+		case methods[c.GetFunction()] != "" && c.GetTarget() != nil && len(args) == 1:
+			return x.value(c.GetTarget()) + " " + methods[c.GetFunction()] + " " + x.value(args[0])
+		}
+	case *gexpr.Expr_SelectExpr:
+		if k.SelectExpr.GetTestOnly() {
+			return x.value(k.SelectExpr.GetOperand()) + "." + k.SelectExpr.GetField() + " is present"
+		}
+		return x.value(e) + " is true"
+	case *gexpr.Expr_IdentExpr:
+		return x.value(e) + " is true"
+	case *gexpr.Expr_ComprehensionExpr:
+		return x.comprehension(k.ComprehensionExpr)
+	}
+	return x.value(e)
+}
+
+// junction explains a logical AND or OR. Operands using the other
+// logical operator are put in parentheses to keep the meaning clear.
+func (x explainer) junction(args []*gexpr.Expr, word, other string) string {
+	parts := make([]string, 0, len(args))
+	for _, a := range args {
+		s := x.condition(a)
+		if a.GetCallExpr().GetFunction() == other {
+			s = "(" + s + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " "+word+" ")
+}
+
+// comprehension explains the all and exists macros; other comprehensions
+// are described in general terms
+func (x explainer) comprehension(c *gexpr.Expr_Comprehension) string {
+	step := c.GetLoopStep().GetCallExpr()
+	rng := x.value(c.GetIterRange())
+
+	if step != nil && len(step.GetArgs()) == 2 {
+		pred := x.condition(step.GetArgs()[1])
+		switch step.GetFunction() {
+		case "_&&_":
+			return fmt.Sprintf("every %s in %s satisfies: %s", c.GetIterVar(), rng, pred)
+		case "_||_":
+			return fmt.Sprintf("some %s in %s satisfies: %s", c.GetIterVar(), rng, pred)
+		}
+	}
+	return "a condition on the elements of " + rng
+}
+
+// value explains an expression that produces a value
+func (x explainer) value(e *gexpr.Expr) string {
+	switch k := e.GetExprKind().(type) {
+	case *gexpr.Expr_ConstExpr:
+		return constant(k.ConstExpr)
+	case *gexpr.Expr_IdentExpr:
+		return x.name(k.IdentExpr.GetName())
+	case *gexpr.Expr_SelectExpr:
+		if n, ok := selectName(e); ok {
+			if f, ok := x.names[n]; ok {
+				return f
+			}
+		}
+		return x.value(k.SelectExpr.GetOperand()) + "." + k.SelectExpr.GetField()
+	case *gexpr.Expr_ListExpr:
+		elems := []string{}
+		for _, el := range k.ListExpr.GetElements() {
+			elems = append(elems, x.value(el))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case *gexpr.Expr_CallExpr:
+		c := k.CallExpr
+		args := c.GetArgs()
+		switch {
+		case arithmetic[c.GetFunction()] != "" && len(args) == 2:
+			return x.value(args[0]) + " " + arithmetic[c.GetFunction()] + " " + x.value(args[1])
+		case c.GetFunction() == "-_" && len(args) == 1:
+			return "minus " + x.value(args[0])
+		case c.GetFunction() == "size" && len(args) == 1:
+			return "the size of " + x.value(args[0])
+		case c.GetFunction() == "size" && c.GetTarget() != nil:
+			return "the size of " + x.value(c.GetTarget())
+		case c.GetFunction() == "_?_:_" && len(args) == 3:
+			return fmt.Sprintf("%s if %s, otherwise %s", x.value(args[1]), x.condition(args[0]), x.value(args[2]))
+		case comparisons[c.GetFunction()] != "" || c.GetFunction() == "_&&_" ||
+			c.GetFunction() == "_||_" || c.GetFunction() == "!_" || methods[c.GetFunction()] != "":
+			return "whether " + x.condition(e)
+		}
+		parts := []string{}
+		for _, a := range args {
+			parts = append(parts, x.value(a))
+		}
+		f := strings.Trim(c.GetFunction(), "_")
+		if c.GetTarget() != nil {
+			f = x.value(c.GetTarget()) + "." + f
+		}
+		return f + "(" + strings.Join(parts, ", ") + ")"
+	case *gexpr.Expr_ComprehensionExpr:
+		return "whether " + x.comprehension(k.ComprehensionExpr)
+	}
+	return "(expression)"
+}
+
+// name returns the friendly name of a variable
+func (x explainer) name(n string) string {
+	if f, ok := x.names[n]; ok {
+		return f
+	}
+	return n
+}
+
+// selectName returns the dotted name of a chain of field selections
+// (a.b.c), which may be the name of a schema element
+func selectName(e *gexpr.Expr) (string, bool) {
+	switch k := e.GetExprKind().(type) {
+	case *gexpr.Expr_IdentExpr:
+		return k.IdentExpr.GetName(), true
+	case *gexpr.Expr_SelectExpr:
+		n, ok := selectName(k.SelectExpr.GetOperand())
+		return n + "." + k.SelectExpr.GetField(), ok
+	}
+	return "", false
+}
+
+// constant formats a literal value for display
+func constant(c *gexpr.Constant) string {
+	switch k := c.GetConstantKind().(type) {
+	case *gexpr.Constant_BoolValue:
+		return strconv.FormatBool(k.BoolValue)
+	case *gexpr.Constant_Int64Value:
+		return groupThousands(strconv.FormatInt(k.Int64Value, 10))
+	case *gexpr.Constant_Uint64Value:
+		return groupThousands(strconv.FormatUint(k.Uint64Value, 10))
+	case *gexpr.Constant_DoubleValue:
+		return groupThousands(strconv.FormatFloat(k.DoubleValue, 'f', -1, 64))
+	case *gexpr.Constant_StringValue:
+		return strconv.Quote(k.StringValue)
+	case *gexpr.Constant_NullValue:
+		return "null"
+	}
+	return c.String()
+}
+
+// groupThousands inserts commas between groups of thousands
+// in the integer part of a formatted number
+func groupThousands(n string) string {
+	sign := ""
+	if strings.HasPrefix(n, "-") {
+		sign, n = "-", n[1:]
+	}
+
+	frac := ""
+	if i := strings.Index(n, "."); i >= 0 {
+		n, frac = n[:i], n[i:]
+	}
+
+	for i := len(n) - 3; i > 0; i -= 3 {
+		n = n[:i] + "," + n[i:]
+	}
+	return sign + n + frac
+}
@@ -0,0 +1,209 @@
+package cel
+
+// This file contains CEL functions for fuzzy string matching.
+
+import (
+	"strings"
+
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Fuzzy makes these fuzzy string matching functions available to rule expressions,
+// for rules that compare names, such as in KYC screening:
+//
+//     levenshtein(a, b) int
+//     jaro_winkler(a, b) double
+//     similarity(a, b) double
+//
+// levenshtein returns the edit distance between the strings: the number of characters
+// inserted, deleted or replaced to change one string into the other.
+// jaro_winkler returns the Jaro-Winkler similarity of the strings, from 0.0 (no
+// similarity) to 1.0 (equal), which favors strings with a common prefix.
+// similarity returns the edit distance normalized to a similarity from 0.0 to 1.0,
+// after converting both strings to lower case, and removing leading and trailing spaces
+// and repeated spaces. levenshtein and jaro_winkler compare the strings as they are.
+//
+// For example, `similarity(customer_name, "Jon Smith") > 0.8`.
+func Fuzzy() Option {
+	return library(fuzzyLib{})
+}
+
+// fuzzyLib is a CEL library with the fuzzy matching functions
+type fuzzyLib struct{}
+
+// fuzzyArgs are the argument types of the fuzzy matching functions
+var fuzzyArgs = []*gexpr.Type{decls.String, decls.String}
+
+func (l fuzzyLib) CompileOptions() []celgo.EnvOption {
+	return []celgo.EnvOption{celgo.Declarations(l.declarations()...)}
+}
+
+// declarations returns the declarations of the functions of the library
+func (fuzzyLib) declarations() []*gexpr.Decl {
+	return []*gexpr.Decl{
+		decls.NewFunction("levenshtein",
+			decls.NewOverload("levenshtein_string_string", fuzzyArgs, decls.Int)),
+		decls.NewFunction("jaro_winkler",
+			decls.NewOverload("jaro_winkler_string_string", fuzzyArgs, decls.Double)),
+		decls.NewFunction("similarity",
+			decls.NewOverload("similarity_string_string", fuzzyArgs, decls.Double)),
+	}
+}
+
+func (fuzzyLib) ProgramOptions() []celgo.ProgramOption {
+	return []celgo.ProgramOption{
+		celgo.Functions(
+			&functions.Overload{
+				Operator: "levenshtein",
+				Binary: fuzzyFunc(func(a, b []rune) ref.Val {
+					return types.Int(levenshtein(a, b))
+				}),
+			},
+			&functions.Overload{
+				Operator: "jaro_winkler",
+				Binary: fuzzyFunc(func(a, b []rune) ref.Val {
+					return types.Double(jaroWinkler(a, b))
+				}),
+			},
+			&functions.Overload{
+				Operator: "similarity",
+				Binary: fuzzyFunc(func(a, b []rune) ref.Val {
+					a, b = normalizeName(a), normalizeName(b)
+					n := len(a)
+					if len(b) > n {
+						n = len(b)
+					}
+					if n == 0 {
+						return types.Double(1)
+					}
+					return types.Double(1 - float64(levenshtein(a, b))/float64(n))
+				}),
+			},
+		),
+	}
+}
+
+// fuzzyFunc checks the arguments of a fuzzy matching function before calling f
+func fuzzyFunc(f func(a, b []rune) ref.Val) functions.BinaryOp {
+	return func(lhs, rhs ref.Val) ref.Val {
+		a, ok1 := lhs.(types.String)
+		b, ok2 := rhs.(types.String)
+		if !ok1 || !ok2 {
+			return types.NewErr("fuzzy matching functions take (string, string) arguments")
+		}
+		return f([]rune(string(a)), []rune(string(b)))
+	}
+}
+
+// normalizeName converts the string to lower case, and removes leading, trailing
+// and repeated spaces
+func normalizeName(s []rune) []rune {
+	return []rune(strings.ToLower(strings.Join(strings.Fields(string(s)), " ")))
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// min3 returns the smallest of three ints
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// jaroWinkler returns the Jaro-Winkler similarity of a and b
+func jaroWinkler(a, b []rune) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	// Characters match if they are equal and not farther apart than window
+	window := len(a)
+	if len(b) > window {
+		window = len(b)
+	}
+	window = window/2 - 1
+	if window < 0 {
+		window = 0
+	}
+
+	matchedA := make([]bool, len(a))
+	matchedB := make([]bool, len(b))
+	matches := 0
+	for i := range a {
+		lo, hi := i-window, i+window+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(b) {
+			hi = len(b)
+		}
+		for j := lo; j < hi; j++ {
+			if !matchedB[j] && a[i] == b[j] {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	// Count the matching characters in a different order
+	transpositions := 0
+	j := 0
+	for i := range a {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if a[i] != b[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(a)) + m/float64(len(b)) + (m-float64(transpositions)/2)/m) / 3
+
+	// Favor strings with a common prefix of up to 4 characters
+	prefix := 0
+	for prefix < 4 && prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
@@ -0,0 +1,230 @@
+package cel
+
+// This file contains geospatial CEL functions.
+
+import (
+	"math"
+	"strings"
+
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/interpreter/functions"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Geo makes these geospatial functions available to rule expressions:
+//
+//     geo_distance(lat1, lon1, lat2, lon2) double
+//     geo_in_polygon(lat, lon, polygon) bool
+//     geohash(lat, lon, precision) string
+//     geohash_contains(hash, lat, lon) bool
+//
+// Coordinates are degrees of latitude and longitude, as doubles.
+// geo_distance returns the great-circle distance in kilometers between two points,
+// using the haversine formula. geo_in_polygon returns true if the point is inside
+// the polygon, a list of [lat, lon] vertices such as
+// [[59.3, 18.0], [59.4, 18.0], [59.4, 18.2]]; the polygon is closed implicitly, and
+// edges are straight lines in latitude and longitude. geohash returns the geohash of the
+// point with precision characters, and geohash_contains whether the point is inside
+// the area of the geohash. For example, `geo_distance(lat, lon, 59.33, 18.07) < 50.0`,
+// or `geohash_contains("u6sc", lat, lon)`.
+func Geo() Option {
+	return library(geoLib{})
+}
+
+// geoLib is a CEL library with the geospatial functions
+type geoLib struct{}
+
+// earthRadius is the mean radius of the earth in kilometers
+const earthRadius = 6371.0
+
+// geohashAlphabet is the base32 alphabet of geohashes
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+func (l geoLib) CompileOptions() []celgo.EnvOption {
+	return []celgo.EnvOption{celgo.Declarations(l.declarations()...)}
+}
+
+// declarations returns the declarations of the functions of the library
+func (geoLib) declarations() []*gexpr.Decl {
+	return []*gexpr.Decl{
+		decls.NewFunction("geo_distance",
+			decls.NewOverload("geo_distance_double_double_double_double",
+				[]*gexpr.Type{decls.Double, decls.Double, decls.Double, decls.Double}, decls.Double)),
+		decls.NewFunction("geo_in_polygon",
+			decls.NewOverload("geo_in_polygon_double_double_list",
+				[]*gexpr.Type{decls.Double, decls.Double, decls.NewListType(decls.NewListType(decls.Double))}, decls.Bool)),
+		decls.NewFunction("geohash",
+			decls.NewOverload("geohash_double_double_int",
+				[]*gexpr.Type{decls.Double, decls.Double, decls.Int}, decls.String)),
+		decls.NewFunction("geohash_contains",
+			decls.NewOverload("geohash_contains_string_double_double",
+				[]*gexpr.Type{decls.String, decls.Double, decls.Double}, decls.Bool)),
+	}
+}
+
+func (geoLib) ProgramOptions() []celgo.ProgramOption {
+	return []celgo.ProgramOption{
+		celgo.Functions(
+			&functions.Overload{
+				Operator: "geo_distance",
+				Function: func(args ...ref.Val) ref.Val {
+					c, ok := doubles(args...)
+					if !ok || len(c) != 4 {
+						return types.NewErr("geo_distance takes 4 double arguments")
+					}
+					return types.Double(haversine(c[0], c[1], c[2], c[3]))
+				},
+			},
+			&functions.Overload{
+				Operator: "geo_in_polygon",
+				Function: func(args ...ref.Val) ref.Val {
+					if len(args) != 3 {
+						return types.NewErr("geo_in_polygon takes 3 arguments, got %d", len(args))
+					}
+					c, ok := doubles(args[0], args[1])
+					if !ok {
+						return types.NewErr("geo_in_polygon takes (double, double, list) arguments")
+					}
+					poly, err := polygon(args[2])
+					if err != nil {
+						return err
+					}
+					return types.Bool(inPolygon(c[0], c[1], poly))
+				},
+			},
+			&functions.Overload{
+				Operator: "geohash",
+				Function: func(args ...ref.Val) ref.Val {
+					if len(args) != 3 {
+						return types.NewErr("geohash takes 3 arguments, got %d", len(args))
+					}
+					c, ok := doubles(args[0], args[1])
+					precision, ok2 := args[2].(types.Int)
+					if !ok || !ok2 || precision < 1 || precision > 12 {
+						return types.NewErr("geohash takes (double, double, int) arguments, with a precision from 1 to 12")
+					}
+					return types.String(geohash(c[0], c[1], int(precision)))
+				},
+			},
+			&functions.Overload{
+				Operator: "geohash_contains",
+				Function: func(args ...ref.Val) ref.Val {
+					if len(args) != 3 {
+						return types.NewErr("geohash_contains takes 3 arguments, got %d", len(args))
+					}
+					hash, ok := args[0].(types.String)
+					c, ok2 := doubles(args[1], args[2])
+					if !ok || !ok2 {
+						return types.NewErr("geohash_contains takes (string, double, double) arguments")
+					}
+					h := strings.ToLower(string(hash))
+					if h == "" || len(h) > 12 {
+						return types.NewErr("invalid geohash %q", h)
+					}
+					return types.Bool(geohash(c[0], c[1], len(h)) == h)
+				},
+			},
+		),
+	}
+}
+
+// doubles returns the values of double arguments
+func doubles(args ...ref.Val) ([]float64, bool) {
+	f := make([]float64, len(args))
+	for i, a := range args {
+		d, ok := a.(types.Double)
+		if !ok {
+			return nil, false
+		}
+		f[i] = float64(d)
+	}
+	return f, true
+}
+
+// polygon returns the [lat, lon] vertices of a polygon
+func polygon(v ref.Val) ([][2]float64, ref.Val) {
+	l, ok := v.(traits.Lister)
+	if !ok {
+		return nil, types.NewErr("polygon is not a list")
+	}
+
+	var poly [][2]float64
+	for it := l.Iterator(); it.HasNext() == types.True; {
+		p, ok := it.Next().(traits.Lister)
+		if !ok || p.Size() != types.Int(2) {
+			return nil, types.NewErr("polygon vertices must be [lat, lon] lists")
+		}
+		c, ok := doubles(p.Get(types.Int(0)), p.Get(types.Int(1)))
+		if !ok {
+			return nil, types.NewErr("polygon coordinates must be doubles")
+		}
+		poly = append(poly, [2]float64{c[0], c[1]})
+	}
+	return poly, nil
+}
+
+// haversine returns the great-circle distance in kilometers between two points
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// inPolygon returns true if the point is inside the polygon, by counting
+// the edges crossed by a ray from the point
+func inPolygon(lat, lon float64, poly [][2]float64) bool {
+	in := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		yi, xi := poly[i][0], poly[i][1]
+		yj, xj := poly[j][0], poly[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
+
+// geohash returns the geohash of the point with precision characters
+func geohash(lat, lon float64, precision int) string {
+	minLat, maxLat := -90.0, 90.0
+	minLon, maxLon := -180.0, 180.0
+
+	var b strings.Builder
+	even := true
+	bit, ch := 0, 0
+	for b.Len() < precision {
+		if even {
+			mid := (minLon + maxLon) / 2
+			if lon >= mid {
+				ch |= 1 << (4 - bit)
+				minLon = mid
+			} else {
+				maxLon = mid
+			}
+		} else {
+			mid := (minLat + maxLat) / 2
+			if lat >= mid {
+				ch |= 1 << (4 - bit)
+				minLat = mid
+			} else {
+				maxLat = mid
+			}
+		}
+		even = !even
+
+		if bit < 4 {
+			bit++
+			continue
+		}
+		b.WriteByte(geohashAlphabet[ch])
+		bit, ch = 0, 0
+	}
+	return b.String()
+}
@@ -0,0 +1,76 @@
+package cel
+
+import (
+	"fmt"
+
+	"github.com/ezachrisen/indigo"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Discriminant returns the constant value the variable must be equal to for the
+// expression to be true, if the expression is an equality test of the variable
+// (such as `country == "SE"`), or a series of conditions joined by && that
+// includes such a test. The variable may be a field selection, such as "customer.country".
+// Discriminant implements the indigo.ExpressionIndexer interface.
+func (e *Evaluator) Discriminant(expr string, s indigo.Schema, variable string) (string, bool, error) {
+	if expr == "" {
+		return "", false, nil
+	}
+
+	_, ast, err := e.compile(expr, s, indigo.Bool{}, false)
+	if err != nil {
+		return "", false, err
+	}
+
+	c, ok := discriminant(ast.Expr(), variable)
+	if !ok {
+		return "", false, nil
+	}
+	return fmt.Sprint(c), true, nil
+}
+
+// discriminant searches the conjunction of conditions in e for an equality
+// test of the variable with a constant, and returns the constant
+func discriminant(e *gexpr.Expr, variable string) (interface{}, bool) {
+	c := e.GetCallExpr()
+	if c == nil || len(c.GetArgs()) != 2 {
+		return nil, false
+	}
+
+	args := c.GetArgs()
+	switch c.GetFunction() {
+	case "_&&_":
+		if v, ok := discriminant(args[0], variable); ok {
+			return v, true
+		}
+		return discriminant(args[1], variable)
+	case "_==_":
+		for i := range args {
+			n, ok := selectName(args[i])
+			if !ok || n != variable {
+				continue
+			}
+			if v, ok := constantValue(args[1-i].GetConstExpr()); ok {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// constantValue returns the Go value of a CEL literal
+func constantValue(c *gexpr.Constant) (interface{}, bool) {
+	switch k := c.GetConstantKind().(type) {
+	case *gexpr.Constant_BoolValue:
+		return k.BoolValue, true
+	case *gexpr.Constant_Int64Value:
+		return k.Int64Value, true
+	case *gexpr.Constant_Uint64Value:
+		return k.Uint64Value, true
+	case *gexpr.Constant_DoubleValue:
+		return k.DoubleValue, true
+	case *gexpr.Constant_StringValue:
+		return k.StringValue, true
+	}
+	return nil, false
+}
@@ -0,0 +1,244 @@
+package cel
+
+// This file contains CEL functions that parse localized numbers and dates.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// LocaleParsing makes these functions, which parse numbers and dates formatted
+// according to a locale, available to rule expressions:
+//
+//	parse_number(s, locale) double
+//	parse_date(s, locale) timestamp
+//
+// The locale is a language tag, such as "en", "de" or "en-GB"; if the tag is not
+// known, its language ("en" for "en-AU") is used. parse_number accepts numbers
+// with the locale's decimal and group separators, such as "1.234,56" for "de" and
+// "1,00,000.5" for "en-IN", which groups the digits before the last three in pairs.
+// parse_date accepts numeric dates with the locale's order of day, month and year,
+// such as "31.12.2021" for "de" and "12/31/2021" for "en", separated by "/", "." or "-",
+// and returns midnight UTC of the date. Two-digit years are years from 2000.
+// Dates in ISO 8601 format ("2021-12-31") are accepted in all locales.
+//
+// For example, `parse_number(amount, "de") > 1000.0`.
+func LocaleParsing() Option {
+	return library(localeLib{})
+}
+
+// localeLib is a CEL library with the locale parsing functions
+type localeLib struct{}
+
+// numberFormat describes how a locale formats numbers and dates
+type numberFormat struct {
+	decimal string
+	groups  string // group separators, including spaces and non-breaking spaces
+	order   string // order of day, month and year in dates: "dmy", "mdy" or "ymd"
+	group   int    // the digits in each group before the last group of three
+}
+
+// localeFormats are the formats of the supported locales, by language tag
+var localeFormats = map[string]numberFormat{
+	"en":    {".", ",", "mdy", 3},
+	"en-gb": {".", ",", "dmy", 3},
+	"en-ie": {".", ",", "dmy", 3},
+	"en-in": {".", ",", "dmy", 2},
+	"en-au": {".", ",", "dmy", 3},
+	"en-nz": {".", ",", "dmy", 3},
+	"en-za": {",", " \u00a0\u202f", "ymd", 3},
+	"en-ca": {".", ",", "ymd", 3},
+	"de":    {",", ".", "dmy", 3},
+	"de-ch": {".", "'’", "dmy", 3},
+	"fr":    {",", " \u00a0\u202f", "dmy", 3},
+	"fr-ch": {".", "'’", "dmy", 3},
+	"es":    {",", ".", "dmy", 3},
+	"es-mx": {".", ",", "dmy", 3},
+	"it":    {",", ".", "dmy", 3},
+	"nl":    {",", ".", "dmy", 3},
+	"pt":    {",", ". \u00a0\u202f", "dmy", 3},
+	"pl":    {",", " \u00a0\u202f", "dmy", 3},
+	"sv":    {",", " \u00a0\u202f", "ymd", 3},
+	"da":    {",", ".", "dmy", 3},
+	"nb":    {",", " \u00a0\u202f", "dmy", 3},
+	"fi":    {",", " \u00a0\u202f", "dmy", 3},
+	"ru":    {",", " \u00a0\u202f", "dmy", 3},
+	"ja":    {".", ",", "ymd", 3},
+	"zh":    {".", ",", "ymd", 3},
+	"ko":    {".", ",", "ymd", 3},
+}
+
+// localeFormat returns the format of the locale
+func localeFormat(locale string) (numberFormat, error) {
+	tag := strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if f, ok := localeFormats[tag]; ok {
+		return f, nil
+	}
+	if i := strings.Index(tag, "-"); i > 0 {
+		if f, ok := localeFormats[tag[:i]]; ok {
+			return f, nil
+		}
+	}
+	return numberFormat{}, fmt.Errorf("unsupported locale %q", locale)
+}
+
+// localeArgs are the argument types of the locale parsing functions
+var localeArgs = []*gexpr.Type{decls.String, decls.String}
+
+func (l localeLib) CompileOptions() []celgo.EnvOption {
+	return []celgo.EnvOption{celgo.Declarations(l.declarations()...)}
+}
+
+// declarations returns the declarations of the functions of the library
+func (localeLib) declarations() []*gexpr.Decl {
+	return []*gexpr.Decl{
+		decls.NewFunction("parse_number",
+			decls.NewOverload("parse_number_string_string", localeArgs, decls.Double)),
+		decls.NewFunction("parse_date",
+			decls.NewOverload("parse_date_string_string", localeArgs, decls.Timestamp)),
+	}
+}
+
+func (localeLib) ProgramOptions() []celgo.ProgramOption {
+	return []celgo.ProgramOption{
+		celgo.Functions(
+			&functions.Overload{
+				Operator: "parse_number",
+				Binary: localeFunc(func(s string, f numberFormat) (ref.Val, error) {
+					n, err := parseNumber(s, f)
+					return types.Double(n), err
+				}),
+			},
+			&functions.Overload{
+				Operator: "parse_date",
+				Binary: localeFunc(func(s string, f numberFormat) (ref.Val, error) {
+					t, err := parseDate(s, f)
+					return types.Timestamp{Time: t}, err
+				}),
+			},
+		),
+	}
+}
+
+// localeFunc checks the arguments of a locale parsing function before calling f
+func localeFunc(f func(s string, nf numberFormat) (ref.Val, error)) functions.BinaryOp {
+	return func(lhs, rhs ref.Val) ref.Val {
+		s, ok1 := lhs.(types.String)
+		locale, ok2 := rhs.(types.String)
+		if !ok1 || !ok2 {
+			return types.NewErr("locale parsing functions take (string, string) arguments")
+		}
+
+		nf, err := localeFormat(string(locale))
+		if err != nil {
+			return types.NewErr("%v", err)
+		}
+
+		v, err := f(strings.TrimSpace(string(s)), nf)
+		if err != nil {
+			return types.NewErr("%v", err)
+		}
+		return v
+	}
+}
+
+// parseNumber parses a number formatted according to f. Group separators
+// must separate a last group of three digits in the integer part, and groups of
+// f.group digits before it.
+func parseNumber(s string, f numberFormat) (float64, error) {
+	invalid := fmt.Errorf("invalid number %q", s)
+
+	intPart, fraction := s, ""
+	if i := strings.Index(s, f.decimal); i >= 0 {
+		intPart, fraction = s[:i], "."+s[i+len(f.decimal):]
+	}
+
+	sign := ""
+	if strings.HasPrefix(intPart, "-") || strings.HasPrefix(intPart, "+") {
+		sign, intPart = intPart[:1], intPart[1:]
+	}
+
+	groups := strings.Split(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(f.groups, r) {
+			return '\n'
+		}
+		return r
+	}, intPart), "\n")
+	if n := len(groups); n > 1 {
+		if len(groups[0]) > f.group || len(groups[n-1]) != 3 {
+			return 0, invalid
+		}
+		for _, g := range groups[1 : n-1] {
+			if len(g) != f.group {
+				return 0, invalid
+			}
+		}
+	}
+
+	digits := strings.Join(groups, "")
+	if digits == "" || strings.IndexFunc(digits+strings.TrimPrefix(fraction, "."), notDigit) >= 0 {
+		return 0, invalid
+	}
+
+	n, err := strconv.ParseFloat(sign+digits+fraction, 64)
+	if err != nil {
+		return 0, invalid
+	}
+	return n, nil
+}
+
+// notDigit reports whether r is not an ASCII digit
+func notDigit(r rune) bool {
+	return r < '0' || r > '9'
+}
+
+// parseDate parses a numeric date formatted according to f
+func parseDate(s string, f numberFormat) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '/' || r == '.' || r == '-'
+	})
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+
+	var n [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q", s)
+		}
+		n[i] = v
+	}
+
+	var day, month, year int
+	switch f.order {
+	case "dmy":
+		day, month, year = n[0], n[1], n[2]
+	case "mdy":
+		month, day, year = n[0], n[1], n[2]
+	default:
+		year, month, day = n[0], n[1], n[2]
+	}
+	if year < 100 {
+		year += 2000
+	}
+
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if t.Day() != day || int(t.Month()) != month {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return t, nil
+}
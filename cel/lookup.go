@@ -0,0 +1,171 @@
+package cel
+
+// This file contains CEL functions that consult reference data tables
+// provided by a ReferenceDataProvider.
+
+import (
+	"sync"
+	"time"
+
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// ReferenceDataProvider is the interface that wraps the Lookup method.
+//
+// Lookup returns the value of the key in the reference data table, such as a
+// sanctions list or a product catalog, and false if the table has no such key.
+// Implementations must be safe for concurrent use.
+type ReferenceDataProvider interface {
+	Lookup(table, key string) (interface{}, bool, error)
+}
+
+// Tables is a ReferenceDataProvider holding tables in memory: a map from
+// table names to the keys and values of the table.
+type Tables map[string]map[string]interface{}
+
+// Lookup returns the value of the key in the table.
+func (t Tables) Lookup(table, key string) (interface{}, bool, error) {
+	v, ok := t[table][key]
+	return v, ok, nil
+}
+
+// Lookup makes the reference data of the provider available to rule expressions
+// through these functions:
+//
+//     lookup(table, key) dyn
+//     in_table(table, key) bool
+//
+// lookup returns the value of the key in the table, or null if the table has no such
+// key, and in_table whether the table has the key. For example,
+// `lookup("catalog", sku).price > 100.0`, or `in_table("sanctions", customer_name)`.
+// Values are converted to CEL values like input data.
+//
+// Values, and the absence of keys, are cached for the duration ttl, to avoid calling
+// the provider for each evaluation; a ttl of 0 disables caching. Errors are not cached:
+// if the provider returns an error, the rule evaluation fails.
+func Lookup(p ReferenceDataProvider, ttl time.Duration) Option {
+	return library(&lookupLib{
+		provider: p,
+		ttl:      ttl,
+		cache:    map[lookupKey]lookupEntry{},
+	})
+}
+
+// lookupLib is a CEL library with the lookup functions
+type lookupLib struct {
+	provider ReferenceDataProvider
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	cache map[lookupKey]lookupEntry
+}
+
+// lookupKey identifies a cached value
+type lookupKey struct {
+	table, key string
+}
+
+// lookupEntry is a cached value
+type lookupEntry struct {
+	value   interface{}
+	found   bool
+	expires time.Time
+}
+
+// maxLookupEntries is the number of cached values above which expired values
+// are removed from the cache when a value is added
+const maxLookupEntries = 10000
+
+// lookupArgs are the argument types of the lookup functions
+var lookupArgs = []*gexpr.Type{decls.String, decls.String}
+
+func (l *lookupLib) CompileOptions() []celgo.EnvOption {
+	return []celgo.EnvOption{celgo.Declarations(l.declarations()...)}
+}
+
+// declarations returns the declarations of the functions of the library
+func (*lookupLib) declarations() []*gexpr.Decl {
+	return []*gexpr.Decl{
+		decls.NewFunction("lookup",
+			decls.NewOverload("lookup_string_string", lookupArgs, decls.Dyn)),
+		decls.NewFunction("in_table",
+			decls.NewOverload("in_table_string_string", lookupArgs, decls.Bool)),
+	}
+}
+
+func (l *lookupLib) ProgramOptions() []celgo.ProgramOption {
+	return []celgo.ProgramOption{
+		celgo.Functions(
+			&functions.Overload{
+				Operator: "lookup",
+				Binary: l.lookupFunc(func(v interface{}, found bool) ref.Val {
+					if !found {
+						return types.NullValue
+					}
+					return types.DefaultTypeAdapter.NativeToValue(v)
+				}),
+			},
+			&functions.Overload{
+				Operator: "in_table",
+				Binary: l.lookupFunc(func(_ interface{}, found bool) ref.Val {
+					return types.Bool(found)
+				}),
+			},
+		),
+	}
+}
+
+// lookupFunc checks the arguments of a lookup function, and calls f with the
+// value of the key in the table
+func (l *lookupLib) lookupFunc(f func(v interface{}, found bool) ref.Val) functions.BinaryOp {
+	return func(lhs, rhs ref.Val) ref.Val {
+		table, ok1 := lhs.(types.String)
+		key, ok2 := rhs.(types.String)
+		if !ok1 || !ok2 {
+			return types.NewErr("lookup functions take (string, string) arguments")
+		}
+
+		v, found, err := l.lookup(string(table), string(key))
+		if err != nil {
+			return types.NewErr("lookup %s: %v", table, err)
+		}
+		return f(v, found)
+	}
+}
+
+// lookup returns the value of the key in the table, from the cache if possible
+func (l *lookupLib) lookup(table, key string) (interface{}, bool, error) {
+	k := lookupKey{table: table, key: key}
+	now := time.Now()
+
+	if l.ttl > 0 {
+		l.mu.Lock()
+		en, ok := l.cache[k]
+		l.mu.Unlock()
+		if ok && now.Before(en.expires) {
+			return en.value, en.found, nil
+		}
+	}
+
+	v, found, err := l.provider.Lookup(table, key)
+	if err != nil || l.ttl <= 0 {
+		return v, found, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.cache) >= maxLookupEntries {
+		for ck, en := range l.cache {
+			if !now.Before(en.expires) {
+				delete(l.cache, ck)
+			}
+		}
+	}
+	l.cache[k] = lookupEntry{value: v, found: found, expires: now.Add(l.ttl)}
+	return v, found, nil
+}
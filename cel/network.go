@@ -0,0 +1,179 @@
+package cel
+
+// This file contains CEL functions for IP addresses and networks.
+
+import (
+	"net"
+
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/interpreter/functions"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Network makes these functions for IP addresses (IPv4 and IPv6) available to
+// rule expressions:
+//
+//     ip_in_cidr(cidr, ip) bool
+//     ip_in_any_cidr(cidrs, ip) bool
+//     is_ip(ip) bool
+//     is_private_ip(ip) bool
+//     is_loopback_ip(ip) bool
+//
+// Addresses and networks are strings, such as "10.1.2.3" and "10.0.0.0/8".
+// ip_in_cidr returns true if the address is in the network, and ip_in_any_cidr
+// if it is in any of the list of networks. is_private_ip returns true for private
+// addresses (RFC 1918 and RFC 4193), and is_loopback_ip for loopback addresses.
+// For example, `ip_in_cidr("10.0.0.0/8", client_ip) || is_private_ip(client_ip)`.
+//
+// The functions return an error for invalid networks, and for invalid addresses,
+// except is_ip, which returns false.
+func Network() Option {
+	return library(networkLib{})
+}
+
+// networkLib is a CEL library with the network functions
+type networkLib struct{}
+
+// privateNetworks are the private IPv4 and IPv6 networks
+var privateNetworks = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+func (l networkLib) CompileOptions() []celgo.EnvOption {
+	return []celgo.EnvOption{celgo.Declarations(l.declarations()...)}
+}
+
+// declarations returns the declarations of the functions of the library
+func (networkLib) declarations() []*gexpr.Decl {
+	return []*gexpr.Decl{
+		decls.NewFunction("ip_in_cidr",
+			decls.NewOverload("ip_in_cidr_string_string",
+				[]*gexpr.Type{decls.String, decls.String}, decls.Bool)),
+		decls.NewFunction("ip_in_any_cidr",
+			decls.NewOverload("ip_in_any_cidr_list_string",
+				[]*gexpr.Type{decls.NewListType(decls.String), decls.String}, decls.Bool)),
+		decls.NewFunction("is_ip",
+			decls.NewOverload("is_ip_string", []*gexpr.Type{decls.String}, decls.Bool)),
+		decls.NewFunction("is_private_ip",
+			decls.NewOverload("is_private_ip_string", []*gexpr.Type{decls.String}, decls.Bool)),
+		decls.NewFunction("is_loopback_ip",
+			decls.NewOverload("is_loopback_ip_string", []*gexpr.Type{decls.String}, decls.Bool)),
+	}
+}
+
+func (networkLib) ProgramOptions() []celgo.ProgramOption {
+	return []celgo.ProgramOption{
+		celgo.Functions(
+			&functions.Overload{
+				Operator: "ip_in_cidr",
+				Binary: func(lhs, rhs ref.Val) ref.Val {
+					n, err := parseCIDR(lhs)
+					if err != nil {
+						return err
+					}
+					ip, err := parseIP(rhs)
+					if err != nil {
+						return err
+					}
+					return types.Bool(n.Contains(ip))
+				},
+			},
+			&functions.Overload{
+				Operator: "ip_in_any_cidr",
+				Binary: func(lhs, rhs ref.Val) ref.Val {
+					l, ok := lhs.(traits.Lister)
+					if !ok {
+						return types.ValOrErr(lhs, "no such overload")
+					}
+					ip, err := parseIP(rhs)
+					if err != nil {
+						return err
+					}
+					for it := l.Iterator(); it.HasNext() == types.True; {
+						n, err := parseCIDR(it.Next())
+						if err != nil {
+							return err
+						}
+						if n.Contains(ip) {
+							return types.True
+						}
+					}
+					return types.False
+				},
+			},
+			&functions.Overload{
+				Operator: "is_ip",
+				Unary: func(v ref.Val) ref.Val {
+					_, err := parseIP(v)
+					return types.Bool(err == nil)
+				},
+			},
+			&functions.Overload{
+				Operator: "is_private_ip",
+				Unary: func(v ref.Val) ref.Val {
+					ip, err := parseIP(v)
+					if err != nil {
+						return err
+					}
+					for _, n := range privateNetworks {
+						if n.Contains(ip) {
+							return types.True
+						}
+					}
+					return types.False
+				},
+			},
+			&functions.Overload{
+				Operator: "is_loopback_ip",
+				Unary: func(v ref.Val) ref.Val {
+					ip, err := parseIP(v)
+					if err != nil {
+						return err
+					}
+					return types.Bool(ip.IsLoopback())
+				},
+			},
+		),
+	}
+}
+
+// parseIP parses an IP address argument
+func parseIP(v ref.Val) (net.IP, ref.Val) {
+	s, ok := v.(types.String)
+	if !ok {
+		return nil, types.ValOrErr(v, "no such overload")
+	}
+	ip := net.ParseIP(string(s))
+	if ip == nil {
+		return nil, types.NewErr("invalid IP address %q", string(s))
+	}
+	return ip, nil
+}
+
+// parseCIDR parses a network argument
+func parseCIDR(v ref.Val) (*net.IPNet, ref.Val) {
+	s, ok := v.(types.String)
+	if !ok {
+		return nil, types.ValOrErr(v, "no such overload")
+	}
+	_, n, err := net.ParseCIDR(string(s))
+	if err != nil {
+		return nil, types.NewErr("invalid network %q", string(s))
+	}
+	return n, nil
+}
+
+// mustParseCIDRs parses networks, panicking if they are invalid
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
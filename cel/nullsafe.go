@@ -0,0 +1,223 @@
+package cel
+
+// This file contains the missing key policy, and the null-safe functions get and default,
+// which let rules read sparse maps without failing on missing keys.
+
+import (
+	"strings"
+	"time"
+
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/interpreter"
+	"github.com/google/cel-go/interpreter/functions"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// MissingKeyPolicy determines the outcome of reading a map key, or an input variable,
+// that is not in the data (see MissingKeys).
+type MissingKeyPolicy int
+
+const (
+	// MissingKeyError makes the evaluation of the rule fail. This is the default.
+	MissingKeyError MissingKeyPolicy = iota
+
+	// MissingKeyZero yields the zero value of the declared type of the missing value:
+	// 0, "", false, an empty list or map, the Unix epoch for timestamps, or null for
+	// other types, such as protocol buffers and dyn.
+	MissingKeyZero
+)
+
+// MissingKeys sets the outcome of reading map keys and input variables that are
+// not in the data, for all rules compiled by the evaluator. For example, with
+// MissingKeyZero, `customer.tags["vip"] == true` is false, rather than an error,
+// if the customer has no "vip" tag.
+//
+// Regardless of the policy, these functions are available to rule expressions:
+//
+//     get(map, path, default)
+//     default(value, fallback)
+//
+// get returns the value at the dot-separated path of keys in the map, or the default
+// if one of the keys is missing, or one of the values along the path is not a map.
+// For example, `get(customer, "address.city", "unknown") == "Stockholm"`.
+// default returns the value, or the fallback if the value is null or cannot be evaluated.
+// For example, `default(customer.address.city, "unknown") == "Stockholm"`.
+func MissingKeys(p MissingKeyPolicy) Option {
+	return func(e *Evaluator) {
+		e.missingKeys = p
+	}
+}
+
+// nullSafeLib is a CEL library with the null-safe functions
+type nullSafeLib struct{}
+
+func (l nullSafeLib) CompileOptions() []celgo.EnvOption {
+	return []celgo.EnvOption{celgo.Declarations(l.declarations()...)}
+}
+
+// declarations returns the declarations of the functions of the library
+func (nullSafeLib) declarations() []*gexpr.Decl {
+	a := decls.NewTypeParamType("A")
+	return []*gexpr.Decl{
+		decls.NewFunction("get",
+			decls.NewParameterizedOverload("get_map_string_any",
+				[]*gexpr.Type{decls.NewMapType(decls.String, decls.Dyn), decls.String, a}, a, []string{"A"})),
+		decls.NewFunction("default",
+			decls.NewParameterizedOverload("default_any_any",
+				[]*gexpr.Type{a, a}, a, []string{"A"})),
+	}
+}
+
+func (nullSafeLib) ProgramOptions() []celgo.ProgramOption {
+	return []celgo.ProgramOption{
+		celgo.Functions(
+			&functions.Overload{
+				Operator: "get",
+				Function: getPath,
+			},
+			&functions.Overload{
+				Operator: "default",
+				Binary: func(v, fallback ref.Val) ref.Val {
+					if v == types.NullValue {
+						return fallback
+					}
+					return v
+				},
+			},
+		),
+		celgo.CustomDecorator(decorateDefault),
+	}
+}
+
+// getPath returns the value at the path in the map, or the default
+func getPath(args ...ref.Val) ref.Val {
+	if len(args) != 3 {
+		return types.NewErr("get takes 3 arguments, got %d", len(args))
+	}
+
+	path, ok := args[1].(types.String)
+	if !ok {
+		return types.ValOrErr(args[1], "get takes (map, string, value) arguments")
+	}
+
+	v := args[0]
+	for _, key := range strings.Split(string(path), ".") {
+		m, ok := v.(traits.Mapper)
+		if !ok {
+			return args[2]
+		}
+		if v, ok = m.Find(types.String(key)); !ok || types.IsError(v) {
+			return args[2]
+		}
+	}
+	return v
+}
+
+// decorateDefault makes calls of the default function return the fallback if
+// the value cannot be evaluated
+func decorateDefault(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+	call, ok := i.(interpreter.InterpretableCall)
+	if !ok || call.Function() != "default" || len(call.Args()) != 2 {
+		return i, nil
+	}
+	return &evalDefault{InterpretableCall: call}, nil
+}
+
+// evalDefault evaluates a call of the default function
+type evalDefault struct {
+	interpreter.InterpretableCall
+}
+
+// Eval implements the interpreter.Interpretable interface.
+func (e *evalDefault) Eval(a interpreter.Activation) ref.Val {
+	args := e.Args()
+	v := args[0].Eval(a)
+	if types.IsUnknownOrError(v) || v == types.NullValue {
+		return args[1].Eval(a)
+	}
+	return v
+}
+
+// missingKeyDecorator returns a decorator applying the MissingKeyZero policy to the
+// attributes (variables, and map keys and fields selected from them) of a program.
+// The types are the checked types of the program's expressions, by expression ID.
+func missingKeyDecorator(typeMap map[int64]*gexpr.Type) interpreter.InterpretableDecorator {
+	return func(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+		switch a := i.(type) {
+		case *evalMissingKey:
+			return i, nil
+		case interpreter.InterpretableAttribute:
+			return &evalMissingKey{InterpretableAttribute: a, typeMap: typeMap}, nil
+		}
+		return i, nil
+	}
+}
+
+// evalMissingKey evaluates an attribute, yielding the zero value of its type if
+// a key or variable is missing
+type evalMissingKey struct {
+	interpreter.InterpretableAttribute
+	typeMap map[int64]*gexpr.Type
+}
+
+// Eval implements the interpreter.Interpretable interface.
+func (e *evalMissingKey) Eval(a interpreter.Activation) ref.Val {
+	v := e.InterpretableAttribute.Eval(a)
+	if !isMissingKey(v) {
+		return v
+	}
+
+	// The type of the attribute is the type of its last qualifier, the outermost
+	// key or field selected
+	id := e.ID()
+	if attr, ok := e.Attr().(interpreter.NamespacedAttribute); ok {
+		if q := attr.Qualifiers(); len(q) > 0 {
+			id = q[len(q)-1].ID()
+		}
+	}
+	return zeroValue(e.typeMap[id])
+}
+
+// isMissingKey reports whether v is the error of a missing map key or variable
+func isMissingKey(v ref.Val) bool {
+	err, ok := v.(*types.Err)
+	return ok && (strings.HasPrefix(err.Error(), "no such key") ||
+		strings.HasPrefix(err.Error(), "no such attribute"))
+}
+
+// zeroValue returns the zero value of the CEL type t
+func zeroValue(t *gexpr.Type) ref.Val {
+	switch t.GetPrimitive() {
+	case gexpr.Type_BOOL:
+		return types.False
+	case gexpr.Type_INT64:
+		return types.Int(0)
+	case gexpr.Type_UINT64:
+		return types.Uint(0)
+	case gexpr.Type_DOUBLE:
+		return types.Double(0)
+	case gexpr.Type_STRING:
+		return types.String("")
+	case gexpr.Type_BYTES:
+		return types.Bytes{}
+	}
+
+	switch t.GetWellKnown() {
+	case gexpr.Type_TIMESTAMP:
+		return types.Timestamp{Time: time.Unix(0, 0).UTC()}
+	case gexpr.Type_DURATION:
+		return types.Duration{}
+	}
+
+	switch {
+	case t.GetListType() != nil:
+		return types.NewDynamicList(types.DefaultTypeAdapter, []interface{}{})
+	case t.GetMapType() != nil:
+		return types.NewDynamicMap(types.DefaultTypeAdapter, map[string]interface{}{})
+	}
+	return types.NullValue
+}
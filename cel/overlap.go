@@ -0,0 +1,97 @@
+package cel
+
+// This file contains functions that determine whether two boolean expressions
+// can both be true for the same input.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ezachrisen/indigo"
+)
+
+// Overlap reports whether the boolean expressions a and b can both be true for the
+// same input data, by checking the comparisons of variables with constants in the
+// conjunctions of conditions (a && b && ...) of both expressions for contradictions.
+// The region is the conjunction of the combined comparisons, such as
+// `amount > 10 && amount <= 20 && country == "SE"`.
+// Overlap implements the indigo.ExpressionOverlapper interface.
+func (e *Evaluator) Overlap(a, b string, s indigo.Schema) (bool, string, error) {
+	var atoms []comparison
+	for _, expr := range []string{a, b} {
+		if expr == "" {
+			continue
+		}
+
+		_, ast, err := e.compile(expr, s, indigo.Bool{}, false)
+		if err != nil {
+			return false, "", err
+		}
+
+		for _, x := range junction(ast.Expr(), "_&&_") {
+			if v, ok := alwaysValue(x); ok && !v {
+				return false, "", nil
+			}
+			if cmp, ok := comparisonOf(x); ok {
+				atoms = append(atoms, cmp)
+			}
+		}
+	}
+
+	vars, ok := intervals(atoms)
+	if !ok {
+		return false, "", nil
+	}
+
+	names := make([]string, 0, len(vars))
+	for n, iv := range vars {
+		if iv.empty() {
+			return false, "", nil
+		}
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var region []string
+	for _, n := range names {
+		region = append(region, vars[n].conditions(n)...)
+	}
+	return true, strings.Join(region, " && "), nil
+}
+
+// conditions returns the comparisons of the variable that describe the interval
+func (iv *interval) conditions(variable string) []string {
+	if iv.lo != nil && iv.hi != nil && iv.lo.compare(*iv.hi) == 0 {
+		return []string{fmt.Sprintf("%s == %s", variable, iv.lo)}
+	}
+
+	var c []string
+	if iv.lo != nil {
+		op := ">="
+		if iv.loOpen {
+			op = ">"
+		}
+		c = append(c, fmt.Sprintf("%s %s %s", variable, op, iv.lo))
+	}
+	if iv.hi != nil {
+		op := "<="
+		if iv.hiOpen {
+			op = "<"
+		}
+		c = append(c, fmt.Sprintf("%s %s %s", variable, op, iv.hi))
+	}
+	for _, x := range iv.excluded {
+		c = append(c, fmt.Sprintf("%s != %s", variable, x))
+	}
+	return c
+}
+
+// String formats the scalar as a CEL literal.
+func (s scalar) String() string {
+	if s.isString {
+		return strconv.Quote(s.str)
+	}
+	return strconv.FormatFloat(s.num, 'f', -1, 64)
+}
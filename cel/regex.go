@@ -0,0 +1,249 @@
+package cel
+
+// This file contains the precompilation of regular expressions in rules,
+// and the matches_any function.
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/overloads"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/interpreter"
+	"github.com/google/cel-go/interpreter/functions"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// regexLib is a CEL library, used to compile every rule, that compiles the
+// constant patterns of the matches and matches_any functions when the rule is
+// compiled (see also precompileLists), instead of each time the rule is evaluated,
+// and declares matches_any:
+//
+//     matches_any(string, list(string)) bool
+//     string.matches_any(list(string)) bool
+//
+// matches_any returns true if the string matches any of the regular expressions.
+// Patterns that are not constants, such as patterns from the input data, are
+// compiled once and kept in a cache.
+type regexLib struct{}
+
+// matchesAnyArgs are the argument types of matches_any
+var matchesAnyArgs = []*gexpr.Type{decls.String, decls.NewListType(decls.String)}
+
+func (l regexLib) CompileOptions() []celgo.EnvOption {
+	return []celgo.EnvOption{celgo.Declarations(l.declarations()...)}
+}
+
+// declarations returns the declarations of the functions of the library
+func (regexLib) declarations() []*gexpr.Decl {
+	return []*gexpr.Decl{
+		decls.NewFunction("matches_any",
+			decls.NewOverload("matches_any_string_list", matchesAnyArgs, decls.Bool),
+			decls.NewInstanceOverload("string_matches_any_list", matchesAnyArgs, decls.Bool)),
+	}
+}
+
+func (regexLib) ProgramOptions() []celgo.ProgramOption {
+	return []celgo.ProgramOption{
+		celgo.Functions(
+			&functions.Overload{
+				Operator: "matches_any",
+				Binary: func(lhs, rhs ref.Val) ref.Val {
+					l, ok := rhs.(traits.Lister)
+					if !ok {
+						return types.ValOrErr(rhs, "no such overload")
+					}
+					res, err := compileList(l, cachedRegexp)
+					if err != nil {
+						return types.NewErr("%v", err)
+					}
+					return matchAny(lhs, res)
+				},
+			},
+		),
+		celgo.CustomDecorator(precompileRegex),
+	}
+}
+
+// precompileRegex replaces calls to matches and matches_any with a constant
+// pattern by calls using the compiled pattern. It returns an error if the pattern is invalid.
+func precompileRegex(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+	call, ok := i.(interpreter.InterpretableCall)
+	if !ok || len(call.Args()) != 2 {
+		return i, nil
+	}
+
+	pattern, ok := call.Args()[1].(interpreter.InterpretableConst)
+	if !ok {
+		return i, nil
+	}
+
+	var res []*regexp.Regexp
+	switch call.Function() {
+	case overloads.Matches:
+		s, ok := pattern.Value().(types.String)
+		if !ok {
+			return i, nil
+		}
+		re, err := regexp.Compile(string(s))
+		if err != nil {
+			return nil, err
+		}
+		res = []*regexp.Regexp{re}
+	case "matches_any":
+		l, ok := pattern.Value().(traits.Lister)
+		if !ok {
+			return i, nil
+		}
+		var err error
+		if res, err = compileList(l, regexp.Compile); err != nil {
+			return nil, err
+		}
+	default:
+		return i, nil
+	}
+
+	return &evalMatch{id: call.ID(), target: call.Args()[0], res: res}, nil
+}
+
+// precompileLists compiles the patterns in the list literals passed to matches_any
+// in the expression, adding them to the cache of compiled patterns.
+// It returns an error if a pattern is invalid.
+func precompileLists(x *gexpr.Expr) error {
+	if x == nil {
+		return nil
+	}
+
+	var children []*gexpr.Expr
+	switch k := x.ExprKind.(type) {
+	case *gexpr.Expr_CallExpr:
+		c := k.CallExpr
+		if c.Function == "matches_any" && len(c.Args) > 0 {
+			if err := precompileList(c.Args[len(c.Args)-1]); err != nil {
+				return err
+			}
+		}
+		children = append([]*gexpr.Expr{c.Target}, c.Args...)
+	case *gexpr.Expr_SelectExpr:
+		children = []*gexpr.Expr{k.SelectExpr.Operand}
+	case *gexpr.Expr_ListExpr:
+		children = k.ListExpr.Elements
+	case *gexpr.Expr_StructExpr:
+		for _, en := range k.StructExpr.Entries {
+			children = append(children, en.GetMapKey(), en.Value)
+		}
+	case *gexpr.Expr_ComprehensionExpr:
+		c := k.ComprehensionExpr
+		children = []*gexpr.Expr{c.IterRange, c.AccuInit, c.LoopCondition, c.LoopStep, c.Result}
+	}
+
+	for _, c := range children {
+		if err := precompileLists(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// precompileList compiles the constant patterns in a list literal
+func precompileList(x *gexpr.Expr) error {
+	for _, el := range x.GetListExpr().GetElements() {
+		if c := el.GetConstExpr(); c != nil {
+			if _, isString := c.ConstantKind.(*gexpr.Constant_StringValue); isString {
+				if _, err := cachedRegexp(c.GetStringValue()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// evalMatch matches a string against compiled regular expressions
+type evalMatch struct {
+	id     int64
+	target interpreter.Interpretable
+	res    []*regexp.Regexp
+}
+
+// ID implements the interpreter.Interpretable interface.
+func (m *evalMatch) ID() int64 {
+	return m.id
+}
+
+// Eval implements the interpreter.Interpretable interface.
+func (m *evalMatch) Eval(a interpreter.Activation) ref.Val {
+	return matchAny(m.target.Eval(a), m.res)
+}
+
+// matchAny returns true if the string v matches any of the regular expressions
+func matchAny(v ref.Val, res []*regexp.Regexp) ref.Val {
+	s, ok := v.(types.String)
+	if !ok {
+		return types.ValOrErr(v, "no such overload")
+	}
+
+	for _, re := range res {
+		if re.MatchString(string(s)) {
+			return types.True
+		}
+	}
+	return types.False
+}
+
+// compileList compiles the patterns in the list l with the function compile
+func compileList(l traits.Lister, compile func(string) (*regexp.Regexp, error)) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for it := l.Iterator(); it.HasNext() == types.True; {
+		s, ok := it.Next().(types.String)
+		if !ok {
+			return nil, errNotAString
+		}
+		re, err := compile(string(s))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// errNotAString is returned when a pattern is not a string
+var errNotAString = errors.New("pattern is not a string")
+
+// maxCachedRegexps is the number of compiled patterns kept in the cache;
+// when it is full, the cache is emptied
+const maxCachedRegexps = 1000
+
+var (
+	regexMu    sync.Mutex
+	regexCache = map[string]*regexp.Regexp{}
+)
+
+// cachedRegexp compiles the pattern, or returns the pattern compiled earlier
+func cachedRegexp(pattern string) (*regexp.Regexp, error) {
+	regexMu.Lock()
+	re, ok := regexCache[pattern]
+	regexMu.Unlock()
+	if ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	regexMu.Lock()
+	defer regexMu.Unlock()
+	if len(regexCache) >= maxCachedRegexps {
+		regexCache = map[string]*regexp.Regexp{}
+	}
+	regexCache[pattern] = re
+	return re, nil
+}
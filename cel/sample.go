@@ -0,0 +1,150 @@
+package cel
+
+// This file contains functions that propose values of the input data for
+// testing expressions, such as the boundary values of comparisons.
+
+import (
+	"strings"
+
+	"github.com/ezachrisen/indigo"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// SampleValues returns values of the schema elements that are likely to make the
+// expression pass or fail: the constant and its neighbors for comparisons with a
+// constant, the members of a list and a non-member for `in` tests, and matching and
+// non-matching strings for startsWith, endsWith and contains. Only elements of type
+// int, float and string that are compared with constants are included.
+// SampleValues implements the indigo.ExpressionSampler interface.
+func (e *Evaluator) SampleValues(expr string, s indigo.Schema) (map[string][]interface{}, error) {
+	samples := map[string][]interface{}{}
+	if expr == "" {
+		return samples, nil
+	}
+
+	_, ast, err := e.compile(expr, s, indigo.Bool{}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	types := map[string]indigo.Type{}
+	for _, el := range s.Elements {
+		types[el.Name] = el.Type
+	}
+
+	addSamples(ast.Expr(), types, samples)
+	return samples, nil
+}
+
+// addSamples adds sample values of the variables compared with constants in x
+// and its subexpressions to samples
+func addSamples(x *gexpr.Expr, types map[string]indigo.Type, samples map[string][]interface{}) {
+	c := x.GetCallExpr()
+	if c == nil {
+		if cx := x.GetComprehensionExpr(); cx != nil {
+			addSamples(cx.GetIterRange(), types, samples)
+			addSamples(cx.GetLoopStep(), types, samples)
+			addSamples(cx.GetResult(), types, samples)
+		}
+		return
+	}
+
+	add := func(variable *gexpr.Expr, values ...scalar) {
+		n, ok := selectName(variable)
+		if !ok {
+			return
+		}
+		for _, v := range values {
+			if gv, ok := v.value(types[n]); ok {
+				samples[n] = append(samples[n], gv)
+			}
+		}
+	}
+
+	args := c.GetArgs()
+	switch c.GetFunction() {
+	case "@in":
+		if len(args) == 2 {
+			var members []scalar
+			for _, el := range args[1].GetListExpr().GetElements() {
+				if v, ok := scalarOf(el); ok {
+					members = append(members, v)
+				}
+			}
+			add(args[0], append(members, nonMember(members))...)
+		}
+
+	case "startsWith", "endsWith", "contains":
+		if v, ok := scalarOf(firstArg(args)); ok && v.isString && c.GetTarget() != nil {
+			add(c.GetTarget(), v, scalar{isString: true, str: strings.Repeat("~", len(v.str)+1)})
+		}
+
+	default:
+		if cmp, ok := comparisonOf(x); ok && cmp.variable != "" {
+			variable := args[0]
+			if _, isConst := scalarOf(variable); isConst {
+				variable = args[1]
+			}
+			if c.GetFunction() == "!_" {
+				variable = nil
+			}
+			if variable != nil {
+				add(variable, cmp.right.neighbors()...)
+			}
+		}
+	}
+
+	if c.GetTarget() != nil {
+		addSamples(c.GetTarget(), types, samples)
+	}
+	for _, a := range args {
+		addSamples(a, types, samples)
+	}
+}
+
+// firstArg returns the first argument, or nil if there are no arguments
+func firstArg(args []*gexpr.Expr) *gexpr.Expr {
+	if len(args) == 0 {
+		return nil
+	}
+	return args[0]
+}
+
+// neighbors returns s and the values just below and above it
+func (s scalar) neighbors() []scalar {
+	if s.isString {
+		return []scalar{s, {isString: true}, {isString: true, str: s.str + "~"}}
+	}
+	return []scalar{{num: s.num - 1}, s, {num: s.num + 1}}
+}
+
+// nonMember returns a value that is not one of the members
+func nonMember(members []scalar) scalar {
+	if len(members) == 0 {
+		return scalar{}
+	}
+
+	v := members[0]
+	for _, m := range members[1:] {
+		if m.compare(v) > 0 {
+			v = m
+		}
+	}
+	if v.isString {
+		return scalar{isString: true, str: v.str + "~"}
+	}
+	return scalar{num: v.num + 1}
+}
+
+// value returns s as a Go value of the indigo type t
+func (s scalar) value(t indigo.Type) (interface{}, bool) {
+	switch t.(type) {
+	case indigo.Int:
+		return int64(s.num), !s.isString
+	case indigo.Float:
+		return s.num, !s.isString
+	case indigo.String:
+		return s.str, s.isString
+	}
+	return nil, false
+}
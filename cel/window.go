@@ -0,0 +1,94 @@
+package cel
+
+// This file contains CEL functions that query sliding-window aggregates
+// kept in a window.Store.
+
+import (
+	"github.com/ezachrisen/indigo/window"
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	gexpr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Windows makes the aggregates of the window store available to rule expressions
+// through these functions:
+//
+//     window_count(name, key, duration) int
+//     window_sum(name, key, duration) double
+//     window_distinct(name, key, duration) int
+//
+// For example, `window_count("logins", user_id, duration("5m")) > 10`.
+// The name and key are strings. The results reflect the events recorded
+// in the store at the time the rule is evaluated.
+func Windows(s *window.Store) Option {
+	return library(windowLib{store: s})
+}
+
+// windowLib is a CEL library with the window functions
+type windowLib struct {
+	store *window.Store
+}
+
+// windowArgs are the argument types of the window functions
+var windowArgs = []*gexpr.Type{decls.String, decls.String, decls.Duration}
+
+func (l windowLib) CompileOptions() []celgo.EnvOption {
+	return []celgo.EnvOption{celgo.Declarations(l.declarations()...)}
+}
+
+// declarations returns the declarations of the functions of the library
+func (windowLib) declarations() []*gexpr.Decl {
+	return []*gexpr.Decl{
+		decls.NewFunction("window_count",
+			decls.NewOverload("window_count_string_string_duration", windowArgs, decls.Int)),
+		decls.NewFunction("window_sum",
+			decls.NewOverload("window_sum_string_string_duration", windowArgs, decls.Double)),
+		decls.NewFunction("window_distinct",
+			decls.NewOverload("window_distinct_string_string_duration", windowArgs, decls.Int)),
+	}
+}
+
+func (l windowLib) ProgramOptions() []celgo.ProgramOption {
+	return []celgo.ProgramOption{
+		celgo.Functions(
+			&functions.Overload{
+				Operator: "window_count",
+				Function: windowFunc(func(name, key string, d types.Duration) ref.Val {
+					return types.Int(l.store.Count(name, key, d.Duration))
+				}),
+			},
+			&functions.Overload{
+				Operator: "window_sum",
+				Function: windowFunc(func(name, key string, d types.Duration) ref.Val {
+					return types.Double(l.store.Sum(name, key, d.Duration))
+				}),
+			},
+			&functions.Overload{
+				Operator: "window_distinct",
+				Function: windowFunc(func(name, key string, d types.Duration) ref.Val {
+					return types.Int(l.store.Distinct(name, key, d.Duration))
+				}),
+			},
+		),
+	}
+}
+
+// windowFunc checks the arguments of a window function before calling f
+func windowFunc(f func(name, key string, d types.Duration) ref.Val) functions.FunctionOp {
+	return func(args ...ref.Val) ref.Val {
+		if len(args) != 3 {
+			return types.NewErr("window functions take 3 arguments, got %d", len(args))
+		}
+
+		name, ok1 := args[0].(types.String)
+		key, ok2 := args[1].(types.String)
+		d, ok3 := args[2].(types.Duration)
+		if !ok1 || !ok2 || !ok3 {
+			return types.NewErr("window functions take (string, string, duration) arguments")
+		}
+		return f(string(name), string(key), d)
+	}
+}
@@ -0,0 +1,128 @@
+package indigo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Chain evaluates the rule r and its children in forward-chaining mode.
+// Rules with an Output derive facts: after each evaluation, the results of those
+// rules are added to the input data under their Output names, and the rules are
+// evaluated again, until the derived facts no longer change (a fixpoint).
+// This allows rules to build on the conclusions of other rules, such as an
+// eligibility rule that depends on a "high_risk" fact derived by other rules.
+//
+// Before the first evaluation, each output that is not in the input data is set to
+// the zero value of its rule's ResultType (false for boolean rules), so rules
+// referring to it can be compiled and evaluated. The input data is not modified.
+//
+// Chain returns the result of the last evaluation and the derived facts.
+// If the facts have not reached a fixpoint after maxIterations evaluations,
+// Chain returns ErrNoFixpoint, along with the last result and facts.
+func Chain(ctx context.Context, e Evaluator, r *Rule, d map[string]interface{},
+	maxIterations int, opts ...EvalOption) (*Result, map[string]interface{}, error) {
+
+	if e == nil {
+		return nil, nil, ErrNilEngine
+	}
+
+	if r == nil {
+		return nil, nil, ErrNilRule
+	}
+
+	if d == nil {
+		return nil, nil, ErrNilData
+	}
+
+	outputs := map[string]*Rule{}
+	Walk(r, func(c *Rule, _ int) bool {
+		if c != nil && c.Output != "" {
+			outputs[c.Output] = c
+		}
+		return true
+	})
+
+	facts := make(map[string]interface{}, len(outputs))
+	data := make(map[string]interface{}, len(d)+len(outputs))
+	for k, v := range d {
+		data[k] = v
+	}
+	for name, c := range outputs {
+		if _, ok := data[name]; !ok {
+			data[name] = zeroValue(defaultResultType(c))
+		}
+	}
+
+	// Collect the results of the rules with outputs, including
+	// results discarded from the result tree
+	derived := map[string]interface{}{}
+	opts = collectOutputs(derived, opts)
+
+	var u *Result
+	for i := 0; i < maxIterations; i++ {
+		var err error
+		u, err = e.Eval(ctx, r, data, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		changed := false
+		for name, v := range derived {
+			if !reflect.DeepEqual(data[name], v) {
+				changed = true
+			}
+			data[name] = v
+			facts[name] = v
+		}
+
+		if !changed {
+			return u, facts, nil
+		}
+	}
+	return u, facts, fmt.Errorf("%w after %d iterations", ErrNoFixpoint, maxIterations)
+}
+
+// collectOutputs returns the options opts with an OnResult function added,
+// which collects the results of rules with an Output into derived, including
+// results discarded from the result tree. An OnResult function in opts is still called.
+func collectOutputs(derived map[string]interface{}, opts []EvalOption) []EvalOption {
+	o := EvalOptions{}
+	applyEvaluatorOptions(&o, opts...)
+
+	collect := OnResult(func(u *Result) {
+		if o.OnResult != nil {
+			o.OnResult(u)
+		}
+		if u.Rule.Output == "" || u.Status == StatusError || u.Status == StatusSkipped {
+			return
+		}
+		if _, isBool := defaultResultType(u.Rule).(Bool); isBool {
+			derived[u.Rule.Output] = u.Pass
+		} else {
+			derived[u.Rule.Output] = u.Value
+		}
+	})
+	return append(opts[:len(opts):len(opts)], collect)
+}
+
+// zeroValue returns the zero value of a simple type, or nil for
+// other types
+func zeroValue(t Type) interface{} {
+	switch t.(type) {
+	case Bool:
+		return false
+	case Int:
+		return int64(0)
+	case Float:
+		return 0.0
+	case String:
+		return ""
+	case Duration:
+		return time.Duration(0)
+	case Timestamp:
+		return time.Time{}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package indigo
+
+import (
+	"context"
+)
+
+// OnClose registers a function that releases a resource used with the engine,
+// such as a watcher, a decision cache or a connection to a rule store. The
+// functions are called by Close, in the reverse order of registration, once the
+// evaluations in progress have finished.
+func (e *DefaultEngine) OnClose(f func() error) {
+	e.closeMu.Lock()
+	defer e.closeMu.Unlock()
+	e.closers = append(e.closers, f)
+}
+
+// Close shuts the engine down gracefully: new evaluations fail with ErrEngineClosed,
+// and Close waits for the evaluations in progress to finish, then releases the
+// compiled programs shared between rules and calls the functions registered with
+// OnClose, returning the first error.
+//
+// If the context is done before the evaluations in progress finish, Close returns
+// the context's error without releasing the resources; call Close again to keep waiting.
+// Calling Close after it has succeeded does nothing.
+func (e *DefaultEngine) Close(ctx context.Context) error {
+	e.closeMu.Lock()
+	e.closed = true
+	e.closeMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	e.closeMu.Lock()
+	if e.released {
+		e.closeMu.Unlock()
+		return nil
+	}
+	e.released = true
+	closers := e.closers
+	e.closers = nil
+	e.closeMu.Unlock()
+
+	e.mu.Lock()
+	e.programs = nil
+	e.mu.Unlock()
+
+	var first error
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i](); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// enter registers the start of an evaluation, returning false if the engine is closed.
+// If it returns true, the caller must call e.inflight.Done when the evaluation ends.
+func (e *DefaultEngine) enter() bool {
+	e.closeMu.RLock()
+	defer e.closeMu.RUnlock()
+	if e.closed {
+		return false
+	}
+	e.inflight.Add(1)
+	return true
+}
@@ -0,0 +1,16 @@
+package main
+
+// #include <stdlib.h>
+import "C"
+
+// The tests cannot use cgo, so they convert strings with these functions
+
+// cString returns s as a C string allocated with malloc
+func cString(s string) *C.char {
+	return C.CString(s)
+}
+
+// goString returns the C string s as a Go string
+func goString(s *C.char) string {
+	return C.GoString(s)
+}
@@ -0,0 +1,80 @@
+// Command indigo-capi builds a C shared library that validates and evaluates rule
+// bundles, so that services written in other languages, such as Python, run the
+// same rules as Go services without a network service (see package bindings).
+//
+// Build the library, and its C header, with:
+//
+//     go build -buildmode=c-shared -o libindigo.so ./cmd/indigo-capi
+//
+// The library exports:
+//
+//     char *indigo_evaluate(char *bundle, char *data);
+//     char *indigo_validate(char *bundle);
+//     void  indigo_free(char *s);
+//
+// bundle is a rule bundle produced by indigo.Export, and data is a JSON object, both
+// as NUL-terminated UTF-8 strings. indigo_evaluate returns {"result": {...}} or
+// {"rule_id": ..., "message": ...}, and indigo_validate returns "null" or the problem,
+// as JSON strings that the caller must release with indigo_free. For example, with
+// Python's ctypes:
+//
+//     lib = ctypes.CDLL("./libindigo.so")
+//     lib.indigo_evaluate.restype = ctypes.c_void_p
+//     lib.indigo_free.argtypes = [ctypes.c_void_p]
+//     p = lib.indigo_evaluate(json.dumps(bundle).encode(), json.dumps(data).encode())
+//     result = json.loads(ctypes.string_at(p))
+//     lib.indigo_free(p)
+package main
+
+// #include <stdlib.h>
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"unsafe"
+
+	"github.com/ezachrisen/indigo/bindings"
+)
+
+// A c-shared library needs a main function, which is not called
+func main() {}
+
+//export indigo_evaluate
+func indigo_evaluate(bundle, data *C.char) *C.char {
+	b, err := bindings.Evaluate(context.Background(), []byte(C.GoString(bundle)), []byte(C.GoString(data)))
+	if err != nil {
+		return toC(problemOf(err))
+	}
+	return C.CString(`{"result":` + string(b) + `}`)
+}
+
+//export indigo_validate
+func indigo_validate(bundle *C.char) *C.char {
+	if p := bindings.Validate([]byte(C.GoString(bundle))); p != nil {
+		return toC(p)
+	}
+	return C.CString("null")
+}
+
+//export indigo_free
+func indigo_free(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+// problemOf returns the problem reported by err
+func problemOf(err error) *bindings.Problem {
+	if p, ok := err.(*bindings.Problem); ok {
+		return p
+	}
+	return &bindings.Problem{Message: err.Error()}
+}
+
+// toC returns v encoded as JSON, in a C string allocated with malloc
+func toC(v interface{}) *C.char {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(&bindings.Problem{Message: err.Error()})
+	}
+	return C.CString(string(b))
+}
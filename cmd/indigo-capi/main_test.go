@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/matryer/is"
+)
+
+func TestExports(t *testing.T) {
+	is := is.New(t)
+
+	b, err := indigo.Export(&indigo.Rule{
+		ID:     "root",
+		Schema: indigo.Schema{Elements: []indigo.DataElement{{Name: "amount", Type: indigo.Int{}}}},
+		Expr:   "amount > 10",
+	})
+	is.NoErr(err)
+	bundle, err := json.Marshal(b)
+	is.NoErr(err)
+
+	validate := func(bundle string) string {
+		cb := cString(bundle)
+		defer indigo_free(cb)
+		out := indigo_validate(cb)
+		defer indigo_free(out)
+		return goString(out)
+	}
+	evaluate := func(bundle, data string) string {
+		cb, cd := cString(bundle), cString(data)
+		defer indigo_free(cb)
+		defer indigo_free(cd)
+		out := indigo_evaluate(cb, cd)
+		defer indigo_free(out)
+		return goString(out)
+	}
+
+	is.Equal(validate(string(bundle)), "null")
+	is.True(validate("{") != "null")
+
+	var res struct {
+		Result struct {
+			RuleID string `json:"rule_id"`
+			Pass   bool   `json:"pass"`
+		} `json:"result"`
+	}
+	is.NoErr(json.Unmarshal([]byte(evaluate(string(bundle), `{"amount": 50}`)), &res))
+	is.Equal(res.Result.RuleID, "root")
+	is.True(res.Result.Pass)
+
+	var p struct {
+		Message string `json:"message"`
+	}
+	is.NoErr(json.Unmarshal([]byte(evaluate(string(bundle), `[`)), &p))
+	is.True(p.Message != "") // malformed data
+}
@@ -0,0 +1,261 @@
+// Command indigo-lsp is a language server that checks CEL rule expressions as they
+// are typed, so that editors supporting the Language Server Protocol show problems
+// in rules before they are saved (see cel.Evaluator.Check).
+//
+// Each open document holds the expression of a rule, checked against a schema read
+// from a JSON file, as encoded by indigo.Schema's JSON encoding:
+//
+//     go build ./cmd/indigo-lsp
+//     indigo-lsp -schema student.json -result bool
+//
+// The server communicates over standard input and output, with full document
+// synchronization, and publishes diagnostics on every change. Protocol buffer types
+// in the schema must be compiled into the server.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/cel"
+)
+
+func main() {
+	schemaFile := flag.String("schema", "", "the JSON file of the schema of the rules")
+	result := flag.String("result", "bool", "the result type of the rules")
+	flag.Parse()
+	log.SetOutput(os.Stderr)
+
+	var s indigo.Schema
+	if *schemaFile != "" {
+		b, err := ioutil.ReadFile(*schemaFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := json.Unmarshal(b, &s); err != nil {
+			log.Fatalf("reading schema: %v", err)
+		}
+	}
+	t, err := indigo.ParseType(*result)
+	if err != nil {
+		log.Fatalf("result type: %v", err)
+	}
+
+	srv := &server{
+		e:      cel.NewEvaluator(),
+		schema: s,
+		result: t,
+		out:    os.Stdout,
+	}
+	if err := srv.serve(os.Stdin); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// server is a language server checking the expressions of the open documents
+type server struct {
+	e      *cel.Evaluator
+	schema indigo.Schema
+	result indigo.Type
+	out    io.Writer
+}
+
+// message is a JSON-RPC request, notification or response
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type diagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+// document is the text document of params, and its content changes
+type document struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+// serve handles the messages read from in until the exit notification
+func (s *server) serve(in io.Reader) error {
+	r := bufio.NewReader(in)
+	for {
+		m, err := read(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch m.Method {
+		case "initialize":
+			s.reply(m, map[string]interface{}{
+				"capabilities": map[string]interface{}{
+					"textDocumentSync": 1, // full document
+				},
+				"serverInfo": map[string]string{"name": "indigo-lsp"},
+			})
+		case "shutdown":
+			s.reply(m, nil)
+		case "exit":
+			return nil
+		case "textDocument/didOpen", "textDocument/didChange":
+			var d document
+			if err := json.Unmarshal(m.Params, &d); err != nil {
+				log.Printf("%s: %v", m.Method, err)
+				continue
+			}
+			text := d.TextDocument.Text
+			if n := len(d.ContentChanges); n > 0 {
+				text = d.ContentChanges[n-1].Text
+			}
+			s.publish(d.TextDocument.URI, text)
+		case "textDocument/didClose":
+			var d document
+			if err := json.Unmarshal(m.Params, &d); err == nil {
+				s.send(notification(d.TextDocument.URI, []diagnostic{}))
+			}
+		default:
+			if m.ID != nil {
+				s.send(message{JSONRPC: "2.0", ID: m.ID, Error: &responseError{Code: -32601, Message: "method not found: " + m.Method}})
+			}
+		}
+	}
+}
+
+// publish checks the expression text of the document with the uri, and publishes
+// its diagnostics
+func (s *server) publish(uri, text string) {
+	res, err := s.e.Check(text, s.schema, s.result)
+	if err != nil {
+		log.Printf("checking %s: %v", uri, err)
+		return
+	}
+
+	ds := []diagnostic{}
+	for _, d := range res.Diagnostics {
+		rng := lspRange{End: end(text)} // the whole expression
+		if d.Line > 0 {
+			p := position{Line: d.Line - 1, Character: utf16Column(text, d.Line, d.Column)}
+			rng = lspRange{Start: p, End: p}
+		}
+		ds = append(ds, diagnostic{Range: rng, Severity: 1, Source: "indigo", Message: d.Message})
+	}
+	s.send(notification(uri, ds))
+}
+
+// notification returns the notification publishing the diagnostics of the document
+func notification(uri string, ds []diagnostic) message {
+	params, _ := json.Marshal(map[string]interface{}{"uri": uri, "diagnostics": ds})
+	return message{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics", Params: params}
+}
+
+// utf16Column converts the column in characters of the line (starting at 1) of
+// the text to a column in UTF-16 code units, as used by the protocol
+func utf16Column(text string, line, column int) int {
+	lines := strings.Split(text, "\n")
+	if line > len(lines) {
+		return 0
+	}
+	r := []rune(lines[line-1])
+	if column > len(r) {
+		column = len(r)
+	}
+	return len(utf16.Encode(r[:column]))
+}
+
+// end returns the position of the end of the text
+func end(text string) position {
+	lines := strings.Split(text, "\n")
+	last := []rune(lines[len(lines)-1])
+	return position{Line: len(lines) - 1, Character: len(utf16.Encode(last))}
+}
+
+// reply responds to the request m with the result
+func (s *server) reply(m *message, result interface{}) {
+	if result == nil {
+		result = json.RawMessage("null")
+	}
+	s.send(message{JSONRPC: "2.0", ID: m.ID, Result: result})
+}
+
+// send writes the message m, with its header
+func (s *server) send(m message) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(b), b)
+}
+
+// read reads a message, with its header
+func read(r *bufio.Reader) (*message, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, fmt.Errorf("header %q: %w", line, err)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	var m message
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("decoding message: %w", err)
+	}
+	return &m, nil
+}
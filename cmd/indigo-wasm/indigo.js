@@ -0,0 +1,19 @@
+// indigo.js loads the Indigo WebAssembly module (see main.go) and returns its
+// functions. Load wasm_exec.js, from the Go distribution, first.
+//
+//     const indigo = await loadIndigo("indigo.wasm");
+//     const problem = indigo.validate(JSON.stringify(bundle));
+//     const preview = indigo.evaluate(JSON.stringify(bundle), JSON.stringify(data));
+async function loadIndigo(url) {
+  const go = new Go();
+  const { instance } = await WebAssembly.instantiateStreaming(fetch(url), go.importObject);
+  go.run(instance); // returns when the module exits, which it does not
+  return {
+    // validate returns null if the bundle is valid, or {rule_id, message}
+    validate: (bundle) => globalThis.indigo.validate(bundle),
+
+    // evaluate returns {result}, or {rule_id, message} if the bundle is not valid
+    // or cannot be evaluated with the data
+    evaluate: (bundle, data) => globalThis.indigo.evaluate(bundle, data),
+  };
+}
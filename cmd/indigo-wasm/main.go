@@ -0,0 +1,76 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Command indigo-wasm exposes the validation and evaluation of rule bundles to
+// JavaScript, so that a rule-authoring web UI can check and preview rules in the
+// browser, with the same semantics as in Go services (see package bindings).
+//
+// Build it, and copy the Go support script, with:
+//
+//     GOOS=js GOARCH=wasm go build -o indigo.wasm ./cmd/indigo-wasm
+//     cp "$(go env GOROOT)/misc/wasm/wasm_exec.js" .   # lib/wasm since Go 1.24
+//
+// Load indigo.js after wasm_exec.js, and wait for the module:
+//
+//     const indigo = await loadIndigo("indigo.wasm");
+//     indigo.validate(bundleJSON);           // null, or {rule_id, message}
+//     indigo.evaluate(bundleJSON, dataJSON); // {result}, or {rule_id, message}
+//
+// Bundles are JSON strings produced by indigo.Export; data is a JSON object string.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"syscall/js"
+
+	"github.com/ezachrisen/indigo/bindings"
+)
+
+func main() {
+	js.Global().Set("indigo", js.ValueOf(map[string]interface{}{
+		"validate": js.FuncOf(validate),
+		"evaluate": js.FuncOf(evaluate),
+	}))
+
+	// Keep the functions available to JavaScript
+	select {}
+}
+
+// validate returns null if the bundle in args[0] is valid, or the problem
+func validate(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return toJS(&bindings.Problem{Message: "validate(bundle): missing bundle"})
+	}
+
+	if p := bindings.Validate([]byte(args[0].String())); p != nil {
+		return toJS(p)
+	}
+	return js.Null()
+}
+
+// evaluate evaluates the bundle in args[0] with the data in args[1], returning
+// {result} or the problem
+func evaluate(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return toJS(&bindings.Problem{Message: "evaluate(bundle, data): missing arguments"})
+	}
+
+	b, err := bindings.Evaluate(context.Background(), []byte(args[0].String()), []byte(args[1].String()))
+	if err != nil {
+		if p, ok := err.(*bindings.Problem); ok {
+			return toJS(p)
+		}
+		return toJS(&bindings.Problem{Message: err.Error()})
+	}
+	return toJS(json.RawMessage(`{"result":` + string(b) + `}`))
+}
+
+// toJS converts v to a JavaScript value through JSON
+func toJS(v interface{}) js.Value {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return js.ValueOf(map[string]interface{}{"message": err.Error()})
+	}
+	return js.Global().Get("JSON").Call("parse", string(b))
+}
@@ -0,0 +1,233 @@
+package indigo
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Coerce returns the data with the values converted to the types of the schema
+// elements with the same names, where a value has a different but compatible type.
+// Use it with data decoded from JSON, whose numbers are float64 (or json.Number),
+// and whose timestamps and durations are strings. It converts:
+//
+//     numbers (float64, json.Number, or any Go integer or float type) to int64 for Int, and float64 for Float
+//     strings in RFC 3339 format to time.Time for Timestamp
+//     strings such as "1h30m" to time.Duration for Duration
+//
+// Elements of lists and maps are converted to the schema's value types.
+// json.Number values of other types are converted to float64.
+// Values that cannot be converted, such as 1.5 for an Int, return an error;
+// other mismatches are left for the evaluator to report.
+//
+// The data is not modified: if any value is converted, Coerce returns a copy.
+func (s *Schema) Coerce(d map[string]interface{}) (map[string]interface{}, error) {
+	var x map[string]interface{}
+	for k, v := range d {
+		var t Type
+		for _, e := range s.Elements {
+			if e.Name == k {
+				t = e.Type
+				break
+			}
+		}
+
+		c, changed, err := coerce(v, t)
+		if err != nil {
+			return nil, fmt.Errorf("data element %s: %w", k, err)
+		}
+		if !changed {
+			continue
+		}
+
+		if x == nil {
+			x = make(map[string]interface{}, len(d))
+			for k, v := range d {
+				x[k] = v
+			}
+		}
+		x[k] = c
+	}
+
+	if x == nil {
+		return d, nil
+	}
+	return x, nil
+}
+
+// coerce converts the value v to the type t, reporting whether it changed
+func coerce(v interface{}, t Type) (interface{}, bool, error) {
+	switch t := t.(type) {
+	case Int:
+		return coerceInt(v)
+	case Float:
+		f, ok := toFloat(v)
+		if !ok {
+			return v, false, nil
+		}
+		_, same := v.(float64)
+		return f, !same, nil
+	case Timestamp:
+		if s, ok := v.(string); ok {
+			ts, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, false, err
+			}
+			return ts, true, nil
+		}
+	case Duration:
+		if s, ok := v.(string); ok {
+			dur, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, false, err
+			}
+			return dur, true, nil
+		}
+	case List:
+		return coerceList(v, t.ValueType)
+	case Map:
+		return coerceMap(v, t.ValueType)
+	}
+
+	switch x := v.(type) {
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil, err
+	case []interface{}:
+		return coerceList(x, nil)
+	case map[string]interface{}:
+		return coerceMap(x, nil)
+	}
+	return v, false, nil
+}
+
+// coerceInt converts a number to int64
+func coerceInt(v interface{}) (interface{}, bool, error) {
+	switch x := v.(type) {
+	case int64:
+		return x, false, nil
+	case int:
+		return int64(x), true, nil
+	case int8:
+		return int64(x), true, nil
+	case int16:
+		return int64(x), true, nil
+	case int32:
+		return int64(x), true, nil
+	case uint8:
+		return int64(x), true, nil
+	case uint16:
+		return int64(x), true, nil
+	case uint32:
+		return int64(x), true, nil
+	case json.Number:
+		i, err := x.Int64()
+		if err != nil {
+			return nil, false, fmt.Errorf("%s is not an int", x)
+		}
+		return i, true, nil
+	}
+
+	f, ok := toFloat(v)
+	if !ok {
+		return v, false, nil
+	}
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return nil, false, fmt.Errorf("%v is not an int", v)
+	}
+	return int64(f), true, nil
+}
+
+// toFloat converts a number to float64, reporting whether v is a number
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case int8:
+		return float64(x), true
+	case int16:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint:
+		return float64(x), true
+	case uint8:
+		return float64(x), true
+	case uint16:
+		return float64(x), true
+	case uint32:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// coerceList converts the elements of a list to the type t
+func coerceList(v interface{}, t Type) (interface{}, bool, error) {
+	l, ok := v.([]interface{})
+	if !ok {
+		return v, false, nil
+	}
+
+	var x []interface{}
+	for i, e := range l {
+		c, changed, err := coerce(e, t)
+		if err != nil {
+			return nil, false, fmt.Errorf("element %d: %w", i, err)
+		}
+		if !changed {
+			continue
+		}
+		if x == nil {
+			x = append([]interface{}{}, l...)
+		}
+		x[i] = c
+	}
+
+	if x == nil {
+		return v, false, nil
+	}
+	return x, true, nil
+}
+
+// coerceMap converts the values of a map to the type t
+func coerceMap(v interface{}, t Type) (interface{}, bool, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v, false, nil
+	}
+
+	var x map[string]interface{}
+	for k, e := range m {
+		c, changed, err := coerce(e, t)
+		if err != nil {
+			return nil, false, fmt.Errorf("key %s: %w", k, err)
+		}
+		if !changed {
+			continue
+		}
+		if x == nil {
+			x = make(map[string]interface{}, len(m))
+			for k, e := range m {
+				x[k] = e
+			}
+		}
+		x[k] = c
+	}
+
+	if x == nil {
+		return v, false, nil
+	}
+	return x, true, nil
+}
@@ -0,0 +1,96 @@
+package indigo
+
+import (
+	"reflect"
+)
+
+// combinedGroup is a group of child rules whose expressions are
+// evaluated together by one program
+type combinedGroup struct {
+	ids     []string
+	exprs   []string
+	program interface{}
+}
+
+// combinable returns true if the rule's expression can be evaluated
+// in a combined program. A rule with enrichers is not, since the combined
+// program is evaluated with its parent's data.
+func combinable(r *Rule) bool {
+	if r == nil || len(r.Rules) > 0 || r.Self != nil || r.ForEach != "" || r.Expr == "" || r.Guard != "" || len(r.Enrich) > 0 {
+		return false
+	}
+	_, isBool := defaultResultType(r).(Bool)
+	return isBool
+}
+
+// combineChildren groups the combinable child rules of r by schema, and compiles
+// a combined program for each group of two or more rules. Groups that fail to
+// compile are left out; their rules are evaluated one by one.
+func combineChildren(r *Rule, x ExpressionCombiner) []combinedGroup {
+	groups := [][]*Rule{}
+	for _, c := range r.sortChildKeys(EvalOptions{}) {
+		if !combinable(c) {
+			continue
+		}
+
+		i := 0
+		for i < len(groups) && !reflect.DeepEqual(groups[i][0].Schema, c.Schema) {
+			i++
+		}
+		if i == len(groups) {
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], c)
+	}
+
+	compiled := []combinedGroup{}
+	for _, g := range groups {
+		if len(g) < 2 {
+			continue
+		}
+
+		exprs := make([]string, 0, len(g))
+		ids := make([]string, 0, len(g))
+		for _, c := range g {
+			exprs = append(exprs, c.Expr)
+			ids = append(ids, c.ID)
+		}
+
+		prg, err := x.CompileCombined(exprs, g[0].Schema)
+		if err != nil {
+			continue
+		}
+		compiled = append(compiled, combinedGroup{ids: ids, exprs: exprs, program: prg})
+	}
+
+	if len(compiled) == 0 {
+		return nil
+	}
+	return compiled
+}
+
+// evaluateCombined evaluates the combined programs of the child rules of r,
+// returning a cache with the value of each combined rule's expression.
+// Groups whose programs fail are left out of the cache.
+func (e *DefaultEngine) evaluateCombined(r *Rule, d map[string]interface{}) *exprCache {
+	x, ok := e.e.(ExpressionCombiner)
+	if !ok {
+		return nil
+	}
+
+	c := &exprCache{values: map[*Rule]cachedValue{}}
+	for _, g := range r.combined {
+		vals, err := x.EvaluateCombined(d, g.program)
+		if err != nil || len(vals) != len(g.ids) {
+			continue
+		}
+		for i, id := range g.ids {
+			// If the child rule has been replaced since the program was
+			// compiled, its value is not used
+			if cr, ok := r.Rules[id]; ok && cr.Expr == g.exprs[i] {
+				c.values[cr] = cachedValue{val: vals[i]}
+			}
+		}
+	}
+	return c
+}
@@ -0,0 +1,42 @@
+package indigo
+
+import (
+	"fmt"
+)
+
+// Complexity returns the complexity of the expressions of the rule and its
+// descendants, by rule ID. Rules without an expression are not included.
+// The evaluator must implement ExpressionAnalyzer.
+func (e *DefaultEngine) Complexity(r *Rule) (map[string]Complexity, error) {
+	if err := validateCompileArguments(r, e); err != nil {
+		return nil, err
+	}
+
+	a, ok := e.e.(ExpressionAnalyzer)
+	if !ok {
+		return nil, fmt.Errorf("evaluator %T cannot analyze expressions", e.e)
+	}
+
+	m := map[string]Complexity{}
+	err := ApplyToRule(r, func(cr *Rule) error {
+		if cr == nil {
+			return ErrNilRule
+		}
+		if cr.Expr == "" {
+			return nil
+		}
+
+		schema, err := e.exprSchemaOf(cr)
+		if err != nil {
+			return &CompileError{RuleID: cr.ID, Err: err}
+		}
+
+		info, err := a.Analyze(cr.Expr, schema, defaultResultType(cr))
+		if err != nil {
+			return &CompileError{RuleID: cr.ID, Err: err}
+		}
+		m[cr.ID] = info.Complexity
+		return nil
+	})
+	return m, err
+}
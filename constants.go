@@ -0,0 +1,45 @@
+package indigo
+
+// SetConstants sets reference data, such as country lists, limits and fee tables,
+// that is added to the input data of every evaluation by the engine, so that callers
+// don't have to copy it into each data map. Declare the constants in the schemas of
+// the rules that refer to them.
+//
+// Input data takes precedence over a constant with the same name. The input data
+// is not modified; the constants are added to a copy.
+//
+// SetConstants replaces all constants atomically: an evaluation in progress uses
+// either the previous or the new constants, never a mix. The map must not be modified
+// after it is passed to SetConstants. Pass nil to remove the constants.
+func (e *DefaultEngine) SetConstants(c map[string]interface{}) {
+	e.constants.Store(constants{values: c})
+}
+
+// Constants returns the constants set with SetConstants.
+// The map must not be modified.
+func (e *DefaultEngine) Constants() map[string]interface{} {
+	c, _ := e.constants.Load().(constants)
+	return c.values
+}
+
+// constants wraps the constants in the engine's atomic.Value, which cannot store nil
+type constants struct {
+	values map[string]interface{}
+}
+
+// withConstants returns the input data with the engine's constants added
+func (e *DefaultEngine) withConstants(d map[string]interface{}) map[string]interface{} {
+	c := e.Constants()
+	if len(c) == 0 || d == nil {
+		return d
+	}
+
+	x := make(map[string]interface{}, len(d)+len(c))
+	for k, v := range c {
+		x[k] = v
+	}
+	for k, v := range d {
+		x[k] = v
+	}
+	return x
+}
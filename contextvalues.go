@@ -0,0 +1,60 @@
+package indigo
+
+import (
+	"context"
+)
+
+// ContextValuesKey is the name under which the context values (see WithContextValues)
+// are added to the input data, and referred to in rule expressions.
+const ContextValuesKey = "ctx"
+
+// contextValuesKey is the context key of the context values
+type contextValuesKey struct{}
+
+// WithContextValues returns a copy of ctx carrying request-scoped values, such as the
+// user ID, the request ID or the environment. When a rule is evaluated with the context,
+// the values are added to the input data as a map under the key ContextValuesKey,
+// and rule expressions can refer to them, as in `ctx.environment == "production"`,
+// without declaring them in the schemas of the business data. (The CEL evaluator
+// declares ctx as a map of dynamically typed values.) Values added to ctx earlier
+// are kept, unless replaced by values with the same name.
+//
+// The map must not be modified after it is passed to WithContextValues.
+func WithContextValues(ctx context.Context, v map[string]interface{}) context.Context {
+	if prev := ContextValues(ctx); len(prev) > 0 {
+		merged := make(map[string]interface{}, len(prev)+len(v))
+		for k, x := range prev {
+			merged[k] = x
+		}
+		for k, x := range v {
+			merged[k] = x
+		}
+		v = merged
+	}
+	return context.WithValue(ctx, contextValuesKey{}, v)
+}
+
+// ContextValues returns the values added to ctx with WithContextValues.
+// The map must not be modified.
+func ContextValues(ctx context.Context) map[string]interface{} {
+	v, _ := ctx.Value(contextValuesKey{}).(map[string]interface{})
+	return v
+}
+
+// inputData returns the input data with the engine's constants (see SetConstants)
+// and the context values added
+func (e *DefaultEngine) inputData(ctx context.Context, d map[string]interface{}) map[string]interface{} {
+	d = e.withConstants(d)
+
+	v := ContextValues(ctx)
+	if len(v) == 0 || d == nil {
+		return d
+	}
+
+	x := make(map[string]interface{}, len(d)+1)
+	for k, val := range d {
+		x[k] = val
+	}
+	x[ContextValuesKey] = v
+	return x
+}
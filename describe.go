@@ -0,0 +1,116 @@
+package indigo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuleDescription is a structured description of a rule and its children,
+// for use by management tools, exporters and diagnostics.
+type RuleDescription struct {
+	// The rule ID
+	ID string `json:"id"`
+
+	// The rule expression
+	Expr string `json:"expr"`
+
+	// The rule's guard (see Rule.Guard)
+	Guard string `json:"guard,omitempty"`
+
+	// The ID of the rule's schema, and the names of the schema's elements
+	SchemaID       string   `json:"schema_id,omitempty"`
+	SchemaElements []string `json:"schema_elements,omitempty"`
+
+	// The result type of the rule; defaults to bool
+	ResultType string `json:"result_type"`
+
+	// True if the rule has a compiled program.
+	// Depending on the evaluator, a rule without an expression may not have a program.
+	Compiled bool `json:"compiled"`
+
+	// The evaluation options set on the rule
+	EvalOptions EvalOptions `json:"eval_options"`
+
+	// The rule's metadata
+	Metadata RuleMetadata `json:"metadata"`
+
+	// Descriptions of the child rules, sorted by ID
+	Children []*RuleDescription `json:"children,omitempty"`
+
+	// Descriptions of the else rules (see Rule.ElseRules), sorted by ID
+	Else []*RuleDescription `json:"else,omitempty"`
+}
+
+// Describe returns a description of the rule r and its children.
+func Describe(r *Rule) *RuleDescription {
+	if r == nil {
+		return nil
+	}
+
+	d := &RuleDescription{
+		ID:          r.ID,
+		Expr:        r.Expr,
+		Guard:       r.Guard,
+		SchemaID:    r.Schema.ID,
+		ResultType:  defaultResultType(r).String(),
+		Compiled:    r.Program != nil,
+		EvalOptions: r.EvalOptions,
+		Metadata:    r.Metadata,
+	}
+
+	for _, e := range r.Schema.Elements {
+		d.SchemaElements = append(d.SchemaElements, e.Name)
+	}
+
+	for _, c := range r.sortChildKeys(EvalOptions{}) {
+		if cd := Describe(c); cd != nil {
+			d.Children = append(d.Children, cd)
+		}
+	}
+	for _, c := range sortRules(r.ElseRules, EvalOptions{}) {
+		if cd := Describe(c); cd != nil {
+			d.Else = append(d.Else, cd)
+		}
+	}
+
+	return d
+}
+
+// Describe returns a description of the rule with the id, and its children.
+func (v *Vault) Describe(id string) (*RuleDescription, error) {
+	r, err := v.Rule(id)
+	if err != nil {
+		return nil, err
+	}
+	return Describe(r), nil
+}
+
+// String returns an indented, human-readable outline of the rule and its children.
+func (d *RuleDescription) String() string {
+	s := strings.Builder{}
+	d.write(&s, 0)
+	return s.String()
+}
+
+func (d *RuleDescription) write(s *strings.Builder, n int) {
+	indent := strings.Repeat("  ", n)
+
+	status := "not compiled"
+	if d.Compiled {
+		status = "compiled"
+	}
+
+	s.WriteString(fmt.Sprintf("%s%s (%s, %s", indent, d.ID, d.ResultType, status))
+	if d.SchemaID != "" {
+		s.WriteString(", schema " + d.SchemaID)
+	}
+	s.WriteString(")\n")
+
+	if d.Expr != "" {
+		s.WriteString(fmt.Sprintf("%s  expr: %s\n", indent, strings.ReplaceAll(d.Expr, "\n", " ")))
+	}
+
+	for _, c := range d.Children {
+		c.write(s, n+1)
+	}
+}
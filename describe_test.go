@@ -0,0 +1,40 @@
+package indigo_test
+
+import (
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/matryer/is"
+)
+
+// Test the structured description of a rule tree
+func TestDescribe(t *testing.T) {
+	is := is.New(t)
+
+	r := makeRule()
+	r.Schema = indigo.Schema{ID: "s1", Elements: []indigo.DataElement{{Name: "a", Type: indigo.Int{}}}}
+	r.Rules["B"].EvalOptions.StopIfParentNegative = true
+
+	d := indigo.Describe(r)
+	is.Equal(d.ID, "rule1")
+	is.Equal(d.SchemaElements, []string{"a"})
+	is.Equal(d.ResultType, "bool")
+	is.True(!d.Compiled)
+	is.Equal(len(d.Children), 3)
+	is.Equal(d.Children[0].ID, "B")
+	is.True(d.Children[0].EvalOptions.StopIfParentNegative)
+
+	v, err := indigo.NewVault(indigo.NewEngine(newMockEvaluator()), r)
+	is.NoErr(err)
+
+	d, err = v.Describe("b4")
+	is.NoErr(err)
+	is.True(d.Compiled)
+	is.Equal(d.String(), `b4 (bool, compiled)
+  expr: false
+  b4-1 (bool, compiled)
+    expr: true
+  b4-2 (bool, compiled)
+    expr: false
+`)
+}
@@ -70,6 +70,7 @@ func (d *Diagnostics) String() string {
 
 // DiagnosticsReport produces an ASCII report of the input rules, input data,
 // the evaluation diagnostics and the results.
+// The values of sensitive data elements (see DataElement.Sensitivity) are redacted.
 func DiagnosticsReport(u *Result, data map[string]interface{}) string {
 
 	// b := box.New(box.Config{Px: 2, Py: 1, Type: "Double", Color: "Cyan", TitlePos: "Top", ContentAlign: "Left"})
@@ -109,9 +110,13 @@ func diagnosticsRecursive(u *Result, data map[string]interface{}) string {
 	s.WriteString("\n\n")
 
 	if u.Diagnostics != nil {
+		d := *u.Diagnostics
+		if u.Rule != nil {
+			d = u.Rule.Schema.redactDiagnostics(d)
+		}
 		s.WriteString("Evaluation:\n")
 		s.WriteString("-----------\n")
-		s.WriteString(u.Diagnostics.String())
+		s.WriteString(d.String())
 	}
 
 	if len(u.RulesEvaluated) > 0 {
@@ -123,6 +128,9 @@ func diagnosticsRecursive(u *Result, data map[string]interface{}) string {
 	}
 
 	if data != nil {
+		if u.Rule != nil {
+			data = u.Rule.Schema.Redact(data)
+		}
 		dt := dataTable(data)
 		s.WriteString("\n")
 		s.WriteString("Input:\n")
@@ -131,11 +139,9 @@ func diagnosticsRecursive(u *Result, data map[string]interface{}) string {
 	}
 
 	s.WriteString("\n")
-	if u.Results != nil {
-		for k := range u.Results {
-			s.WriteString(diagnosticsRecursive(u.Results[k], nil))
-			s.WriteString("\n")
-		}
+	for _, cu := range u.OrderedResults {
+		s.WriteString(diagnosticsRecursive(cu, nil))
+		s.WriteString("\n")
 	}
 	return s.String()
 
@@ -40,6 +40,10 @@
 //  4. You should not modify a rule after it's been evaluated and before the results have been consumed.
 //  5. A rule must not be a child rule of more than one parent.
 //
+// Alternatively, hand the rules to a Vault, which compiles rules as they are added,
+// and makes sure that rules are not changed while they are being evaluated.
+// A Vault also notifies subscribers when rules are added, replaced or removed.
+//
 // Updating Rules
 //
 // To add or remove rules, you do so by modifying the parent rule's map of Rules
@@ -0,0 +1,293 @@
+// Package docgen generates documentation of the rules in a vault, in Markdown or
+// HTML: the rule tree, the expressions and their plain-English explanations, the
+// data elements each rule uses, the owners and other metadata, and the changes
+// made to each rule. Since the documentation is generated from the rules being
+// evaluated, it is never out of date:
+//
+//     var b bytes.Buffer
+//     if err := docgen.Markdown(&b, e, v); err != nil {
+//         return err
+//     }
+//
+// Explanations and data elements are included if the engine's evaluator implements
+// indigo.ExpressionExplainer and indigo.ExpressionAnalyzer, as the CEL evaluator does.
+// Changes are included if the vault keeps history (see indigo.Vault.KeepHistory).
+package docgen
+
+import (
+	"errors"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ezachrisen/indigo"
+)
+
+// Document is the documentation of the rules in a vault.
+type Document struct {
+	// The time the documentation was generated
+	Generated time.Time
+
+	// The rules, depth first, with child rules in alphabetical order by ID
+	Rules []RuleDoc
+}
+
+// RuleDoc is the documentation of a rule.
+type RuleDoc struct {
+	ID string
+
+	// The depth of the rule in the tree; the root rule is at depth 0
+	Depth int
+
+	// The ID of the parent rule; blank for the root rule
+	ParentID string
+
+	// The expression and guard of the rule, and the explanation of the expression
+	Expr        string
+	Guard       string
+	Explanation string
+
+	// The names of the data elements that the rule's expression and guard refer to
+	Uses []string
+
+	// The rule's metadata
+	Metadata indigo.RuleMetadata
+
+	// The changes made to the rule, oldest first
+	Changes []Change
+}
+
+// Change is a change made to a rule, found by comparing the versions kept by a vault.
+type Change struct {
+	// The time the version with the change took effect
+	At time.Time
+
+	// A description of the change, such as "expression changed from `a > 1`"
+	Description string
+}
+
+// Generate returns the documentation of the rules in the vault v, whose engine is e.
+func Generate(e *indigo.DefaultEngine, v *indigo.Vault) (*Document, error) {
+	var root *indigo.Rule
+	doc := &Document{Generated: time.Now()}
+	parents := map[string]string{}
+
+	v.Walk(func(r *indigo.Rule, depth int) bool {
+		if depth == 0 {
+			root = r
+		}
+		for _, c := range r.Rules {
+			parents[c.ID] = r.ID
+		}
+		for _, c := range r.ElseRules {
+			parents[c.ID] = r.ID
+		}
+		doc.Rules = append(doc.Rules, RuleDoc{
+			ID:       r.ID,
+			Depth:    depth,
+			ParentID: parents[r.ID],
+			Expr:     r.Expr,
+			Guard:    r.Guard,
+			Metadata: r.Metadata,
+		})
+		return true
+	})
+	if root == nil {
+		return doc, nil
+	}
+
+	explanations, err := e.Explain(root)
+	if err != nil && !unsupported(err) {
+		return nil, err
+	}
+	uses, err := e.References(root)
+	if err != nil && !unsupported(err) {
+		return nil, err
+	}
+
+	changes := history(v.History())
+	for i := range doc.Rules {
+		rd := &doc.Rules[i]
+		rd.Explanation = explanations[rd.ID]
+		rd.Uses = uses[rd.ID]
+		rd.Changes = changes[rd.ID]
+	}
+	return doc, nil
+}
+
+// unsupported reports whether err is returned because the evaluator does not
+// explain or analyze expressions
+func unsupported(err error) bool {
+	var ce *indigo.CompileError
+	return !errors.As(err, &ce)
+}
+
+// history returns the changes made to each rule between the versions, by rule ID
+func history(versions []indigo.Version) map[string][]Change {
+	changes := map[string][]Change{}
+	var prev map[string]*indigo.Rule
+	for i, ver := range versions {
+		cur := map[string]*indigo.Rule{}
+		ver.Snapshot.Walk(func(r *indigo.Rule, _ int) bool {
+			cur[r.ID] = r
+			return true
+		})
+
+		if i > 0 {
+			for id, r := range cur {
+				for _, d := range compare(prev[id], r) {
+					changes[id] = append(changes[id], Change{At: ver.From, Description: d})
+				}
+			}
+			for id := range prev {
+				if cur[id] == nil {
+					changes[id] = append(changes[id], Change{At: ver.From, Description: "removed"})
+				}
+			}
+		}
+		prev = cur
+	}
+	return changes
+}
+
+// compare describes the changes from the rule was to the rule now, which may be nil
+func compare(was, now *indigo.Rule) []string {
+	if was == nil {
+		return []string{"added"}
+	}
+
+	var d []string
+	if was.Expr != now.Expr {
+		d = append(d, "expression changed from `"+was.Expr+"`")
+	}
+	if was.Guard != now.Guard {
+		d = append(d, "guard changed from `"+was.Guard+"`")
+	}
+	if was.Metadata.Owner != now.Metadata.Owner {
+		d = append(d, "owner changed from "+was.Metadata.Owner)
+	}
+	if was.Metadata.Severity != now.Metadata.Severity {
+		d = append(d, "severity changed from "+string(was.Metadata.Severity))
+	}
+	return d
+}
+
+// Markdown writes the documentation of the rules in the vault v, whose engine is e,
+// as Markdown.
+func Markdown(w io.Writer, e *indigo.DefaultEngine, v *indigo.Vault) error {
+	doc, err := Generate(e, v)
+	if err != nil {
+		return err
+	}
+	return markdownTemplate.Execute(w, doc)
+}
+
+// HTML writes the documentation of the rules in the vault v, whose engine is e,
+// as an HTML page.
+func HTML(w io.Writer, e *indigo.DefaultEngine, v *indigo.Vault) error {
+	doc, err := Generate(e, v)
+	if err != nil {
+		return err
+	}
+	return htmlTemplate.Execute(w, doc)
+}
+
+var funcs = map[string]interface{}{
+	"indent": func(n int) string { return strings.Repeat("  ", n) },
+	"join":   strings.Join,
+	"date":   func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+}
+
+var markdownTemplate = template.Must(template.New("markdown").Funcs(funcs).Parse(`# Rules
+
+Generated {{date .Generated}}
+
+## Tree
+{{range .Rules}}
+{{indent .Depth}}- [{{.ID}}](#{{.ID}}){{if .Metadata.Description}}: {{.Metadata.Description}}{{end}}{{end}}
+{{range .Rules}}
+## {{.ID}}
+{{if .Metadata.Description}}
+{{.Metadata.Description}}
+{{end}}
+{{- if .ParentID}}
+- Parent: [{{.ParentID}}](#{{.ParentID}}){{end}}
+{{- if .Metadata.Owner}}
+- Owner: {{.Metadata.Owner}}{{end}}
+{{- if .Metadata.Severity}}
+- Severity: {{.Metadata.Severity}}{{end}}
+{{- if .Metadata.Category}}
+- Category: {{.Metadata.Category}}{{end}}
+{{- if .Metadata.Tags}}
+- Tags: {{join .Metadata.Tags ", "}}{{end}}
+{{- if .Metadata.TicketURL}}
+- Ticket: {{.Metadata.TicketURL}}{{end}}
+{{- if .Uses}}
+- Uses: {{join .Uses ", "}}{{end}}
+{{if .Expr}}
+` + "```" + `
+{{.Expr}}
+` + "```" + `
+{{end}}
+{{- if .Explanation}}
+{{.Explanation}}
+{{end}}
+{{- if .Guard}}
+Applies only if ` + "`{{.Guard}}`" + `
+{{end}}
+{{- if .Changes}}
+### Changes
+{{range .Changes}}
+- {{date .At}}: {{.Description}}{{end}}
+{{end}}
+{{- end}}`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Rules</title></head>
+<body>
+<h1>Rules</h1>
+<p>Generated {{date .Generated}}</p>
+<h2>Tree</h2>
+<ul>
+{{- range .Rules}}
+<li style="margin-left: {{.Depth}}em"><a href="#{{.ID}}">{{.ID}}</a>{{if .Metadata.Description}}: {{.Metadata.Description}}{{end}}</li>
+{{- end}}
+</ul>
+{{- range .Rules}}
+<h2 id="{{.ID}}">{{.ID}}</h2>
+{{- if .Metadata.Description}}
+<p>{{.Metadata.Description}}</p>
+{{- end}}
+<dl>
+{{- if .ParentID}}<dt>Parent</dt><dd><a href="#{{.ParentID}}">{{.ParentID}}</a></dd>{{end}}
+{{- if .Metadata.Owner}}<dt>Owner</dt><dd>{{.Metadata.Owner}}</dd>{{end}}
+{{- if .Metadata.Severity}}<dt>Severity</dt><dd>{{.Metadata.Severity}}</dd>{{end}}
+{{- if .Metadata.Category}}<dt>Category</dt><dd>{{.Metadata.Category}}</dd>{{end}}
+{{- if .Metadata.Tags}}<dt>Tags</dt><dd>{{join .Metadata.Tags ", "}}</dd>{{end}}
+{{- if .Metadata.TicketURL}}<dt>Ticket</dt><dd><a href="{{.Metadata.TicketURL}}">{{.Metadata.TicketURL}}</a></dd>{{end}}
+{{- if .Uses}}<dt>Uses</dt><dd>{{join .Uses ", "}}</dd>{{end}}
+</dl>
+{{- if .Expr}}
+<pre><code>{{.Expr}}</code></pre>
+{{- end}}
+{{- if .Explanation}}
+<p>{{.Explanation}}</p>
+{{- end}}
+{{- if .Guard}}
+<p>Applies only if <code>{{.Guard}}</code></p>
+{{- end}}
+{{- if .Changes}}
+<h3>Changes</h3>
+<ul>
+{{- range .Changes}}
+<li>{{date .At}}: {{.Description}}</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
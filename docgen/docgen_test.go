@@ -0,0 +1,68 @@
+package docgen_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/cel"
+	"github.com/ezachrisen/indigo/docgen"
+	"github.com/matryer/is"
+)
+
+func TestDocgen(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "country", Type: indigo.String{}},
+		},
+	}
+	root := &indigo.Rule{
+		ID:     "orders",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"large": {
+				ID:       "large",
+				Schema:   schema,
+				Expr:     `amount > 1000`,
+				Metadata: indigo.RuleMetadata{Owner: "risk", Description: "Large <orders>"},
+			},
+		},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	v, err := indigo.NewVault(e, root)
+	is.NoErr(err)
+	v.KeepHistory(time.Hour)
+	is.NoErr(v.Replace(&indigo.Rule{
+		ID:       "large",
+		Schema:   schema,
+		Expr:     `amount > 5000 && country == "SE"`,
+		Metadata: indigo.RuleMetadata{Owner: "risk", Description: "Large <orders>"},
+	}))
+	is.NoErr(v.Add("orders", &indigo.Rule{ID: "any", Schema: schema, Expr: "true"}))
+
+	doc, err := docgen.Generate(e, v)
+	is.NoErr(err)
+	is.Equal(len(doc.Rules), 3)
+	large := doc.Rules[2]
+	is.Equal(large.ID, "large")
+	is.Equal(large.ParentID, "orders")
+	is.Equal(large.Uses, []string{"amount", "country"})
+	is.True(large.Explanation != "")
+	is.Equal(len(large.Changes), 1)
+	is.Equal(large.Changes[0].Description, "expression changed from `amount > 1000`")
+	is.Equal(doc.Rules[1].Changes[0].Description, "added") // any
+
+	var md bytes.Buffer
+	is.NoErr(docgen.Markdown(&md, e, v))
+	is.True(strings.Contains(md.String(), "## large\n\nLarge <orders>\n\n- Parent: [orders](#orders)\n- Owner: risk\n- Uses: amount, country\n"))
+
+	var html bytes.Buffer
+	is.NoErr(docgen.HTML(&html, e, v))
+	is.True(strings.Contains(html.String(), "<p>Large &lt;orders&gt;</p>")) // escaped
+}
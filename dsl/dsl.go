@@ -0,0 +1,517 @@
+// Package dsl translates rule conditions written in a small business language to
+// CEL expressions, so that people who do not write code can author rules that
+// compile to the same programs as rules written in CEL:
+//
+//     Amount is greater than 1,000 AND Country is one of [SE, NO]
+//
+// translates to
+//
+//     amount > 1000.0 && country in ["SE", "NO"]
+//
+// for a schema with a float element amount and a string element country.
+//
+// Names refer to the elements of the schema, ignoring case, spaces and
+// underscores, so that "Enrollment Date" refers to the element enrollment_date,
+// and to the fields of protocol buffer messages, as in "Student.GPA". A word that
+// is not a name is a string, as are quoted text and the words of a list.
+//
+// Conditions are combined with AND, OR and NOT, and grouped with parentheses.
+// The comparisons are:
+//
+//     is, equals, is equal to                      ==
+//     is not, is not equal to                      !=
+//     is greater than, is more than, is after      >
+//     is less than, is fewer than, is before       <
+//     is at least, is greater than or equal to     >=
+//     is at most, is less than or equal to         <=
+//     is between A and B                           A <= x && x <= B
+//     is one of [A, B], is not one of [A, B]       in
+//     contains, does not contain                   contains for strings, in for lists
+//     starts with, ends with, matches              startsWith, endsWith, matches
+//
+// Numbers may have thousands separators, as in 1,000, and are floats when
+// compared with a float element; separate the numbers of a list with spaces, as
+// in [100, 200].
+package dsl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ezachrisen/indigo"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// SyntaxError is a problem in an expression of the language.
+type SyntaxError struct {
+	// The offset of the problem in the expression, in bytes
+	Offset int
+
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Message)
+}
+
+// Translate translates the expression src to a CEL expression, resolving its
+// names with the schema s. The error is a *SyntaxError if src is not valid.
+func Translate(src string, s indigo.Schema) (string, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return "", err
+	}
+
+	p := &parser{toks: toks, schema: s, end: len(src)}
+	x, err := p.or()
+	if err != nil {
+		return "", err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return "", p.errorf(t, "unexpected %q", t.text)
+	}
+	return x.cel, nil
+}
+
+// Name returns the CEL expression of the name of an element of the schema s, or of
+// a field of an element, as in "Enrollment Date" or "Student.GPA", and its type, or
+// nil if the type is not used by the language. The error is a *SyntaxError if
+// name is not valid.
+func Name(name string, s indigo.Schema) (string, indigo.Type, error) {
+	toks, err := tokenize(name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	p := &parser{toks: toks, schema: s, end: len(name)}
+	x, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return "", nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return x.cel, x.typ, nil
+}
+
+// node is a translated part of an expression
+type node struct {
+	cel  string
+	prec int         // the precedence of the CEL operator of cel (see the constants)
+	typ  indigo.Type // the type of a name, or nil if not known
+}
+
+// The precedence of CEL operators
+const (
+	precOr = iota + 1
+	precAnd
+	precCompare
+	precUnary
+	precPrimary
+)
+
+// wrap returns the CEL of n, in parentheses if its precedence is lower than prec
+func (n node) wrap(prec int) string {
+	if n.prec < prec {
+		return "(" + n.cel + ")"
+	}
+	return n.cel
+}
+
+type parser struct {
+	toks   []token
+	pos    int
+	schema indigo.Schema
+	end    int // the length of the expression
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword reports whether the next tokens are the words, ignoring case, and
+// consumes them if they are
+func (p *parser) keyword(words ...string) bool {
+	for i, w := range words {
+		// The tokens end with tokEOF, which is not a word
+		t := p.toks[p.pos+i]
+		if t.kind != tokWord || !strings.EqualFold(t.text, w) {
+			return false
+		}
+	}
+	p.pos += len(words)
+	return true
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	off := t.offset
+	if t.kind == tokEOF {
+		off = p.end
+	}
+	return &SyntaxError{Offset: off, Message: fmt.Sprintf(format, args...)}
+}
+
+// or parses conditions joined by OR
+func (p *parser) or() (node, error) {
+	x, err := p.and()
+	if err != nil {
+		return x, err
+	}
+	for p.keyword("or") {
+		y, err := p.and()
+		if err != nil {
+			return y, err
+		}
+		x = node{cel: x.wrap(precOr) + " || " + y.wrap(precOr), prec: precOr}
+	}
+	return x, nil
+}
+
+// and parses conditions joined by AND
+func (p *parser) and() (node, error) {
+	x, err := p.not()
+	if err != nil {
+		return x, err
+	}
+	for p.keyword("and") {
+		y, err := p.not()
+		if err != nil {
+			return y, err
+		}
+		x = node{cel: x.wrap(precAnd) + " && " + y.wrap(precAnd), prec: precAnd}
+	}
+	return x, nil
+}
+
+// not parses a condition, negated by NOT
+func (p *parser) not() (node, error) {
+	if p.keyword("not") {
+		x, err := p.not()
+		if err != nil {
+			return x, err
+		}
+		return node{cel: "!" + x.wrap(precUnary), prec: precUnary}, nil
+	}
+
+	if p.peek().kind == tokLParen {
+		p.next()
+		x, err := p.or()
+		if err != nil {
+			return x, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return x, p.errorf(t, "expected )")
+		}
+		return node{cel: x.cel, prec: x.prec}, nil
+	}
+	return p.comparison()
+}
+
+// comparison parses a name compared with a value, or a boolean name
+func (p *parser) comparison() (node, error) {
+	start := p.peek()
+	x, err := p.name()
+	if err != nil {
+		return x, err
+	}
+
+	switch {
+	case p.keyword("is", "between"):
+		lo, err := p.value(x.typ)
+		if err != nil {
+			return lo, err
+		}
+		if !p.keyword("and") {
+			return x, p.errorf(p.peek(), "expected AND in between")
+		}
+		hi, err := p.value(x.typ)
+		if err != nil {
+			return hi, err
+		}
+		return node{cel: lo.cel + " <= " + x.cel + " && " + x.cel + " <= " + hi.cel, prec: precAnd}, nil
+
+	case p.keyword("is", "not", "one", "of"):
+		return p.in(x, true)
+	case p.keyword("is", "one", "of"):
+		return p.in(x, false)
+
+	case p.keyword("does", "not", "contain"):
+		return p.contains(x, true)
+	case p.keyword("contains"):
+		return p.contains(x, false)
+
+	case p.keyword("starts", "with"):
+		return p.method(x, "startsWith")
+	case p.keyword("ends", "with"):
+		return p.method(x, "endsWith")
+	case p.keyword("matches"):
+		return p.method(x, "matches")
+	}
+
+	for _, c := range comparisons {
+		if p.keyword(c.words...) {
+			y, err := p.value(x.typ)
+			if err != nil {
+				return y, err
+			}
+			return node{cel: x.cel + " " + c.op + " " + y.cel, prec: precCompare}, nil
+		}
+	}
+
+	if _, ok := x.typ.(indigo.Bool); ok {
+		return x, nil
+	}
+	return x, p.errorf(p.peek(), "expected a comparison after %q", strings.TrimSpace(start.text))
+}
+
+// comparisons are the words of the comparison operators, longest first
+var comparisons = []struct {
+	words []string
+	op    string
+}{
+	{[]string{"is", "greater", "than", "or", "equal", "to"}, ">="},
+	{[]string{"is", "less", "than", "or", "equal", "to"}, "<="},
+	{[]string{"is", "not", "equal", "to"}, "!="},
+	{[]string{"is", "greater", "than"}, ">"},
+	{[]string{"is", "more", "than"}, ">"},
+	{[]string{"is", "less", "than"}, "<"},
+	{[]string{"is", "fewer", "than"}, "<"},
+	{[]string{"is", "at", "least"}, ">="},
+	{[]string{"is", "at", "most"}, "<="},
+	{[]string{"is", "equal", "to"}, "=="},
+	{[]string{"is", "after"}, ">"},
+	{[]string{"is", "before"}, "<"},
+	{[]string{"is", "not"}, "!="},
+	{[]string{"equals"}, "=="},
+	{[]string{"is"}, "=="},
+}
+
+// in parses the list of x is (not) one of
+func (p *parser) in(x node, negate bool) (node, error) {
+	if t := p.next(); t.kind != tokLBracket {
+		return x, p.errorf(t, "expected [ after one of")
+	}
+	var items []string
+	for p.peek().kind != tokRBracket {
+		if len(items) > 0 {
+			if t := p.next(); t.kind != tokComma {
+				return x, p.errorf(t, "expected , or ]")
+			}
+		}
+		v, err := p.value(x.typ)
+		if err != nil {
+			return v, err
+		}
+		items = append(items, v.cel)
+	}
+	p.next()
+
+	n := node{cel: x.cel + " in [" + strings.Join(items, ", ") + "]", prec: precCompare}
+	if negate {
+		return node{cel: "!" + n.wrap(precUnary), prec: precUnary}, nil
+	}
+	return n, nil
+}
+
+// contains parses the value that x (does not) contain
+func (p *parser) contains(x node, negate bool) (node, error) {
+	var n node
+	if l, ok := x.typ.(indigo.List); ok {
+		y, err := p.value(l.ValueType)
+		if err != nil {
+			return y, err
+		}
+		n = node{cel: y.cel + " in " + x.cel, prec: precCompare}
+	} else {
+		y, err := p.value(indigo.String{})
+		if err != nil {
+			return y, err
+		}
+		n = node{cel: x.cel + ".contains(" + y.cel + ")", prec: precPrimary}
+	}
+	if negate {
+		return node{cel: "!" + n.wrap(precUnary), prec: precUnary}, nil
+	}
+	return n, nil
+}
+
+// method parses the string argument of the method of x
+func (p *parser) method(x node, method string) (node, error) {
+	y, err := p.value(indigo.String{})
+	if err != nil {
+		return y, err
+	}
+	return node{cel: x.cel + "." + method + "(" + y.cel + ")", prec: precPrimary}, nil
+}
+
+// value parses a literal, a list word or a name; t is the type of the
+// name the value is compared with, if known
+func (p *parser) value(t indigo.Type) (node, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokNumber:
+		p.next()
+		n := strings.ReplaceAll(tok.text, ",", "")
+		if _, ok := t.(indigo.Float); ok && !strings.Contains(n, ".") {
+			n += ".0"
+		}
+		return node{cel: n, prec: precPrimary}, nil
+	case tokString:
+		p.next()
+		return node{cel: strconv.Quote(tok.text), prec: precPrimary}, nil
+	case tokWord:
+		if x, ok, err := p.resolve(); ok || err != nil {
+			return x, err
+		}
+		p.next()
+		switch strings.ToLower(tok.text) {
+		case "true", "false":
+			return node{cel: strings.ToLower(tok.text), prec: precPrimary}, nil
+		}
+		return node{cel: strconv.Quote(tok.text), prec: precPrimary}, nil
+	}
+	return node{}, p.errorf(tok, "expected a value")
+}
+
+// name parses the name of an element, or of a field of an element
+func (p *parser) name() (node, error) {
+	t := p.peek()
+	x, ok, err := p.resolve()
+	if err != nil {
+		return x, err
+	}
+	if !ok {
+		if t.kind != tokWord {
+			return x, p.errorf(t, "expected a name")
+		}
+		return x, p.errorf(t, "unknown name %q", t.text)
+	}
+	return x, nil
+}
+
+// resolve parses the longest name of an element that follows, with its fields,
+// reporting false if no element has a name starting with the next words
+func (p *parser) resolve() (node, bool, error) {
+	var el *indigo.DataElement
+	n := 0
+	for i := p.pos; p.toks[i].kind == tokWord; i++ {
+		if e := p.element(words(p.toks[p.pos : i+1])); e != nil {
+			el, n = e, i+1-p.pos
+		}
+	}
+	if el == nil {
+		return node{}, false, nil
+	}
+	p.pos += n
+
+	x := node{cel: el.Name, prec: precPrimary, typ: el.Type}
+	for p.peek().kind == tokDot {
+		p.next()
+		start := p.peek()
+		if start.kind != tokWord {
+			return x, true, p.errorf(start, "expected a field name after .")
+		}
+		y, n, err := field(x, p.toks[p.pos:])
+		if err != nil {
+			return x, true, p.errorf(start, "%v", err)
+		}
+		x = y
+		p.pos += n
+	}
+	return x, true, nil
+}
+
+// element returns the element of the schema with the name, ignoring case,
+// spaces and underscores, or nil
+func (p *parser) element(name string) *indigo.DataElement {
+	for i := range p.schema.Elements {
+		if normalize(p.schema.Elements[i].Name) == name {
+			return &p.schema.Elements[i]
+		}
+	}
+	return nil
+}
+
+// field returns x selecting its field with the longest name made of the words
+// at the start of toks, ignoring case, spaces and underscores, and the number of
+// words of the name
+func field(x node, toks []token) (node, int, error) {
+	switch t := x.typ.(type) {
+	case indigo.Proto:
+		if t.Message == nil {
+			break
+		}
+		fs := t.Message.ProtoReflect().Descriptor().Fields()
+		var y node
+		n := 0
+		for i := 0; toks[i].kind == tokWord; i++ {
+			name := words(toks[:i+1])
+			for j := 0; j < fs.Len(); j++ {
+				if f := fs.Get(j); normalize(string(f.Name())) == name {
+					y, n = node{cel: x.cel + "." + string(f.Name()), prec: precPrimary, typ: fieldType(f)}, i+1
+				}
+			}
+		}
+		if n == 0 {
+			return x, 0, fmt.Errorf("%s has no field %q", x.cel, toks[0].text)
+		}
+		return y, n, nil
+	case indigo.Map:
+		if _, ok := t.KeyType.(indigo.String); ok {
+			return node{cel: x.cel + "[" + strconv.Quote(toks[0].text) + "]", prec: precPrimary, typ: t.ValueType}, 1, nil
+		}
+	}
+	return x, 0, fmt.Errorf("%s has no fields", x.cel)
+}
+
+// fieldType returns the type of the proto field f, or nil if the language
+// does not use its type
+func fieldType(f protoreflect.FieldDescriptor) indigo.Type {
+	if f.IsList() || f.IsMap() {
+		return nil
+	}
+	switch f.Kind() {
+	case protoreflect.BoolKind:
+		return indigo.Bool{}
+	case protoreflect.StringKind:
+		return indigo.String{}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return indigo.Float{}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		switch f.Message().FullName() {
+		case "google.protobuf.Timestamp":
+			return indigo.Timestamp{}
+		case "google.protobuf.Duration":
+			return indigo.Duration{}
+		}
+		return indigo.Proto{Message: dynamicpb.NewMessage(f.Message())}
+	case protoreflect.BytesKind:
+		return nil
+	default:
+		return indigo.Int{}
+	}
+}
+
+// words returns the normalized name made of the word tokens
+func words(toks []token) string {
+	var b strings.Builder
+	for _, t := range toks {
+		b.WriteString(t.text)
+	}
+	return normalize(b.String())
+}
+
+// normalize returns the name in lower case, without spaces and underscores
+func normalize(name string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "_", "").Replace(name))
+}
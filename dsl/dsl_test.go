@@ -0,0 +1,87 @@
+package dsl_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/cel"
+	"github.com/ezachrisen/indigo/dsl"
+	"github.com/ezachrisen/indigo/testdata/school"
+	"github.com/matryer/is"
+)
+
+func TestTranslate(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Float{}},
+			{Name: "country", Type: indigo.String{}},
+			{Name: "item_count", Type: indigo.Int{}},
+			{Name: "is_active", Type: indigo.Bool{}},
+			{Name: "tags", Type: indigo.List{ValueType: indigo.String{}}},
+			{Name: "limit", Type: indigo.Float{}},
+			{Name: "student", Type: indigo.Proto{Message: &school.Student{}}},
+		},
+	}
+
+	cases := []struct {
+		src  string
+		cel  string
+		pass bool
+	}{
+		{`Amount is greater than 1,000 AND Country is one of [SE, NO]`, `amount > 1000.0 && country in ["SE", "NO"]`, true},
+		{`Item Count is at least 3 or NOT (Is Active)`, `item_count >= 3 || !is_active`, true},
+		{`Is Active is true AND (Country is DK OR Country is "SE")`, `is_active == true && (country == "DK" || country == "SE")`, true},
+		{`Amount is greater than or equal to Limit`, `amount >= limit`, true},
+		{`Amount is between 10 and 2,000.5 and item_count is not 4`, `10.0 <= amount && amount <= 2000.5 && item_count != 4`, true},
+		{`Country is not one of [SE, NO]`, `!(country in ["SE", "NO"])`, false},
+		{`Tags contains vip and country starts with S`, `"vip" in tags && country.startsWith("S")`, true},
+		{`Country does not contain 'X'`, `!country.contains("X")`, true},
+		{`Student.GPA is at least 3.5 and Student.Enrollment Date is before Student.Enrollment Date`, `student.gpa >= 3.5 && student.enrollment_date < student.enrollment_date`, false},
+	}
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	data := map[string]interface{}{
+		"amount":     1500.0,
+		"country":    "SE",
+		"item_count": 5,
+		"is_active":  true,
+		"tags":       []string{"vip"},
+		"limit":      1000.0,
+		"student":    &school.Student{Gpa: 3.8},
+	}
+
+	for _, c := range cases {
+		x, err := dsl.Translate(c.src, schema)
+		is.NoErr(err)
+		is.Equal(x, c.cel)
+
+		r := &indigo.Rule{ID: "r", Schema: schema, Expr: x}
+		is.NoErr(e.Compile(r)) // the translation compiles
+		u, err := e.Eval(context.Background(), r, data)
+		is.NoErr(err)
+		is.Equal(u.Pass, c.pass)
+	}
+
+	errs := []struct {
+		src    string
+		offset int
+	}{
+		{`Amount is greater than`, 22},
+		{`Price is 3`, 0},
+		{`Amount 3`, 7},
+		{`Country is one of SE`, 18},
+		{`(Amount is 3`, 12},
+		{`Country is "SE`, 11},
+		{`Student.Name is x`, 8},
+	}
+	for _, c := range errs {
+		_, err := dsl.Translate(c.src, schema)
+		var se *dsl.SyntaxError
+		is.True(errors.As(err, &se))
+		is.Equal(se.Offset, c.offset)
+	}
+}
@@ -0,0 +1,114 @@
+package dsl
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokNumber
+	tokString
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokDot
+)
+
+// token is a word, literal or punctuation of an expression
+type token struct {
+	kind   tokenKind
+	text   string // the text, unquoted for a string
+	offset int    // the offset of the token in the expression, in bytes
+}
+
+// punctuation are the tokens of single characters
+var punctuation = map[rune]tokenKind{
+	'(': tokLParen,
+	')': tokRParen,
+	'[': tokLBracket,
+	']': tokRBracket,
+	',': tokComma,
+	'.': tokDot,
+}
+
+// tokenize splits the expression src into tokens, ending with a tokEOF token
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(src); {
+		r, n := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += n
+
+		case unicode.IsLetter(r) || r == '_':
+			j := i + n
+			for j < len(src) {
+				r, n := utf8.DecodeRuneInString(src[j:])
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+					break
+				}
+				j += n
+			}
+			toks = append(toks, token{kind: tokWord, text: src[i:j], offset: i})
+			i = j
+
+		case isDigit(src, i) || (r == '-' && isDigit(src, i+1)):
+			j := number(src, i+1)
+			toks = append(toks, token{kind: tokNumber, text: src[i:j], offset: i})
+			i = j
+
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(src) && rune(src[j]) != r {
+				j++
+			}
+			if j == len(src) {
+				return nil, &SyntaxError{Offset: i, Message: "unterminated string"}
+			}
+			toks = append(toks, token{kind: tokString, text: src[i+1 : j], offset: i})
+			i = j + 1
+
+		default:
+			k, ok := punctuation[r]
+			if !ok {
+				return nil, &SyntaxError{Offset: i, Message: "unexpected " + string(r)}
+			}
+			toks = append(toks, token{kind: k, text: string(r), offset: i})
+			i += n
+		}
+	}
+	return append(toks, token{kind: tokEOF, offset: len(src)}), nil
+}
+
+// number returns the end of the number continuing at i in src, which may have
+// thousands separators, as in 1,000, and a fraction
+func number(src string, i int) int {
+	for i < len(src) {
+		switch {
+		case isDigit(src, i):
+			i++
+		case src[i] == ',' && isDigit(src, i+1) && isDigit(src, i+2) && isDigit(src, i+3) && !isDigit(src, i+4):
+			i += 4
+		case src[i] == '.' && isDigit(src, i+1):
+			i++
+			for isDigit(src, i) {
+				i++
+			}
+			return i
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// isDigit reports whether src has a digit at i
+func isDigit(src string, i int) bool {
+	return i < len(src) && src[i] >= '0' && src[i] <= '9'
+}
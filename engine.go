@@ -3,6 +3,11 @@ package indigo
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Compiler is the interface that wraps the Compile method.
@@ -30,6 +35,44 @@ type Engine interface {
 // to evaluate rules locally.
 type DefaultEngine struct {
 	e ExpressionCompilerEvaluator
+
+	// Compiled programs shared between rules (see SharePrograms),
+	// keyed by programKey
+	mu       sync.Mutex
+	programs map[string]*sharedProgram
+
+	// Schemas referred to by rules (see RegisterSchema), by ID
+	schemaMu sync.RWMutex
+	schemas  map[string]Schema
+
+	// Enrichers referred to by rules (see RegisterEnricher), by name
+	enrichMu  sync.RWMutex
+	enrichers map[string]Enricher
+
+	// Reference data added to the input data (see SetConstants)
+	constants atomic.Value
+
+	// The logger, and the threshold of slow evaluations (see SetLogger)
+	logging atomic.Value
+
+	// The policy deciding which rules are evaluated (see SetApprovalPolicy)
+	approval atomic.Value
+
+	// The circuit breakers of the rules (see SetCircuitBreaker)
+	breakers atomic.Value
+
+	// The middleware wrapping Eval, and the evaluator calling the
+	// middleware in order (see Use)
+	middleware []Middleware
+	chain      Evaluator
+
+	// The shutdown state: whether the engine is closed, the evaluations in
+	// progress, and the functions releasing resources (see Close)
+	closeMu  sync.RWMutex
+	closed   bool
+	released bool
+	inflight sync.WaitGroup
+	closers  []func() error
 }
 
 // NewEngine initializes and returns a DefaultEngine.
@@ -39,49 +82,347 @@ func NewEngine(e ExpressionCompilerEvaluator) *DefaultEngine {
 	}
 }
 
+// RegisterSchema makes the schema available to rules that refer to it by its ID
+// (see Rule.SchemaID), replacing a schema registered with the same ID.
+// Rules compiled with the previous schema keep using it until they are recompiled.
+func (e *DefaultEngine) RegisterSchema(s Schema) error {
+	if s.ID == "" {
+		return fmt.Errorf("schema has no ID")
+	}
+
+	e.schemaMu.Lock()
+	defer e.schemaMu.Unlock()
+	if e.schemas == nil {
+		e.schemas = map[string]Schema{}
+	}
+	e.schemas[s.ID] = s
+	return nil
+}
+
+// Schema returns the registered schema with the id, and false if there is none.
+func (e *DefaultEngine) Schema(id string) (Schema, bool) {
+	e.schemaMu.RLock()
+	defer e.schemaMu.RUnlock()
+	s, ok := e.schemas[id]
+	return s, ok
+}
+
+// schemaOf returns the schema used to compile the rule: the registered schema
+// the rule refers to, if any, or the rule's own schema
+func (e *DefaultEngine) schemaOf(r *Rule) (Schema, error) {
+	if r.SchemaID == "" {
+		return r.Schema, nil
+	}
+
+	s, ok := e.Schema(r.SchemaID)
+	if !ok {
+		return Schema{}, fmt.Errorf("%w: %s", ErrSchemaNotFound, r.SchemaID)
+	}
+	return s, nil
+}
+
+// exprSchemaOf returns the schema to compile the rule's expressions with: the schema
+// of the rule (see schemaOf), with the reserved names the rule uses declared
+func (e *DefaultEngine) exprSchemaOf(r *Rule) (Schema, error) {
+	s, err := e.schemaOf(r)
+	if err != nil {
+		return Schema{}, err
+	}
+	return reservedSchema(r, s)
+}
+
+// reservedSchema returns s, with SelfKey, ItemKey and ChildrenKey declared if
+// the rule uses them (see selfSchema, itemSchema and childrenSchema)
+func reservedSchema(r *Rule, s Schema) (Schema, error) {
+	s, err := selfSchema(r, s)
+	if err != nil {
+		return Schema{}, err
+	}
+	return childrenSchema(r, itemSchema(r, s)), nil
+}
+
 // Eval evaluates the expression of the rule and its children. It uses the evaluation
 // options of each rule to determine what to do with the results, and whether to proceed
 // evaluating. Options passed to this function will override the options set on the rules.
-// Eval uses the Evaluator provided to the engine to perform the expression evaluation.
+// Eval uses the Evaluator provided to the engine to perform the expression evaluation,
+// and calls the middleware added with Use around the evaluation.
 func (e *DefaultEngine) Eval(ctx context.Context, r *Rule,
 	d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+	if !e.enter() {
+		return nil, ErrEngineClosed
+	}
+	defer e.inflight.Done()
+
+	start := time.Now()
+	var u *Result
+	var err error
+	if e.chain != nil {
+		u, err = e.chain.Eval(ctx, r, d, opts...)
+	} else {
+		u, err = e.evalRoot(ctx, r, d, opts...)
+	}
+	e.logEval(r, d, time.Since(start), err)
+	return u, err
+}
+
+// evalRoot evaluates the rule and its children, after any middleware (see Use)
+func (e *DefaultEngine) evalRoot(ctx context.Context, r *Rule,
+	d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+	if r != nil && r.unapproved {
+		return nil, &EvalError{RuleID: r.ID, Err: ErrNotApproved}
+	}
+
+	u, err := e.eval(ctx, r, e.inputData(ctx, d), 0, nil, nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.EvalOptions.OnResult != nil {
+		u.EvalOptions.OnResult(u)
+	}
+	return u, nil
+}
+
+// eval evaluates the rule and its children recursively. depth is the
+// depth of the rule r, relative to the rule passed to Eval.
+// If c is not nil, expression values are reused from the cache where possible.
+// in holds the options inherited from the rule's ancestors (see Rule.InheritOptions), if any.
+// If the evaluation fails, the rule's fallback is returned, if it has one.
+func (e *DefaultEngine) eval(ctx context.Context, r *Rule,
+	d map[string]interface{}, depth int, c *exprCache, in *EvalOptions, opts ...EvalOption) (*Result, error) {
+	u, err := e.evalStrict(ctx, r, d, depth, c, in, opts...)
+	if err != nil && r != nil && r.Fallback != nil && ctx.Err() == nil {
+		return r.Fallback.result(r, err), nil
+	}
+	return u, err
+}
+
+// evalStrict evaluates the rule and its children (see eval), without the rule's fallback
+func (e *DefaultEngine) evalStrict(ctx context.Context, r *Rule,
+	d map[string]interface{}, depth int, c *exprCache, in *EvalOptions, opts ...EvalOption) (*Result, error) {
 
 	if err := validateEvalArguments(r, e, d); err != nil {
 		return nil, err
 	}
 
-	o := r.EvalOptions
-	applyEvaluatorOptions(&o, opts...)
-	setSelfKey(r, d)
+	local := r.EvalOptions
+	if in != nil && !r.OverrideInherited {
+		local = inheritOptions(local, *in)
+	}
+
+	o := local
+	if len(opts) > 0 {
+		applyEvaluatorOptions(&o, opts...)
+	}
+
+	if o.StrictOptions {
+		if err := o.Validate(); err != nil {
+			return nil, &EvalError{RuleID: r.ID, Err: err}
+		}
+	}
+
+	if o.CoerceData {
+		var err error
+		if d, err = r.Schema.Coerce(d); err != nil {
+			return nil, &EvalError{RuleID: r.ID, Err: err}
+		}
+	}
 
-	val, diagnostics, err := e.e.Evaluate(d, r.Expr, r.Schema, r.Self, r.Program, defaultResultType(r), o.ReturnDiagnostics)
+	if len(r.Enrich) > 0 {
+		var err error
+		if d, err = e.enrich(ctx, r, d); err != nil {
+			return nil, &EvalError{RuleID: r.ID, Err: err}
+		}
+	}
+
+	if r.ForEach != "" {
+		return e.evalForEach(ctx, r, d, depth, in, local, o, opts...)
+	}
+	return e.evalRule(ctx, r, d, depth, c, in, local, o, opts...)
+}
+
+// evalRule evaluates the rule with the data d, and its children, once the options
+// o are determined; local holds the rule's options, before the options passed to Eval
+func (e *DefaultEngine) evalRule(ctx context.Context, r *Rule, d map[string]interface{}, depth int,
+	c *exprCache, in *EvalOptions, local, o EvalOptions, opts ...EvalOption) (*Result, error) {
+	u, err := e.evalRuleChildren(ctx, r, d, depth, c, in, local, o, opts...)
+	if err != nil || r.Quorum == nil || r.shardRoot {
+		return u, err
+	}
+	r.Quorum.apply(u)
+	return u, nil
+}
+
+// evalRuleChildren evaluates the rule's expression, then its children (see evalRule)
+func (e *DefaultEngine) evalRuleChildren(ctx context.Context, r *Rule, d map[string]interface{}, depth int,
+	c *exprCache, in *EvalOptions, local, o EvalOptions, opts ...EvalOption) (*Result, error) {
+
+	// The rule's own view of the data, with its Self; the children are given d
+	rd := selfData(r.Self, d)
+
+	if r.Guard != "" {
+		applies, err := e.evalGuard(r, rd)
+		if err != nil {
+			return nil, &EvalError{RuleID: r.ID, Err: err}
+		}
+
+		if !applies {
+			u := newResult(0, o.PoolResults)
+			*u = Result{
+				Rule:            r,
+				Metadata:        &r.Metadata,
+				Status:          StatusNotApplicable,
+				Results:         u.Results,
+				OrderedResults:  u.OrderedResults,
+				RulesEvaluated:  u.RulesEvaluated,
+				EvalOptions:     o,
+				EvaluationCount: 1,
+			}
+			return u, nil
+		}
+	}
+
+	// The options inherited by the child rules
+	childIn := in
+	switch {
+	case r.InheritOptions:
+		childIn = &local
+	case r.OverrideInherited:
+		childIn = nil
+	}
+
+	// The children of a rule whose expression refers to their outcomes are
+	// evaluated first (see ChildrenKey)
+	var first map[*Rule]childOutcome
+	if r.aggregate {
+		var summary map[string]interface{}
+		var err error
+		if first, summary, err = e.evalChildrenFirst(ctx, r, d, depth, c, childIn, o, opts...); err != nil {
+			return nil, err
+		}
+		rd = withValue(rd, ChildrenKey, summary)
+	}
+
+	start := time.Now()
+	val, diagnostics, err := c.evaluate(e.e, r, rd, o.ReturnDiagnostics)
 	if err != nil {
-		return nil, fmt.Errorf("rule %s: %w", r.ID, err)
+		return nil, &EvalError{RuleID: r.ID, Err: err}
+	}
+	if diagnostics != nil && r.Schema.hasSensitive() {
+		redacted := r.Schema.redactDiagnostics(*diagnostics)
+		diagnostics = &redacted
+	}
+
+	u := newResult(len(r.Rules), o.PoolResults)
+	*u = Result{
+		Rule:            r,
+		Metadata:        &r.Metadata,
+		Pass:            true, // default boolean result
+		Results:         u.Results,
+		OrderedResults:  u.OrderedResults,
+		RulesEvaluated:  u.RulesEvaluated,
+		Value:           val,
+		Diagnostics:     diagnostics,
+		EvalOptions:     o,
+		EvaluationCount: 1,
 	}
 
-	u := &Result{
-		Rule:        r,
-		Pass:        true,                                   // default boolean result
-		Results:     make(map[string]*Result, len(r.Rules)), // TODO: consider how large to make it
-		Value:       val,
-		Diagnostics: diagnostics,
-		EvalOptions: o,
+	if o.ReturnTiming {
+		defer func() {
+			u.Duration = time.Since(start)
+		}()
+	}
+
+	if len(r.Messages) > 0 {
+		if m, ok := selectMessage(r.Messages, o.Locale); ok {
+			u.Message = renderMessage(m, rd)
+		}
 	}
 
 	// If the evaluation returned a boolean, set the Result's value,
 	// otherwise keep the default, true
 	if pass, ok := val.(bool); ok {
 		u.Pass = pass
+		if !pass {
+			u.Status = StatusFail
+		}
+	} else if _, isBool := defaultResultType(r).(Bool); isBool {
+		switch o.NonBoolPolicy {
+		case NonBoolTruthy:
+			u.Pass = truthy(val)
+			if !u.Pass {
+				u.Status = StatusFail
+			}
+		case NonBoolError:
+			return nil, &EvalError{RuleID: r.ID, Err: fmt.Errorf("%w: %T", ErrNonBoolResult, val)}
+		default:
+			u.Status = StatusIndeterminate
+		}
+	}
+
+	if u.Pass {
+		u.Verdict = r.Metadata.Severity
+		if len(r.Outputs) > 0 {
+			if u.Outputs, err = e.evalOutputs(r, rd); err != nil {
+				return nil, &EvalError{RuleID: r.ID, Err: err}
+			}
+		}
 	}
 
-	if o.StopIfParentNegative && !u.Pass {
+	// Most rules have no children; they are done
+	if len(r.Rules) == 0 && len(r.ElseRules) == 0 {
 		return u, nil
 	}
 
-	// count the number of failed children
-	var failCount int
+	// The else rules are evaluated if the rule's own expression is false
+	var elseRules []*Rule
+	if !u.Pass && len(r.ElseRules) > 0 {
+		elseRules = sortRules(r.ElseRules, o)
+	}
+
+	stopped := o.StopIfParentNegative && !u.Pass && !r.aggregate
+	if stopped {
+		if o.RecordSkipped {
+			recordSkipped(u, r, o)
+		}
+		if len(elseRules) == 0 {
+			return u, nil
+		}
+	}
+
+	if o.MaxDepth > 0 && depth >= o.MaxDepth && (len(r.Rules) > 0 || len(elseRules) > 0) {
+		if !o.TruncateAtMaxDepth {
+			return nil, &EvalError{RuleID: r.ID, Err: ErrMaxDepthExceeded}
+		}
+		u.Truncated = true
+		return u, nil
+	}
+
+	// Evaluate the combined programs of the child rules, if any
+	var cc *exprCache
+	if c == nil && len(r.combined) > 0 && !o.ReturnDiagnostics {
+		cc = e.evaluateCombined(r, selfData(nil, d))
+	}
+
+	var children []*Rule
+	switch {
+	case stopped:
+	case r.Experiment != nil:
+		u.Variant = r.Experiment.variant(r, d)
+		if cr, ok := r.Rules[u.Variant]; ok {
+			children = []*Rule{cr}
+		}
+	default:
+		children = r.selectChildren(d, o)
+	}
+
+	breakers := e.circuitBreakers()
+	for _, cr := range append(children, elseRules...) {
+		// Rules that are switched off are left out, as if they did not exist
+		if cr != nil && !cr.active(d, o) {
+			continue
+		}
 
-	for _, cr := range r.sortChildKeys(o) {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
@@ -90,25 +431,77 @@ func (e *DefaultEngine) Eval(ctx context.Context, r *Rule,
 				u.RulesEvaluated = append(u.RulesEvaluated, cr)
 			}
 
-			result, err := e.Eval(ctx, cr, d, opts...)
+			childCache := c
+			if cc != nil {
+				if _, ok := cc.values[cr]; ok {
+					childCache = cc
+				}
+			}
+
+			var result *Result
+			var err error
+			switch out, ok := first[cr]; {
+			case ok:
+				result, err = out.result, out.err
+			case breakers != nil && cr != nil:
+				if !breakers.allows(cr.ID) {
+					result = skippedResult(cr, o)
+					break
+				}
+				start := time.Now()
+				result, err = e.eval(ctx, cr, d, depth+1, childCache, childIn, opts...)
+				failure := err
+				if result != nil && result.Fallback {
+					failure = result.Error
+				}
+				breakers.record(ctx, cr.ID, time.Since(start), failure)
+			default:
+				result, err = e.eval(ctx, cr, d, depth+1, childCache, childIn, opts...)
+			}
 			if err != nil {
-				return nil, err
+				// A nil rule or a canceled context always stops the evaluation
+				if !o.ContinueOnError || cr == nil || ctx.Err() != nil {
+					return nil, err
+				}
+				result = &Result{
+					Rule:            cr,
+					Metadata:        &cr.Metadata,
+					Pass:            false,
+					Status:          StatusError,
+					Error:           err,
+					EvalOptions:     o,
+					EvaluationCount: 1,
+				}
 			}
+			u.EvaluationCount += result.EvaluationCount
 
-			if !result.Pass {
-				failCount++
+			if o.OnResult != nil {
+				o.OnResult(result)
 			}
+			u.Verdict = maxSeverity(u.Verdict, result.Verdict)
 
-			if (!result.Pass && !o.DiscardFail) ||
+			// Rules that did not apply, or were skipped, did not fail
+			failed := !result.Pass && result.Status != StatusNotApplicable && !result.Skipped
+			if failed {
+				u.ChildrenFailed++
+			} else if result.Pass {
+				u.ChildrenPassed++
+			}
+
+			// Failed evaluations are always returned, so that the error is not lost,
+			// as are rules skipped by their circuit breakers
+			if result.Error != nil || result.Skipped ||
+				(!result.Pass && !o.DiscardFail) ||
 				(result.Pass && !o.DiscardPass) {
 				u.Results[cr.ID] = result
+				u.OrderedResults = append(u.OrderedResults, result)
 			}
 
 			if o.StopFirstPositiveChild && result.Pass {
 				return u, nil
 			}
 
-			if o.StopFirstNegativeChild && !result.Pass {
+			if o.StopFirstNegativeChild && failed {
 				return u, nil
 			}
 		}
@@ -117,14 +510,52 @@ func (e *DefaultEngine) Eval(ctx context.Context, r *Rule,
 	// if we're rolling up the child results, and any of them failed,
 	// we fail the parent rule as well
 	if o.RollupChildResults {
-		if failCount > 0 {
+		if u.ChildrenFailed > 0 {
 			u.Pass = false
+			u.Status = StatusFail
 		}
 	}
 
 	return u, nil
 }
 
+// evalGuard evaluates the guard of the rule r, returning whether the rule applies
+func (e *DefaultEngine) evalGuard(r *Rule, d map[string]interface{}) (bool, error) {
+	val, _, err := e.e.Evaluate(d, r.Guard, r.Schema, r.Self, r.guardProgram, Bool{}, false)
+	if err != nil {
+		return false, fmt.Errorf("guard: %w", err)
+	}
+
+	applies, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("guard: %w: %T", ErrNonBoolResult, val)
+	}
+	return applies, nil
+}
+
+// recordSkipped adds a result marked as Skipped to u for each child
+// of the rule r. The children of the child rules are not recorded.
+func recordSkipped(u *Result, r *Rule, o EvalOptions) {
+	for _, cr := range r.sortChildKeys(o) {
+		if cr == nil {
+			continue
+		}
+		result := &Result{
+			Rule:        cr,
+			Metadata:    &cr.Metadata,
+			Skipped:     true,
+			Status:      StatusSkipped,
+			EvalOptions: cr.EvalOptions,
+		}
+		u.Results[cr.ID] = result
+		u.OrderedResults = append(u.OrderedResults, result)
+
+		if o.OnResult != nil {
+			o.OnResult(result)
+		}
+	}
+}
+
 // Compile uses the Evaluator's compile method to check the rule and its children,
 // returning any validation errors. Stores a compiled version of the rule in the
 // rule.Program field (if the compiler returns a program).
@@ -133,35 +564,317 @@ func (e *DefaultEngine) Compile(r *Rule, opts ...CompilationOption) error {
 		return err
 	}
 
+	// A rule that is its own descendant would cause compilation and
+	// evaluation to recurse forever
+	if err := checkCycles(r, nil); err != nil {
+		return err
+	}
+
 	o := compileOptions{}
 	applyCompilerOptions(&o, opts...)
+	err := e.compile(r, o)
+	e.logCompile(r, err)
+	return err
+}
+
+// compile compiles the rule and its children recursively
+func (e *DefaultEngine) compile(r *Rule, o compileOptions) error {
+	if r == nil {
+		return ErrNilRule
+	}
+
+	if r.Metadata.Severity.rank() < 0 {
+		return &CompileError{RuleID: r.ID, Err: fmt.Errorf("%w: %q", ErrInvalidSeverity, r.Metadata.Severity)}
+	}
+
+	if q := r.Quorum; q != nil {
+		if err := q.validate(); err != nil {
+			return &CompileError{RuleID: r.ID, Err: err}
+		}
+	}
+
+	if f := r.Fallback; f != nil {
+		if err := f.validate(r); err != nil {
+			return &CompileError{RuleID: r.ID, Err: err}
+		}
+	}
+
+	if x := r.Experiment; x != nil {
+		if x.Key == "" {
+			return &CompileError{RuleID: r.ID, Err: fmt.Errorf("experiment has no key")}
+		}
+		if _, ok := r.Rules[x.Default]; x.Default != "" && !ok {
+			return &CompileError{RuleID: r.ID, Err: fmt.Errorf("experiment default %s: %w", x.Default, ErrRuleNotFound)}
+		}
+	}
+
+	for _, name := range r.Enrich {
+		if _, err := e.enricher(name); err != nil {
+			return &CompileError{RuleID: r.ID, Err: err}
+		}
+	}
 
 	resultType := r.ResultType
 	if resultType == nil {
 		resultType = Bool{}
 	}
 
-	prg, err := e.e.Compile(r.Expr, r.Schema, resultType, o.collectDiagnostics, o.dryRun)
+	schema, err := e.schemaOf(r)
 	if err != nil {
-		return fmt.Errorf("rule %s: %w", r.ID, err)
+		return &CompileError{RuleID: r.ID, Err: err}
+	}
+
+	// The expressions are compiled with self and item declared; the rule keeps the schema as is
+	exprSchema, err := reservedSchema(r, schema)
+	if err != nil {
+		return &CompileError{RuleID: r.ID, Err: err}
+	}
+
+	// Rules whose expressions and schemas are unchanged since the previous
+	// version of the rule was compiled reuse its programs (see Vault.Update)
+	compilation := compilationKey(r, exprSchema, resultType, o)
+	var c compiledExprs
+	if prev := o.previous[r.ID]; prev != nil && prev.compiled && prev.compilation == compilation {
+		c = compiledExprs{prg: prev.Program, key: prev.programKey, guard: prev.guardProgram,
+			outputs: prev.outputPrograms, aggregate: prev.aggregate}
+	} else {
+		if c, err = e.compileExprs(r, schema, exprSchema, resultType, o); err != nil {
+			return err
+		}
+		if o.recompiled != nil {
+			*o.recompiled = append(*o.recompiled, r.ID)
+		}
+	}
+
+	approved, err := e.approved(r)
+	if err != nil {
+		return &CompileError{RuleID: r.ID, Err: err}
 	}
 
 	if !o.dryRun {
-		r.Program = prg
+		r.Schema = schema
+		r.Program = c.prg
+		e.useProgram(r, c.key)
+		r.guardProgram = c.guard
+		r.outputPrograms = c.outputs
+		r.compiled = true
+		r.compilation = compilation
+		r.aggregate = c.aggregate
+		r.unapproved = !approved
 	}
 
 	for _, cr := range r.Rules {
-		err := e.Compile(cr, opts...)
+		err := e.compile(cr, o)
 		if err != nil {
 			return err
 		}
 	}
+
+	for id, cr := range r.ElseRules {
+		if _, ok := r.Rules[id]; ok {
+			return &CompileError{RuleID: r.ID, Err: fmt.Errorf("else rule %s: %w", id, ErrDuplicateRuleID)}
+		}
+		if err := e.compile(cr, o); err != nil {
+			return err
+		}
+	}
+	return e.prepareChildren(r, o)
+}
+
+// compiledExprs holds the programs of the expressions of a rule
+type compiledExprs struct {
+	prg       interface{}
+	key       string // the key of the shared program (see compileExpr)
+	guard     interface{}
+	outputs   map[string]interface{}
+	aggregate bool // whether the expression refers to the outcomes of the child rules
+}
+
+// compileExprs checks and compiles the expression, guard and outputs of the rule r,
+// whose schema is s, and s with the reserved names declared is exprSchema
+func (e *DefaultEngine) compileExprs(r *Rule, s, exprSchema Schema, resultType Type, o compileOptions) (compiledExprs, error) {
+	if o.maxComplexity > 0 || o.rejectConstant {
+		if err := e.checkExpression(r, exprSchema, resultType, o); err != nil {
+			return compiledExprs{}, &CompileError{RuleID: r.ID, Err: err}
+		}
+	}
+
+	prg, key, err := e.compileExpr(r, exprSchema, resultType, o)
+	if err != nil {
+		return compiledExprs{}, &CompileError{RuleID: r.ID, Err: err}
+	}
+
+	var guard interface{}
+	if r.Guard != "" {
+		guard, err = e.e.Compile(r.Guard, exprSchema, Bool{}, o.collectDiagnostics, o.dryRun)
+		if err != nil {
+			return compiledExprs{}, &CompileError{RuleID: r.ID, Err: fmt.Errorf("guard: %w", err)}
+		}
+	}
+
+	outputs, err := e.compileOutputs(r, exprSchema, o)
+	if err != nil {
+		return compiledExprs{}, &CompileError{RuleID: r.ID, Err: err}
+	}
+
+	c := compiledExprs{prg: prg, key: key, guard: guard, outputs: outputs}
+	if !o.dryRun {
+		c.aggregate = e.aggregates(r, s, exprSchema)
+	}
+	return c, nil
+}
+
+// compilationKey identifies the inputs to compiling the expressions of the rule r
+// with the schema s; rules with the same key compile to equivalent programs
+func compilationKey(r *Rule, s Schema, resultType Type, o compileOptions) string {
+	var b strings.Builder
+	b.WriteString(programKey("", s, resultType, o.collectDiagnostics))
+	fmt.Fprintf(&b, "\x00%t\x00%t\x00%d\x00%t", o.sharePrograms, o.rejectConstant, o.maxComplexity, len(r.Rules) > 0)
+	for _, x := range ruleExprs(r) {
+		fmt.Fprintf(&b, "\x00%s%s\x00%s", x.name, x.expr, x.t)
+	}
+	return b.String()
+}
+
+// compileExpr compiles the rule's expression, reusing a program compiled
+// for an identical expression and schema if programs are shared. It returns
+// the key of the shared program, or a blank key if the program is not shared.
+func (e *DefaultEngine) compileExpr(r *Rule, s Schema, resultType Type, o compileOptions) (interface{}, string, error) {
+	if !o.sharePrograms || o.dryRun {
+		prg, err := e.e.Compile(r.Expr, s, resultType, o.collectDiagnostics, o.dryRun)
+		return prg, "", err
+	}
+
+	key := programKey(r.Expr, s, resultType, o.collectDiagnostics)
+	e.mu.Lock()
+	sp, ok := e.programs[key]
+	e.mu.Unlock()
+	if ok {
+		return sp.prg, key, nil
+	}
+
+	prg, err := e.e.Compile(r.Expr, s, resultType, o.collectDiagnostics, o.dryRun)
+	if err != nil || prg == nil {
+		return prg, "", err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.programs == nil {
+		e.programs = map[string]*sharedProgram{}
+	}
+	if sp, ok := e.programs[key]; ok {
+		return sp.prg, key, nil
+	}
+	e.programs[key] = &sharedProgram{prg: prg}
+	return prg, key, nil
+}
+
+// sharedProgram is a program shared between rules, and the number of compiled
+// rules using it
+type sharedProgram struct {
+	prg  interface{}
+	refs int
+}
+
+// useProgram records that the rule r uses the shared program with the key, and
+// no longer uses the shared program it was compiled with before, if any
+func (e *DefaultEngine) useProgram(r *Rule, key string) {
+	if r.programKey == key {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.release(r.programKey)
+	if sp, ok := e.programs[key]; ok {
+		sp.refs++
+	}
+	r.programKey = key
+}
+
+// releasePrograms records that the rule r and its descendants, except the rules
+// in keep, no longer use their shared programs, and removes the programs no rule uses
+func (e *DefaultEngine) releasePrograms(r *Rule, keep map[*Rule]bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_ = ApplyToRule(r, func(c *Rule) error {
+		if c != nil && !keep[c] {
+			e.release(c.programKey)
+		}
+		return nil
+	})
+}
+
+// release removes a use of the shared program with the key, removing the program
+// if no rule uses it. The caller must hold e.mu.
+func (e *DefaultEngine) release(key string) {
+	if sp, ok := e.programs[key]; ok && key != "" {
+		if sp.refs--; sp.refs <= 0 {
+			delete(e.programs, key)
+		}
+	}
+}
+
+// programKey identifies the inputs to compiling an expression
+func programKey(expr string, s Schema, resultType Type, collectDiagnostics bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\x00%s\x00%t", expr, resultType, collectDiagnostics)
+	for _, el := range s.Elements {
+		fmt.Fprintf(&b, "\x00%s:%s", el.Name, el.Type)
+	}
+	return b.String()
+}
+
+// ClearSharedPrograms removes the programs shared between rules (see SharePrograms)
+// from the engine. Rules that have been compiled keep their programs.
+// Rules removed from a vault release their shared programs; call it after
+// discarding rules compiled outside a vault, to release the memory held by their
+// programs.
+func (e *DefaultEngine) ClearSharedPrograms() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.programs = nil
+}
+
+// prepareChildren builds the index (see Rule.IndexBy) and the combined
+// programs (see CombineSiblings) used to evaluate the compiled child rules of r
+func (e *DefaultEngine) prepareChildren(r *Rule, o compileOptions) error {
+	var idx *childIndex
+	if r.IndexBy != "" {
+		var err error
+		if idx, err = buildIndex(r, e.e); err != nil {
+			return err
+		}
+	}
+
+	var groups []combinedGroup
+	if o.combineSiblings && r.IndexBy == "" && r.Experiment == nil {
+		if x, ok := e.e.(ExpressionCombiner); ok {
+			groups = combineChildren(r, x)
+		}
+	}
+
+	if !o.dryRun {
+		r.index = idx
+		r.combined = groups
+	}
 	return nil
 }
 
 type compileOptions struct {
 	dryRun             bool
 	collectDiagnostics bool
+	combineSiblings    bool
+	sharePrograms      bool
+	maxComplexity      int
+	rejectConstant     bool
+
+	// The previous versions of the rules, by ID, whose programs are reused if
+	// their expressions and schemas are unchanged, and the IDs of the rules
+	// compiled instead (see Vault.Update)
+	previous   map[string]*Rule
+	recompiled *[]string
 }
 
 // CompilationOption is a functional option to specify compilation behavior.
@@ -185,6 +898,57 @@ func CollectDiagnostics(b bool) CompilationOption {
 	}
 }
 
+// CombineSiblings instructs the engine to compile the simple child rules of each
+// rule into combined programs, if the evaluator implements ExpressionCombiner.
+// A combined program evaluates the expressions of many child rules in one step,
+// which reduces the overhead of evaluating rule sets with many small expressions.
+//
+// Child rules are combined if they have no children, no Self, a boolean result type
+// and the same schema. Rules using Rule.IndexBy do not combine their children.
+// Combined programs are not used when diagnostics are returned. All combined
+// child rules are evaluated, even if the evaluation of the child rules stops early;
+// if a combined program fails, the child rules are evaluated one by one.
+func CombineSiblings(b bool) CompilationOption {
+	return func(f *compileOptions) {
+		f.combineSiblings = b
+	}
+}
+
+// SharePrograms instructs the engine to reuse compiled programs for rules with
+// identical expressions, result types and schemas (compared by the names and
+// types of their elements), instead of compiling each rule separately.
+// This reduces the memory used by copies of rule trees. The engine keeps a shared
+// program until no rule uses it: until the rules using it are removed or replaced
+// in a vault, or recompiled, or until ClearSharedPrograms is called.
+// Use it only with evaluators whose programs can safely be shared between rules,
+// such as the CEL evaluator.
+func SharePrograms(b bool) CompilationOption {
+	return func(f *compileOptions) {
+		f.sharePrograms = b
+	}
+}
+
+// MaxComplexity makes compilation fail with ErrTooComplex if the complexity score
+// (see Complexity.Score) of a rule's expression is above max, to keep rules authored
+// by users reviewable and fast. A max of 0 sets no limit. The evaluator must
+// implement ExpressionAnalyzer.
+func MaxComplexity(max int) CompilationOption {
+	return func(f *compileOptions) {
+		f.maxComplexity = max
+	}
+}
+
+// RejectConstantRules makes compilation fail with ErrConstantRule if a boolean rule's
+// expression is always true or always false, regardless of the input data, such as
+// `amount > 10 && amount < 5`; such rules are almost always authoring mistakes.
+// Expressions that are a single literal, such as `true`, are allowed.
+// The evaluator must implement ExpressionAnalyzer.
+func RejectConstantRules(b bool) CompilationOption {
+	return func(f *compileOptions) {
+		f.rejectConstant = b
+	}
+}
+
 // Given an array of EngineOption functions, apply their effect
 // on the engineOptions struct.
 func applyCompilerOptions(o *compileOptions, opts ...CompilationOption) {
@@ -200,15 +964,22 @@ type EvalOptions struct {
 	// Use case: apply a "global" rule to all the child rules.
 	StopIfParentNegative bool `json:"stop_if_parent_negative"`
 
+	// RecordSkipped adds a result marked as Skipped for each child rule
+	// that was not evaluated because of StopIfParentNegative, so that they can be
+	// told apart from rules that were evaluated and discarded.
+	// Skipped results are included even if DiscardFail is set.
+	// Default: child rules that were not evaluated are not in the results
+	RecordSkipped bool `json:"record_skipped"`
+
 	// Stops the evaluation of child rules when the first positive child is encountered.
 	// Results will be partial. Only the child rules that were evaluated will be in the results.
-	// By default rules are evaluated in alphabetical order.
+	// By default rules are evaluated in alphabetical order by rule ID.
 	// Use case: role-based access; allow action if any child rule (permission rule) allows it.
 	StopFirstPositiveChild bool `json:"stop_first_positive_child"`
 
 	// Stops the evaluation of child rules when the first negative child is encountered.
 	// Results will be partial. Only the child rules that were evaluated will be in the results.
-	// By default rules are evaluated in alphabetical order.
+	// By default rules are evaluated in alphabetical order by rule ID.
 	// Use case: you require ALL child rules to be satisfied.
 	StopFirstNegativeChild bool `json:"stop_first_negative_child"`
 
@@ -225,6 +996,11 @@ type EvalOptions struct {
 	// collection at the engine level with the CollectDiagnostics EngineOption.
 	ReturnDiagnostics bool `json:"return_diagnostics"`
 
+	// Record the time taken to evaluate each rule (including its children)
+	// in Result.Duration.
+	// Use this to identify slow rules from production results.
+	ReturnTiming bool `json:"return_timing"`
+
 	// RollupChildResults indicates that the rule's Pass will
 	// be the result of this rule AND the child rules.
 	// In order for this rule to return Pass, the rule itself must be
@@ -232,14 +1008,103 @@ type EvalOptions struct {
 	// This only affects the value of result.Pass; it does not affect the result.Value.
 	RollupChildResults bool `json:"rollup_child_results"`
 
+	// MaxDepth is the maximum depth of child rules to evaluate, counting the
+	// rule passed to Eval as depth 0. If a rule at the maximum depth has child rules,
+	// Eval returns ErrMaxDepthExceeded, unless TruncateAtMaxDepth is set.
+	// Default: 0, no limit
+	MaxDepth int `json:"max_depth"`
+
+	// TruncateAtMaxDepth stops the evaluation at MaxDepth without returning an
+	// error. Instead, rules whose children were not evaluated are marked
+	// with Result.Truncated.
+	TruncateAtMaxDepth bool `json:"truncate_at_max_depth"`
+
+	// ContinueOnError records an error evaluating a child rule in the child's
+	// Result.Error and continues evaluating the remaining child rules, instead of
+	// aborting the evaluation of the entire rule tree.
+	// A child rule with an error is treated as a failed (negative) rule, and it is
+	// always included in the results, even if DiscardFail is set.
+	// Default: an error in any rule stops evaluation and is returned from Eval.
+	ContinueOnError bool `json:"continue_on_error"`
+
+	// NonBoolPolicy determines how a boolean rule (a rule whose ResultType is
+	// Bool or nil) that yields a value that is not a boolean is treated.
+	// Default: NonBoolIndeterminate
+	NonBoolPolicy NonBoolPolicy `json:"non_bool_policy"`
+
+	// Environment is the environment the rules are evaluated in, such as "prod".
+	// Child rules limited to other environments (see Rule.Environments) are not evaluated.
+	// Default: "", only rules that are not limited to any environment are evaluated
+	Environment string `json:"environment,omitempty"`
+
+	// AsOf evaluates the rules in a vault as they were at the time, if the vault
+	// keeps history (see Vault.KeepHistory). Ignored when rules are evaluated with Engine.Eval.
+	// Default: the zero time, the current rules
+	AsOf time.Time `json:"as_of,omitempty"`
+
+	// Locale selects the message template (see Rule.Messages) used to render
+	// Result.Message, such as "en" or "sv-SE".
+	// Default: "", the default message
+	Locale string `json:"locale,omitempty"`
+
+	// OnResult is called with the result of each rule as soon as the rule and its
+	// children have been evaluated, before the evaluation of the rest of the rule
+	// tree continues. Child rules are therefore reported before their parent, and the
+	// rule passed to Eval is reported last. Results discarded by DiscardPass or
+	// DiscardFail are reported as well.
+	// Use it to show the progress of evaluating large rule trees; to stop the
+	// evaluation early, cancel the context passed to Eval.
+	// The results are also returned from Eval; OnResult must not modify them.
+	OnResult func(*Result) `json:"-"`
+
+	// PoolResults takes the results of the evaluation from a pool of results
+	// released with Result.Release, instead of allocating new results.
+	// Use it in services that evaluate rules at a high rate, and release each
+	// result when it is no longer needed.
+	PoolResults bool `json:"pool_results"`
+
+	// StrictOptions checks the options of each rule before it is evaluated, and
+	// returns an error wrapping ErrConflictingOptions if they contradict each other
+	// (see Validate).
+	// Default: conflicting options are accepted, and the first applicable option wins
+	StrictOptions bool `json:"strict_options"`
+
+	// CoerceData converts the values of the input data to the types of the rule's
+	// schema before the rule is evaluated, such as JSON numbers to int64 (see Schema.Coerce).
+	// The input data is not modified.
+	// Default: values must have the types of the schema
+	CoerceData bool `json:"coerce_data"`
+
 	// Specify the function used to sort the child rules before evaluation.
+	// The sort order determines the order of evaluation, and therefore the
+	// order of Result.OrderedResults.
 	// Useful in scenarios where you are asking the engine to stop evaluating
 	// after either the first negative or first positive child.
-	// See the provided SortAlpha function as an example.
-	// Default: No sort
+	// Default: alphabetical by rule ID
 	SortFunc func(rules []*Rule, i, j int) bool `json:"-"`
 }
 
+// NonBoolPolicy determines how a boolean rule that yields a value that is not
+// a boolean, such as nil, is treated.
+//
+// Evaluators that type-check expressions, such as the CEL evaluator, reject
+// most such rules when they are compiled; the policy applies to the values
+// that can only be detected during evaluation.
+type NonBoolPolicy int
+
+const (
+	// NonBoolIndeterminate sets the result's Status to StatusIndeterminate.
+	// Pass keeps its default value, true.
+	NonBoolIndeterminate NonBoolPolicy = iota
+
+	// NonBoolTruthy converts the value to a boolean: nil, zero numbers and
+	// empty strings, lists and maps are false, all other values are true.
+	NonBoolTruthy
+
+	// NonBoolError treats the value as an evaluation error (ErrNonBoolResult).
+	NonBoolError
+)
+
 // EvalOption is a functional option for specifying how evaluations behave.
 type EvalOption func(f *EvalOptions)
 
@@ -252,9 +1117,17 @@ func ReturnDiagnostics(b bool) EvalOption {
 	}
 }
 
+// ReturnTiming specifies that the time taken to evaluate each rule
+// should be recorded in the Duration field of the rule's result.
+func ReturnTiming(b bool) EvalOption {
+	return func(f *EvalOptions) {
+		f.ReturnTiming = b
+	}
+}
+
 // SortFunc specifies the function used to sort child rules before evaluation.
-// Sorting is only performed if the evaluation order of the child rules is important (i.e.,
-// if an option such as StopFirstNegativeChild is set).
+// If no sort function is given, child rules are evaluated in alphabetical order by
+// rule ID, so that the order of results is stable from one evaluation to the next.
 func SortFunc(x func(rules []*Rule, i, j int) bool) EvalOption {
 	return func(f *EvalOptions) {
 		f.SortFunc = x
@@ -291,6 +1164,14 @@ func StopFirstNegativeChild(b bool) EvalOption {
 	}
 }
 
+// RecordSkipped specifies that child rules not evaluated because of
+// StopIfParentNegative should be recorded as skipped in the results.
+func RecordSkipped(b bool) EvalOption {
+	return func(f *EvalOptions) {
+		f.RecordSkipped = b
+	}
+}
+
 // StopFirstPositiveChild stops the evaluation of child rules once the first
 // positive child has been found.
 func StopFirstPositiveChild(b bool) EvalOption {
@@ -309,6 +1190,132 @@ func RollupChildResults(b bool) EvalOption {
 	}
 }
 
+// ContinueOnError specifies that an error evaluating a child rule should
+// be recorded in the child's result, and the evaluation of the remaining
+// rules should continue.
+func ContinueOnError(b bool) EvalOption {
+	return func(f *EvalOptions) {
+		f.ContinueOnError = b
+	}
+}
+
+// MaxDepth specifies the maximum depth of child rules to evaluate.
+// A value of 0 means there is no limit.
+func MaxDepth(n int) EvalOption {
+	return func(f *EvalOptions) {
+		f.MaxDepth = n
+	}
+}
+
+// TruncateAtMaxDepth specifies that rules deeper than MaxDepth should be
+// skipped, and the truncation recorded in the results, instead of returning an error.
+func TruncateAtMaxDepth(b bool) EvalOption {
+	return func(f *EvalOptions) {
+		f.TruncateAtMaxDepth = b
+	}
+}
+
+// OnResult specifies a function to call with the result of each rule
+// as soon as it has been evaluated.
+func OnResult(x func(*Result)) EvalOption {
+	return func(f *EvalOptions) {
+		f.OnResult = x
+	}
+}
+
+// NonBool specifies how boolean rules that yield a non-boolean value are treated.
+func NonBool(p NonBoolPolicy) EvalOption {
+	return func(f *EvalOptions) {
+		f.NonBoolPolicy = p
+	}
+}
+
+// Environment specifies the environment the rules are evaluated in (see Rule.Environments).
+func Environment(env string) EvalOption {
+	return func(f *EvalOptions) {
+		f.Environment = env
+	}
+}
+
+// AsOf specifies that the rules in a vault should be evaluated as they were at the time t.
+func AsOf(t time.Time) EvalOption {
+	return func(f *EvalOptions) {
+		f.AsOf = t
+	}
+}
+
+// Locale specifies the locale of the messages rendered in the results.
+func Locale(l string) EvalOption {
+	return func(f *EvalOptions) {
+		f.Locale = l
+	}
+}
+
+// PoolResults specifies that results should be taken from a pool of
+// released results (see Result.Release).
+func PoolResults(b bool) EvalOption {
+	return func(f *EvalOptions) {
+		f.PoolResults = b
+	}
+}
+
+// StrictOptions specifies that rules with conflicting evaluation options
+// should not be evaluated (see EvalOptions.Validate).
+func StrictOptions(b bool) EvalOption {
+	return func(f *EvalOptions) {
+		f.StrictOptions = b
+	}
+}
+
+// CoerceData specifies that the input data should be converted to the
+// types of the rule's schema before evaluation (see Schema.Coerce).
+func CoerceData(b bool) EvalOption {
+	return func(f *EvalOptions) {
+		f.CoerceData = b
+	}
+}
+
+// inheritOptions adds the options inherited from a rule's ancestors to the
+// rule's own options: a boolean option is on if it is on in either, and
+// the other options are inherited if the rule does not set them.
+func inheritOptions(own, in EvalOptions) EvalOptions {
+	o := own
+	o.StopIfParentNegative = own.StopIfParentNegative || in.StopIfParentNegative
+	o.RecordSkipped = own.RecordSkipped || in.RecordSkipped
+	o.StopFirstPositiveChild = own.StopFirstPositiveChild || in.StopFirstPositiveChild
+	o.StopFirstNegativeChild = own.StopFirstNegativeChild || in.StopFirstNegativeChild
+	o.DiscardPass = own.DiscardPass || in.DiscardPass
+	o.DiscardFail = own.DiscardFail || in.DiscardFail
+	o.ReturnDiagnostics = own.ReturnDiagnostics || in.ReturnDiagnostics
+	o.ReturnTiming = own.ReturnTiming || in.ReturnTiming
+	o.RollupChildResults = own.RollupChildResults || in.RollupChildResults
+	o.TruncateAtMaxDepth = own.TruncateAtMaxDepth || in.TruncateAtMaxDepth
+	o.ContinueOnError = own.ContinueOnError || in.ContinueOnError
+	o.PoolResults = own.PoolResults || in.PoolResults
+	o.StrictOptions = own.StrictOptions || in.StrictOptions
+	o.CoerceData = own.CoerceData || in.CoerceData
+
+	if o.MaxDepth == 0 {
+		o.MaxDepth = in.MaxDepth
+	}
+	if o.NonBoolPolicy == NonBoolIndeterminate {
+		o.NonBoolPolicy = in.NonBoolPolicy
+	}
+	if o.Environment == "" {
+		o.Environment = in.Environment
+	}
+	if o.Locale == "" {
+		o.Locale = in.Locale
+	}
+	if o.OnResult == nil {
+		o.OnResult = in.OnResult
+	}
+	if o.SortFunc == nil {
+		o.SortFunc = in.SortFunc
+	}
+	return o
+}
+
 // // See the EvalOptions struct for documentation.
 func applyEvaluatorOptions(o *EvalOptions, opts ...EvalOption) {
 	for _, opt := range opts {
@@ -321,32 +1328,18 @@ func validateEvalArguments(r *Rule, e *DefaultEngine, d map[string]interface{})
 
 	switch {
 	case r == nil:
-		return fmt.Errorf("rule is nil")
+		return ErrNilRule
 	case e == nil:
-		return fmt.Errorf("engine is nil")
+		return ErrNilEngine
 	case e.e == nil:
-		return fmt.Errorf("evaluator is nil")
+		return ErrNilEvaluator
 	case d == nil:
-		return fmt.Errorf("data is nil")
+		return ErrNilData
 	default:
 		return nil
 	}
 }
 
-func setSelfKey(r *Rule, d map[string]interface{}) {
-	if d == nil {
-		return
-	}
-	// If this rule has a reference to a 'self' object, insert it into the d.
-	// If it doesn't, we must remove any existing reference to self, so that
-	// child rules do not accidentally "inherit" the self object.
-	if r.Self != nil {
-		d[selfKey] = r.Self
-	} else {
-		delete(d, selfKey)
-	}
-}
-
 // Default the result type to boolean
 // This is the result type passed to the evaluator. The evaluator may use it to
 // inspect / validate the result it generates.
@@ -366,12 +1359,37 @@ func validateCompileArguments(r *Rule, e *DefaultEngine) error {
 
 	switch {
 	case r == nil:
-		return fmt.Errorf("rule is nil")
+		return ErrNilRule
 	case e == nil:
-		return fmt.Errorf("engine is nil")
+		return ErrNilEngine
 	case e.e == nil:
-		return fmt.Errorf("evaluator is nil")
+		return ErrNilEvaluator
 	default:
 		return nil
 	}
 }
+
+// truthy converts a value to a boolean: nil, zero numbers and empty
+// strings, lists and maps are false, all other values are true
+func truthy(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() != 0
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return rv.Len() > 0
+	case reflect.Ptr, reflect.Interface:
+		return !rv.IsNil()
+	}
+	return true
+}
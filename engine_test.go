@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -152,6 +153,553 @@ func TestSelf(t *testing.T) {
 	is.Equal(result.Results["D"].Results["d1"].Pass, false) // d1 should not inherit D's self
 }
 
+// Test that rule metadata is available in the results
+func TestMetadata(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	r.Rules["B"].Metadata.Owner = "someone"
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(u.Results["B"].Metadata.Owner, "someone")
+	is.Equal(u.Metadata.Owner, "")
+}
+
+// Test that children not evaluated because of StopIfParentNegative
+// can be recorded as skipped
+func TestRecordSkipped(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	r.Rules["B"].EvalOptions.StopIfParentNegative = true
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(len(u.Results["B"].Results), 0)
+
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.RecordSkipped(true), indigo.DiscardFail(true))
+	is.NoErr(err)
+	b := u.Results["B"]
+	is.Equal(b, (*indigo.Result)(nil)) // B failed and was discarded
+
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.RecordSkipped(true))
+	is.NoErr(err)
+	b = u.Results["B"]
+	is.Equal(len(b.OrderedResults), 4)
+	for _, c := range b.OrderedResults {
+		is.True(c.Skipped)
+		is.Equal(c.Pass, false)
+		is.Equal(len(c.Results), 0)
+	}
+	is.Equal(b.OrderedResults[0].Rule.ID, "b1")
+	is.Equal(u.Results["D"].Results["d1"].Skipped, false)
+}
+
+// Test the status of results
+func TestStatus(t *testing.T) {
+	is := is.New(t)
+
+	r := &indigo.Rule{
+		ID:   "root",
+		Expr: `true`,
+		Rules: map[string]*indigo.Rule{
+			"pass":  {ID: "pass", Expr: `true`},
+			"fail":  {ID: "fail", Expr: `false`},
+			"error": {ID: "error", Expr: `error`},
+			"indet": {ID: "indet", Expr: `self`, Self: 5},
+			"stop": {
+				ID:          "stop",
+				Expr:        `false`,
+				EvalOptions: indigo.EvalOptions{StopIfParentNegative: true},
+				Rules: map[string]*indigo.Rule{
+					"skipped": {ID: "skipped", Expr: `true`},
+				},
+			},
+		},
+	}
+
+	e := indigo.NewEngine(newMockEvaluator())
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{}, indigo.ContinueOnError(true), indigo.RecordSkipped(true))
+	is.NoErr(err)
+	is.Equal(u.Status, indigo.StatusPass)
+	is.Equal(u.Results["pass"].Status, indigo.StatusPass)
+	is.Equal(u.Results["fail"].Status, indigo.StatusFail)
+	is.Equal(u.Results["error"].Status, indigo.StatusError)
+	is.Equal(u.Results["indet"].Status, indigo.StatusIndeterminate)
+	is.Equal(u.Results["stop"].Results["skipped"].Status, indigo.StatusSkipped)
+	is.Equal(indigo.StatusIndeterminate.String(), "Indeterminate")
+
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.ContinueOnError(true), indigo.RollupChildResults(true))
+	is.NoErr(err)
+	is.Equal(u.Status, indigo.StatusFail)
+}
+
+// Test the policies for boolean rules yielding non-boolean values
+func TestNonBoolPolicy(t *testing.T) {
+	is := is.New(t)
+
+	r := &indigo.Rule{
+		ID:   "root",
+		Expr: `true`,
+		Rules: map[string]*indigo.Rule{
+			"zero":  {ID: "zero", Expr: `self`, Self: 0},
+			"five":  {ID: "five", Expr: `self`, Self: 5},
+			"empty": {ID: "empty", Expr: `self`, Self: []string{}},
+		},
+	}
+
+	e := indigo.NewEngine(newMockEvaluator())
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(u.Results["zero"].Status, indigo.StatusIndeterminate)
+	is.Equal(u.Results["zero"].Pass, true)
+
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.NonBool(indigo.NonBoolTruthy))
+	is.NoErr(err)
+	is.Equal(u.Results["zero"].Pass, false)
+	is.Equal(u.Results["zero"].Status, indigo.StatusFail)
+	is.Equal(u.Results["five"].Pass, true)
+	is.Equal(u.Results["five"].Status, indigo.StatusPass)
+	is.Equal(u.Results["empty"].Pass, false)
+
+	_, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.NonBool(indigo.NonBoolError))
+	is.True(errors.Is(err, indigo.ErrNonBoolResult))
+
+	// The policy can be set on individual rules
+	r.Rules["five"].EvalOptions.NonBoolPolicy = indigo.NonBoolError
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.ContinueOnError(true))
+	is.NoErr(err)
+	is.Equal(u.Results["five"].Status, indigo.StatusError)
+	is.Equal(u.Results["zero"].Status, indigo.StatusIndeterminate)
+}
+
+// Test that results are reported as soon as they are available
+func TestOnResult(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	is.NoErr(e.Compile(r))
+
+	ids := []string{}
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{}, indigo.DiscardPass(true),
+		indigo.OnResult(func(u *indigo.Result) {
+			ids = append(ids, u.Rule.ID)
+		}))
+	is.NoErr(err)
+	is.Equal(len(ids), u.EvaluationCount)
+	is.Equal(ids[:4], []string{"b1", "b2", "b3", "b4-1"})
+	is.Equal(ids[len(ids)-2:], []string{"E", "rule1"})
+
+	// Stop the evaluation after the first failure
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ids = []string{}
+	_, err = e.Eval(ctx, r, map[string]interface{}{}, indigo.OnResult(func(u *indigo.Result) {
+		ids = append(ids, u.Rule.ID)
+		if !u.Pass {
+			cancel()
+		}
+	}))
+	is.True(errors.Is(err, context.Canceled))
+	is.Equal(ids, []string{"b1", "b2"})
+}
+
+func TestMiddleware(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	is.NoErr(e.Compile(r))
+
+	calls := []string{}
+	trace := func(name string) indigo.Middleware {
+		return func(next indigo.Evaluator) indigo.Evaluator {
+			return indigo.EvaluatorFunc(func(ctx context.Context, r *indigo.Rule,
+				d map[string]interface{}, opts ...indigo.EvalOption) (*indigo.Result, error) {
+				calls = append(calls, name+" before")
+				u, err := next.Eval(ctx, r, d, opts...)
+				calls = append(calls, name+" after")
+				return u, err
+			})
+		}
+	}
+
+	// Replace the result of the evaluation
+	override := func(next indigo.Evaluator) indigo.Evaluator {
+		return indigo.EvaluatorFunc(func(ctx context.Context, r *indigo.Rule,
+			d map[string]interface{}, opts ...indigo.EvalOption) (*indigo.Result, error) {
+			u, err := next.Eval(ctx, r, d, opts...)
+			if err == nil {
+				u.Pass = false
+			}
+			return u, err
+		})
+	}
+
+	e.Use(trace("outer"), trace("inner"))
+	e.Use(override)
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.True(!u.Pass)
+	is.Equal(calls, []string{"outer before", "inner before", "inner after", "outer after"})
+}
+
+// Test that pooled results are the same as allocated results, and can be reused
+func TestPoolResults(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	is.NoErr(e.Compile(r))
+
+	want, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+
+	for i := 0; i < 3; i++ {
+		d := indigo.AcquireData()
+		is.Equal(len(d), 0)
+		d["x"] = i
+
+		u, err := e.Eval(context.Background(), r, d, indigo.PoolResults(true), indigo.DiscardFail(i == 1))
+		is.NoErr(err)
+		if i == 1 {
+			is.True(len(flattenResults(u)) < len(flattenResults(want)))
+		} else {
+			is.Equal(flattenResults(u), flattenResults(want))
+		}
+		u.Release()
+		indigo.ReleaseData(d)
+	}
+
+	u := &indigo.Result{Pass: true, Results: map[string]*indigo.Result{"a": {}}, OrderedResults: []*indigo.Result{{}}}
+	u.Reset()
+	is.True(!u.Pass)
+	is.Equal(len(u.Results), 0)
+	is.Equal(len(u.OrderedResults), 0)
+}
+
+// Test that conflicting evaluation options are reported
+func TestStrictOptions(t *testing.T) {
+	is := is.New(t)
+
+	is.NoErr(indigo.EvalOptions{DiscardPass: true, MaxDepth: 2, TruncateAtMaxDepth: true}.Validate())
+
+	err := indigo.EvalOptions{DiscardPass: true, DiscardFail: true, TruncateAtMaxDepth: true}.Validate()
+	is.True(errors.Is(err, indigo.ErrConflictingOptions))
+	is.True(strings.Contains(err.Error(), "DiscardFail"))
+	is.True(strings.Contains(err.Error(), "MaxDepth"))
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	r.Rules["B"].EvalOptions.StopFirstPositiveChild = true
+	is.NoErr(e.Compile(r))
+
+	is.NoErr(indigo.ValidateOptions(r))
+	err = indigo.ValidateOptions(r, indigo.StopFirstNegativeChild(true))
+	is.True(errors.Is(err, indigo.ErrConflictingOptions))
+	is.True(strings.HasPrefix(err.Error(), "rule B:"))
+
+	// Conflicting options are accepted unless StrictOptions is set
+	_, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.StopFirstNegativeChild(true))
+	is.NoErr(err)
+
+	_, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.StopFirstNegativeChild(true), indigo.StrictOptions(true))
+	var ee *indigo.EvalError
+	is.True(errors.As(err, &ee))
+	is.Equal(ee.RuleID, "B")
+	is.True(errors.Is(err, indigo.ErrConflictingOptions))
+}
+
+// Test that options apply to the rule only, unless they are inherited
+func TestInheritOptions(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	r.EvalOptions.DiscardFail = true
+	is.NoErr(e.Compile(r))
+
+	d := map[string]interface{}{}
+	u, err := e.Eval(context.Background(), r, d)
+	is.NoErr(err)
+	is.Equal(len(u.Results), 1) // B and E failed
+	is.Equal(len(u.Results["D"].Results), 3)
+
+	r.InheritOptions = true
+	u, err = e.Eval(context.Background(), r, d)
+	is.NoErr(err)
+	is.Equal(len(u.Results), 1)
+	is.Equal(len(u.Results["D"].Results), 2) // d2 failed
+	is.True(u.Results["D"].EvalOptions.DiscardFail)
+
+	r.Rules["D"].OverrideInherited = true
+	u, err = e.Eval(context.Background(), r, d)
+	is.NoErr(err)
+	is.Equal(len(u.Results["D"].Results), 3)
+
+	// Options passed to Eval take precedence
+	u, err = e.Eval(context.Background(), r, d, indigo.DiscardFail(false))
+	is.NoErr(err)
+	is.Equal(len(u.Results), 3)
+}
+
+// Test that rules whose guard is false are not applicable, rather than failed
+func TestGuard(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	r.EvalOptions.RollupChildResults = true
+	r.Rules["B"].Guard = "false"
+	r.Rules["E"].Guard = "false"
+	r.Rules["D"].Guard = "true"
+	r.Rules["D"].Rules["d2"].Expr = "true"
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.True(u.Pass) // B and E did not apply
+	is.Equal(u.Results["B"].Status, indigo.StatusNotApplicable)
+	is.Equal(u.Results["B"].Status.String(), "NotApplicable")
+	is.Equal(len(u.Results["B"].Results), 0)
+	is.Equal(u.Results["D"].Status, indigo.StatusPass)
+	is.Equal(len(u.Results["D"].Results), 3)
+	is.Equal(u.EvaluationCount, 7) // rule1, B, D, d1-d3, E
+
+	r.Rules["E"].Guard = "error"
+	is.NoErr(e.Compile(r))
+	_, err = e.Eval(context.Background(), r, map[string]interface{}{})
+	var ee *indigo.EvalError
+	is.True(errors.As(err, &ee))
+	is.Equal(ee.RuleID, "E")
+
+	r.Rules["E"].Guard = "invalid"
+	var ce *indigo.CompileError
+	is.True(errors.As(e.Compile(r), &ce))
+}
+
+// Test that else rules are evaluated only if the rule's expression is false
+func TestElseRules(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := &indigo.Rule{
+		ID:          "vip",
+		Expr:        "false",
+		EvalOptions: indigo.EvalOptions{StopIfParentNegative: true},
+		Rules: map[string]*indigo.Rule{
+			"vip_discount": {ID: "vip_discount", Expr: "true"},
+		},
+		ElseRules: map[string]*indigo.Rule{
+			"regular_discount": {ID: "regular_discount", Expr: "true"},
+			"newsletter":       {ID: "newsletter", Expr: "true"},
+		},
+	}
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(len(u.Results), 2)
+	is.True(u.Results["regular_discount"].Pass)
+	is.Equal(u.OrderedResults[0].Rule.ID, "newsletter")
+
+	r.Expr = "true"
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(len(u.Results), 1)
+	is.True(u.Results["vip_discount"].Pass)
+
+	// Without StopIfParentNegative, the child rules are evaluated too
+	r.Expr = "false"
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.StopIfParentNegative(false))
+	is.NoErr(err)
+	is.Equal(len(u.Results), 3)
+	is.Equal(u.OrderedResults[0].Rule.ID, "vip_discount")
+
+	r.ElseRules["vip_discount"] = &indigo.Rule{ID: "vip_discount"}
+	is.True(errors.Is(e.Compile(r), indigo.ErrDuplicateRuleID))
+}
+
+// Test switching rules off, limiting them to environments, and rolling them out
+func TestGating(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	r.Rules["B"].Disabled = true
+	r.Rules["D"].Environments = []string{"dev", "stage"}
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(len(u.Results), 1) // E
+	is.Equal(u.EvaluationCount, 5)
+
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.Environment("stage"))
+	is.NoErr(err)
+	is.Equal(len(u.Results), 2) // D and E
+
+	r.Rules["E"].Rollout = &indigo.Rollout{Key: "user", Percent: 25}
+	included := 0
+	for i := 0; i < 1000; i++ {
+		d := map[string]interface{}{"user": i}
+		u, err = e.Eval(context.Background(), r, d)
+		is.NoErr(err)
+		if _, ok := u.Results["E"]; ok {
+			included++
+		}
+
+		// The decision is stable
+		u2, err := e.Eval(context.Background(), r, d)
+		is.NoErr(err)
+		is.Equal(len(u.Results), len(u2.Results))
+	}
+	is.True(included > 200 && included < 300)
+
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(len(u.Results), 0) // no user
+}
+
+// Test that experiments select one variant deterministically
+func TestExperiment(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := &indigo.Rule{
+		ID:         "pricing",
+		Expr:       "true",
+		Experiment: &indigo.Experiment{Key: "user", Weights: map[string]float64{"control": 3}, Default: "control"},
+		Rules: map[string]*indigo.Rule{
+			"control":   {ID: "control", Expr: "true"},
+			"treatment": {ID: "treatment", Expr: "false"},
+		},
+	}
+	is.NoErr(e.Compile(r))
+
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		d := map[string]interface{}{"user": fmt.Sprintf("user%d", i)}
+		u, err := e.Eval(context.Background(), r, d)
+		is.NoErr(err)
+		is.Equal(len(u.Results), 1)
+		is.True(u.Results[u.Variant] != nil)
+		counts[u.Variant]++
+
+		u2, err := e.Eval(context.Background(), r, d)
+		is.NoErr(err)
+		is.Equal(u.Variant, u2.Variant)
+	}
+	is.True(counts["control"] > 700 && counts["control"] < 800)
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(u.Variant, "control")
+
+	r.Experiment.Default = "nope"
+	is.True(errors.Is(e.Compile(r), indigo.ErrRuleNotFound))
+}
+
+// Test that only the indexed child rules matching the data are evaluated
+func TestIndexBy(t *testing.T) {
+	is := is.New(t)
+
+	r := &indigo.Rule{
+		ID:      "root",
+		Expr:    `true`,
+		IndexBy: "country",
+		Rules: map[string]*indigo.Rule{
+			"se1": {ID: "se1", Expr: `true`, IndexKey: "SE"},
+			"se2": {ID: "se2", Expr: `false`, IndexKey: "SE"},
+			"no1": {ID: "no1", Expr: `true`, IndexKey: "NO"},
+			"all": {ID: "all", Expr: `true`},
+		},
+	}
+
+	e := indigo.NewEngine(newMockEvaluator())
+	is.NoErr(e.Compile(r))
+
+	ids := func(u *indigo.Result) []string {
+		x := []string{}
+		for _, c := range u.OrderedResults {
+			x = append(x, c.Rule.ID)
+		}
+		return x
+	}
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{"country": "SE"})
+	is.NoErr(err)
+	is.Equal(ids(u), []string{"all", "se1", "se2"})
+	is.Equal(u.EvaluationCount, 4)
+
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{"country": "DK"})
+	is.NoErr(err)
+	is.Equal(ids(u), []string{"all"})
+
+	// Rules added after compilation make the index stale; all rules are evaluated
+	r.Rules["dk1"] = &indigo.Rule{ID: "dk1", Expr: `true`, IndexKey: "DK"}
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{"country": "DK"})
+	is.NoErr(err)
+	is.Equal(ids(u), []string{"all", "dk1", "no1", "se1", "se2"})
+
+	// A vault keeps the index up to date
+	delete(r.Rules, "dk1")
+	v, err := indigo.NewVault(e, r)
+	is.NoErr(err)
+	is.NoErr(v.Add("root", &indigo.Rule{ID: "dk1", Expr: `true`, IndexKey: "DK"}))
+	u, err = v.Eval(context.Background(), "root", map[string]interface{}{"country": "DK"})
+	is.NoErr(err)
+	is.Equal(ids(u), []string{"all", "dk1"})
+
+	// A dotted IndexBy selects a key of a map in the data
+	r.IndexBy = "customer.country"
+	is.NoErr(e.Compile(r))
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{"customer": map[string]interface{}{"country": "SE"}})
+	is.NoErr(err)
+	is.Equal(ids(u), []string{"all", "se1", "se2"})
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{"customer": map[string]string{"country": "NO"}})
+	is.NoErr(err)
+	is.Equal(ids(u), []string{"all", "no1"})
+}
+
+// Test that the verdict is the highest severity of the passing rules
+func TestVerdict(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	r.Rules["B"].Metadata.Severity = indigo.SeverityDeny             // fails
+	r.Rules["B"].Rules["b1"].Metadata.Severity = indigo.SeverityInfo // passes
+	r.Rules["D"].Rules["d3"].Metadata.Severity = indigo.SeverityWarn // passes
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{}, indigo.DiscardPass(true))
+	is.NoErr(err)
+	is.Equal(u.Verdict, indigo.SeverityWarn)
+	is.Equal(u.Results["B"].Verdict, indigo.SeverityInfo)
+	is.Equal(u.Results["E"].Verdict, indigo.Severity(""))
+
+	r.Rules["E"].Rules["e1"].Metadata.Severity = indigo.SeverityDeny
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(u.Verdict, indigo.SeverityDeny)
+
+	r.Rules["E"].Rules["e1"].Metadata.Severity = "critical"
+	err = e.Compile(r)
+	is.True(errors.Is(err, indigo.ErrInvalidSeverity))
+}
+
 // Test that the engine checks for nil data and rule
 func TestNilDataOrRule(t *testing.T) {
 	is := is.New(t)
@@ -674,3 +1222,545 @@ func TestTimeout(t *testing.T) {
 	_, err := e.Eval(ctx, r, map[string]interface{}{})
 	is.True(errors.Is(err, context.DeadlineExceeded))
 }
+
+// Test that child results are returned in a stable order, matching the
+// order of evaluation
+func TestOrderedResults(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	is.NoErr(e.Compile(r))
+
+	for i := 0; i < 10; i++ {
+		u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+		is.NoErr(err)
+		is.Equal(orderedIDs(u), []string{"B", "D", "E"}) // default order is alphabetical
+		is.Equal(orderedIDs(u.Results["B"]), []string{"b1", "b2", "b3", "b4"})
+		for _, c := range u.OrderedResults {
+			is.Equal(u.Results[c.Rule.ID], c) // ordered results and the map agree
+		}
+	}
+
+	reverse := func(rules []*indigo.Rule, i, j int) bool {
+		return rules[i].ID > rules[j].ID
+	}
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{}, indigo.SortFunc(reverse), indigo.DiscardPass(true))
+	is.NoErr(err)
+	is.Equal(orderedIDs(u), []string{"E", "B"})
+}
+
+// orderedIDs returns the rule IDs of the ordered child results
+func orderedIDs(u *indigo.Result) []string {
+	ids := []string{}
+	for _, c := range u.OrderedResults {
+		ids = append(ids, c.Rule.ID)
+	}
+	return ids
+}
+
+// Test that per-rule timing and evaluation counts are recorded
+func TestTiming(t *testing.T) {
+	is := is.New(t)
+
+	m := newMockEvaluator()
+	m.evalDelay = time.Millisecond
+	e := indigo.NewEngine(m)
+	r := makeRule()
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(u.Duration, time.Duration(0)) // timing not requested
+	is.Equal(u.EvaluationCount, 16)
+
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.ReturnTiming(true), indigo.DiscardFail(true))
+	is.NoErr(err)
+	is.Equal(u.EvaluationCount, 16) // discarded rules are still counted
+	is.Equal(u.Results["D"].EvaluationCount, 4)
+	is.True(u.Results["D"].Duration >= 4*time.Millisecond)
+	is.True(u.Duration >= u.Results["D"].Duration)
+}
+
+// Test that errors in child rules are recorded when ContinueOnError is set,
+// and abort the evaluation when it is not
+func TestContinueOnError(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	r.Rules["B"].Rules["b2"].Expr = "error"
+	is.NoErr(e.Compile(r))
+
+	_, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.True(err != nil) // default: the error aborts the evaluation
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{}, indigo.ContinueOnError(true))
+	is.NoErr(err)
+	b2 := u.Results["B"].Results["b2"]
+	is.True(b2.Error != nil)
+	is.True(!b2.Pass)
+	is.True(u.Results["B"].Results["b3"] != nil) // siblings are still evaluated
+	is.True(u.Results["E"] != nil)
+	is.Equal(u.Error, nil)
+
+	r.Rules["B"].EvalOptions.DiscardFail = true
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.ContinueOnError(true))
+	is.NoErr(err)
+	is.True(u.Results["B"].Results["b2"].Error != nil) // errors are returned even though failures are discarded
+	is.True(u.Results["B"].Results["b4"] == nil)
+}
+
+// Test that exceeding the maximum depth is reported
+func TestMaxDepth(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	is.NoErr(e.Compile(r))
+
+	// The tree is 4 levels deep (rule1, B, b4, b4-1)
+	_, err := e.Eval(context.Background(), r, map[string]interface{}{}, indigo.MaxDepth(3))
+	is.NoErr(err)
+
+	_, err = e.Eval(context.Background(), r, map[string]interface{}{}, indigo.MaxDepth(2))
+	is.True(errors.Is(err, indigo.ErrMaxDepthExceeded))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{}, indigo.MaxDepth(2), indigo.TruncateAtMaxDepth(true))
+	is.NoErr(err)
+	b4 := u.Results["B"].Results["b4"]
+	is.True(b4.Truncated)
+	is.Equal(len(b4.Results), 0)
+	is.True(!u.Results["B"].Truncated)
+	is.True(!u.Results["D"].Results["d1"].Truncated) // no children, so nothing was cut off
+}
+
+// Test that a rule with a quorum passes only if enough of its children pass
+func TestQuorum(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := &indigo.Rule{
+		ID:   "q",
+		Expr: "true",
+		Rules: map[string]*indigo.Rule{
+			"a": {ID: "a", Expr: "true"},
+			"b": {ID: "b", Expr: "true"},
+			"c": {ID: "c", Expr: "false"},
+		},
+	}
+
+	cases := []struct {
+		quorum *indigo.Quorum
+		pass   bool
+	}{
+		{indigo.AtLeast(2), true},
+		{indigo.AtLeast(3), false},
+		{indigo.AtMost(2), true},
+		{indigo.AtMost(1), false},
+	}
+
+	for _, c := range cases {
+		r.Quorum = c.quorum
+		is.NoErr(e.Compile(r))
+		u, err := e.Eval(context.Background(), r, map[string]interface{}{}, indigo.DiscardFail(true))
+		is.NoErr(err)
+		is.Equal(u.Pass, c.pass) // quorum
+		is.Equal(u.ChildrenPassed, 2)
+		is.Equal(u.ChildrenFailed, 1) // discarded results are counted
+	}
+
+	r.Quorum = &indigo.Quorum{Kind: "most", N: 1}
+	is.True(e.Compile(r) != nil)
+}
+
+// Test that the readiness of the engine to evaluate a rule is checked
+func TestHealthCheck(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	is.True(errors.Is(e.Ready(r), indigo.ErrNotCompiled))
+
+	is.NoErr(e.Compile(r))
+	is.NoErr(e.Ready(r))
+	is.NoErr(e.HealthCheck(context.Background(), r, nil))
+	is.NoErr(e.HealthCheck(context.Background(), r, map[string]interface{}{}))
+
+	r.Rules["D"].Rules["d4"] = &indigo.Rule{ID: "d4", Expr: "true"}
+	err := e.Ready(r)
+	is.True(errors.Is(err, indigo.ErrNotCompiled)) // added after compilation
+
+	is.NoErr(e.Compile(r))
+	r.Rules["B"].Expr = "error"
+	is.True(e.HealthCheck(context.Background(), r, map[string]interface{}{}) != nil) // the smoke evaluation fails
+}
+
+// Test that closing the engine waits for the evaluations in progress
+func TestClose(t *testing.T) {
+	is := is.New(t)
+
+	m := newMockEvaluator()
+	m.evalDelay = 20 * time.Millisecond
+	e := indigo.NewEngine(m)
+	r := &indigo.Rule{ID: "r", Expr: "true"}
+	is.NoErr(e.Compile(r))
+
+	var released []string
+	e.OnClose(func() error { released = append(released, "store"); return nil })
+	e.OnClose(func() error { released = append(released, "cache"); return nil })
+
+	done := make(chan error)
+	go func() {
+		_, err := e.Eval(context.Background(), r, map[string]interface{}{})
+		done <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	is.True(errors.Is(e.Close(ctx), context.DeadlineExceeded)) // still evaluating
+	is.Equal(len(released), 0)
+
+	_, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.True(errors.Is(err, indigo.ErrEngineClosed)) // no new evaluations
+
+	is.NoErr(e.Close(context.Background()))
+	is.NoErr(<-done) // the evaluation in progress finished
+	is.Equal(released, []string{"cache", "store"})
+
+	is.NoErr(e.Close(context.Background()))
+	is.Equal(len(released), 2) // released once
+}
+
+// Test that evaluations are limited per key
+func TestRateLimit(t *testing.T) {
+	is := is.New(t)
+
+	r := &indigo.Rule{ID: "r", Expr: "true"}
+	tenant := func(ctx context.Context, _ *indigo.Rule, _ map[string]interface{}) string {
+		s, _ := indigo.ContextValues(ctx)["tenant"].(string)
+		return s
+	}
+	ctxA := indigo.WithContextValues(context.Background(), map[string]interface{}{"tenant": "a"})
+	ctxB := indigo.WithContextValues(context.Background(), map[string]interface{}{"tenant": "b"})
+
+	// Rate
+	e := indigo.NewEngine(newMockEvaluator())
+	is.NoErr(e.Compile(r))
+	e.Use(indigo.RateLimit(tenant, indigo.AdmissionPolicy{Rate: 1, Burst: 2}))
+
+	for i := 0; i < 2; i++ {
+		_, err := e.Eval(ctxA, r, map[string]interface{}{})
+		is.NoErr(err)
+	}
+	_, err := e.Eval(ctxA, r, map[string]interface{}{})
+	is.True(errors.Is(err, indigo.ErrRateLimited)) // over the burst
+	_, err = e.Eval(ctxB, r, map[string]interface{}{})
+	is.NoErr(err) // other tenants are not affected
+
+	// Concurrency
+	m := newMockEvaluator()
+	m.evalDelay = 20 * time.Millisecond
+	e = indigo.NewEngine(m)
+	is.NoErr(e.Compile(r))
+	e.Use(indigo.RateLimit(nil, indigo.AdmissionPolicy{MaxConcurrent: 1}))
+
+	done := make(chan error)
+	go func() {
+		_, err := e.Eval(ctxA, r, map[string]interface{}{})
+		done <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	_, err = e.Eval(ctxB, r, map[string]interface{}{})
+	is.True(errors.Is(err, indigo.ErrRateLimited)) // the limit is global
+	is.NoErr(<-done)
+
+	// Waiting
+	e = indigo.NewEngine(m)
+	is.NoErr(e.Compile(r))
+	e.Use(indigo.RateLimit(nil, indigo.AdmissionPolicy{MaxConcurrent: 1, Wait: true}))
+	go func() {
+		_, err := e.Eval(ctxA, r, map[string]interface{}{})
+		done <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	_, err = e.Eval(ctxB, r, map[string]interface{}{})
+	is.NoErr(err) // waited for the first evaluation
+	is.NoErr(<-done)
+}
+
+func TestSampleDiagnostics(t *testing.T) {
+	is := is.New(t)
+
+	r := &indigo.Rule{ID: "r", Expr: "true"}
+	debug := func(ctx context.Context, _ *indigo.Rule, _ map[string]interface{}) bool {
+		b, _ := indigo.ContextValues(ctx)["debug"].(bool)
+		return b
+	}
+	ctxDebug := indigo.WithContextValues(context.Background(), map[string]interface{}{"debug": true})
+
+	cases := []struct {
+		rate   float64
+		ctx    context.Context
+		timing bool
+	}{
+		{0, context.Background(), false},
+		{0, ctxDebug, true}, // forced
+		{1, context.Background(), true},
+	}
+
+	for _, c := range cases {
+		e := indigo.NewEngine(newMockEvaluator())
+		is.NoErr(e.Compile(r))
+		e.Use(indigo.SampleDiagnostics(indigo.SamplePolicy{Rate: c.rate, Force: debug}, indigo.ReturnTiming(true)))
+
+		u, err := e.Eval(c.ctx, r, map[string]interface{}{})
+		is.NoErr(err)
+		is.Equal(u.EvalOptions.ReturnTiming, c.timing)
+	}
+}
+
+// testLogger records the messages logged, with their levels
+type testLogger struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (l *testLogger) log(level, msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs = append(l.logs, level+" "+msg)
+}
+
+func (l *testLogger) Debug(msg string, _ ...interface{}) { l.log("DEBUG", msg) }
+func (l *testLogger) Info(msg string, _ ...interface{})  { l.log("INFO", msg) }
+func (l *testLogger) Warn(msg string, _ ...interface{})  { l.log("WARN", msg) }
+func (l *testLogger) Error(msg string, _ ...interface{}) { l.log("ERROR", msg) }
+
+func TestLogger(t *testing.T) {
+	is := is.New(t)
+
+	m := newMockEvaluator()
+	m.evalDelay = 5 * time.Millisecond
+	e := indigo.NewEngine(m)
+	l := &testLogger{}
+	e.SetLogger(l, time.Millisecond)
+
+	r := &indigo.Rule{ID: "root", Expr: "true", Rules: map[string]*indigo.Rule{}}
+	is.NoErr(e.Compile(r))
+	is.True(e.Compile(&indigo.Rule{ID: "bad", Expr: "invalid"}) != nil)
+
+	_, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	_, err = e.Eval(context.Background(), &indigo.Rule{ID: "err", Expr: "error"}, map[string]interface{}{})
+	is.True(err != nil)
+
+	v, err := indigo.NewVault(e, r)
+	is.NoErr(err)
+	is.NoErr(v.Add("root", &indigo.Rule{ID: "child", Expr: "true"}))
+
+	is.Equal(l.logs, []string{
+		"DEBUG compiled rule",
+		"ERROR compiling rule",
+		"WARN slow evaluation",
+		"ERROR evaluating rule",
+		"DEBUG compiled rule", // NewVault
+		"DEBUG compiled rule", // Add
+		"INFO rules changed",
+	})
+
+	e.SetLogger(nil, 0)
+	is.NoErr(e.Compile(r))
+	is.Equal(len(l.logs), 7) // no longer logging
+}
+
+func TestApprovalPolicy(t *testing.T) {
+	is := is.New(t)
+
+	approved := indigo.RuleMetadata{Owner: "ann", Approval: indigo.ApprovalApproved, ApprovedBy: []string{"bob"}}
+	selfApproved := indigo.RuleMetadata{Owner: "ann", Approval: indigo.ApprovalApproved, ApprovedBy: []string{"ann"}}
+	pending := indigo.RuleMetadata{Owner: "ann", Approval: indigo.ApprovalPending}
+
+	r := &indigo.Rule{
+		ID:       "root",
+		Expr:     "true",
+		Metadata: approved,
+		Rules: map[string]*indigo.Rule{
+			"approved":      {ID: "approved", Expr: "true", Metadata: approved},
+			"self_approved": {ID: "self_approved", Expr: "true", Metadata: selfApproved},
+			"pending":       {ID: "pending", Expr: "true", Metadata: pending},
+		},
+	}
+
+	e := indigo.NewEngine(newMockEvaluator())
+	e.SetApprovalPolicy(&indigo.ApprovalPolicy{MinApprovers: 1})
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(len(u.Results), 1) // only the rule approved by someone other than its owner
+	is.True(u.Results["approved"] != nil)
+
+	_, err = e.Eval(context.Background(), r.Rules["pending"], map[string]interface{}{})
+	is.True(errors.Is(err, indigo.ErrNotApproved))
+
+	// An external approval system
+	var checked []string
+	e.SetApprovalPolicy(&indigo.ApprovalPolicy{Check: func(r *indigo.Rule) (indigo.ApprovalStatus, error) {
+		checked = append(checked, r.ID)
+		if r.ID == "pending" {
+			return "", errors.New("unavailable")
+		}
+		return indigo.ApprovalApproved, nil
+	}})
+	err = e.Compile(r)
+	is.True(err != nil) // the approval of the pending rule cannot be checked
+	is.True(len(checked) > 0)
+
+	// Without a policy, all rules are evaluated
+	e.SetApprovalPolicy(nil)
+	is.NoErr(e.Compile(r))
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(len(u.Results), 3)
+}
+
+func TestRouter(t *testing.T) {
+	is := is.New(t)
+
+	tenantA := indigo.NewEngine(newMockEvaluator())
+	pii := indigo.NewEngine(newMockEvaluator())
+	fallback := indigo.NewEngine(newMockEvaluator())
+
+	rt, err := indigo.NewRouter(fallback,
+		indigo.Route{Prefix: "a/", Engine: tenantA},
+		indigo.Route{Tag: "pii", Engine: pii},
+	)
+	is.NoErr(err)
+
+	cases := []struct {
+		r    *indigo.Rule
+		want indigo.Engine
+	}{
+		{&indigo.Rule{ID: "a/limit", Expr: "true", Metadata: indigo.RuleMetadata{Tags: []string{"pii"}}}, tenantA}, // first route
+		{&indigo.Rule{ID: "b/limit", Expr: "true", Metadata: indigo.RuleMetadata{Tags: []string{"pii"}}}, pii},
+		{&indigo.Rule{ID: "b/other", Expr: "true"}, fallback},
+	}
+
+	for _, c := range cases {
+		e, err := rt.Route(c.r)
+		is.NoErr(err)
+		is.True(e == c.want)
+
+		is.NoErr(rt.Compile(c.r))
+		u, err := rt.Eval(context.Background(), c.r, map[string]interface{}{})
+		is.NoErr(err)
+		is.True(u.Pass)
+	}
+
+	rt, err = indigo.NewRouter(nil, indigo.Route{Prefix: "a/", Engine: tenantA})
+	is.NoErr(err)
+	is.True(errors.Is(rt.Compile(&indigo.Rule{ID: "b/limit"}), indigo.ErrNoRoute))
+
+	_, err = indigo.NewRouter(nil, indigo.Route{Engine: tenantA})
+	is.True(err != nil) // a route must have a prefix or a tag
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	is := is.New(t)
+
+	r := &indigo.Rule{
+		ID:   "root",
+		Expr: "true",
+		Rules: map[string]*indigo.Rule{
+			"local":  {ID: "local", Expr: "true"},
+			"lookup": {ID: "lookup", Expr: "error"},
+		},
+	}
+
+	var changes []string
+	e := indigo.NewEngine(newMockEvaluator())
+	e.SetCircuitBreaker(&indigo.BreakerPolicy{
+		Failures: 2,
+		Cooldown: 20 * time.Millisecond,
+		OnChange: func(id string, open bool) { changes = append(changes, fmt.Sprintf("%s %v", id, open)) },
+	})
+	is.NoErr(e.Compile(r))
+
+	// The failures open the breaker
+	for i := 0; i < 2; i++ {
+		_, err := e.Eval(context.Background(), r, map[string]interface{}{})
+		is.True(err != nil)
+	}
+	is.Equal(changes, []string{"lookup true"})
+
+	// The rule is skipped instead of failing the evaluation
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{}, indigo.DiscardFail(true))
+	is.NoErr(err)
+	is.True(u.Results["lookup"].Skipped)
+	is.Equal(u.Results["lookup"].Status, indigo.StatusSkipped)
+	is.Equal(u.ChildrenFailed, 0)
+	is.True(u.Results["local"].Pass)
+
+	// After the cool-down, a successful evaluation closes the breaker
+	time.Sleep(30 * time.Millisecond)
+	r.Rules["lookup"].Expr = "true"
+	u, err = e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.True(u.Results["lookup"].Pass)
+	is.Equal(changes, []string{"lookup true", "lookup false"})
+
+	// Without a policy, failures fail the evaluation
+	r.Rules["lookup"].Expr = "error"
+	e.SetCircuitBreaker(nil)
+	for i := 0; i < 3; i++ {
+		_, err = e.Eval(context.Background(), r, map[string]interface{}{})
+		is.True(err != nil)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	is := is.New(t)
+
+	r := &indigo.Rule{
+		ID:   "root",
+		Expr: "true",
+		Rules: map[string]*indigo.Rule{
+			"optional": {ID: "optional", Expr: "error", Fallback: indigo.PassOnError(), Metadata: indigo.RuleMetadata{Severity: indigo.SeverityWarn}},
+			"score":    {ID: "score", Expr: "error", ResultType: indigo.Int{}, Fallback: indigo.ValueOnError(0)},
+			"group": {ID: "group", Expr: "true", Fallback: indigo.FailOnError(), Rules: map[string]*indigo.Rule{
+				"inner": {ID: "inner", Expr: "error"},
+			}},
+		},
+	}
+
+	e := indigo.NewEngine(newMockEvaluator())
+	is.NoErr(e.Compile(r))
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+	is.True(u.Results["optional"].Pass)
+	is.True(u.Results["optional"].Fallback)
+	is.True(u.Results["optional"].Error != nil)
+	is.Equal(u.Verdict, indigo.SeverityWarn)
+	is.Equal(u.Results["score"].Value, 0)
+
+	// The error of a descendant uses the fallback of the group
+	is.True(!u.Results["group"].Pass)
+	is.Equal(u.Results["group"].Status, indigo.StatusFail)
+	var ee *indigo.EvalError
+	is.True(errors.As(u.Results["group"].Error, &ee))
+	is.Equal(ee.RuleID, "inner")
+
+	// Critical rules still abort
+	r.Rules["critical"] = &indigo.Rule{ID: "critical", Expr: "error"}
+	is.NoErr(e.Compile(r))
+	_, err = e.Eval(context.Background(), r, map[string]interface{}{})
+	is.True(err != nil)
+
+	// The fallback of a boolean rule must be a boolean
+	r.Rules["critical"].Fallback = indigo.ValueOnError("yes")
+	is.True(e.Compile(r) != nil)
+}
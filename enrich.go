@@ -0,0 +1,63 @@
+package indigo
+
+import (
+	"context"
+	"fmt"
+)
+
+// Enricher adds derived values to the input data before a rule is evaluated,
+// such as amount_usd computed from amount and currency. d is a copy of the input
+// data that the enricher may modify; the values in it must not be modified in place.
+// The rule's schema describes the data, and should declare the values added.
+// Enrichers must be safe for concurrent use.
+type Enricher func(ctx context.Context, r *Rule, d map[string]interface{}) error
+
+// RegisterEnricher makes the enricher available to rules that refer to it by name
+// (see Rule.Enrich), replacing an enricher registered with the same name.
+func (e *DefaultEngine) RegisterEnricher(name string, f Enricher) error {
+	if name == "" {
+		return fmt.Errorf("enricher has no name")
+	}
+
+	if f == nil {
+		return fmt.Errorf("enricher %s is nil", name)
+	}
+
+	e.enrichMu.Lock()
+	defer e.enrichMu.Unlock()
+	if e.enrichers == nil {
+		e.enrichers = map[string]Enricher{}
+	}
+	e.enrichers[name] = f
+	return nil
+}
+
+// enricher returns the enricher registered with the name
+func (e *DefaultEngine) enricher(name string) (Enricher, error) {
+	e.enrichMu.RLock()
+	defer e.enrichMu.RUnlock()
+	f, ok := e.enrichers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEnricherNotFound, name)
+	}
+	return f, nil
+}
+
+// enrich returns a copy of the data with the values added by the rule's enrichers
+func (e *DefaultEngine) enrich(ctx context.Context, r *Rule, d map[string]interface{}) (map[string]interface{}, error) {
+	cp := make(map[string]interface{}, len(d)+len(r.Enrich))
+	for k, v := range d {
+		cp[k] = v
+	}
+
+	for _, name := range r.Enrich {
+		f, err := e.enricher(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := f(ctx, r, cp); err != nil {
+			return nil, fmt.Errorf("enricher %s: %w", name, err)
+		}
+	}
+	return cp, nil
+}
@@ -0,0 +1,138 @@
+package indigo
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by Indigo. Use errors.Is to check for them, as they
+// may be wrapped with additional context.
+var (
+	// ErrNilRule is returned when a nil rule is compiled or evaluated,
+	// including a nil child rule.
+	ErrNilRule = errors.New("rule is nil")
+
+	// ErrNilEngine is returned when a method is called on a nil engine.
+	ErrNilEngine = errors.New("engine is nil")
+
+	// ErrNilEvaluator is returned when the engine has no expression evaluator.
+	ErrNilEvaluator = errors.New("evaluator is nil")
+
+	// ErrNilData is returned when the input data to an evaluation is nil.
+	ErrNilData = errors.New("data is nil")
+
+	// ErrMaxDepthExceeded is returned when the rule tree is deeper than
+	// the MaxDepth evaluation option allows.
+	ErrMaxDepthExceeded = errors.New("maximum rule depth exceeded")
+
+	// ErrRuleCycle is returned when a rule is its own descendant,
+	// which would cause compilation and evaluation to recurse forever.
+	ErrRuleCycle = errors.New("rule cycle")
+
+	// ErrInvalidSignature is returned when the signature of a rule bundle
+	// does not match its contents.
+	ErrInvalidSignature = errors.New("invalid bundle signature")
+
+	// ErrDuplicateRuleID is returned when a rule ID is used more than once
+	// where IDs must be unique, such as in a Vault.
+	ErrDuplicateRuleID = errors.New("duplicate rule ID")
+
+	// ErrRuleNotFound is returned when a rule cannot be found by its ID.
+	ErrRuleNotFound = errors.New("rule not found")
+
+	// ErrSchemaNotFound is returned when a rule refers to a schema that is not
+	// registered with the engine (see Rule.SchemaID).
+	ErrSchemaNotFound = errors.New("schema not found")
+
+	// ErrEnricherNotFound is returned when a rule refers to an enricher that is not
+	// registered with the engine (see Rule.Enrich).
+	ErrEnricherNotFound = errors.New("enricher not found")
+
+	// ErrTooComplex is returned when the complexity score of a rule's expression
+	// is above the limit set with the MaxComplexity compilation option.
+	ErrTooComplex = errors.New("expression too complex")
+
+	// ErrConstantRule is returned when a rule's expression is always true or always
+	// false, and the RejectConstantRules compilation option is set.
+	ErrConstantRule = errors.New("rule is always true or always false")
+
+	// ErrNonBoolResult is returned when a boolean rule yields a value that
+	// is not a boolean, and the NonBoolError policy is in effect.
+	ErrNonBoolResult = errors.New("rule yielded a non-boolean value")
+
+	// ErrNoFixpoint is returned by Chain when the derived facts are still
+	// changing after the maximum number of iterations.
+	ErrNoFixpoint = errors.New("derived facts did not reach a fixpoint")
+
+	// ErrConflictingOptions is returned when evaluation options contradict
+	// each other, such as DiscardPass and DiscardFail (see EvalOptions.Validate).
+	ErrConflictingOptions = errors.New("conflicting evaluation options")
+
+	// ErrHistoryUnavailable is returned when rules are requested as they were at a
+	// time for which a vault does not keep history (see Vault.KeepHistory).
+	ErrHistoryUnavailable = errors.New("rule history not available")
+
+	// ErrInvalidSeverity is returned when a rule is compiled with a severity
+	// other than the Severity constants.
+	ErrInvalidSeverity = errors.New("invalid severity")
+
+	// ErrEngineClosed is returned when an evaluation is started after the
+	// engine is closed (see DefaultEngine.Close).
+	ErrEngineClosed = errors.New("engine closed")
+
+	// ErrRateLimited is returned when an evaluation is over the limits of the
+	// RateLimit middleware.
+	ErrRateLimited = errors.New("evaluation rate limited")
+
+	// ErrNoRoute is returned by a Router when a rule matches none of its routes,
+	// and there is no fallback engine.
+	ErrNoRoute = errors.New("no route for rule")
+
+	// ErrNotApproved is returned when a rule that is not approved is evaluated
+	// (see DefaultEngine.SetApprovalPolicy).
+	ErrNotApproved = errors.New("rule not approved")
+
+	// ErrNotCompiled is returned by readiness checks when a rule has not been
+	// compiled (see DefaultEngine.Ready).
+	ErrNotCompiled = errors.New("rule not compiled")
+
+	// ErrSelfType is returned when a rule's Self value does not have the type
+	// declared for SelfKey in the rule's schema.
+	ErrSelfType = errors.New("self value does not match the schema")
+)
+
+// CompileError is returned when the expression of a rule fails to compile.
+// Use errors.As to obtain the ID of the failing rule.
+type CompileError struct {
+	// ID of the rule that failed to compile
+	RuleID string
+	// The error returned by the expression compiler
+	Err error
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("rule %s: %v", e.RuleID, e.Err)
+}
+
+// Unwrap returns the error returned by the expression compiler.
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// EvalError is returned when the expression of a rule fails to evaluate.
+// Use errors.As to obtain the ID of the failing rule.
+type EvalError struct {
+	// ID of the rule that failed to evaluate
+	RuleID string
+	// The error returned by the expression evaluator
+	Err error
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("rule %s: %v", e.RuleID, e.Err)
+}
+
+// Unwrap returns the error returned by the expression evaluator.
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,62 @@
+package indigo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/matryer/is"
+)
+
+// Test that callers can branch on the category of error returned
+func TestErrors(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+
+	_, err := e.Eval(context.Background(), r, nil)
+	is.True(errors.Is(err, indigo.ErrNilData))
+
+	_, err = e.Eval(context.Background(), nil, map[string]interface{}{})
+	is.True(errors.Is(err, indigo.ErrNilRule))
+
+	err = indigo.NewEngine(nil).Compile(r)
+	is.True(errors.Is(err, indigo.ErrNilEvaluator))
+
+	r.Rules["B"].Rules["b2"].Expr = "invalid"
+	err = e.Compile(r)
+	var ce *indigo.CompileError
+	is.True(errors.As(err, &ce))
+	is.Equal(ce.RuleID, "b2")
+	is.Equal(err.Error(), "rule b2: mock compilation error")
+
+	r.Rules["B"].Rules["b2"].Expr = "error"
+	_, err = e.Eval(context.Background(), r, map[string]interface{}{})
+	var ee *indigo.EvalError
+	is.True(errors.As(err, &ee))
+	is.Equal(ee.RuleID, "b2")
+
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{}, indigo.ContinueOnError(true))
+	is.NoErr(err)
+	is.True(errors.As(u.Results["B"].Results["b2"].Error, &ee))
+}
+
+// Test that a rule that is its own descendant is rejected at compile time
+func TestRuleCycle(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	b4 := r.Rules["B"].Rules["b4"]
+	b4.Rules["loop"] = r.Rules["B"]
+
+	err := e.Compile(r)
+	is.True(errors.Is(err, indigo.ErrRuleCycle))
+	is.Equal(err.Error(), "rule cycle: B -> b4 -> B")
+	is.Equal(b4.Program, nil) // nothing was compiled
+
+	delete(b4.Rules, "loop")
+	is.NoErr(e.Compile(r))
+}
@@ -30,3 +30,103 @@ type ExpressionCompilerEvaluator interface {
 	ExpressionCompiler
 	ExpressionEvaluator
 }
+
+// ExpressionAnalyzer is the interface that wraps the Analyze method.
+// Analyze compiles the expression, without storing the result, and returns
+// information about the compiled expression.
+// Implementing this interface is optional; the Indigo engine uses it, if it's available,
+// to report on the resources used by rules.
+type ExpressionAnalyzer interface {
+	Analyze(expr string, s Schema, resultType Type) (ExpressionInfo, error)
+}
+
+// ExpressionInfo describes a compiled expression.
+// The exact meaning of the values depends on the ExpressionAnalyzer used.
+type ExpressionInfo struct {
+	// Estimated minimum and maximum cost of evaluating the expression
+	MinCost int64
+	MaxCost int64
+
+	// Names of the schema elements, and ContextValuesKey if the context values are
+	// referenced in the expression, sorted alphabetically
+	ReferencedVariables []string
+
+	// Approximate size in bytes of the compiled expression
+	Size int
+
+	// How hard the expression is to read and evaluate
+	Complexity Complexity
+
+	// Whether a boolean expression yields the same value regardless of the input data,
+	// such as `amount > 10 && amount < 5`; this is usually an authoring mistake.
+	// Expressions that are a single literal, such as `true`, are not reported.
+	AlwaysTrue  bool
+	AlwaysFalse bool
+}
+
+// Complexity measures how hard an expression is to read and evaluate.
+type Complexity struct {
+	// Number of nodes in the expression tree, such as constants, variables and calls
+	Nodes int
+
+	// Number of function and operator calls
+	Calls int
+
+	// Maximum nesting of comprehensions, such as the all and exists macros in CEL
+	ComprehensionDepth int
+}
+
+// Score combines the measures of complexity into one number: the number of nodes,
+// plus 2 per call, plus 10 per level of comprehension nesting.
+func (c Complexity) Score() int {
+	return c.Nodes + 2*c.Calls + 10*c.ComprehensionDepth
+}
+
+// ExpressionExplainer is the interface that wraps the Explain method.
+// Explain returns a plain-English explanation of the expression, for display
+// to business users. Implementing this interface is optional.
+type ExpressionExplainer interface {
+	Explain(expr string, s Schema, resultType Type) (string, error)
+}
+
+// ExpressionIndexer is the interface that wraps the Discriminant method.
+// Discriminant returns the value that the variable must have for the expression
+// to be true, if the expression requires the variable to equal a constant,
+// such as `country == "SE" && amount > 100`. The value is formatted with fmt.Sprint.
+// Implementing this interface is optional; the Indigo engine uses it, if it's available,
+// to index child rules (see Rule.IndexBy).
+type ExpressionIndexer interface {
+	Discriminant(expr string, s Schema, variable string) (value string, ok bool, err error)
+}
+
+// ExpressionCombiner is the interface that wraps the CompileCombined and
+// EvaluateCombined methods.
+// CompileCombined compiles a list of boolean expressions sharing a schema
+// into a single program. EvaluateCombined evaluates the program, returning the
+// value of each expression, in the order they were compiled.
+// Implementing this interface is optional; the Indigo engine uses it, if it's available,
+// to reduce the overhead of evaluating many small rules (see CombineSiblings).
+type ExpressionCombiner interface {
+	CompileCombined(exprs []string, s Schema) (interface{}, error)
+	EvaluateCombined(d map[string]interface{}, program interface{}) ([]interface{}, error)
+}
+
+// ExpressionOverlapper is the interface that wraps the Overlap method.
+// Overlap reports whether the boolean expressions a and b can both be true for
+// the same input data, and describes the input for which they are both true,
+// such as `amount > 10 && amount <= 20`, if it can be determined. If the evaluator
+// cannot prove that the expressions are never both true, they overlap.
+// Implementing this interface is optional; the Indigo engine uses it, if it's available,
+// to detect conflicting sibling rules (see DefaultEngine.Overlaps).
+type ExpressionOverlapper interface {
+	Overlap(a, b string, s Schema) (overlap bool, region string, err error)
+}
+
+// ExpressionSampler is the interface that wraps the SampleValues method.
+// SampleValues returns values of the schema elements that are likely to make the
+// expression pass or fail, such as the boundary values of comparisons, by element name.
+// Implementing this interface is optional; the Indigo engine uses it, if it's available,
+// to generate test data for rules (see DefaultEngine.GenerateExamples).
+type ExpressionSampler interface {
+	SampleValues(expr string, s Schema) (map[string][]interface{}, error)
+}
@@ -3,6 +3,7 @@ package indigo_test
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/ezachrisen/indigo"
 	"github.com/ezachrisen/indigo/cel"
@@ -110,3 +111,18 @@ func ExampleSortFunc() {
 	fmt.Println("Ok")
 	//Output: Ok
 }
+
+// Demonstrate walking a rule tree in a stable order
+func ExampleWalk() {
+
+	r := makeRule()
+
+	indigo.Walk(r, func(r *indigo.Rule, depth int) bool {
+		fmt.Printf("%s%s\n", strings.Repeat("  ", depth), r.ID)
+		return r.ID != "B" // do not continue past B
+	})
+
+	// Output:
+	// rule1
+	//   B
+}
@@ -0,0 +1,137 @@
+package indigo
+
+import (
+	"fmt"
+	"time"
+)
+
+// Examples are input data generated to test a rule (see GenerateExamples).
+type Examples struct {
+	// Input data for which the rule's expression is true
+	Pass []map[string]interface{}
+
+	// Input data for which the rule's expression is false
+	Fail []map[string]interface{}
+}
+
+// maxExampleCandidates is the number of combinations of values GenerateExamples
+// evaluates at most
+const maxExampleCandidates = 10000
+
+// GenerateExamples synthesizes up to n examples of input data for which the rule's
+// expression is true, and up to n for which it is false, to bootstrap a test suite
+// for the rule. Child rules are not considered.
+//
+// The examples combine values of the schema elements proposed by the evaluator,
+// such as the boundary values of comparisons, and the members and a non-member of
+// lists in `in` tests. Elements for which the evaluator proposes no values are
+// given the zero value of their type (both true and false for booleans); lists, maps
+// and protocol buffers are left out. Each combination is evaluated to determine
+// whether it passes. The evaluator must implement ExpressionSampler.
+func (e *DefaultEngine) GenerateExamples(r *Rule, n int) (*Examples, error) {
+	if err := validateCompileArguments(r, e); err != nil {
+		return nil, err
+	}
+
+	if r.Expr == "" {
+		return nil, fmt.Errorf("rule %s has no expression", r.ID)
+	}
+
+	sampler, ok := e.e.(ExpressionSampler)
+	if !ok {
+		return nil, fmt.Errorf("evaluator %T cannot sample values", e.e)
+	}
+
+	schema, err := e.exprSchemaOf(r)
+	if err != nil {
+		return nil, &CompileError{RuleID: r.ID, Err: err}
+	}
+
+	prg, err := e.e.Compile(r.Expr, schema, Bool{}, false, false)
+	if err != nil {
+		return nil, &CompileError{RuleID: r.ID, Err: err}
+	}
+
+	samples, err := sampler.SampleValues(r.Expr, schema)
+	if err != nil {
+		return nil, &CompileError{RuleID: r.ID, Err: err}
+	}
+
+	var names []string
+	var values [][]interface{}
+	for _, el := range schema.Elements {
+		v := exampleValues(samples[el.Name], el.Type)
+		if len(v) > 0 {
+			names = append(names, el.Name)
+			values = append(values, v)
+		}
+	}
+
+	ex := &Examples{}
+	idx := make([]int, len(names))
+	for i := 0; i < maxExampleCandidates && (len(ex.Pass) < n || len(ex.Fail) < n); i++ {
+		d := make(map[string]interface{}, len(names))
+		for j, name := range names {
+			d[name] = values[j][idx[j]]
+		}
+
+		v, _, err := e.e.Evaluate(d, r.Expr, schema, r.Self, prg, Bool{}, false)
+		if pass, ok := v.(bool); err == nil && ok {
+			if pass && len(ex.Pass) < n {
+				ex.Pass = append(ex.Pass, d)
+			} else if !pass && len(ex.Fail) < n {
+				ex.Fail = append(ex.Fail, d)
+			}
+		}
+
+		if !nextCombination(idx, values) {
+			break
+		}
+	}
+	return ex, nil
+}
+
+// nextCombination advances idx to the next combination of values, and returns
+// false if all combinations have been visited
+func nextCombination(idx []int, values [][]interface{}) bool {
+	for j := len(idx) - 1; j >= 0; j-- {
+		idx[j]++
+		if idx[j] < len(values[j]) {
+			return true
+		}
+		idx[j] = 0
+	}
+	return false
+}
+
+// exampleValues returns the distinct sample values, or the zero values of the type t
+// if there are none
+func exampleValues(samples []interface{}, t Type) []interface{} {
+	var values []interface{}
+	seen := map[interface{}]bool{}
+	for _, s := range samples {
+		if !seen[s] {
+			seen[s] = true
+			values = append(values, s)
+		}
+	}
+	if len(values) > 0 {
+		return values
+	}
+
+	switch t.(type) {
+	case Int:
+		return []interface{}{int64(0)}
+	case Float:
+		return []interface{}{0.0}
+	case String:
+		return []interface{}{""}
+	case Bool:
+		return []interface{}{true, false}
+	case Duration:
+		return []interface{}{time.Duration(0)}
+	case Timestamp:
+		return []interface{}{time.Unix(0, 0).UTC()}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package indigo
+
+// Experiment turns the child rules of a rule into the variants of an A/B experiment:
+// for each evaluation, one of the child rules is selected, and the others are not evaluated.
+// The variant is selected by hashing the value of a data element, such as a user ID,
+// so the same value always gets the same variant. The selected variant is recorded in
+// the Variant field of the rule's result.
+type Experiment struct {
+	// The name of the experiment. Changing the name reassigns the values to variants.
+	// If blank, the rule ID is used.
+	Name string `json:"name,omitempty"`
+
+	// The name of the data element whose value selects the variant, such as "user_id"
+	Key string `json:"key"`
+
+	// The relative weights of the variants, by child rule ID; for example, {"a": 9, "b": 1}
+	// sends 90% of the values to a. Child rules without a weight have the weight 1.
+	Weights map[string]float64 `json:"weights,omitempty"`
+
+	// The ID of the child rule to evaluate if the Key element is missing from the input data.
+	// If blank, no child rule is evaluated.
+	Default string `json:"default,omitempty"`
+}
+
+// variant returns the ID of the child rule of r selected by the experiment,
+// or "" if no child rule is selected
+func (x *Experiment) variant(r *Rule, d map[string]interface{}) string {
+	v, ok := d[x.Key]
+	if !ok {
+		return x.Default
+	}
+
+	children := r.sortChildKeys(EvalOptions{})
+	total := 0.0
+	for _, c := range children {
+		total += x.weight(c.ID)
+	}
+	if total <= 0 {
+		return ""
+	}
+
+	name := x.Name
+	if name == "" {
+		name = r.ID
+	}
+
+	target := rolloutBucket(name, v) / 10000 * total
+	sum := 0.0
+	for _, c := range children {
+		sum += x.weight(c.ID)
+		if target < sum {
+			return c.ID
+		}
+	}
+	return ""
+}
+
+// weight returns the weight of the variant with the id
+func (x *Experiment) weight(id string) float64 {
+	if w, ok := x.Weights[id]; ok {
+		return w
+	}
+	return 1
+}
@@ -0,0 +1,94 @@
+package indigo
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Explain returns a plain-English explanation of the expressions of the rule and its
+// descendants (see ExpressionExplainer), by rule ID, for documentation and review
+// tools. Rules without an expression are not included. The evaluator must implement
+// ExpressionExplainer.
+func (e *DefaultEngine) Explain(r *Rule) (map[string]string, error) {
+	if err := validateCompileArguments(r, e); err != nil {
+		return nil, err
+	}
+
+	x, ok := e.e.(ExpressionExplainer)
+	if !ok {
+		return nil, fmt.Errorf("evaluator %T cannot explain expressions", e.e)
+	}
+
+	m := map[string]string{}
+	err := ApplyToRule(r, func(cr *Rule) error {
+		if cr == nil {
+			return ErrNilRule
+		}
+		if cr.Expr == "" {
+			return nil
+		}
+
+		schema, err := e.exprSchemaOf(cr)
+		if err != nil {
+			return &CompileError{RuleID: cr.ID, Err: err}
+		}
+
+		s, err := x.Explain(cr.Expr, schema, defaultResultType(cr))
+		if err != nil {
+			return &CompileError{RuleID: cr.ID, Err: err}
+		}
+		m[cr.ID] = s
+		return nil
+	})
+	return m, err
+}
+
+// References returns the names of the data elements that the expression and guard
+// of the rule and its descendants refer to, sorted, by rule ID. Rules that refer to
+// no data are not included. The evaluator must implement ExpressionAnalyzer.
+func (e *DefaultEngine) References(r *Rule) (map[string][]string, error) {
+	if err := validateCompileArguments(r, e); err != nil {
+		return nil, err
+	}
+
+	a, ok := e.e.(ExpressionAnalyzer)
+	if !ok {
+		return nil, fmt.Errorf("evaluator %T cannot analyze expressions", e.e)
+	}
+
+	m := map[string][]string{}
+	err := ApplyToRule(r, func(cr *Rule) error {
+		if cr == nil {
+			return ErrNilRule
+		}
+
+		schema, err := e.exprSchemaOf(cr)
+		if err != nil {
+			return &CompileError{RuleID: cr.ID, Err: err}
+		}
+
+		seen := map[string]bool{}
+		for _, x := range []struct {
+			expr string
+			t    Type
+		}{{cr.Expr, defaultResultType(cr)}, {cr.Guard, Bool{}}} {
+			if x.expr == "" {
+				continue
+			}
+			info, err := a.Analyze(x.expr, schema, x.t)
+			if err != nil {
+				return &CompileError{RuleID: cr.ID, Err: err}
+			}
+			for _, v := range info.ReferencedVariables {
+				seen[v] = true
+			}
+		}
+
+		for v := range seen {
+			m[cr.ID] = append(m[cr.ID], v)
+		}
+		sort.Strings(m[cr.ID])
+		return nil
+	})
+	return m, err
+}
@@ -0,0 +1,80 @@
+package indigo
+
+import (
+	"fmt"
+)
+
+// Fallback is the result used for a rule that cannot be evaluated, so that a
+// non-critical rule degrades gracefully instead of failing the evaluation of the
+// whole tree, while rules without a fallback still do.
+//
+// The fallback is used when the evaluation of the rule, or of one of its
+// descendants, returns an error, other than because the context passed to Eval is
+// done. The result has no child results, its Fallback field is set, and the error
+// is kept in its Error field.
+type Fallback struct {
+	// Whether the rule passes
+	Pass bool `json:"pass"`
+
+	// The value of the rule (see Result.Value); if nil, the value is Pass.
+	// For a rule whose ResultType is not boolean, the rule passes, and Pass is ignored.
+	Value interface{} `json:"value,omitempty"`
+}
+
+// PassOnError returns a fallback that makes the rule pass when it cannot be evaluated.
+func PassOnError() *Fallback {
+	return &Fallback{Pass: true}
+}
+
+// FailOnError returns a fallback that makes the rule fail when it cannot be evaluated.
+func FailOnError() *Fallback {
+	return &Fallback{Pass: false}
+}
+
+// ValueOnError returns a fallback that makes the value of a rule whose ResultType is
+// not boolean v when it cannot be evaluated.
+func ValueOnError(v interface{}) *Fallback {
+	return &Fallback{Pass: true, Value: v}
+}
+
+// validate returns an error if the fallback value of the rule r is not a boolean
+// for a boolean rule
+func (f *Fallback) validate(r *Rule) error {
+	if _, isBool := defaultResultType(r).(Bool); !isBool || f.Value == nil {
+		return nil
+	}
+	if _, ok := f.Value.(bool); !ok {
+		return fmt.Errorf("fallback: value of a boolean rule is %T", f.Value)
+	}
+	return nil
+}
+
+// result returns the result of the rule r, which could not be evaluated because of err
+func (f *Fallback) result(r *Rule, err error) *Result {
+	u := &Result{
+		Rule:            r,
+		Metadata:        &r.Metadata,
+		Pass:            f.Pass,
+		Value:           f.Value,
+		Error:           err,
+		Fallback:        true,
+		EvalOptions:     r.EvalOptions,
+		EvaluationCount: 1,
+	}
+
+	if _, isBool := defaultResultType(r).(Bool); isBool {
+		if b, ok := f.Value.(bool); ok {
+			u.Pass = b
+		}
+		u.Value = u.Pass
+	} else {
+		u.Pass = true
+	}
+
+	if u.Pass {
+		u.Verdict = r.Metadata.Severity
+	} else {
+		u.Status = StatusFail
+	}
+	return u
+}
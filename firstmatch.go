@@ -0,0 +1,58 @@
+package indigo
+
+import (
+	"context"
+	"fmt"
+)
+
+// EvalFirstMatch evaluates the child rules of r in order, and returns the result of
+// the first child rule that passes, or nil if none passes. Use it for policy chains,
+// where the first matching policy wins: the child rules after the first match are not
+// evaluated. The order is the order of evaluation of the child rules, alphabetical by
+// ID unless set with the SortFunc option; rules that do not apply (see Rule.Guard)
+// or are switched off are passed over.
+//
+// The rule r is evaluated as with e.Eval, with the StopFirstPositiveChild option set,
+// so its own expression and options, such as StopIfParentNegative, apply.
+func EvalFirstMatch(ctx context.Context, e Evaluator, r *Rule, d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+	if e == nil {
+		return nil, ErrNilEngine
+	}
+
+	// The match must not be discarded from the parent's results
+	opts = append(append([]EvalOption{}, opts...), StopFirstPositiveChild(true), DiscardPass(false))
+	u, err := e.Eval(ctx, r, d, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cu := range u.OrderedResults {
+		if cu.Pass && cu.Error == nil {
+			return cu, nil
+		}
+	}
+	return nil, nil
+}
+
+// EvalFirstMatch evaluates the child rules of the rule with the parentID in order,
+// and returns the result of the first child rule that passes, or nil if none passes
+// (see the EvalFirstMatch function), using the current rules in the vault, or the
+// rules at the time given with the AsOf option.
+func (v *Vault) EvalFirstMatch(ctx context.Context, parentID string, d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+	s, err := v.snapshotFor(opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.EvalFirstMatch(ctx, parentID, d, opts...)
+}
+
+// EvalFirstMatch evaluates the child rules of the rule with the parentID in order,
+// and returns the result of the first child rule that passes, or nil if none passes
+// (see the EvalFirstMatch function).
+func (s *Snapshot) EvalFirstMatch(ctx context.Context, parentID string, d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+	r, _ := findRule(s.root, nil, parentID)
+	if r == nil {
+		return nil, fmt.Errorf("rule %s: %w", parentID, ErrRuleNotFound)
+	}
+	return EvalFirstMatch(ctx, s.engine, r, d, opts...)
+}
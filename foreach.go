@@ -0,0 +1,125 @@
+package indigo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// ItemKey is the name under which the list element is available to a rule
+// evaluated for each element of a list (see Rule.ForEach).
+const ItemKey = "item"
+
+// evalForEach evaluates the rule r once for each element of the list named by
+// r.ForEach, adding the result for each element to the rule's result. Expression
+// values are not reused from a cache, since they depend on the element.
+func (e *DefaultEngine) evalForEach(ctx context.Context, r *Rule, d map[string]interface{}, depth int,
+	in *EvalOptions, local, o EvalOptions, opts ...EvalOption) (*Result, error) {
+
+	items, err := listItems(d[r.ForEach])
+	if err != nil {
+		return nil, &EvalError{RuleID: r.ID, Err: fmt.Errorf("for each %s: %w", r.ForEach, err)}
+	}
+
+	start := time.Now()
+	u := newResult(len(items), o.PoolResults)
+	*u = Result{
+		Rule:           r,
+		Metadata:       &r.Metadata,
+		Pass:           true,
+		Results:        u.Results,
+		OrderedResults: u.OrderedResults,
+		RulesEvaluated: u.RulesEvaluated,
+		EvalOptions:    o,
+	}
+
+	for i, item := range items {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		result, err := e.evalRule(ctx, r, withValue(d, ItemKey, item), depth, nil, in, local, o, opts...)
+		if err != nil {
+			if !o.ContinueOnError || ctx.Err() != nil {
+				return nil, err
+			}
+			result = &Result{
+				Rule:            r,
+				Metadata:        &r.Metadata,
+				Status:          StatusError,
+				Error:           err,
+				EvalOptions:     o,
+				EvaluationCount: 1,
+			}
+		}
+		result.Item = item
+		u.EvaluationCount += result.EvaluationCount
+
+		if o.OnResult != nil {
+			o.OnResult(result)
+		}
+		u.Verdict = maxSeverity(u.Verdict, result.Verdict)
+
+		if !result.Pass && result.Status != StatusNotApplicable {
+			u.Pass = false
+			u.Status = StatusFail
+		}
+
+		// Failed evaluations are always returned, so that the error is not lost
+		if result.Error != nil ||
+			(!result.Pass && !o.DiscardFail) ||
+			(result.Pass && !o.DiscardPass) {
+			u.Results[strconv.Itoa(i)] = result
+			u.OrderedResults = append(u.OrderedResults, result)
+		}
+	}
+
+	if o.ReturnTiming {
+		u.Duration = time.Since(start)
+	}
+	return u, nil
+}
+
+// withValue returns a copy of d with the value v under the key k
+func withValue(d map[string]interface{}, k string, v interface{}) map[string]interface{} {
+	x := make(map[string]interface{}, len(d)+1)
+	for dk, dv := range d {
+		x[dk] = dv
+	}
+	x[k] = v
+	return x
+}
+
+// listItems returns the elements of the list v
+func listItems(v interface{}) ([]interface{}, error) {
+	rv := reflect.ValueOf(v)
+	if k := rv.Kind(); k != reflect.Slice && k != reflect.Array {
+		return nil, fmt.Errorf("%T is not a list", v)
+	}
+
+	items := make([]interface{}, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items, nil
+}
+
+// itemSchema returns s, with ItemKey declared if the rule has ForEach and s does
+// not declare it: as the value type of the list, if s declares the list, or as Any
+func itemSchema(r *Rule, s Schema) Schema {
+	if _, ok := s.element(ItemKey); ok || r.ForEach == "" {
+		return s
+	}
+
+	var t Type = Any{}
+	if el, ok := s.element(r.ForEach); ok {
+		if l, ok := el.Type.(List); ok {
+			t = l.ValueType
+		}
+	}
+
+	s.Elements = append(append([]DataElement{}, s.Elements...), DataElement{Name: ItemKey, Type: t})
+	return s
+}
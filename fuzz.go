@@ -0,0 +1,153 @@
+package indigo
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// FuzzData decodes b, typically generated by a fuzzer, into input data conforming
+// to the schema. Each element is given a value of its type, made from the next bytes
+// of b, including extreme values such as NaN and the largest integers; an element
+// is occasionally left out, to test rules with missing data. When b runs out, the
+// remaining values are zero. Protocol buffer elements are left out.
+func FuzzData(s Schema, b []byte) map[string]interface{} {
+	f := &fuzzBytes{b: b}
+	d := make(map[string]interface{}, len(s.Elements))
+	for _, el := range s.Elements {
+		if f.byte()%16 == 15 {
+			continue
+		}
+		if v, ok := f.value(el.Type, 0); ok {
+			d[el.Name] = v
+		}
+	}
+	return d
+}
+
+// FuzzEval evaluates the compiled rule with input data decoded from b (see FuzzData),
+// and returns an error if the evaluation panics, or does not finish within the budget.
+// Errors returned by the evaluation, such as a value of the wrong type, are not
+// reported: they are the expected outcome of evaluating random data.
+//
+// Use FuzzEval in a fuzz test to check that production rules are robust:
+//
+//     func FuzzRules(f *testing.F) {
+//         f.Fuzz(func(t *testing.T, b []byte) {
+//             if err := engine.FuzzEval(rule, b, time.Second); err != nil {
+//                 t.Fatal(err)
+//             }
+//         })
+//     }
+//
+// An evaluation that exceeds the budget keeps running in the background.
+func (e *DefaultEngine) FuzzEval(r *Rule, b []byte, budget time.Duration, opts ...EvalOption) error {
+	if r == nil {
+		return ErrNilRule
+	}
+
+	d := FuzzData(r.Schema, b)
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("evaluating rule %s with %v: panic: %v", r.ID, d, p)
+			}
+		}()
+		_, _ = e.Eval(context.Background(), r, d, opts...)
+		done <- nil
+	}()
+
+	t := time.NewTimer(budget)
+	defer t.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-t.C:
+		return fmt.Errorf("evaluating rule %s with %v: not finished within %v", r.ID, d, budget)
+	}
+}
+
+// fuzzBytes produces values from bytes generated by a fuzzer
+type fuzzBytes struct {
+	b []byte
+}
+
+// maxFuzzDepth is the depth of nested lists and maps above which FuzzData
+// produces empty lists and maps
+const maxFuzzDepth = 3
+
+// byte returns the next byte, or 0 if there are no more bytes
+func (f *fuzzBytes) byte() byte {
+	if len(f.b) == 0 {
+		return 0
+	}
+	c := f.b[0]
+	f.b = f.b[1:]
+	return c
+}
+
+// uint64 returns the next 8 bytes as an integer
+func (f *fuzzBytes) uint64() uint64 {
+	var buf [8]byte
+	n := copy(buf[:], f.b)
+	f.b = f.b[n:]
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+// string returns a string of up to 31 of the next bytes
+func (f *fuzzBytes) string() string {
+	n := int(f.byte() % 32)
+	if n > len(f.b) {
+		n = len(f.b)
+	}
+	s := string(f.b[:n])
+	f.b = f.b[n:]
+	return s
+}
+
+// value returns a value of the type t, nested in depth lists and maps
+func (f *fuzzBytes) value(t Type, depth int) (interface{}, bool) {
+	switch v := t.(type) {
+	case String:
+		return f.string(), true
+	case Int:
+		return int64(f.uint64()), true
+	case Float:
+		return math.Float64frombits(f.uint64()), true
+	case Bool:
+		return f.byte()%2 == 1, true
+	case Duration:
+		return time.Duration(f.uint64()), true
+	case Timestamp:
+		// Between years 1 and 9999
+		return time.Unix(int64(f.uint64()%253402300800)-62135596800, 0).UTC(), true
+	case Any:
+		types := []Type{String{}, Int{}, Float{}, Bool{}}
+		return f.value(types[int(f.byte())%len(types)], depth)
+	case List:
+		var l []interface{}
+		for n := int(f.byte() % 8); depth < maxFuzzDepth && len(l) < n; {
+			el, ok := f.value(v.ValueType, depth+1)
+			if !ok {
+				break
+			}
+			l = append(l, el)
+		}
+		return l, true
+	case Map:
+		m := map[interface{}]interface{}{}
+		for n := int(f.byte() % 8); depth < maxFuzzDepth && n > 0; n-- {
+			k, ok1 := f.value(v.KeyType, depth+1)
+			el, ok2 := f.value(v.ValueType, depth+1)
+			if !ok1 || !ok2 {
+				break
+			}
+			m[k] = el
+		}
+		return m, true
+	}
+	return nil, false
+}
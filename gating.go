@@ -0,0 +1,57 @@
+package indigo
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Rollout limits the evaluation of a rule to a percentage of the values of a
+// data element, such as a user ID, so that a new rule can be rolled out
+// gradually. The same value always gets the same decision for a rule, and
+// raising the percentage keeps the values already included.
+type Rollout struct {
+	// The percentage (0 to 100) of values for which the rule is evaluated
+	Percent float64 `json:"percent"`
+
+	// The name of the data element whose value selects the rule, such as "user_id".
+	// If the element is missing from the input data, the rule is not evaluated.
+	Key string `json:"key"`
+}
+
+// active returns true if the child rule r should be evaluated against the data d,
+// taking into account whether it is disabled or not approved, its environments and its rollout.
+func (r *Rule) active(d map[string]interface{}, o EvalOptions) bool {
+	if r.Disabled || r.unapproved {
+		return false
+	}
+
+	if len(r.Environments) > 0 && !contains(r.Environments, o.Environment) {
+		return false
+	}
+
+	if r.Rollout != nil {
+		v, ok := d[r.Rollout.Key]
+		if !ok {
+			return false
+		}
+		return rolloutBucket(r.ID, v) < r.Rollout.Percent*100
+	}
+	return true
+}
+
+// rolloutBucket hashes the rule ID and the value to a bucket between 0 and 9999
+func rolloutBucket(id string, v interface{}) float64 {
+	h := fnv.New32a()
+	_, _ = fmt.Fprintf(h, "%s\x00%v", id, v)
+	return float64(h.Sum32() % 10000)
+}
+
+// contains returns true if the string s is in the list
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package indigo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file contains functions that render rule trees as diagrams.
+
+// Node colors used when a diagram includes evaluation results
+const (
+	colorPass         = "#9be39b"
+	colorFail         = "#f29191"
+	colorError        = "#f2c46d"
+	colorNotEvaluated = "#dddddd"
+)
+
+// DOT renders the rule tree as a Graphviz DOT graph.
+// If u is not nil, each rule is colored according to its result in u: green for
+// pass, red for fail, orange for errors and gray for rules not in the results.
+func (d *RuleDescription) DOT(u *Result) string {
+	s := strings.Builder{}
+	s.WriteString("digraph rules {\n")
+	s.WriteString("  node [shape=box, style=\"rounded,filled\", fillcolor=\"white\", fontname=\"Helvetica\"];\n")
+	n := 0
+	d.writeDOT(&s, u, u != nil, &n)
+	s.WriteString("}\n")
+	return s.String()
+}
+
+func (d *RuleDescription) writeDOT(s *strings.Builder, u *Result, color bool, n *int) string {
+	node := fmt.Sprintf("n%d", *n)
+	*n++
+
+	attrs := fmt.Sprintf("label=\"%s\"", dotEscape(nodeLabel(d, "\\n")))
+	if color {
+		attrs += fmt.Sprintf(", fillcolor=\"%s\"", resultColor(u))
+	}
+	s.WriteString(fmt.Sprintf("  %s [%s];\n", node, attrs))
+
+	for _, c := range d.Children {
+		cn := c.writeDOT(s, childResult(u, c.ID), color, n)
+		s.WriteString(fmt.Sprintf("  %s -> %s;\n", node, cn))
+	}
+	return node
+}
+
+// Mermaid renders the rule tree as a Mermaid flowchart, suitable for
+// embedding in Markdown documentation.
+// If u is not nil, each rule is colored according to its result in u (see DOT).
+func (d *RuleDescription) Mermaid(u *Result) string {
+	s := strings.Builder{}
+	s.WriteString("flowchart TD\n")
+	n := 0
+	d.writeMermaid(&s, u, u != nil, &n)
+	if u != nil {
+		s.WriteString(fmt.Sprintf("  classDef pass fill:%s\n", colorPass))
+		s.WriteString(fmt.Sprintf("  classDef fail fill:%s\n", colorFail))
+		s.WriteString(fmt.Sprintf("  classDef error fill:%s\n", colorError))
+		s.WriteString(fmt.Sprintf("  classDef notevaluated fill:%s\n", colorNotEvaluated))
+	}
+	return s.String()
+}
+
+func (d *RuleDescription) writeMermaid(s *strings.Builder, u *Result, color bool, n *int) string {
+	node := fmt.Sprintf("n%d", *n)
+	*n++
+
+	s.WriteString(fmt.Sprintf("  %s[\"%s\"]", node, mermaidEscape(nodeLabel(d, "<br/>"))))
+	if color {
+		s.WriteString(":::" + resultClass(u))
+	}
+	s.WriteString("\n")
+
+	for _, c := range d.Children {
+		cn := c.writeMermaid(s, childResult(u, c.ID), color, n)
+		s.WriteString(fmt.Sprintf("  %s --> %s\n", node, cn))
+	}
+	return node
+}
+
+// nodeLabel returns the label of a rule in a diagram: the rule ID
+// and the expression, separated by newline
+func nodeLabel(d *RuleDescription, newline string) string {
+	if d.Expr == "" {
+		return d.ID
+	}
+	return d.ID + newline + strings.Join(strings.Fields(d.Expr), " ")
+}
+
+// childResult returns the result of the child rule with the id, or nil
+func childResult(u *Result, id string) *Result {
+	if u == nil {
+		return nil
+	}
+	return u.Results[id]
+}
+
+// resultClass returns the name of the class used to color a result
+func resultClass(u *Result) string {
+	switch {
+	case u == nil:
+		return "notevaluated"
+	case u.Error != nil:
+		return "error"
+	case u.Pass:
+		return "pass"
+	default:
+		return "fail"
+	}
+}
+
+// resultColor returns the color used for a result
+func resultColor(u *Result) string {
+	switch resultClass(u) {
+	case "pass":
+		return colorPass
+	case "fail":
+		return colorFail
+	case "error":
+		return colorError
+	default:
+		return colorNotEvaluated
+	}
+}
+
+func dotEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `\\n`, `\n`) // keep the line breaks between ID and expression
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
+
+func mermaidEscape(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
@@ -0,0 +1,45 @@
+package indigo_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/matryer/is"
+)
+
+// Test rendering a rule tree as DOT and Mermaid diagrams
+func TestDiagrams(t *testing.T) {
+	is := is.New(t)
+
+	r := makeRule()
+	delete(r.Rules, "B")
+	delete(r.Rules, "E")
+	r.Rules["D"].Rules["d2"].Expr = `x == "y"`
+	r.Rules["D"].Expr = "false"
+	r.Rules["D"].EvalOptions.StopIfParentNegative = true
+
+	e := indigo.NewEngine(newMockEvaluator())
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{})
+	is.NoErr(err)
+
+	d := indigo.Describe(r)
+
+	dot := d.DOT(nil)
+	is.True(strings.HasPrefix(dot, "digraph rules {"))
+	is.True(strings.Contains(dot, `n3 [label="d2\nx == \"y\""];`))
+	is.True(strings.Contains(dot, "n1 -> n3;"))
+
+	dot = d.DOT(u)
+	is.True(strings.Contains(dot, `n0 [label="rule1\ntrue", fillcolor="#9be39b"];`))
+	is.True(strings.Contains(dot, `n1 [label="D\nfalse", fillcolor="#f29191"];`))
+	is.True(strings.Contains(dot, `n2 [label="d1\ntrue", fillcolor="#dddddd"];`)) // not evaluated
+
+	m := d.Mermaid(u)
+	is.True(strings.HasPrefix(m, "flowchart TD\n"))
+	is.True(strings.Contains(m, `n3["d2<br/>x == #quot;y#quot;"]:::notevaluated`))
+	is.True(strings.Contains(m, `n1["D<br/>false"]:::fail`))
+	is.True(strings.Contains(m, "n1 --> n3"))
+	is.True(!strings.Contains(d.Mermaid(nil), "classDef"))
+}
@@ -0,0 +1,75 @@
+package indigo
+
+import (
+	"context"
+	"fmt"
+)
+
+// Ready returns an error wrapping ErrNotCompiled if the rule r or any of its
+// descendants has not been compiled, so the engine is not ready to evaluate it.
+func (e *DefaultEngine) Ready(r *Rule) error {
+	if err := validateCompileArguments(r, e); err != nil {
+		return err
+	}
+	return checkCompiled(r)
+}
+
+// HealthCheck returns an error if the engine is not ready to evaluate the rule r
+// (see Ready), or, if d is not nil, if evaluating r with the sample data d fails.
+// Use it in the readiness probe of a decision service, such as a Kubernetes
+// readiness probe, to check that the rules are compiled and can be evaluated:
+//
+//     http.HandleFunc("/ready", func(w http.ResponseWriter, req *http.Request) {
+//         if err := engine.HealthCheck(req.Context(), rule, sample); err != nil {
+//             http.Error(w, err.Error(), http.StatusServiceUnavailable)
+//         }
+//     })
+//
+// The result of the evaluation is discarded.
+func (e *DefaultEngine) HealthCheck(ctx context.Context, r *Rule, d map[string]interface{}, opts ...EvalOption) error {
+	if err := e.Ready(r); err != nil {
+		return err
+	}
+	return smokeEval(ctx, e, r, d, opts...)
+}
+
+// Ready returns an error wrapping ErrNotCompiled if any rule in the vault has
+// not been compiled. Only DefaultEngine records that a rule has been compiled.
+func (v *Vault) Ready() error {
+	return checkCompiled(v.Snapshot().root)
+}
+
+// HealthCheck returns an error if any rule in the vault has not been compiled, or,
+// if d is not nil, if evaluating the root rule with the sample data d fails
+// (see DefaultEngine.HealthCheck).
+func (v *Vault) HealthCheck(ctx context.Context, d map[string]interface{}, opts ...EvalOption) error {
+	s := v.Snapshot()
+	if err := checkCompiled(s.root); err != nil {
+		return err
+	}
+	return smokeEval(ctx, s.engine, s.root, d, opts...)
+}
+
+// checkCompiled returns an error if r or any of its descendants has not been compiled
+func checkCompiled(r *Rule) error {
+	return ApplyToRule(r, func(cr *Rule) error {
+		if cr == nil {
+			return ErrNilRule
+		}
+		if !cr.compiled {
+			return fmt.Errorf("rule %s: %w", cr.ID, ErrNotCompiled)
+		}
+		return nil
+	})
+}
+
+// smokeEval evaluates r with the sample data d, if d is not nil
+func smokeEval(ctx context.Context, e Evaluator, r *Rule, d map[string]interface{}, opts ...EvalOption) error {
+	if d == nil {
+		return nil
+	}
+	if _, err := e.Eval(ctx, r, d, opts...); err != nil {
+		return fmt.Errorf("evaluating sample data: %w", err)
+	}
+	return nil
+}
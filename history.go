@@ -0,0 +1,101 @@
+package indigo
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// version is the root rule of a vault from a point in time
+type version struct {
+	from time.Time
+	root *Rule
+}
+
+// KeepHistory makes the vault remember the versions of its rules for the duration d,
+// so that rules can be evaluated as they were at an earlier time with the AsOf
+// evaluation option or SnapshotAt. Use it to reproduce earlier decisions.
+// History is kept from the time KeepHistory is first called; a duration of 0 stops
+// keeping history and forgets the versions kept so far.
+//
+// Since changes to a vault copy only the changed rules, the versions share
+// the rules that did not change.
+func (v *Vault) KeepHistory(d time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.histMu.Lock()
+	defer v.histMu.Unlock()
+
+	v.keepFor = d
+	switch {
+	case d == 0:
+		v.versions = nil
+	case len(v.versions) == 0:
+		v.versions = []version{{from: time.Now(), root: v.root.Load().(*Rule)}}
+	}
+}
+
+// publish makes root the current root rule, recording the previous root in
+// the history if history is kept. The caller must hold the write lock.
+func (v *Vault) publish(root *Rule) {
+	v.root.Store(root)
+
+	v.histMu.Lock()
+	defer v.histMu.Unlock()
+	if v.keepFor == 0 {
+		return
+	}
+
+	now := time.Now()
+	v.versions = append(v.versions, version{from: now, root: root})
+
+	// Forget the versions replaced before the cutoff
+	cutoff := now.Add(-v.keepFor)
+	i := 0
+	for i+1 < len(v.versions) && v.versions[i+1].from.Before(cutoff) {
+		i++
+	}
+	v.versions = append(v.versions[:0], v.versions[i:]...)
+}
+
+// SnapshotAt returns a snapshot of the rules in the vault as they were at the time t.
+// It returns ErrHistoryUnavailable if the vault does not keep history for that time (see KeepHistory).
+func (v *Vault) SnapshotAt(t time.Time) (*Snapshot, error) {
+	v.histMu.RLock()
+	defer v.histMu.RUnlock()
+
+	// The version in effect at t is the last one from before or at t
+	i := sort.Search(len(v.versions), func(i int) bool {
+		return v.versions[i].from.After(t)
+	})
+	if i == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrHistoryUnavailable, t.Format(time.RFC3339))
+	}
+
+	return &Snapshot{
+		root:   v.versions[i-1].root,
+		engine: v.engine,
+	}, nil
+}
+
+// Version is a version of the rules in a vault (see Vault.History).
+type Version struct {
+	// The time the version took effect
+	From time.Time
+
+	// The rules of the version
+	Snapshot *Snapshot
+}
+
+// History returns the versions of the rules kept by the vault (see KeepHistory),
+// oldest first, or nil if the vault does not keep history.
+func (v *Vault) History() []Version {
+	v.histMu.RLock()
+	defer v.histMu.RUnlock()
+
+	var h []Version
+	for _, ver := range v.versions {
+		h = append(h, Version{From: ver.from, Snapshot: &Snapshot{root: ver.root, engine: v.engine}})
+	}
+	return h
+}
@@ -0,0 +1,178 @@
+package indigo
+
+import (
+	"context"
+	"reflect"
+)
+
+// Incremental evaluates a rule tree repeatedly against data that changes
+// a little between evaluations, such as a set of facts that is updated
+// one field at a time. It remembers the value of each rule's expression, and
+// only evaluates the expressions that refer to data that changed since
+// the previous evaluation.
+//
+// To find the data each expression refers to, the engine's evaluator must implement
+// ExpressionAnalyzer. Expressions whose references are unknown, rules with
+// a Self value, rules that refer to the outcomes of their children (see ChildrenKey),
+// and rules evaluated for each element of a list (see Rule.ForEach) and their
+// descendants, are always evaluated.
+//
+// Changes are detected by comparing each top-level value in the data with the
+// value in the previous evaluation. If you modify a map, slice or pointer value in place,
+// Incremental cannot detect the change; replace the value instead.
+//
+// Expressions must depend only on the data: do not use Incremental with
+// expressions whose values depend on external state, such as the window
+// functions of the CEL evaluator.
+//
+// After changing or recompiling the rules, create a new Incremental.
+// An Incremental is not safe for concurrent use.
+type Incremental struct {
+	engine *DefaultEngine
+	rule   *Rule
+	cache  *exprCache
+	prev   map[string]interface{}
+}
+
+// exprCache holds the values of expressions from a previous evaluation
+type exprCache struct {
+	// The variables referenced by each rule's expression; rules that are
+	// not in the map are always evaluated
+	refs map[*Rule][]string
+
+	// The expression values that are still valid for the current data
+	values map[*Rule]cachedValue
+
+	// The number of expressions evaluated in the current evaluation
+	evaluated int
+}
+
+// cachedValue is the output of evaluating an expression
+type cachedValue struct {
+	val         interface{}
+	diagnostics *Diagnostics
+}
+
+// Incremental returns an incremental evaluator for the rule r and its children.
+// The rules must be compiled.
+func (e *DefaultEngine) Incremental(r *Rule) (*Incremental, error) {
+	if err := validateCompileArguments(r, e); err != nil {
+		return nil, err
+	}
+
+	c := &exprCache{
+		refs:   map[*Rule][]string{},
+		values: map[*Rule]cachedValue{},
+	}
+
+	if a, ok := e.e.(ExpressionAnalyzer); ok {
+		err := ApplyToRule(r, func(cr *Rule) error {
+			if cr == nil || cr.Self != nil || cr.ForEach != "" || cr.aggregate {
+				return nil
+			}
+			info, err := a.Analyze(cr.Expr, cr.Schema, defaultResultType(cr))
+			if err != nil {
+				return &CompileError{RuleID: cr.ID, Err: err}
+			}
+			c.refs[cr] = info.ReferencedVariables
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Incremental{
+		engine: e,
+		rule:   r,
+		cache:  c,
+	}, nil
+}
+
+// Eval evaluates the rules against the data, like Engine.Eval, reusing the
+// values of expressions that do not refer to data that changed since the previous call.
+func (i *Incremental) Eval(ctx context.Context, d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+	if d == nil {
+		return nil, ErrNilData
+	}
+	if !i.engine.enter() {
+		return nil, ErrEngineClosed
+	}
+	defer i.engine.inflight.Done()
+	d = i.engine.inputData(ctx, d)
+
+	changed := map[string]bool{}
+	for k, v := range d {
+		if pv, ok := i.prev[k]; !ok || !reflect.DeepEqual(pv, v) {
+			changed[k] = true
+		}
+	}
+	for k := range i.prev {
+		if _, ok := d[k]; !ok {
+			changed[k] = true
+		}
+	}
+
+	// Discard the values of expressions that refer to changed data,
+	// including expressions that were not evaluated last time
+	c := i.cache
+	c.evaluated = 0
+	for r := range c.values {
+		for _, ref := range c.refs[r] {
+			if changed[ref] {
+				delete(c.values, r)
+				break
+			}
+		}
+	}
+
+	u, err := i.engine.eval(ctx, i.rule, d, 0, c, nil, opts...)
+	if err != nil {
+		// The cached values may be incomplete; start over next time
+		i.prev = nil
+		c.values = map[*Rule]cachedValue{}
+		return nil, err
+	}
+
+	i.prev = make(map[string]interface{}, len(d))
+	for k, v := range d {
+		i.prev[k] = v
+	}
+
+	if u.EvalOptions.OnResult != nil {
+		u.EvalOptions.OnResult(u)
+	}
+	return u, nil
+}
+
+// Evaluated returns the number of expressions evaluated by the most recent call to Eval.
+// The other rules in the results reused the values from a previous evaluation.
+func (i *Incremental) Evaluated() int {
+	return i.cache.evaluated
+}
+
+// evaluate returns the value of the rule's expression, from the cache if
+// it is still valid. If c is nil, the expression is always evaluated.
+func (c *exprCache) evaluate(e ExpressionEvaluator, r *Rule, d map[string]interface{},
+	returnDiagnostics bool) (interface{}, *Diagnostics, error) {
+
+	if c == nil {
+		return e.Evaluate(d, r.Expr, r.Schema, r.Self, r.Program, defaultResultType(r), returnDiagnostics)
+	}
+
+	if v, ok := c.values[r]; ok && (v.diagnostics != nil || !returnDiagnostics) {
+		return v.val, v.diagnostics, nil
+	}
+
+	c.evaluated++
+	val, diagnostics, err := e.Evaluate(d, r.Expr, r.Schema, r.Self, r.Program, defaultResultType(r), returnDiagnostics)
+	if err != nil {
+		delete(c.values, r)
+		return nil, diagnostics, err
+	}
+
+	if _, known := c.refs[r]; known {
+		c.values[r] = cachedValue{val: val, diagnostics: diagnostics}
+	}
+	return val, diagnostics, nil
+}
@@ -0,0 +1,140 @@
+package indigo
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// childIndex selects the child rules of a rule that can pass, given the value
+// of the rule's IndexBy data element
+type childIndex struct {
+	// IDs of the child rules by their index key
+	byKey map[string][]string
+
+	// IDs of the child rules without an index key, which are always evaluated
+	unkeyed []string
+
+	// The number of child rules when the index was built. If the number changes,
+	// the index is stale and is not used.
+	size int
+}
+
+// buildIndex builds the index of the child rules of r, using the rules'
+// IndexKey, or the key derived by the evaluator if it implements ExpressionIndexer.
+func buildIndex(r *Rule, e ExpressionEvaluator) (*childIndex, error) {
+	x, _ := e.(ExpressionIndexer)
+
+	idx := &childIndex{
+		byKey: map[string][]string{},
+		size:  len(r.Rules),
+	}
+
+	for _, c := range r.Rules {
+		if c == nil {
+			return nil, ErrNilRule
+		}
+
+		key, ok := c.IndexKey, c.IndexKey != ""
+		if !ok && x != nil && c.Expr != "" {
+			var err error
+			key, ok, err = x.Discriminant(c.Expr, c.Schema, r.IndexBy)
+			if err != nil {
+				return nil, &CompileError{RuleID: c.ID, Err: fmt.Errorf("indexing by %s: %w", r.IndexBy, err)}
+			}
+		}
+
+		if ok {
+			idx.byKey[key] = append(idx.byKey[key], c.ID)
+		} else {
+			idx.unkeyed = append(idx.unkeyed, c.ID)
+		}
+	}
+	return idx, nil
+}
+
+// selectChildren returns the child rules to evaluate, sorted according to the
+// options. If the rule has an index, only the child rules matching the
+// value of the IndexBy element in the data are returned.
+func (r *Rule) selectChildren(d map[string]interface{}, o EvalOptions) []*Rule {
+	idx := r.index
+	if r.IndexBy == "" || idx == nil || idx.size != len(r.Rules) {
+		return r.sortChildKeys(o)
+	}
+
+	var matches []string
+	if v, ok := dataValue(d, r.IndexBy); ok {
+		matches = idx.byKey[fmt.Sprint(v)]
+	}
+	rules := make([]*Rule, 0, len(matches)+len(idx.unkeyed))
+	for _, ids := range [][]string{matches, idx.unkeyed} {
+		for _, id := range ids {
+			c, ok := r.Rules[id]
+			if !ok {
+				// The index is stale
+				return r.sortChildKeys(o)
+			}
+			rules = append(rules, c)
+		}
+	}
+
+	sortFunc := o.SortFunc
+	if sortFunc == nil {
+		sortFunc = sortByID
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return sortFunc(rules, i, j)
+	})
+	return rules
+}
+
+// dataValue returns the value of the data element with the name. A dotted name,
+// such as "customer.country", selects a key of a map or a field of a protocol
+// buffer message in the element. It returns false if there is no such value.
+func dataValue(d map[string]interface{}, name string) (interface{}, bool) {
+	path := strings.Split(name, ".")
+	v, ok := d[path[0]]
+	for _, p := range path[1:] {
+		if !ok {
+			break
+		}
+		v, ok = selectValue(v, p)
+	}
+	return v, ok
+}
+
+// selectValue returns the value of the key of the map, or of the field of the
+// protocol buffer message, v
+func selectValue(v interface{}, key string) (interface{}, bool) {
+	var m protoreflect.Message
+	switch x := v.(type) {
+	case map[string]interface{}:
+		v, ok := x[key]
+		return v, ok
+	case proto.Message:
+		m = x.ProtoReflect()
+	case protoreflect.Message:
+		m = x
+	default:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		e := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()))
+		if !e.IsValid() {
+			return nil, false
+		}
+		return e.Interface(), true
+	}
+
+	f := m.Descriptor().Fields().ByName(protoreflect.Name(key))
+	if f == nil {
+		return nil, false
+	}
+	return m.Get(f).Interface(), true
+}
@@ -0,0 +1,177 @@
+package indigo
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+)
+
+// Invariant is a relationship between two rules that must hold for all input data:
+// whenever the rule If passes, the rule Then must pass, or, if ThenFails is set,
+// the rule Then must fail. Use invariants to catch logical regressions when rules
+// are edited independently, such as "every order flagged as fraud is also blocked".
+type Invariant struct {
+	// A description of the invariant, for reports
+	Description string `json:"description,omitempty"`
+
+	// The IDs of the rules
+	If   string `json:"if"`
+	Then string `json:"then"`
+
+	// Whether the rule Then must fail, rather than pass, when the rule If passes
+	ThenFails bool `json:"then_fails,omitempty"`
+}
+
+// String describes the invariant.
+func (inv Invariant) String() string {
+	if inv.Description != "" {
+		return inv.Description
+	}
+	verb := "pass"
+	if inv.ThenFails {
+		verb = "fail"
+	}
+	return fmt.Sprintf("if %s passes, %s must %s", inv.If, inv.Then, verb)
+}
+
+// Counterexample is input data for which an invariant does not hold.
+type Counterexample struct {
+	Invariant Invariant
+	Data      map[string]interface{}
+}
+
+// String describes the counterexample.
+func (c Counterexample) String() string {
+	return fmt.Sprintf("%s: violated by %v", c.Invariant, c.Data)
+}
+
+// CheckInvariants searches for input data for which the invariants between the
+// rule r and its descendants do not hold, and returns the first counterexample found
+// for each invariant that does not hold. An empty list does not prove that the
+// invariants hold, only that no counterexample was found.
+//
+// The rule must be compiled. The candidate input data combines the values of the
+// schema elements proposed by the evaluator for the expressions of all the rules
+// (see GenerateExamples), followed by n random inputs (see FuzzData), generated
+// from a fixed seed so that the search is repeatable. Inputs whose evaluation fails
+// are skipped. A rule that is not evaluated, such as a child of a failed rule
+// with StopIfParentNegative, does not pass.
+func (e *DefaultEngine) CheckInvariants(ctx context.Context, r *Rule, invariants []Invariant, n int, opts ...EvalOption) ([]Counterexample, error) {
+	if err := validateCompileArguments(r, e); err != nil {
+		return nil, err
+	}
+
+	for _, inv := range invariants {
+		for _, id := range []string{inv.If, inv.Then} {
+			if f, _ := findRule(r, nil, id); f == nil {
+				return nil, fmt.Errorf("invariant %q: rule %s: %w", inv, id, ErrRuleNotFound)
+			}
+		}
+	}
+
+	candidates, err := e.invariantCandidates(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		b := make([]byte, 64+rnd.Intn(192))
+		rnd.Read(b)
+		candidates = append(candidates, FuzzData(r.Schema, b))
+	}
+
+	found := make([]*Counterexample, len(invariants))
+	remaining := len(invariants)
+	for _, d := range candidates {
+		if remaining == 0 {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		u, err := e.Eval(ctx, r, d, opts...)
+		if err != nil {
+			continue
+		}
+		pass := map[string]bool{}
+		addPasses(u, pass)
+
+		for i, inv := range invariants {
+			if found[i] == nil && pass[inv.If] && pass[inv.Then] == inv.ThenFails {
+				found[i] = &Counterexample{Invariant: inv, Data: d}
+				remaining--
+			}
+		}
+	}
+
+	var cx []Counterexample
+	for _, c := range found {
+		if c != nil {
+			cx = append(cx, *c)
+		}
+	}
+	return cx, nil
+}
+
+// invariantCandidates returns the combinations of the values of the schema elements
+// that the evaluator proposes for the expressions of r and its descendants
+func (e *DefaultEngine) invariantCandidates(r *Rule) ([]map[string]interface{}, error) {
+	sampler, ok := e.e.(ExpressionSampler)
+	if !ok {
+		return nil, nil
+	}
+
+	samples := map[string][]interface{}{}
+	err := ApplyToRule(r, func(cr *Rule) error {
+		for _, expr := range []string{cr.Expr, cr.Guard} {
+			if expr == "" {
+				continue
+			}
+			s, err := sampler.SampleValues(expr, cr.Schema)
+			if err != nil {
+				return &CompileError{RuleID: cr.ID, Err: err}
+			}
+			for name, v := range s {
+				samples[name] = append(samples[name], v...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	var values [][]interface{}
+	for _, el := range r.Schema.Elements {
+		if v := exampleValues(samples[el.Name], el.Type); len(v) > 0 {
+			names = append(names, el.Name)
+			values = append(values, v)
+		}
+	}
+
+	var candidates []map[string]interface{}
+	idx := make([]int, len(names))
+	for len(candidates) < maxExampleCandidates {
+		d := make(map[string]interface{}, len(names))
+		for j, name := range names {
+			d[name] = values[j][idx[j]]
+		}
+		candidates = append(candidates, d)
+
+		if !nextCombination(idx, values) {
+			break
+		}
+	}
+	return candidates, nil
+}
+
+// addPasses adds whether u and its descendants passed to pass, by rule ID
+func addPasses(u *Result, pass map[string]bool) {
+	pass[u.Rule.ID] = u.Pass
+	for _, c := range u.Results {
+		addPasses(c, pass)
+	}
+}
@@ -0,0 +1,220 @@
+package indigo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// jsonSchemaVersion is the JSON Schema draft produced by Schema.JSONSchema
+const jsonSchemaVersion = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchema is the subset of JSON Schema used to describe a rule schema.
+// Indigo types that JSON Schema cannot express, such as durations and protocol
+// buffers, are recorded in the x-indigo-type extension.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"` // a type name, or a list of names
+	Format               string                 `json:"format,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties,omitempty"` // a schema, or a boolean
+	IndigoType           string                 `json:"x-indigo-type,omitempty"`
+	Sensitivity          Sensitivity            `json:"x-indigo-sensitivity,omitempty"`
+}
+
+// JSONSchema returns the schema as a JSON Schema (draft 2020-12) of an object
+// with one property per data element. Use it to validate the input data in
+// other services, or to document it.
+//
+// Timestamps are strings in the date-time format, and durations strings in the
+// format accepted by time.ParseDuration, such as "1h30m". Durations, protocol buffers
+// and maps with keys that are not strings are marked with their Indigo type in the
+// x-indigo-type extension, and sensitive elements with x-indigo-sensitivity, so that
+// SchemaFromJSONSchema restores the schema.
+func (s *Schema) JSONSchema() ([]byte, error) {
+	js := &jsonSchema{
+		Schema:      jsonSchemaVersion,
+		ID:          s.ID,
+		Title:       s.Name,
+		Description: s.Description,
+		Type:        "object",
+		Properties:  make(map[string]*jsonSchema, len(s.Elements)),
+	}
+
+	for _, e := range s.Elements {
+		p, err := toJSONSchema(e.Type)
+		if err != nil {
+			return nil, fmt.Errorf("element %s: %w", e.Name, err)
+		}
+		p.Description = e.Description
+		p.Sensitivity = e.Sensitivity
+		js.Properties[e.Name] = p
+	}
+
+	b, err := json.MarshalIndent(js, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding schema %s: %w", s.ID, err)
+	}
+	return b, nil
+}
+
+// toJSONSchema returns the JSON Schema of values of the type t
+func toJSONSchema(t Type) (*jsonSchema, error) {
+	switch t := t.(type) {
+	case String:
+		return &jsonSchema{Type: "string"}, nil
+	case Int:
+		return &jsonSchema{Type: "integer"}, nil
+	case Float:
+		return &jsonSchema{Type: "number"}, nil
+	case Bool:
+		return &jsonSchema{Type: "boolean"}, nil
+	case Timestamp:
+		return &jsonSchema{Type: "string", Format: "date-time"}, nil
+	case Duration:
+		return &jsonSchema{Type: "string", IndigoType: t.String()}, nil
+	case Any, nil:
+		return &jsonSchema{}, nil
+	case Proto, *Proto:
+		return &jsonSchema{Type: "object", IndigoType: t.String()}, nil
+	case List:
+		items, err := toJSONSchema(t.ValueType)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Type: "array", Items: items}, nil
+	case Map:
+		values, err := toJSONSchema(t.ValueType)
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		js := &jsonSchema{Type: "object", AdditionalProperties: b}
+		if _, ok := t.KeyType.(String); !ok {
+			js.IndigoType = t.String()
+		}
+		return js, nil
+	}
+	return nil, fmt.Errorf("type %v cannot be represented in JSON Schema", t)
+}
+
+// SchemaFromJSONSchema returns a rule schema with one data element per property of
+// the object described by the JSON Schema b, sorted by name. Use it to seed a rule
+// schema from the schema of an existing API.
+//
+// Integers are converted to Int, numbers to Float, strings in the date-time format
+// to Timestamp, arrays to lists and objects to maps with string keys, whose values
+// have the type of additionalProperties, or Any. Properties without a type, or
+// with several types other than null, are Any. References ($ref) are not followed.
+// The x-indigo-type and x-indigo-sensitivity extensions written by Schema.JSONSchema
+// take precedence; protocol buffer types must be in the global registry (see ParseType).
+func SchemaFromJSONSchema(b []byte) (Schema, error) {
+	js := jsonSchema{}
+	if err := json.Unmarshal(b, &js); err != nil {
+		return Schema{}, fmt.Errorf("decoding JSON Schema: %w", err)
+	}
+
+	if t := jsonTypes(js.Type); len(js.Properties) == 0 && (len(t) != 1 || t[0] != "object") {
+		return Schema{}, fmt.Errorf("JSON Schema %s does not describe an object", js.ID)
+	}
+
+	s := Schema{
+		ID:          js.ID,
+		Name:        js.Title,
+		Description: js.Description,
+	}
+
+	names := make([]string, 0, len(js.Properties))
+	for name := range js.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := js.Properties[name]
+		if p == nil {
+			p = &jsonSchema{}
+		}
+		t, err := fromJSONSchema(p)
+		if err != nil {
+			return Schema{}, fmt.Errorf("property %s: %w", name, err)
+		}
+		s.Elements = append(s.Elements, DataElement{
+			Name:        name,
+			Type:        t,
+			Description: p.Description,
+			Sensitivity: p.Sensitivity,
+		})
+	}
+	return s, nil
+}
+
+// fromJSONSchema returns the type of the values described by the JSON Schema js
+func fromJSONSchema(js *jsonSchema) (Type, error) {
+	if js.IndigoType != "" {
+		return ParseType(js.IndigoType)
+	}
+
+	types := jsonTypes(js.Type)
+	if len(types) != 1 {
+		return Any{}, nil
+	}
+
+	switch types[0] {
+	case "string":
+		if js.Format == "date-time" {
+			return Timestamp{}, nil
+		}
+		return String{}, nil
+	case "integer":
+		return Int{}, nil
+	case "number":
+		return Float{}, nil
+	case "boolean":
+		return Bool{}, nil
+	case "array":
+		if js.Items == nil {
+			return List{ValueType: Any{}}, nil
+		}
+		t, err := fromJSONSchema(js.Items)
+		if err != nil {
+			return nil, err
+		}
+		return List{ValueType: t}, nil
+	case "object":
+		values := &jsonSchema{}
+		if len(js.AdditionalProperties) > 0 {
+			// additionalProperties may be a boolean, which is not a schema
+			_ = json.Unmarshal(js.AdditionalProperties, values)
+		}
+		t, err := fromJSONSchema(values)
+		if err != nil {
+			return nil, err
+		}
+		return Map{KeyType: String{}, ValueType: t}, nil
+	}
+	return Any{}, nil
+}
+
+// jsonTypes returns the type names of a JSON Schema "type" keyword, except null
+func jsonTypes(t interface{}) []string {
+	var types []string
+	switch t := t.(type) {
+	case string:
+		types = append(types, t)
+	case []interface{}:
+		for _, x := range t {
+			if s, ok := x.(string); ok && s != "null" {
+				types = append(types, s)
+			}
+		}
+	}
+	return types
+}
@@ -0,0 +1,32 @@
+package indigo
+
+import (
+	"fmt"
+)
+
+// checkExpression analyzes the rule's expression, and returns an error if it is
+// too complex (see MaxComplexity), or constant (see RejectConstantRules)
+func (e *DefaultEngine) checkExpression(r *Rule, s Schema, resultType Type, o compileOptions) error {
+	if r.Expr == "" {
+		return nil
+	}
+
+	a, ok := e.e.(ExpressionAnalyzer)
+	if !ok {
+		return fmt.Errorf("evaluator %T cannot analyze expressions", e.e)
+	}
+
+	info, err := a.Analyze(r.Expr, s, resultType)
+	if err != nil {
+		return err
+	}
+
+	if score := info.Complexity.Score(); o.maxComplexity > 0 && score > o.maxComplexity {
+		return fmt.Errorf("%w: score %d, limit %d", ErrTooComplex, score, o.maxComplexity)
+	}
+
+	if o.rejectConstant && (info.AlwaysTrue || info.AlwaysFalse) {
+		return fmt.Errorf("%w: always %t", ErrConstantRule, info.AlwaysTrue)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package indigo
+
+import (
+	"time"
+)
+
+// Logger is the interface of the leveled, structured logger used by the engine
+// (see SetLogger). args are alternating keys and values, as in "rule", "r1".
+// A *slog.Logger from the log/slog package implements Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// SetLogger sets the logger of the engine, which logs:
+//
+//     Debug  each rule compiled
+//     Info   each change to the rules of a vault using the engine
+//     Warn   each evaluation taking longer than slow; none if slow is 0
+//     Error  each rule that cannot be compiled or evaluated
+//
+// Compile and Eval log the rule passed to them, not each child rule. The values of
+// sensitive data elements (see DataElement.Sensitivity) are masked in the errors
+// logged; the errors returned are not changed. Pass a nil logger to stop logging.
+// SetLogger is safe to call concurrently with Eval. Default: no logging
+func (e *DefaultEngine) SetLogger(l Logger, slow time.Duration) {
+	e.logging.Store(logging{l: l, slow: slow})
+}
+
+// logging wraps the logger in the engine's atomic.Value, which cannot store nil
+type logging struct {
+	l    Logger
+	slow time.Duration
+}
+
+// logger returns the engine's logger, and the threshold of slow evaluations
+func (e *DefaultEngine) logger() (Logger, time.Duration) {
+	lg, _ := e.logging.Load().(logging)
+	return lg.l, lg.slow
+}
+
+// logCompile logs the compilation of the rule r, which returned err
+func (e *DefaultEngine) logCompile(r *Rule, err error) {
+	l, _ := e.logger()
+	switch {
+	case l == nil:
+	case err != nil:
+		l.Error("compiling rule", "rule", ruleID(r), "error", err)
+	default:
+		l.Debug("compiled rule", "rule", r.ID)
+	}
+}
+
+// logEval logs the evaluation of the rule r with the data, which took d and
+// returned err. The values of sensitive data elements are masked in the error.
+func (e *DefaultEngine) logEval(r *Rule, data map[string]interface{}, d time.Duration, err error) {
+	l, slow := e.logger()
+	switch {
+	case l == nil:
+	case err != nil:
+		l.Error("evaluating rule", "rule", ruleID(r), "error", redactError(r, data, err))
+	case slow > 0 && d > slow:
+		l.Warn("slow evaluation", "rule", r.ID, "duration", d)
+	}
+}
+
+// logChange logs a change to the rules of a vault using the engine
+func (e *DefaultEngine) logChange(ev ChangeEvent) {
+	if l, _ := e.logger(); l != nil {
+		l.Info("rules changed", "change", ev.Type.String(), "rule", ev.RuleID, "parent", ev.ParentID)
+	}
+}
+
+// ruleID returns the ID of the rule, or a blank ID if the rule is nil
+func ruleID(r *Rule) string {
+	if r == nil {
+		return ""
+	}
+	return r.ID
+}
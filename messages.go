@@ -0,0 +1,41 @@
+package indigo
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// placeholder matches a {{name}} placeholder in a message template
+var placeholder = regexp.MustCompile(`{{\s*([^{}\s]+)\s*}}`)
+
+// selectMessage chooses the message template for the locale from the rule's
+// messages. It falls back first to the base language of the locale
+// (for example "sv" for "sv-SE"), then to the default message with the key "".
+func selectMessage(messages map[string]string, locale string) (string, bool) {
+	if m, ok := messages[locale]; ok {
+		return m, true
+	}
+
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		if m, ok := messages[locale[:i]]; ok {
+			return m, true
+		}
+	}
+
+	m, ok := messages[""]
+	return m, ok
+}
+
+// renderMessage replaces the {{name}} placeholders in the message template with
+// the value of the element with that name in the data. Placeholders that do not
+// refer to an element in the data are left as they are.
+func renderMessage(tmpl string, d map[string]interface{}) string {
+	return placeholder.ReplaceAllStringFunc(tmpl, func(p string) string {
+		name := placeholder.FindStringSubmatch(p)[1]
+		if v, ok := d[name]; ok {
+			return fmt.Sprintf("%v", v)
+		}
+		return p
+	})
+}
@@ -0,0 +1,40 @@
+package indigo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/matryer/is"
+)
+
+// Test that rule messages are rendered with the input data in the requested locale
+func TestMessages(t *testing.T) {
+	is := is.New(t)
+
+	r := makeRule()
+	r.Rules["D"].Messages = map[string]string{
+		"":   "Your order of {{amount}} exceeds the limit of {{ limit }}",
+		"sv": "Din order på {{amount}} överskrider gränsen {{limit}}",
+		"de": "Ihre Bestellung über {{amount}} {{unknown}}",
+	}
+
+	e := indigo.NewEngine(newMockEvaluator())
+	is.NoErr(e.Compile(r))
+	d := map[string]interface{}{"amount": 1200, "limit": 1000}
+
+	cases := map[string]string{
+		"":      "Your order of 1200 exceeds the limit of 1000",
+		"en-US": "Your order of 1200 exceeds the limit of 1000",
+		"sv-SE": "Din order på 1200 överskrider gränsen 1000",
+		"sv":    "Din order på 1200 överskrider gränsen 1000",
+		"de":    "Ihre Bestellung über 1200 {{unknown}}",
+	}
+
+	for locale, want := range cases {
+		u, err := e.Eval(context.Background(), r, d, indigo.Locale(locale))
+		is.NoErr(err)
+		is.Equal(u.Results["D"].Message, want)
+		is.Equal(u.Message, "") // no messages on the root
+	}
+}
@@ -0,0 +1,76 @@
+package indigo
+
+import "time"
+
+// Severity indicates how serious it is when a rule passes (fires).
+// Severities are ordered: SeverityInfo < SeverityWarn < SeverityDeny.
+type Severity string
+
+// The severities a rule can have. A blank severity is lower than all of them.
+const (
+	SeverityInfo Severity = "info"
+	SeverityWarn Severity = "warn"
+	SeverityDeny Severity = "deny"
+)
+
+// rank returns the position of the severity in the order of severities,
+// or -1 if the severity is not known
+func (s Severity) rank() int {
+	switch s {
+	case "":
+		return 0
+	case SeverityInfo:
+		return 1
+	case SeverityWarn:
+		return 2
+	case SeverityDeny:
+		return 3
+	}
+	return -1
+}
+
+// maxSeverity returns the higher of the two severities
+func maxSeverity(a, b Severity) Severity {
+	if b.rank() > a.rank() {
+		return b
+	}
+	return a
+}
+
+// RuleMetadata holds descriptive information about a rule. It is serialized with
+// the rule (see RuleBundle) and is available in the rule's results.
+// The engine uses the Severity as the verdict of the results, and the Approval,
+// ApprovedBy and Owner to enforce an approval policy; the other fields are used by
+// the functions documented with them, if at all.
+type RuleMetadata struct {
+	// The person or team responsible for the rule
+	Owner string `json:"owner,omitempty"`
+
+	// A user-friendly description of the rule
+	Description string `json:"description,omitempty"`
+
+	// How serious it is when the rule fires
+	Severity Severity `json:"severity,omitempty"`
+
+	// A category used to group rules, such as "fraud" or "pricing" (see ShardByCategory)
+	Category string `json:"category,omitempty"`
+
+	// Labels used to select rules, such as to send them to an engine (see Router)
+	Tags []string `json:"tags,omitempty"`
+
+	// When the rule was created and last updated; nil if not known
+	Created *time.Time `json:"created,omitempty"`
+	Updated *time.Time `json:"updated,omitempty"`
+
+	// A link to the ticket or change request for the rule
+	TicketURL string `json:"ticket_url,omitempty"`
+
+	// The stage of the rule in the approval workflow, and the people who approved it
+	// (see DefaultEngine.SetApprovalPolicy)
+	Approval   ApprovalStatus `json:"approval,omitempty"`
+	ApprovedBy []string       `json:"approved_by,omitempty"`
+
+	// Any other information about the rule. The values must be serializable
+	// with encoding/json if the rule is exported.
+	Extra map[string]interface{} `json:"extra,omitempty"`
+}
@@ -0,0 +1,43 @@
+package indigo
+
+import (
+	"context"
+)
+
+// EvaluatorFunc adapts a function to the Evaluator interface.
+type EvaluatorFunc func(ctx context.Context, r *Rule, d map[string]interface{}, opts ...EvalOption) (*Result, error)
+
+// Eval calls f.
+func (f EvaluatorFunc) Eval(ctx context.Context, r *Rule, d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+	return f(ctx, r, d, opts...)
+}
+
+// Middleware wraps an Evaluator, adding behavior before or after the evaluation,
+// such as logging, metrics, caching, enriching the input data or redacting the results.
+// The middleware calls next to continue the evaluation.
+type Middleware func(next Evaluator) Evaluator
+
+// Use wraps the engine's Eval method in the middleware. Middleware added first is the
+// outermost: it is called first, and sees the result last. For example:
+//
+//     e.Use(func(next indigo.Evaluator) indigo.Evaluator {
+//         return indigo.EvaluatorFunc(func(ctx context.Context, r *indigo.Rule,
+//             d map[string]interface{}, opts ...indigo.EvalOption) (*indigo.Result, error) {
+//             start := time.Now()
+//             u, err := next.Eval(ctx, r, d, opts...)
+//             log.Printf("evaluated %s in %v", r.ID, time.Since(start))
+//             return u, err
+//         })
+//     })
+//
+// The middleware applies to each call of Eval, not to the evaluation of each child rule.
+// Use is not safe to call concurrently with Eval; add the middleware when setting up the engine.
+func (e *DefaultEngine) Use(mw ...Middleware) {
+	e.middleware = append(e.middleware, mw...)
+
+	var chain Evaluator = EvaluatorFunc(e.evalRoot)
+	for i := len(e.middleware) - 1; i >= 0; i-- {
+		chain = e.middleware[i](chain)
+	}
+	e.chain = chain
+}
@@ -31,6 +31,10 @@ func newMockEvaluator() *mockEvaluator {
 
 func (m *mockEvaluator) Compile(expr string, s indigo.Schema, resultType indigo.Type, collectDiagnostics, dryRun bool) (interface{}, error) {
 
+	if expr == `invalid` {
+		return nil, fmt.Errorf("mock compilation error")
+	}
+
 	p := program{}
 	if collectDiagnostics {
 		p.compiledDiagnostics = true
@@ -40,6 +44,7 @@ func (m *mockEvaluator) Compile(expr string, s indigo.Schema, resultType indigo.
 }
 
 // The mockEvaluator only knows how to evaluate 1 string: `true`. If the expression is this, the evaluation is true, otherwise false.
+// The expression `error` always produces an evaluation error.
 func (m *mockEvaluator) Evaluate(data map[string]interface{}, expr string, s indigo.Schema, self interface{}, prog interface{}, resultType indigo.Type, returnDiagnostics bool) (interface{}, *indigo.Diagnostics, error) {
 	//	m.rulesTested = append(m.rulesTested, r.ID)
 	time.Sleep(m.evalDelay)
@@ -60,6 +65,10 @@ func (m *mockEvaluator) Evaluate(data map[string]interface{}, expr string, s ind
 		diagnostics = &indigo.Diagnostics{}
 	}
 
+	if expr == `error` {
+		return nil, diagnostics, fmt.Errorf("mock evaluation error")
+	}
+
 	if expr == `true` {
 		// return indigo.Value{
 		// 	Val:  true,
@@ -86,9 +95,3 @@ func (m *mockEvaluator) Evaluate(data map[string]interface{}, expr string, s ind
 func (m *mockEvaluator) Reset() {
 	m.rules = []string{}
 }
-
-func (e *mockEvaluator) PrintInternalStructure() {
-	for _, v := range e.rules {
-		fmt.Println("Rule id", v)
-	}
-}
@@ -0,0 +1,64 @@
+package indigo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Validate returns an error wrapping ErrConflictingOptions if the options contradict
+// each other, or have values that make no sense. It reports:
+//
+//  - DiscardPass and DiscardFail together: no child results would be returned
+//  - StopFirstPositiveChild and StopFirstNegativeChild together: the evaluation
+//    of child rules would always stop after the first child
+//  - TruncateAtMaxDepth without MaxDepth: there is no depth to truncate at
+//  - A negative MaxDepth, or an unknown NonBoolPolicy
+//
+// The engine accepts such options unless the StrictOptions option is set.
+func (o EvalOptions) Validate() error {
+	var problems []string
+
+	if o.DiscardPass && o.DiscardFail {
+		problems = append(problems, "DiscardPass and DiscardFail discard all child results")
+	}
+
+	if o.StopFirstPositiveChild && o.StopFirstNegativeChild {
+		problems = append(problems, "StopFirstPositiveChild and StopFirstNegativeChild stop after the first child rule")
+	}
+
+	if o.MaxDepth < 0 {
+		problems = append(problems, fmt.Sprintf("MaxDepth is negative (%d)", o.MaxDepth))
+	}
+
+	if o.TruncateAtMaxDepth && o.MaxDepth == 0 {
+		problems = append(problems, "TruncateAtMaxDepth requires MaxDepth")
+	}
+
+	if o.NonBoolPolicy < NonBoolIndeterminate || o.NonBoolPolicy > NonBoolError {
+		problems = append(problems, fmt.Sprintf("unknown NonBoolPolicy (%d)", o.NonBoolPolicy))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrConflictingOptions, strings.Join(problems, "; "))
+}
+
+// ValidateOptions checks the evaluation options of the rule r and its descendants,
+// with the options opts applied as they would be by Eval, and returns an error for
+// the first rule whose options conflict (see EvalOptions.Validate).
+// Use it to check rules before they are deployed.
+func ValidateOptions(r *Rule, opts ...EvalOption) error {
+	return ApplyToRule(r, func(c *Rule) error {
+		if c == nil {
+			return ErrNilRule
+		}
+
+		o := c.EvalOptions
+		applyEvaluatorOptions(&o, opts...)
+		if err := o.Validate(); err != nil {
+			return fmt.Errorf("rule %s: %w", c.ID, err)
+		}
+		return nil
+	})
+}
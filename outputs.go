@@ -0,0 +1,70 @@
+package indigo
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ruleExpr is one of the expressions of a rule
+type ruleExpr struct {
+	// The prefix of errors about the expression, such as "guard: "
+	name string
+	expr string
+	t    Type
+}
+
+// ruleExprs returns the expressions of the rule r that are not blank: its expression,
+// guard and outputs, with the outputs in order of name
+func ruleExprs(r *Rule) []ruleExpr {
+	var exprs []ruleExpr
+	if r.Expr != "" {
+		exprs = append(exprs, ruleExpr{"", r.Expr, defaultResultType(r)})
+	}
+	if r.Guard != "" {
+		exprs = append(exprs, ruleExpr{"guard: ", r.Guard, Bool{}})
+	}
+	for _, name := range outputNames(r) {
+		exprs = append(exprs, ruleExpr{"output " + name + ": ", r.Outputs[name], Any{}})
+	}
+	return exprs
+}
+
+// outputNames returns the names of the rule's outputs in order
+func outputNames(r *Rule) []string {
+	names := make([]string, 0, len(r.Outputs))
+	for name := range r.Outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compileOutputs compiles the output expressions of the rule with the schema s
+func (e *DefaultEngine) compileOutputs(r *Rule, s Schema, o compileOptions) (map[string]interface{}, error) {
+	if len(r.Outputs) == 0 {
+		return nil, nil
+	}
+
+	programs := make(map[string]interface{}, len(r.Outputs))
+	for _, name := range outputNames(r) {
+		prg, err := e.e.Compile(r.Outputs[name], s, Any{}, o.collectDiagnostics, o.dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %w", name, err)
+		}
+		programs[name] = prg
+	}
+	return programs, nil
+}
+
+// evalOutputs evaluates the output expressions of the rule with the data d
+func (e *DefaultEngine) evalOutputs(r *Rule, d map[string]interface{}) (map[string]interface{}, error) {
+	outputs := make(map[string]interface{}, len(r.Outputs))
+	for _, name := range outputNames(r) {
+		val, _, err := e.e.Evaluate(d, r.Outputs[name], r.Schema, r.Self, r.outputPrograms[name], Any{}, false)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %w", name, err)
+		}
+		outputs[name] = val
+	}
+	return outputs, nil
+}
@@ -0,0 +1,145 @@
+package indigo
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Overlap describes two sibling rules that can both pass for the same input data,
+// as in a decision table where more than one row matches.
+type Overlap struct {
+	// The ID of the parent of the rules
+	ParentID string
+
+	// The IDs of the rules, sorted
+	RuleIDs [2]string
+
+	// The input data for which both rules pass, such as `amount > 10 && amount <= 20`,
+	// if the evaluator can determine it
+	Region string
+
+	// Whether the rules have different outcomes: a different severity, or Self value
+	Conflict bool
+}
+
+// String describes the overlap.
+func (o Overlap) String() string {
+	kind := "overlap"
+	if o.Conflict {
+		kind = "conflict"
+	}
+	s := fmt.Sprintf("%s: rules %s and %s (children of %s)", kind, o.RuleIDs[0], o.RuleIDs[1], o.ParentID)
+	if o.Region != "" {
+		s += " both pass when " + o.Region
+	}
+	return s
+}
+
+// Overlaps returns the pairs of sibling rules, in the rule and its descendants,
+// that can both pass for the same input data. Only enabled boolean rules are
+// compared; rules without an expression overlap with all their siblings.
+// Siblings are compared with the data elements of both their schemas, which must
+// not declare the same name with different types.
+// The evaluator must implement ExpressionOverlapper.
+//
+// Overlapping rules with different outcomes (see Overlap.Conflict) are usually
+// authoring mistakes in rule sets where only one child is meant to pass.
+func (e *DefaultEngine) Overlaps(r *Rule) ([]Overlap, error) {
+	if err := validateCompileArguments(r, e); err != nil {
+		return nil, err
+	}
+
+	o, ok := e.e.(ExpressionOverlapper)
+	if !ok {
+		return nil, fmt.Errorf("evaluator %T cannot compare expressions", e.e)
+	}
+
+	var overlaps []Overlap
+	err := e.overlaps(r, o, &overlaps)
+	return overlaps, err
+}
+
+// overlaps adds the overlapping children of r and its descendants to overlaps
+func (e *DefaultEngine) overlaps(r *Rule, o ExpressionOverlapper, overlaps *[]Overlap) error {
+	if r == nil {
+		return ErrNilRule
+	}
+
+	var children []*Rule
+	for _, c := range r.Rules {
+		if c == nil {
+			return ErrNilRule
+		}
+		if _, ok := defaultResultType(c).(Bool); ok && !c.Disabled {
+			children = append(children, c)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].ID < children[j].ID
+	})
+
+	schemas := make([]Schema, len(children))
+	for i, c := range children {
+		s, err := e.exprSchemaOf(c)
+		if err != nil {
+			return &CompileError{RuleID: c.ID, Err: err}
+		}
+		schemas[i] = s
+	}
+
+	for i, a := range children {
+		for j := i + 1; j < len(children); j++ {
+			b := children[j]
+			schema, err := mergeSchemas(schemas[i], schemas[j])
+			if err != nil {
+				return &CompileError{RuleID: r.ID, Err: fmt.Errorf("comparing %s and %s: %w", a.ID, b.ID, err)}
+			}
+
+			overlap, region, err := o.Overlap(a.Expr, b.Expr, schema)
+			if err != nil {
+				return &CompileError{RuleID: r.ID, Err: fmt.Errorf("comparing %s and %s: %w", a.ID, b.ID, err)}
+			}
+			if !overlap {
+				continue
+			}
+
+			*overlaps = append(*overlaps, Overlap{
+				ParentID: r.ID,
+				RuleIDs:  [2]string{a.ID, b.ID},
+				Region:   region,
+				Conflict: a.Metadata.Severity != b.Metadata.Severity || !reflect.DeepEqual(a.Self, b.Self),
+			})
+		}
+	}
+
+	for _, c := range append(r.sortChildKeys(EvalOptions{}), sortRules(r.ElseRules, EvalOptions{})...) {
+		if err := e.overlaps(c, o, overlaps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeSchemas returns the schema a, with the data elements of b that a does not
+// declare. It returns an error if a and b declare an element with different types.
+func mergeSchemas(a, b Schema) (Schema, error) {
+	elements := append([]DataElement(nil), a.Elements...)
+	for _, eb := range b.Elements {
+		found := false
+		for _, ea := range a.Elements {
+			if ea.Name != eb.Name {
+				continue
+			}
+			if !reflect.DeepEqual(ea.Type, eb.Type) {
+				return Schema{}, fmt.Errorf("%s is %v in one schema and %v in the other", eb.Name, ea.Type, eb.Type)
+			}
+			found = true
+		}
+		if !found {
+			elements = append(elements, eb)
+		}
+	}
+	a.Elements = elements
+	return a, nil
+}
@@ -0,0 +1,138 @@
+package indigo
+
+import (
+	"context"
+	"fmt"
+)
+
+// A Pipeline evaluates a sequence of rule trees (stages), such as the steps of
+// a decision funnel: validation, then fraud checks, then pricing.
+// The derived values of each stage are passed on to the following stages as input data:
+//
+//  - The results of rules with an Output (see Rule.Output), as with Chain
+//  - The stage's Pass and Verdict, if the stage names them with PassOutput and VerdictOutput
+//
+// The rules of later stages can refer to these values, if they are in the rules' schemas.
+// After each stage, the stage's StopIf function decides whether to stop the pipeline.
+type Pipeline struct {
+	engine Evaluator
+	stages []Stage
+}
+
+// Stage is one step of a pipeline.
+type Stage struct {
+	// The name of the stage, used in errors and in the PipelineResult
+	Name string
+
+	// The compiled rule to evaluate
+	Rule *Rule
+
+	// The input data names under which the Pass and Verdict of the stage's rule
+	// are passed to the following stages. Optional.
+	PassOutput    string
+	VerdictOutput string
+
+	// StopIf is called with the result of the stage. If it returns true, the
+	// following stages are not evaluated. If StopIf is nil, the pipeline continues.
+	// See StopIfFail, StopIfPass and StopAtSeverity.
+	StopIf func(u *Result) bool
+}
+
+// StopIfFail stops a pipeline if the stage's rule did not pass.
+func StopIfFail(u *Result) bool {
+	return !u.Pass
+}
+
+// StopIfPass stops a pipeline if the stage's rule passed.
+func StopIfPass(u *Result) bool {
+	return u.Pass
+}
+
+// StopAtSeverity returns a function that stops a pipeline if the stage's
+// verdict is the severity s or higher, such as SeverityDeny.
+func StopAtSeverity(s Severity) func(u *Result) bool {
+	return func(u *Result) bool {
+		return u.Verdict != "" && u.Verdict.rank() >= s.rank()
+	}
+}
+
+// PipelineResult is the result of evaluating a pipeline.
+type PipelineResult struct {
+	// The results of the stages evaluated, in order
+	Stages []*Result
+
+	// The values derived by the stages, by name
+	Facts map[string]interface{}
+
+	// The name of the stage whose StopIf function stopped the pipeline;
+	// blank if all stages were evaluated
+	StoppedAt string
+
+	// The highest verdict of the stages evaluated
+	Verdict Severity
+}
+
+// NewPipeline returns a pipeline evaluating the stages, in order, with the evaluator e.
+func NewPipeline(e Evaluator, stages ...Stage) (*Pipeline, error) {
+	if e == nil {
+		return nil, ErrNilEngine
+	}
+
+	for i, s := range stages {
+		if s.Rule == nil {
+			return nil, fmt.Errorf("stage %d (%s): %w", i, s.Name, ErrNilRule)
+		}
+	}
+
+	return &Pipeline{
+		engine: e,
+		stages: stages,
+	}, nil
+}
+
+// Eval evaluates the stages of the pipeline against the data, and the values
+// derived by earlier stages. The options are passed to the evaluation of each stage.
+// The input data is not modified.
+func (p *Pipeline) Eval(ctx context.Context, d map[string]interface{}, opts ...EvalOption) (*PipelineResult, error) {
+	if d == nil {
+		return nil, ErrNilData
+	}
+
+	data := make(map[string]interface{}, len(d))
+	for k, v := range d {
+		data[k] = v
+	}
+
+	pr := &PipelineResult{
+		Facts: map[string]interface{}{},
+	}
+
+	for _, s := range p.stages {
+		derived := map[string]interface{}{}
+		u, err := p.engine.Eval(ctx, s.Rule, data, collectOutputs(derived, opts)...)
+		if err != nil {
+			return nil, fmt.Errorf("stage %s: %w", s.Name, err)
+		}
+
+		if s.PassOutput != "" {
+			derived[s.PassOutput] = u.Pass
+		}
+		if s.VerdictOutput != "" {
+			derived[s.VerdictOutput] = string(u.Verdict)
+		}
+
+		for k, v := range derived {
+			data[k] = v
+			pr.Facts[k] = v
+		}
+
+		pr.Stages = append(pr.Stages, u)
+		pr.Verdict = maxSeverity(pr.Verdict, u.Verdict)
+
+		if s.StopIf != nil && s.StopIf(u) {
+			pr.StoppedAt = s.Name
+			break
+		}
+	}
+	return pr, nil
+}
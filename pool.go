@@ -0,0 +1,99 @@
+package indigo
+
+import "sync"
+
+// Services that evaluate rules at a high rate allocate a result for every rule
+// evaluated, and often a map of input data for every evaluation. The pools in this
+// file let them reuse that memory, reducing the work of the garbage collector.
+
+var resultPool = sync.Pool{
+	New: func() interface{} {
+		return &Result{}
+	},
+}
+
+// newResult returns an empty result with room for n child results,
+// taken from the pool if pooled is true. If n is 0, no memory is allocated
+// for child results.
+func newResult(n int, pooled bool) *Result {
+	if !pooled {
+		if n == 0 {
+			return &Result{}
+		}
+		return &Result{
+			Results:        make(map[string]*Result, n),
+			OrderedResults: make([]*Result, 0, n),
+		}
+	}
+
+	u := resultPool.Get().(*Result)
+	if u.Results == nil {
+		u.Results = make(map[string]*Result, n)
+	}
+	if u.OrderedResults == nil {
+		u.OrderedResults = make([]*Result, 0, n)
+	}
+	return u
+}
+
+// Reset clears the result, keeping the memory allocated for the child results
+// so that it can be reused. The child results themselves are not reset.
+func (u *Result) Reset() {
+	for k := range u.Results {
+		delete(u.Results, k)
+	}
+
+	for i := range u.OrderedResults {
+		u.OrderedResults[i] = nil
+	}
+
+	for i := range u.RulesEvaluated {
+		u.RulesEvaluated[i] = nil
+	}
+
+	*u = Result{
+		Results:        u.Results,
+		OrderedResults: u.OrderedResults[:0],
+		RulesEvaluated: u.RulesEvaluated[:0],
+	}
+}
+
+// Release resets the result and its child results, and returns them to the pool
+// used by evaluations with the PoolResults option. The result, and any child result,
+// must not be used after it has been released.
+func (u *Result) Release() {
+	if u == nil {
+		return
+	}
+
+	for _, c := range u.Results {
+		c.Release()
+	}
+	u.Reset()
+	resultPool.Put(u)
+}
+
+var dataPool = sync.Pool{
+	New: func() interface{} {
+		return map[string]interface{}{}
+	},
+}
+
+// AcquireData returns an empty map for the input data of an evaluation,
+// reusing a map released with ReleaseData if one is available.
+func AcquireData() map[string]interface{} {
+	return dataPool.Get().(map[string]interface{})
+}
+
+// ReleaseData clears the map d and makes it available to AcquireData.
+// The map must not be used after it has been released.
+func ReleaseData(d map[string]interface{}) {
+	if d == nil {
+		return
+	}
+
+	for k := range d {
+		delete(d, k)
+	}
+	dataPool.Put(d)
+}
@@ -0,0 +1,82 @@
+package indigo
+
+import (
+	"fmt"
+)
+
+// Quorum turns a rule into a composite rule that passes only if the number of its
+// child rules that pass is within a limit, such as "at least two of the following".
+// The rule's own expression, if any, must also pass. The numbers of child rules
+// that passed and failed are recorded in the ChildrenPassed and ChildrenFailed
+// fields of the rule's result; child rules that do not apply are not counted.
+//
+// The quorum is checked after the child rules are evaluated, so a rule that fails
+// its quorum does not evaluate its ElseRules. Child rules that are not evaluated,
+// because of StopIfParentNegative or StopFirstPositiveChild, do not pass.
+type Quorum struct {
+	// Whether N is the minimum or the maximum number of child rules that pass
+	Kind QuorumKind `json:"kind"`
+
+	// The number of child rules
+	N int `json:"n"`
+}
+
+// QuorumKind is the kind of limit of a Quorum.
+type QuorumKind string
+
+const (
+	// At least N child rules must pass
+	QuorumAtLeast QuorumKind = "at_least"
+
+	// At most N child rules may pass
+	QuorumAtMost QuorumKind = "at_most"
+)
+
+// AtLeast returns a quorum that requires at least n child rules to pass.
+func AtLeast(n int) *Quorum {
+	return &Quorum{Kind: QuorumAtLeast, N: n}
+}
+
+// AtMost returns a quorum that allows at most n child rules to pass.
+func AtMost(n int) *Quorum {
+	return &Quorum{Kind: QuorumAtMost, N: n}
+}
+
+// String describes the quorum, as in "at least 2".
+func (q Quorum) String() string {
+	switch q.Kind {
+	case QuorumAtLeast:
+		return fmt.Sprintf("at least %d", q.N)
+	case QuorumAtMost:
+		return fmt.Sprintf("at most %d", q.N)
+	}
+	return fmt.Sprintf("%s %d", q.Kind, q.N)
+}
+
+// validate returns an error if the quorum has an unknown kind or a negative number
+func (q *Quorum) validate() error {
+	if q.Kind != QuorumAtLeast && q.Kind != QuorumAtMost {
+		return fmt.Errorf("quorum: unknown kind %q", q.Kind)
+	}
+	if q.N < 0 {
+		return fmt.Errorf("quorum: negative number %d", q.N)
+	}
+	return nil
+}
+
+// apply fails the result u of a rule that passed, if the number of its child rules
+// that passed is not within the quorum
+func (q *Quorum) apply(u *Result) {
+	if !u.Pass {
+		return
+	}
+
+	met := u.ChildrenPassed >= q.N
+	if q.Kind == QuorumAtMost {
+		met = u.ChildrenPassed <= q.N
+	}
+	if !met {
+		u.Pass = false
+		u.Status = StatusFail
+	}
+}
@@ -0,0 +1,227 @@
+package indigo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// AdmissionPolicy sets the limits on the evaluations admitted by the RateLimit
+// middleware, for each key.
+type AdmissionPolicy struct {
+	// The maximum number of evaluations in progress at the same time; no limit if 0
+	MaxConcurrent int
+
+	// The number of evaluations started per second, on average; no limit if 0
+	Rate float64
+
+	// The number of evaluations that can start at once, above the average Rate,
+	// after a quiet period. At least 1.
+	Burst int
+
+	// Whether an evaluation over the limits waits until it is admitted, or the context
+	// is done, rather than failing at once with ErrRateLimited
+	Wait bool
+}
+
+// AdmissionKey returns the key of an evaluation: evaluations with the same key share
+// the limits of an AdmissionPolicy. For example, use the tenant ID in the context
+// values to stop one tenant from starving the others:
+//
+//     func(ctx context.Context, _ *indigo.Rule, _ map[string]interface{}) string {
+//         tenant, _ := indigo.ContextValues(ctx)["tenant"].(string)
+//         return tenant
+//     }
+type AdmissionKey func(ctx context.Context, r *Rule, d map[string]interface{}) string
+
+// RateLimit returns middleware (see DefaultEngine.Use) that limits the evaluations per
+// key, as returned by key, to the policy p. If key is nil, the limits apply to all
+// evaluations together. Evaluations over the limits fail with ErrRateLimited, or, if
+// p.Wait is set, wait to be admitted. The state of a key is removed when it has no
+// evaluations in progress and its Burst is restored, so keys need not be bounded.
+func RateLimit(key AdmissionKey, p AdmissionPolicy) Middleware {
+	if p.Burst < 1 {
+		p.Burst = 1
+	}
+	l := &admission{p: p, keys: map[string]*keyAdmission{}}
+
+	return func(next Evaluator) Evaluator {
+		return EvaluatorFunc(func(ctx context.Context, r *Rule, d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+			var k string
+			if key != nil {
+				k = key(ctx, r, d)
+			}
+
+			release, err := l.admit(ctx, k)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			return next.Eval(ctx, r, d, opts...)
+		})
+	}
+}
+
+// minSweepInterval is the shortest time between sweeps of the idle keys of an admission
+const minSweepInterval = 10 * time.Millisecond
+
+// admission holds the state of the limits of each key
+type admission struct {
+	p    AdmissionPolicy
+	mu   sync.Mutex
+	keys map[string]*keyAdmission
+
+	// When the idle keys were last removed
+	swept time.Time
+}
+
+// keyAdmission holds the state of the limits of one key
+type keyAdmission struct {
+	// The evaluations admitted or waiting to be; guarded by admission.mu
+	users int
+
+	// The slots of the evaluations in progress; nil if there is no MaxConcurrent
+	slots chan struct{}
+
+	// The token bucket of the Rate: the tokens available as of last
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// admit waits for, or refuses, the admission of an evaluation with the key k,
+// returning a function to call when the evaluation ends
+func (l *admission) admit(ctx context.Context, k string) (func(), error) {
+	now := time.Now()
+	l.mu.Lock()
+	l.sweep(now)
+	ka, ok := l.keys[k]
+	if !ok {
+		ka = &keyAdmission{tokens: float64(l.p.Burst), last: now}
+		if l.p.MaxConcurrent > 0 {
+			ka.slots = make(chan struct{}, l.p.MaxConcurrent)
+		}
+		l.keys[k] = ka
+	}
+	ka.users++
+	l.mu.Unlock()
+
+	done := func() { l.done(k, ka) }
+	if l.p.Rate > 0 {
+		if err := ka.take(ctx, l.p); err != nil {
+			done()
+			return nil, err
+		}
+	}
+
+	if ka.slots == nil {
+		return done, nil
+	}
+
+	release := func() {
+		<-ka.slots
+		done()
+	}
+	select {
+	case ka.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if !l.p.Wait {
+		done()
+		return nil, ErrRateLimited
+	}
+	select {
+	case ka.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		done()
+		return nil, ctx.Err()
+	}
+}
+
+// done ends an admission of the key k, removing the key if it is idle
+func (l *admission) done(k string, ka *keyAdmission) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ka.users--
+	if ka.idle(l.p, time.Now()) {
+		delete(l.keys, k)
+	}
+}
+
+// sweep removes the keys that have become idle since they were last admitted,
+// at most once in the time it takes to restore the Burst. l.mu must be held.
+func (l *admission) sweep(now time.Time) {
+	if l.p.Rate <= 0 {
+		return // keys are idle when their evaluations are done
+	}
+	interval := time.Duration(float64(l.p.Burst) / l.p.Rate * float64(time.Second))
+	if interval < minSweepInterval {
+		interval = minSweepInterval
+	}
+	if now.Sub(l.swept) < interval {
+		return
+	}
+
+	l.swept = now
+	for k, ka := range l.keys {
+		if ka.idle(l.p, now) {
+			delete(l.keys, k)
+		}
+	}
+}
+
+// idle reports whether the key has no admissions, and a full token bucket as of now,
+// so that its state is the same as a new key's. admission.mu must be held.
+func (ka *keyAdmission) idle(p AdmissionPolicy, now time.Time) bool {
+	if ka.users > 0 {
+		return false
+	}
+	if p.Rate <= 0 {
+		return true
+	}
+
+	ka.mu.Lock()
+	defer ka.mu.Unlock()
+	return ka.tokens+now.Sub(ka.last).Seconds()*p.Rate >= float64(p.Burst)
+}
+
+// take takes a token from the bucket, waiting for one to be added if p.Wait is set
+func (ka *keyAdmission) take(ctx context.Context, p AdmissionPolicy) error {
+	ka.mu.Lock()
+	now := time.Now()
+	ka.tokens += now.Sub(ka.last).Seconds() * p.Rate
+	if ka.tokens > float64(p.Burst) {
+		ka.tokens = float64(p.Burst)
+	}
+	ka.last = now
+
+	if ka.tokens >= 1 {
+		ka.tokens--
+		ka.mu.Unlock()
+		return nil
+	}
+	if !p.Wait {
+		ka.mu.Unlock()
+		return ErrRateLimited
+	}
+
+	// Reserve the next token, and wait until it is added
+	ka.tokens--
+	wait := time.Duration(-ka.tokens / p.Rate * float64(time.Second))
+	ka.mu.Unlock()
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		ka.mu.Lock()
+		ka.tokens++
+		ka.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,54 @@
+package indigo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestAdmissionEviction(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	// Without a rate, keys are removed when their evaluations are done
+	l := &admission{p: AdmissionPolicy{MaxConcurrent: 2, Burst: 1}, keys: map[string]*keyAdmission{}}
+	r1, err := l.admit(ctx, "a")
+	is.NoErr(err)
+	r2, err := l.admit(ctx, "a")
+	is.NoErr(err)
+	_, err = l.admit(ctx, "a")
+	is.Equal(err, ErrRateLimited)
+	is.Equal(len(l.keys), 1) // the refused evaluation does not remove the key
+	r1()
+	is.Equal(len(l.keys), 1) // in progress
+	r2()
+	is.Equal(len(l.keys), 0)
+
+	// With a rate, keys are removed once their tokens are restored
+	l = &admission{p: AdmissionPolicy{Rate: 1000, Burst: 1}, keys: map[string]*keyAdmission{}}
+	for i := 0; i < 100; i++ {
+		release, err := l.admit(ctx, fmt.Sprint(i))
+		is.NoErr(err)
+		release()
+	}
+	is.True(len(l.keys) > 0) // the tokens taken are not restored yet
+	time.Sleep(2 * minSweepInterval)
+	release, err := l.admit(ctx, "x")
+	is.NoErr(err)
+	is.Equal(len(l.keys), 1)
+	release()
+
+	// Keys with evaluations in progress are kept
+	l = &admission{p: AdmissionPolicy{Rate: 1000, Burst: 1, MaxConcurrent: 1}, keys: map[string]*keyAdmission{}}
+	release, err = l.admit(ctx, "a")
+	is.NoErr(err)
+	time.Sleep(2 * minSweepInterval)
+	_, err = l.admit(ctx, "b")
+	is.NoErr(err)
+	is.Equal(len(l.keys), 2)
+	release()
+	is.Equal(len(l.keys), 1) // "a" was full when it was released
+}
@@ -0,0 +1,152 @@
+package indigo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Sensitivity classifies data elements whose values must not be shown in
+// reports and logs (see DataElement.Sensitivity).
+type Sensitivity string
+
+const (
+	// SensitivityPII marks personally identifiable information, such as names and addresses.
+	SensitivityPII Sensitivity = "pii"
+
+	// SensitivitySecret marks secrets, such as passwords and API keys.
+	SensitivitySecret Sensitivity = "secret"
+)
+
+// Redacted replaces the values of sensitive data elements in redacted data.
+const Redacted = "[REDACTED]"
+
+// Sensitive reports whether the data element with the name is marked sensitive.
+func (s *Schema) Sensitive(name string) bool {
+	for _, e := range s.Elements {
+		if e.Name == name {
+			return e.Sensitivity != ""
+		}
+	}
+	return false
+}
+
+// Redact returns a copy of the data with the values of the sensitive data
+// elements replaced by Redacted. Use it before logging input data.
+// The data is not modified; if the schema has no sensitive elements, d is returned.
+func (s *Schema) Redact(d map[string]interface{}) map[string]interface{} {
+	if d == nil || !s.hasSensitive() {
+		return d
+	}
+
+	x := make(map[string]interface{}, len(d))
+	for k, v := range d {
+		if s.Sensitive(k) {
+			v = Redacted
+		}
+		x[k] = v
+	}
+	return x
+}
+
+// hasSensitive reports whether any data element is marked sensitive
+func (s *Schema) hasSensitive() bool {
+	for _, e := range s.Elements {
+		if e.Sensitivity != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// redactDiagnostics returns a copy of the diagnostics with the values of sensitive
+// data elements, and of their fields, replaced by Redacted, whether they are marked
+// as input or evaluated
+func (s *Schema) redactDiagnostics(d Diagnostics) Diagnostics {
+	if s.sensitiveExpr(d.Expr) {
+		d.Interface = Redacted
+	}
+
+	if len(d.Children) > 0 {
+		children := make([]Diagnostics, len(d.Children))
+		for i, c := range d.Children {
+			children[i] = s.redactDiagnostics(c)
+		}
+		d.Children = children
+	}
+	return d
+}
+
+// sensitiveExpr reports whether the expression refers to a sensitive data element,
+// or a field or element of one, such as "customer.ssn" or "cards[0]"
+func (s *Schema) sensitiveExpr(expr string) bool {
+	name := expr
+	if i := strings.IndexAny(expr, ".["); i >= 0 {
+		name = expr[:i]
+	}
+	return s.Sensitive(strings.TrimSpace(name))
+}
+
+// minRedactedLength is the length of the shortest value masked in error messages,
+// so that short values, such as 0, do not mask unrelated text
+const minRedactedLength = 3
+
+// redactError returns err, or the message of err with the values in the data of the
+// sensitive data elements of the rule r, and of their fields and elements,
+// replaced by Redacted, if the message contains any
+func redactError(r *Rule, data map[string]interface{}, err error) interface{} {
+	if r == nil || data == nil || !r.Schema.hasSensitive() {
+		return err
+	}
+
+	msg := err.Error()
+	for k, v := range data {
+		if r.Schema.Sensitive(k) {
+			msg = redactValues(msg, reflect.ValueOf(v))
+		}
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return msg
+}
+
+// redactValues returns msg with the scalar values in v replaced by Redacted
+func redactValues(msg string, v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Invalid, reflect.Bool:
+		return msg
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return msg
+		}
+		return redactValues(msg, v.Elem())
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			msg = redactValues(msg, iter.Value())
+		}
+		return msg
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			msg = redactValues(msg, v.Index(i))
+		}
+		return msg
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" { // exported
+				msg = redactValues(msg, v.Field(i))
+			}
+		}
+		return msg
+	}
+
+	if !v.CanInterface() {
+		return msg
+	}
+	s := fmt.Sprint(v.Interface())
+	if len(s) < minRedactedLength {
+		return msg
+	}
+	return strings.ReplaceAll(msg, s, Redacted)
+}
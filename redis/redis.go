@@ -0,0 +1,360 @@
+// Package redis stores rules and evaluation results in Redis, so that a fleet of
+// services evaluating the same rules shares a decision cache, and loads new
+// versions of the rules as soon as they are saved.
+//
+// The package does not depend on a Redis client library. Instead, you connect it
+// to Redis by implementing the small Client interface. For example, with go-redis:
+//
+//     type client struct{ rdb *goredis.Client }
+//
+//     func (c client) Get(ctx context.Context, key string) ([]byte, bool, error) {
+//         b, err := c.rdb.Get(ctx, key).Bytes()
+//         if err == goredis.Nil {
+//             return nil, false, nil
+//         }
+//         return b, err == nil, err
+//     }
+//
+//     func (c client) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+//         return c.rdb.Set(ctx, key, value, ttl).Err()
+//     }
+//
+//     func (c client) Publish(ctx context.Context, channel string, message []byte) error {
+//         return c.rdb.Publish(ctx, channel, message).Err()
+//     }
+//
+//     func (c client) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+//         sub := c.rdb.Subscribe(ctx, channel)
+//         ch := make(chan []byte)
+//         go func() {
+//             defer close(ch)
+//             defer sub.Close()
+//             for m := range sub.Channel() {
+//                 select {
+//                 case ch <- []byte(m.Payload):
+//                 case <-ctx.Done():
+//                     return
+//                 }
+//             }
+//         }()
+//         return ch, nil
+//     }
+package redis
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ezachrisen/indigo"
+)
+
+// Client is the interface to the Redis commands used by the package.
+//
+// Get returns the value of the key, and false if the key does not exist.
+//
+// Set sets the value of the key, to expire after the ttl; a ttl of 0 means no expiry.
+//
+// Publish sends the message to the subscribers of the channel.
+//
+// Subscribe returns a channel receiving the messages published to the Redis channel,
+// until the context is canceled, when the returned channel is closed.
+type Client interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Publish(ctx context.Context, channel string, message []byte) error
+	Subscribe(ctx context.Context, channel string) (<-chan []byte, error)
+}
+
+// Store saves rule trees in Redis, and notifies the services using them when
+// a new version of a rule tree is saved.
+type Store struct {
+	client Client
+	prefix string
+}
+
+// NewStore returns a store keeping rules under keys beginning with the prefix,
+// such as "indigo:".
+func NewStore(c Client, prefix string) *Store {
+	return &Store{
+		client: c,
+		prefix: prefix,
+	}
+}
+
+// ruleKey returns the key of the rule tree with the id
+func (s *Store) ruleKey(id string) string {
+	return s.prefix + "rules:" + id
+}
+
+// channel returns the channel announcing new versions of the rule tree with the id
+func (s *Store) channel(id string) string {
+	return s.prefix + "changes:" + id
+}
+
+// Save stores the rule r and its children (see indigo.Export), and publishes the
+// new version to the services watching the rule. It returns the version, a hash
+// of the encoded rules.
+func (s *Store) Save(ctx context.Context, r *indigo.Rule) (string, error) {
+	b, err := indigo.Export(r)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return "", fmt.Errorf("encoding rule %s: %w", r.ID, err)
+	}
+
+	if err := s.client.Set(ctx, s.ruleKey(r.ID), data, 0); err != nil {
+		return "", fmt.Errorf("saving rule %s: %w", r.ID, err)
+	}
+
+	version := versionOf(data)
+	if err := s.client.Publish(ctx, s.channel(r.ID), []byte(version)); err != nil {
+		return "", fmt.Errorf("publishing rule %s: %w", r.ID, err)
+	}
+	return version, nil
+}
+
+// Load returns the rule tree with the id, and its version.
+// The rules are not compiled.
+func (s *Store) Load(ctx context.Context, id string) (*indigo.Rule, string, error) {
+	data, ok, err := s.client.Get(ctx, s.ruleKey(id))
+	if err != nil {
+		return nil, "", fmt.Errorf("loading rule %s: %w", id, err)
+	}
+	if !ok {
+		return nil, "", fmt.Errorf("rule %s: %w", id, indigo.ErrRuleNotFound)
+	}
+
+	b := indigo.RuleBundle{}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, "", fmt.Errorf("decoding rule %s: %w", id, err)
+	}
+
+	r, err := indigo.Import(b)
+	if err != nil {
+		return nil, "", err
+	}
+	return r, versionOf(data), nil
+}
+
+// Watch calls f with the rule tree with the id, and its version, each time a new
+// version is saved, until the context is canceled. Use it to compile the new
+// rules and replace them in a vault, and to switch the decision cache to the
+// new version with Cache.SetVersion.
+// If the new version cannot be loaded, or f returns an error, Watch stops and
+// returns the error. Watch returns nil when the context is canceled.
+func (s *Store) Watch(ctx context.Context, id string, f func(r *indigo.Rule, version string) error) error {
+	ch, err := s.client.Subscribe(ctx, s.channel(id))
+	if err != nil {
+		return fmt.Errorf("subscribing to rule %s: %w", id, err)
+	}
+
+	for range ch {
+		r, version, err := s.Load(ctx, id)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if err := f(r, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionOf returns the version of encoded rules
+func versionOf(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:8])
+}
+
+// Cache is an indigo.DecisionCache that stores results in Redis, shared by all
+// services using the same rules.
+//
+// Results are encoded as JSON. When a result is read from the cache, the rules of the result
+// and its children are looked up by ID in the rule tree given to NewCache, so the rules must
+// have unique IDs. Values that cannot be encoded as JSON are lost, and numbers are decoded as float64.
+// Diagnostics, timings and errors are not cached.
+//
+// The keys include the version of the rules (see SetVersion), so results of
+// earlier versions of the rules are not used after the rules change.
+type Cache struct {
+	client  Client
+	prefix  string
+	onError func(error)
+
+	mu      sync.RWMutex
+	rules   map[string]*indigo.Rule
+	version string
+}
+
+// NewCache returns a cache storing results of evaluating the rule tree r under keys
+// beginning with the prefix. The version identifies the rules, such as the version
+// returned by Store.Load.
+// The cache cannot return an error from Get and Add; errors from Redis are passed to onError,
+// if it is not nil, and the cache behaves as if the result was not in the cache.
+func NewCache(c Client, prefix string, r *indigo.Rule, version string, onError func(error)) *Cache {
+	x := &Cache{
+		client:  c,
+		prefix:  prefix,
+		onError: onError,
+	}
+	x.SetVersion(r, version)
+	return x
+}
+
+// SetVersion changes the rules whose results are cached.
+func (c *Cache) SetVersion(r *indigo.Rule, version string) {
+	rules := map[string]*indigo.Rule{}
+	indigo.Walk(r, func(cr *indigo.Rule, _ int) bool {
+		rules[cr.ID] = cr
+		return true
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = rules
+	c.version = version
+}
+
+// cachedResult is the encoded form of an indigo.Result
+type cachedResult struct {
+	RuleID          string          `json:"rule_id"`
+	Pass            bool            `json:"pass"`
+	Status          indigo.Status   `json:"status"`
+	Verdict         indigo.Severity `json:"verdict,omitempty"`
+	Variant         string          `json:"variant,omitempty"`
+	Value           interface{}     `json:"value,omitempty"`
+	Message         string          `json:"message,omitempty"`
+	Skipped         bool            `json:"skipped,omitempty"`
+	Truncated       bool            `json:"truncated,omitempty"`
+	EvaluationCount int             `json:"evaluation_count"`
+	Results         []*cachedResult `json:"results,omitempty"`
+}
+
+// errUnknownRule is reported when a cached result refers to a rule that is not in the rule tree
+var errUnknownRule = errors.New("cached result refers to an unknown rule")
+
+// Get returns the result stored under the key, if it exists and has not expired.
+func (c *Cache) Get(key string) (*indigo.Result, bool) {
+	c.mu.RLock()
+	rules, version := c.rules, c.version
+	c.mu.RUnlock()
+
+	data, ok, err := c.client.Get(context.Background(), c.prefix+version+":"+key)
+	if err != nil {
+		c.report(err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+
+	cr := &cachedResult{}
+	if err := json.Unmarshal(data, cr); err != nil {
+		c.report(err)
+		return nil, false
+	}
+
+	u, err := decode(cr, rules)
+	if err != nil {
+		c.report(err)
+		return nil, false
+	}
+	return u, true
+}
+
+// Add stores the result under the key, to expire after the ttl.
+func (c *Cache) Add(key string, u *indigo.Result, ttl time.Duration) {
+	c.mu.RLock()
+	version := c.version
+	c.mu.RUnlock()
+
+	data, err := json.Marshal(encode(u))
+	if err != nil {
+		c.report(err)
+		return
+	}
+
+	if err := c.client.Set(context.Background(), c.prefix+version+":"+key, data, ttl); err != nil {
+		c.report(err)
+	}
+}
+
+// report passes the error to the error handler, if any
+func (c *Cache) report(err error) {
+	if c.onError != nil {
+		c.onError(err)
+	}
+}
+
+// encode converts a result and its children to their encoded form
+func encode(u *indigo.Result) *cachedResult {
+	cr := &cachedResult{
+		RuleID:          u.Rule.ID,
+		Pass:            u.Pass,
+		Status:          u.Status,
+		Verdict:         u.Verdict,
+		Variant:         u.Variant,
+		Value:           u.Value,
+		Message:         u.Message,
+		Skipped:         u.Skipped,
+		Truncated:       u.Truncated,
+		EvaluationCount: u.EvaluationCount,
+	}
+
+	for _, c := range u.OrderedResults {
+		cr.Results = append(cr.Results, encode(c))
+	}
+	return cr
+}
+
+// decode converts an encoded result and its children to a result,
+// looking up their rules by ID
+func decode(cr *cachedResult, rules map[string]*indigo.Rule) (*indigo.Result, error) {
+	r, ok := rules[cr.RuleID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownRule, cr.RuleID)
+	}
+
+	u := &indigo.Result{
+		Rule:            r,
+		Metadata:        &r.Metadata,
+		Pass:            cr.Pass,
+		Status:          cr.Status,
+		Verdict:         cr.Verdict,
+		Variant:         cr.Variant,
+		Value:           cr.Value,
+		Message:         cr.Message,
+		Skipped:         cr.Skipped,
+		Truncated:       cr.Truncated,
+		EvalOptions:     r.EvalOptions,
+		EvaluationCount: cr.EvaluationCount,
+	}
+
+	if len(cr.Results) > 0 {
+		u.Results = make(map[string]*indigo.Result, len(cr.Results))
+		u.OrderedResults = make([]*indigo.Result, 0, len(cr.Results))
+	}
+
+	for _, c := range cr.Results {
+		cu, err := decode(c, rules)
+		if err != nil {
+			return nil, err
+		}
+		u.Results[c.RuleID] = cu
+		u.OrderedResults = append(u.OrderedResults, cu)
+	}
+	return u, nil
+}
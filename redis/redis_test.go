@@ -0,0 +1,166 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/cel"
+	"github.com/ezachrisen/indigo/redis"
+	"github.com/matryer/is"
+)
+
+// fakeClient is an in-memory Client
+type fakeClient struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	subs   map[string][]chan []byte
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		values: map[string][]byte{},
+		subs:   map[string][]chan []byte{},
+	}
+}
+
+func (f *fakeClient) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.values[key]
+	return v, ok, nil
+}
+
+func (f *fakeClient) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[key] = value
+	return nil
+}
+
+func (f *fakeClient) Publish(ctx context.Context, channel string, message []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ch := range f.subs[channel] {
+		ch <- message
+	}
+	return nil
+}
+
+func (f *fakeClient) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan []byte, 10)
+	f.subs[channel] = append(f.subs[channel], ch)
+	go func() {
+		<-ctx.Done()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func makeRule(threshold string) *indigo.Rule {
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{{Name: "amount", Type: indigo.Int{}}},
+	}
+	return &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"large": {ID: "large", Schema: schema, Expr: "amount > " + threshold},
+			"small": {ID: "small", Schema: schema, Expr: "amount < 10"},
+		},
+	}
+}
+
+func TestStore(t *testing.T) {
+	is := is.New(t)
+
+	client := newFakeClient()
+	s := redis.NewStore(client, "test:")
+
+	_, _, err := s.Load(context.Background(), "root")
+	is.True(errors.Is(err, indigo.ErrRuleNotFound))
+
+	v1, err := s.Save(context.Background(), makeRule("1000"))
+	is.NoErr(err)
+
+	r, version, err := s.Load(context.Background(), "root")
+	is.NoErr(err)
+	is.Equal(version, v1)
+	is.Equal(r.Rules["large"].Expr, "amount > 1000")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan string, 1)
+	done := make(chan error)
+	go func() {
+		done <- s.Watch(ctx, "root", func(r *indigo.Rule, version string) error {
+			changes <- r.Rules["large"].Expr
+			return nil
+		})
+	}()
+
+	// Wait for the subscription
+	for {
+		client.mu.Lock()
+		n := len(client.subs["test:changes:root"])
+		client.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	v2, err := s.Save(context.Background(), makeRule("500"))
+	is.NoErr(err)
+	is.True(v1 != v2)
+	is.Equal(<-changes, "amount > 500")
+
+	cancel()
+	is.NoErr(<-done)
+}
+
+func TestCache(t *testing.T) {
+	is := is.New(t)
+
+	client := newFakeClient()
+	e := indigo.NewEngine(cel.NewEvaluator())
+	r := makeRule("1000")
+	is.NoErr(e.Compile(r))
+
+	var cacheErr error
+	rc := redis.NewCache(client, "test:", r, "v1", func(err error) { cacheErr = err })
+	c, err := e.Cached(r, rc, time.Minute)
+	is.NoErr(err)
+
+	d := map[string]interface{}{"amount": 5000}
+	u1, err := c.Eval(context.Background(), d)
+	is.NoErr(err)
+	is.Equal(len(client.values), 1)
+
+	u2, err := c.Eval(context.Background(), d)
+	is.NoErr(err)
+	is.True(u1 != u2) // decoded from the cache
+	is.True(u2.Results["large"].Pass)
+	is.True(!u2.Results["small"].Pass)
+	is.Equal(u2.Results["large"].Rule, r.Rules["large"])
+	is.Equal(u2.OrderedResults[0].Rule.ID, "large")
+	is.Equal(u2.EvaluationCount, u1.EvaluationCount)
+	is.NoErr(cacheErr)
+
+	// A new version of the rules does not use the old results
+	r2 := makeRule("10000")
+	is.NoErr(e.Compile(r2))
+	rc.SetVersion(r2, "v2")
+	c, err = e.Cached(r2, rc, time.Minute)
+	is.NoErr(err)
+	u3, err := c.Eval(context.Background(), d)
+	is.NoErr(err)
+	is.True(!u3.Results["large"].Pass)
+	is.Equal(len(client.values), 2)
+}
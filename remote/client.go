@@ -0,0 +1,178 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ezachrisen/indigo"
+)
+
+// Client is an indigo.Engine that evaluates rules held by a rules service
+// (see Handler). Rules are identified by their IDs: the expressions and options
+// of the rules passed to Compile and Eval are those of the service, not the client.
+//
+// The results returned by Eval refer to the rules passed to Eval, or, for child
+// rules that are not among the children of that rule, to rules holding only the ID.
+// Values are decoded from JSON, so numbers are float64, and Diagnostics are not
+// returned. Errors evaluating the rule are returned as *indigo.EvalError, and rules
+// that the service does not hold fail with an error wrapping indigo.ErrRuleNotFound.
+type Client struct {
+	url  string
+	http *http.Client
+}
+
+// NewClient returns a client of the rules service at the URL, such as
+// "http://rules.internal/rules", using the HTTP client c, or http.DefaultClient if c is nil.
+func NewClient(url string, c *http.Client) *Client {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	return &Client{url: strings.TrimSuffix(url, "/"), http: c}
+}
+
+// Compile checks that the service holds the rule with the ID of r. The rule is not
+// compiled by the client; the compilation options are ignored.
+func (c *Client) Compile(r *indigo.Rule, opts ...indigo.CompilationOption) error {
+	if r == nil {
+		return indigo.ErrNilRule
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.url+"/rule/"+url.PathEscape(r.ID), nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req, r.ID)
+	return err
+}
+
+// Eval evaluates the rule with the ID of r, held by the service, with the data d.
+// The options opts are sent to the service, except OnResult and SortFunc, which
+// cannot be sent; OnResult is called with the results on the client.
+func (c *Client) Eval(ctx context.Context, r *indigo.Rule, d map[string]interface{}, opts ...indigo.EvalOption) (*indigo.Result, error) {
+	switch {
+	case r == nil:
+		return nil, indigo.ErrNilRule
+	case d == nil:
+		return nil, indigo.ErrNilData
+	}
+
+	o, err := encodeOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("encoding options: %w", err)
+	}
+	body, err := json.Marshal(evalRequest{RuleID: r.ID, Data: d, Options: o})
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/eval", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(req, r.ID)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Result == nil {
+		return nil, fmt.Errorf("rule %s: no result from the rules service", r.ID)
+	}
+
+	eo := indigo.EvalOptions{}
+	for _, opt := range opts {
+		opt(&eo)
+	}
+	return decodeResult(resp.Result, r, eo.OnResult), nil
+}
+
+// do sends the request about the rule with the id, and decodes the response
+func (c *Client) do(req *http.Request, id string) (*evalResponse, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	er := &evalResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(er); err != nil {
+		return nil, fmt.Errorf("decoding response (%s): %w", resp.Status, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return er, nil
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("rule %s: %w", id, indigo.ErrRuleNotFound)
+	case http.StatusServiceUnavailable:
+		return nil, fmt.Errorf("rules service: %w", indigo.ErrEngineClosed)
+	case http.StatusUnprocessableEntity:
+		if er.RuleID != "" {
+			id = er.RuleID
+		}
+		return nil, &indigo.EvalError{RuleID: id, Err: errors.New(er.Error)}
+	}
+	return nil, fmt.Errorf("rules service (%s): %s", resp.Status, er.Error)
+}
+
+// decodeResult returns the result x of the rule r, or of a rule with the ID of x if r
+// is nil, calling onResult, if not nil, with each result, children first
+func decodeResult(x *result, r *indigo.Rule, onResult func(*indigo.Result)) *indigo.Result {
+	if r == nil || r.ID != x.RuleID {
+		r = &indigo.Rule{ID: x.RuleID}
+	}
+
+	u := &indigo.Result{
+		Rule:           r,
+		Metadata:       &r.Metadata,
+		Pass:           x.Pass,
+		Status:         x.Status,
+		Verdict:        x.Verdict,
+		Variant:        x.Variant,
+		Value:          x.Value,
+		Outputs:        x.Outputs,
+		Message:        x.Message,
+		ChildrenPassed: x.ChildrenPassed,
+		ChildrenFailed: x.ChildrenFailed,
+		Item:           x.Item,
+		Skipped:        x.Skipped,
+		Truncated:      x.Truncated,
+		Fallback:       x.Fallback,
+		EvalOptions:    x.EvalOptions,
+		Duration:       x.Duration,
+		Results:        make(map[string]*indigo.Result, len(x.Results)),
+	}
+	if x.Error != "" {
+		u.Error = errors.New(x.Error)
+	}
+
+	for _, cx := range x.Results {
+		// The results of a rule evaluated for each element of a list are its own
+		child := r
+		if cx.RuleID != r.ID {
+			child = r.Rules[cx.RuleID]
+			if child == nil {
+				child = r.ElseRules[cx.RuleID]
+			}
+		}
+		cu := decodeResult(cx, child, onResult)
+
+		key := cx.Key
+		if key == "" {
+			key = cx.RuleID
+		}
+		u.Results[key] = cu
+		u.OrderedResults = append(u.OrderedResults, cu)
+	}
+
+	if onResult != nil {
+		onResult(u)
+	}
+	return u
+}
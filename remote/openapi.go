@@ -0,0 +1,202 @@
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/ezachrisen/indigo"
+)
+
+// openAPIVersion is the version of the OpenAPI specification produced by OpenAPI;
+// it uses JSON Schema draft 2020-12, like indigo.Schema.JSONSchema
+const openAPIVersion = "3.1.0"
+
+// OpenAPI returns the OpenAPI specification, encoded as JSON, of the API served by
+// the Handler for the rules in the vault v, so that client teams can generate typed
+// clients. It describes an evaluation endpoint for each rule, POST /eval/{id}, whose
+// input data is described by the rule's schema (see indigo.Schema.JSONSchema), as
+// well as the endpoints described in the package documentation. The handler serves
+// the specification at GET /openapi.json.
+//
+// The specification describes the rules at the time it is generated; generate it
+// again when rules or schemas change.
+func OpenAPI(v *indigo.Vault, title, version string) ([]byte, error) {
+	paths := map[string]interface{}{
+		"/rule/{id}": map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": "getRule",
+				"summary":     "Check that a rule exists",
+				"parameters": []interface{}{map[string]interface{}{
+					"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+				}},
+				"responses": responses(nil),
+			},
+		},
+		"/eval": map[string]interface{}{
+			"post": map[string]interface{}{
+				"operationId": "eval",
+				"summary":     "Evaluate a rule by ID",
+				"requestBody": body(map[string]interface{}{
+					"type":     "object",
+					"required": []string{"rule_id", "data"},
+					"properties": map[string]interface{}{
+						"rule_id": map[string]interface{}{"type": "string"},
+						"data":    map[string]interface{}{"type": "object"},
+						"options": ref("Options"),
+					},
+				}),
+				"responses": responses(ref("EvalResponse")),
+			},
+		},
+	}
+	schemas := map[string]interface{}{
+		"Result":       resultSchema,
+		"Options":      map[string]interface{}{"type": "object", "description": "The evaluation options, as the JSON fields of indigo.EvalOptions"},
+		"Error":        errorSchema,
+		"EvalResponse": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"result": ref("Result")}},
+	}
+
+	var err error
+	v.Walk(func(r *indigo.Rule, _ int) bool {
+		name := "Data_" + componentName(r.ID)
+		if r.Schema.ID != "" {
+			name = "Data_" + componentName(r.Schema.ID)
+		}
+		if _, ok := schemas[name]; !ok {
+			var s interface{}
+			if s, err = dataSchema(r.Schema); err != nil {
+				err = fmt.Errorf("rule %s: %w", r.ID, err)
+				return false
+			}
+			schemas[name] = s
+		}
+
+		summary := "Evaluate rule " + r.ID
+		if r.Metadata.Description != "" {
+			summary = r.Metadata.Description
+		}
+		paths["/eval/"+r.ID] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"operationId": "eval_" + componentName(r.ID),
+				"summary":     summary,
+				"requestBody": body(map[string]interface{}{
+					"type":     "object",
+					"required": []string{"data"},
+					"properties": map[string]interface{}{
+						"data":    ref(name),
+						"options": ref("Options"),
+					},
+				}),
+				"responses": responses(ref("EvalResponse")),
+			},
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"openapi":    openAPIVersion,
+		"info":       map[string]interface{}{"title": title, "version": version},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}, "", "  ")
+}
+
+// dataSchema returns the JSON Schema of the input data of the schema s, for
+// inclusion in the specification
+func dataSchema(s indigo.Schema) (map[string]interface{}, error) {
+	b, err := s.JSONSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	// Identifiers of the schema would change how references are resolved
+	delete(m, "$schema")
+	delete(m, "$id")
+	return m, nil
+}
+
+// invalidName matches the characters not allowed in the names of components
+var invalidName = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// componentName returns the id with the characters not allowed in the names of
+// components replaced
+func componentName(id string) string {
+	return invalidName.ReplaceAllString(id, "_")
+}
+
+// ref returns a reference to the schema component with the name
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// body returns a JSON request body with the schema
+func body(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"required": true,
+		"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}},
+	}
+}
+
+// responses returns the responses of an endpoint whose successful response has
+// the schema ok, or any JSON object if ok is nil
+func responses(ok interface{}) map[string]interface{} {
+	if ok == nil {
+		ok = map[string]interface{}{"type": "object"}
+	}
+	content := func(schema interface{}) map[string]interface{} {
+		return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+	}
+
+	return map[string]interface{}{
+		"200": map[string]interface{}{"description": "Success", "content": content(ok)},
+		"400": map[string]interface{}{"description": "The request cannot be decoded", "content": content(ref("Error"))},
+		"404": map[string]interface{}{"description": "The rule does not exist", "content": content(ref("Error"))},
+		"422": map[string]interface{}{"description": "The rule cannot be evaluated with the data", "content": content(ref("Error"))},
+		"503": map[string]interface{}{"description": "The service is shutting down", "content": content(ref("Error"))},
+	}
+}
+
+// errorSchema is the schema of the response to a request that failed
+var errorSchema = map[string]interface{}{
+	"type":     "object",
+	"required": []string{"error"},
+	"properties": map[string]interface{}{
+		"error":   map[string]interface{}{"type": "string"},
+		"rule_id": map[string]interface{}{"type": "string", "description": "The rule that could not be evaluated"},
+	},
+}
+
+// resultSchema is the schema of a result
+var resultSchema = map[string]interface{}{
+	"type":     "object",
+	"required": []string{"rule_id", "pass", "status"},
+	"properties": map[string]interface{}{
+		"rule_id":         map[string]interface{}{"type": "string"},
+		"key":             map[string]interface{}{"type": "string", "description": "The key of the result in its parent's results, if not the rule ID"},
+		"pass":            map[string]interface{}{"type": "boolean"},
+		"status":          map[string]interface{}{"type": "integer", "description": "The indigo.Status of the rule"},
+		"verdict":         map[string]interface{}{"type": "string"},
+		"variant":         map[string]interface{}{"type": "string"},
+		"value":           map[string]interface{}{},
+		"outputs":         map[string]interface{}{"type": "object"},
+		"message":         map[string]interface{}{"type": "string"},
+		"error":           map[string]interface{}{"type": "string"},
+		"children_passed": map[string]interface{}{"type": "integer"},
+		"children_failed": map[string]interface{}{"type": "integer"},
+		"item":            map[string]interface{}{},
+		"skipped":         map[string]interface{}{"type": "boolean"},
+		"truncated":       map[string]interface{}{"type": "boolean"},
+		"fallback":        map[string]interface{}{"type": "boolean", "description": "The rule could not be evaluated, and the result is its fallback"},
+		"eval_options":    ref("Options"),
+		"duration":        map[string]interface{}{"type": "integer", "description": "Nanoseconds"},
+		"results":         map[string]interface{}{"type": "array", "items": ref("Result")},
+	},
+}
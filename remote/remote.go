@@ -0,0 +1,295 @@
+// Package remote evaluates rules held by a rules service over HTTP, so that
+// application code can switch between in-process and remote evaluation without
+// changes: the Client implements the indigo.Engine interface.
+//
+// The service serves the rules of a vault with the Handler:
+//
+//     v, _ := indigo.NewVault(indigo.NewEngine(cel.NewEvaluator()), root)
+//     http.Handle("/rules/", http.StripPrefix("/rules", remote.NewHandler(v)))
+//
+// and applications evaluate them, by rule ID, with a client:
+//
+//     var e indigo.Engine = remote.NewClient("http://rules.internal/rules", nil)
+//     u, err := e.Eval(ctx, &indigo.Rule{ID: "fraud"}, data)
+//
+// The protocol is JSON over HTTP:
+//
+//     GET  /rule/{id}      200 if the rule exists, 404 if not
+//     POST /eval           evaluates {"rule_id": ..., "data": {...}, "options": {...}},
+//                          returning {"result": {...}} or {"error": ..., "rule_id": ...}
+//     POST /eval/{id}      evaluates the rule with the id, like /eval
+//     GET  /openapi.json   the OpenAPI specification of the API (see OpenAPI)
+//
+// Requests larger than 10 MB, and requests that cannot be decoded, including their
+// options, fail with status 400.
+//
+// The input data is converted to the types of the rule's schema by the service
+// (see indigo.Schema.Coerce), so timestamps are sent as RFC 3339 strings and
+// durations as strings such as "1h30m".
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ezachrisen/indigo"
+)
+
+// evalRequest is the body of a request to evaluate a rule
+type evalRequest struct {
+	RuleID string                 `json:"rule_id"`
+	Data   map[string]interface{} `json:"data"`
+
+	// The evaluation options set by the caller, as encoded by the fields of
+	// indigo.EvalOptions; options that are not set are omitted
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// evalResponse is the body of the response to an evaluation request
+type evalResponse struct {
+	Result *result `json:"result,omitempty"`
+	Error  string  `json:"error,omitempty"`
+
+	// The ID of the rule that could not be evaluated, if the error is an indigo.EvalError
+	RuleID string `json:"rule_id,omitempty"`
+}
+
+// result is an indigo.Result, encoded for the client
+type result struct {
+	RuleID         string                 `json:"rule_id"`
+	Key            string                 `json:"key,omitempty"`
+	Pass           bool                   `json:"pass"`
+	Status         indigo.Status          `json:"status"`
+	Verdict        indigo.Severity        `json:"verdict,omitempty"`
+	Variant        string                 `json:"variant,omitempty"`
+	Value          interface{}            `json:"value,omitempty"`
+	Outputs        map[string]interface{} `json:"outputs,omitempty"`
+	Message        string                 `json:"message,omitempty"`
+	Error          string                 `json:"error,omitempty"`
+	ChildrenPassed int                    `json:"children_passed,omitempty"`
+	ChildrenFailed int                    `json:"children_failed,omitempty"`
+	Item           interface{}            `json:"item,omitempty"`
+	Skipped        bool                   `json:"skipped,omitempty"`
+	Truncated      bool                   `json:"truncated,omitempty"`
+	Fallback       bool                   `json:"fallback,omitempty"`
+	EvalOptions    indigo.EvalOptions     `json:"eval_options"`
+	Duration       time.Duration          `json:"duration,omitempty"`
+
+	// The child results, in the order of Result.OrderedResults
+	Results []*result `json:"results,omitempty"`
+}
+
+// maxRequestSize is the size of the largest evaluation request served, in bytes
+const maxRequestSize = 10 << 20
+
+// Handler serves the rules of a vault to clients (see NewHandler).
+type Handler struct {
+	v *indigo.Vault
+}
+
+// NewHandler returns a handler serving the rules of the vault v.
+func NewHandler(v *indigo.Vault) *Handler {
+	return &Handler{v: v}
+}
+
+// ServeHTTP serves a request of the protocol described in the package documentation.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/rule/"):
+		h.serveRule(w, strings.TrimPrefix(req.URL.Path, "/rule/"))
+	case req.Method == http.MethodPost && req.URL.Path == "/eval":
+		h.serveEval(w, req, "")
+	case req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, "/eval/"):
+		h.serveEval(w, req, strings.TrimPrefix(req.URL.Path, "/eval/"))
+	case req.Method == http.MethodGet && req.URL.Path == "/openapi.json":
+		h.serveOpenAPI(w)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// serveRule responds whether the rule with the id exists
+func (h *Handler) serveRule(w http.ResponseWriter, id string) {
+	if _, err := h.v.Rule(id); err != nil {
+		writeJSON(w, statusOf(err), evalResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		ID string `json:"id"`
+	}{id})
+}
+
+// serveOpenAPI responds with the OpenAPI specification of the API (see OpenAPI)
+func (h *Handler) serveOpenAPI(w http.ResponseWriter) {
+	b, err := OpenAPI(h.v, "Rules", "1")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, evalResponse{Error: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// serveEval evaluates the rule requested, or the rule with the id if it is not blank
+func (h *Handler) serveEval(w http.ResponseWriter, req *http.Request, id string) {
+	er := evalRequest{}
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRequestSize))
+	dec.UseNumber()
+	if err := dec.Decode(&er); err != nil {
+		writeJSON(w, http.StatusBadRequest, evalResponse{Error: fmt.Sprintf("decoding request: %v", err)})
+		return
+	}
+	if len(er.Options) > 0 {
+		if err := json.Unmarshal(er.Options, &indigo.EvalOptions{}); err != nil {
+			writeJSON(w, http.StatusBadRequest, evalResponse{Error: fmt.Sprintf("decoding options: %v", err)})
+			return
+		}
+	}
+	if id != "" {
+		er.RuleID = id
+	}
+	if er.Data == nil {
+		er.Data = map[string]interface{}{}
+	}
+
+	opts := []indigo.EvalOption{indigo.CoerceData(true)}
+	if len(er.Options) > 0 {
+		opts = append(opts, func(f *indigo.EvalOptions) {
+			// Only the options set by the caller are in the request; they were
+			// decoded above, so the error is nil
+			_ = json.Unmarshal(er.Options, f)
+		})
+	}
+
+	u, err := h.v.Eval(req.Context(), er.RuleID, er.Data, opts...)
+	var ee *indigo.EvalError
+	switch {
+	case errors.As(err, &ee):
+		writeJSON(w, statusOf(err), evalResponse{Error: ee.Err.Error(), RuleID: ee.RuleID})
+		return
+	case err != nil:
+		writeJSON(w, statusOf(err), evalResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, evalResponse{Result: encodeResult(u, "")})
+}
+
+// statusOf returns the HTTP status of the response to a request that failed with err
+func statusOf(err error) int {
+	var ee *indigo.EvalError
+	switch {
+	case errors.Is(err, indigo.ErrRuleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, indigo.ErrEngineClosed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &ee):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// writeJSON writes the response v with the HTTP status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// encodeResult encodes the result u, stored under the key in its parent's results
+func encodeResult(u *indigo.Result, key string) *result {
+	x := &result{
+		Pass:           u.Pass,
+		Status:         u.Status,
+		Verdict:        u.Verdict,
+		Variant:        u.Variant,
+		Value:          u.Value,
+		Outputs:        u.Outputs,
+		Message:        u.Message,
+		ChildrenPassed: u.ChildrenPassed,
+		ChildrenFailed: u.ChildrenFailed,
+		Item:           u.Item,
+		Skipped:        u.Skipped,
+		Truncated:      u.Truncated,
+		Fallback:       u.Fallback,
+		EvalOptions:    u.EvalOptions,
+		Duration:       u.Duration,
+	}
+	if u.Rule != nil {
+		x.RuleID = u.Rule.ID
+	}
+	if key != x.RuleID {
+		x.Key = key
+	}
+	if u.Error != nil {
+		x.Error = u.Error.Error()
+	}
+
+	keys := make(map[*indigo.Result]string, len(u.Results))
+	for k, c := range u.Results {
+		keys[c] = k
+	}
+	for _, c := range u.OrderedResults {
+		x.Results = append(x.Results, encodeResult(c, keys[c]))
+	}
+	return x
+}
+
+// encodeOptions returns the evaluation options set by opts, encoded as the fields of
+// indigo.EvalOptions. An option is set if applying opts gives the field the same
+// value whatever its value was before.
+func encodeOptions(opts []indigo.EvalOption) (json.RawMessage, error) {
+	if len(opts) == 0 {
+		return nil, nil
+	}
+
+	var zero, other indigo.EvalOptions
+	setAll(reflect.ValueOf(&other).Elem())
+	for _, opt := range opts {
+		opt(&zero)
+		opt(&other)
+	}
+
+	set := map[string]interface{}{}
+	zv, ov := reflect.ValueOf(zero), reflect.ValueOf(other)
+	for i := 0; i < zv.NumField(); i++ {
+		name := strings.Split(zv.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if reflect.DeepEqual(zv.Field(i).Interface(), ov.Field(i).Interface()) {
+			set[name] = zv.Field(i).Interface()
+		}
+	}
+	if len(set) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(set)
+}
+
+// setAll sets the fields of the struct v that can be encoded to values other
+// than their zero values
+func setAll(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Bool:
+			f.SetBool(true)
+		case reflect.Int:
+			f.SetInt(-1)
+		case reflect.String:
+			f.SetString("\x00")
+		case reflect.Struct:
+			if _, ok := f.Interface().(time.Time); ok {
+				f.Set(reflect.ValueOf(time.Unix(1, 0)))
+			}
+		}
+	}
+}
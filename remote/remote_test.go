@@ -0,0 +1,132 @@
+package remote_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/cel"
+	"github.com/ezachrisen/indigo/remote"
+	"github.com/matryer/is"
+)
+
+func TestClient(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{{Name: "amount", Type: indigo.Int{}}},
+	}
+	root := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"large": {ID: "large", Schema: schema, Expr: "amount > 1000", Metadata: indigo.RuleMetadata{Severity: indigo.SeverityDeny}},
+			"small": {ID: "small", Schema: schema, Expr: "amount < 10"},
+			"ratio": {ID: "ratio", Schema: schema, Expr: "100 / amount > 1"},
+		},
+	}
+
+	v, err := indigo.NewVault(indigo.NewEngine(cel.NewEvaluator()), root)
+	is.NoErr(err)
+	srv := httptest.NewServer(remote.NewHandler(v))
+	defer srv.Close()
+
+	var e indigo.Engine = remote.NewClient(srv.URL, srv.Client())
+	r := &indigo.Rule{ID: "root"}
+	is.NoErr(e.Compile(r))
+	is.True(errors.Is(e.Compile(&indigo.Rule{ID: "nope"}), indigo.ErrRuleNotFound))
+
+	var reported []string
+	u, err := e.Eval(context.Background(), r, map[string]interface{}{"amount": 5000},
+		indigo.DiscardFail(true), indigo.OnResult(func(u *indigo.Result) {
+			reported = append(reported, u.Rule.ID)
+		}))
+	is.NoErr(err)
+	is.True(u.Rule == r)
+	is.Equal(u.Verdict, indigo.SeverityDeny)
+	is.Equal(len(u.Results), 1) // the failed rules are discarded by the service
+	is.True(u.Results["large"].Pass)
+	is.Equal(reported, []string{"large", "root"})
+
+	// Evaluation errors
+	_, err = e.Eval(context.Background(), r, map[string]interface{}{"amount": 0})
+	var ee *indigo.EvalError
+	is.True(errors.As(err, &ee))
+	is.Equal(ee.RuleID, "ratio")
+
+	_, err = e.Eval(context.Background(), &indigo.Rule{ID: "nope"}, map[string]interface{}{})
+	is.True(errors.Is(err, indigo.ErrRuleNotFound))
+}
+
+func TestHandlerBadRequests(t *testing.T) {
+	is := is.New(t)
+
+	root := &indigo.Rule{ID: "root", Expr: "true"}
+	v, err := indigo.NewVault(indigo.NewEngine(cel.NewEvaluator()), root)
+	is.NoErr(err)
+	srv := httptest.NewServer(remote.NewHandler(v))
+	defer srv.Close()
+
+	post := func(body string) int {
+		res, err := srv.Client().Post(srv.URL+"/eval/root", "application/json", strings.NewReader(body))
+		is.NoErr(err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	is.Equal(post(`{"data": {}}`), http.StatusOK)
+	is.Equal(post(`{"data": {}, "options": {"discard_fail": "yes"}}`), http.StatusBadRequest)   // malformed options
+	is.Equal(post(`{"data": {"x": "`+strings.Repeat("x", 11<<20)+`"}}`), http.StatusBadRequest) // too large
+}
+
+func TestOpenAPI(t *testing.T) {
+	is := is.New(t)
+
+	schema := indigo.Schema{
+		ID:       "orders",
+		Elements: []indigo.DataElement{{Name: "amount", Type: indigo.Int{}}, {Name: "at", Type: indigo.Timestamp{}}},
+	}
+	root := &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"large": {ID: "large", Schema: schema, Expr: "amount > 1000"},
+		},
+	}
+	v, err := indigo.NewVault(indigo.NewEngine(cel.NewEvaluator()), root)
+	is.NoErr(err)
+
+	srv := httptest.NewServer(remote.NewHandler(v))
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/openapi.json")
+	is.NoErr(err)
+	defer resp.Body.Close()
+
+	spec := struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+		Comps   struct {
+			Schemas map[string]map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}{}
+	is.NoErr(json.NewDecoder(resp.Body).Decode(&spec))
+	is.Equal(spec.OpenAPI, "3.1.0")
+	is.True(spec.Paths["/eval/large"]["post"] != nil)
+	is.True(spec.Paths["/eval/root"]["post"] != nil)
+
+	data := spec.Comps.Schemas["Data_orders"]
+	is.Equal(data["type"], "object")
+	is.Equal(data["properties"].(map[string]interface{})["at"].(map[string]interface{})["format"], "date-time")
+
+	// The endpoint of a rule evaluates it
+	resp, err = srv.Client().Post(srv.URL+"/eval/large", "application/json",
+		strings.NewReader(`{"data": {"amount": 5000, "at": "2021-01-01T00:00:00Z"}}`))
+	is.NoErr(err)
+	defer resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusOK)
+}
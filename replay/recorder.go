@@ -0,0 +1,135 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+
+	"github.com/ezachrisen/indigo"
+)
+
+// RecorderOption is a functional option to specify the behavior of a recorder.
+type RecorderOption func(rc *recorder)
+
+// SampleRate specifies the fraction of evaluations recorded, between 0 and 1,
+// chosen at random. Default: 1 (all evaluations)
+func SampleRate(f float64) RecorderOption {
+	return func(rc *recorder) {
+		rc.rate = f
+	}
+}
+
+// RecordID specifies the function returning the ID of a record, such as a request
+// ID from the context. Default: no ID
+func RecordID(f func(ctx context.Context, r *indigo.Rule, d map[string]interface{}) string) RecorderOption {
+	return func(rc *recorder) {
+		rc.id = f
+	}
+}
+
+// OnRecordError specifies the function called when an evaluation cannot be recorded,
+// such as when the input data cannot be encoded as JSON, or the writer fails.
+// The evaluation itself is not affected. Default: the error is ignored
+func OnRecordError(f func(err error)) RecorderOption {
+	return func(rc *recorder) {
+		rc.onError = f
+	}
+}
+
+// Unredacted records the input data as is, including the values of sensitive data
+// elements (see indigo.DataElement.Sensitivity). Default: the values of sensitive
+// data elements are recorded as indigo.Redacted
+func Unredacted() RecorderOption {
+	return func(rc *recorder) {
+		rc.unredacted = true
+	}
+}
+
+// recorder holds the state of the Recorder middleware
+type recorder struct {
+	rate    float64
+	id      func(ctx context.Context, r *indigo.Rule, d map[string]interface{}) string
+	onError func(err error)
+
+	// Record the values of sensitive data elements
+	unredacted bool
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// Recorder returns middleware (see indigo.DefaultEngine.Use) that records evaluations
+// to w, one Record per line, in the format read by Replay, so that production traffic
+// can be replayed against changed rules:
+//
+//     f, _ := os.Create("evaluations.jsonl")
+//     e.Use(replay.Recorder(f, replay.SampleRate(0.01)))
+//
+// Each record holds the ID of the rule evaluated, the input data encoded as JSON, and
+// the outcome of the evaluation. The input data must be encodable as JSON, and decode
+// to the same data with the schema of the rule (see stream.JSONDecoder). Evaluations
+// that fail are not recorded. Records are written while the evaluation returns, and
+// writes to w are serialized; use a buffered writer if w is slow.
+//
+// The values of sensitive data elements are recorded as indigo.Redacted, unless the
+// Unredacted option is given, so rules referring to them may not replay to the
+// recorded outcome.
+func Recorder(w io.Writer, opts ...RecorderOption) indigo.Middleware {
+	rc := &recorder{rate: 1, enc: json.NewEncoder(w)}
+	for _, opt := range opts {
+		opt(rc)
+	}
+
+	return func(next indigo.Evaluator) indigo.Evaluator {
+		return indigo.EvaluatorFunc(func(ctx context.Context, r *indigo.Rule, d map[string]interface{}, opts ...indigo.EvalOption) (*indigo.Result, error) {
+			u, err := next.Eval(ctx, r, d, opts...)
+			if err == nil && u != nil && r != nil && rc.sampled() {
+				rc.record(ctx, r, d, u)
+			}
+			return u, err
+		})
+	}
+}
+
+// sampled reports whether to record an evaluation
+func (rc *recorder) sampled() bool {
+	return rc.rate >= 1 || (rc.rate > 0 && rand.Float64() < rc.rate)
+}
+
+// record writes the record of the evaluation of r with the data d, and the result u
+func (rc *recorder) record(ctx context.Context, r *indigo.Rule, d map[string]interface{}, u *indigo.Result) {
+	rec := Record{
+		RuleID:  r.ID,
+		Outcome: NewOutcome(u),
+	}
+	if rc.id != nil {
+		rec.ID = rc.id(ctx, r, d)
+	}
+
+	if !rc.unredacted {
+		d = r.Schema.Redact(d)
+	}
+	in, err := json.Marshal(d)
+	if err != nil {
+		rc.fail(fmt.Errorf("recording %s: encoding input: %w", r.ID, err))
+		return
+	}
+	rec.Input = in
+
+	rc.mu.Lock()
+	err = rc.enc.Encode(rec)
+	rc.mu.Unlock()
+	if err != nil {
+		rc.fail(fmt.Errorf("recording %s: %w", r.ID, err))
+	}
+}
+
+// fail reports an error recording an evaluation
+func (rc *recorder) fail(err error) {
+	if rc.onError != nil {
+		rc.onError(err)
+	}
+}
@@ -0,0 +1,257 @@
+// Package replay backtests changes to rules: it evaluates recorded inputs against
+// the current rules, and reports the decisions that differ from the recorded ones.
+//
+// Recordings are JSON lines, one Record per line, such as:
+//
+//     {"id":"order-1","input":{"amount":5000,"country":"SE"},"outcome":{"pass":{"root":true,"large":true},"verdict":"warn"}}
+//
+// Produce them in production with the Recorder middleware, which records a sample of
+// the evaluations of an engine:
+//
+//     e.Use(replay.Recorder(w, replay.SampleRate(0.01)))
+//
+// or by recording the input of each evaluation together with the outcome returned
+// by NewOutcome:
+//
+//     rec := replay.Record{ID: orderID, Input: inputJSON, Outcome: replay.NewOutcome(result)}
+//     json.NewEncoder(w).Encode(rec)
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/stream"
+)
+
+// Record is a recorded evaluation.
+type Record struct {
+	// An identifier of the record, such as a request ID. Optional.
+	ID string `json:"id,omitempty"`
+
+	// The ID of the rule evaluated. Optional; if set, Replay skips the record
+	// unless it is the ID of the rule replayed.
+	RuleID string `json:"rule_id,omitempty"`
+
+	// The input data of the evaluation
+	Input json.RawMessage `json:"input"`
+
+	// The recorded outcome of the evaluation
+	Outcome Outcome `json:"outcome"`
+}
+
+// Outcome is the part of a result compared by Replay.
+type Outcome struct {
+	// Whether each rule in the result passed, by rule ID
+	Pass map[string]bool `json:"pass"`
+
+	// The verdict of the result
+	Verdict indigo.Severity `json:"verdict,omitempty"`
+}
+
+// NewOutcome returns the outcome of the result u and its child results.
+func NewOutcome(u *indigo.Result) Outcome {
+	o := Outcome{
+		Pass:    map[string]bool{},
+		Verdict: u.Verdict,
+	}
+	addPass(u, o.Pass)
+	return o
+}
+
+// addPass adds the Pass of u and its children to m
+func addPass(u *indigo.Result, m map[string]bool) {
+	m[u.Rule.ID] = u.Pass
+	for _, c := range u.OrderedResults {
+		addPass(c, m)
+	}
+}
+
+// Report is the outcome of a replay.
+type Report struct {
+	// The number of records replayed, including records that could not be evaluated
+	Records int
+
+	// The number of records skipped, because they were recorded for another rule
+	Skipped int
+
+	// The number of records whose outcome changed
+	Changed int
+
+	// The differences between the recorded and the current outcomes, in the order
+	// of the records
+	Diffs []Diff
+
+	// The records that could not be decoded or evaluated
+	Errors []error
+}
+
+// Diff is a difference between the recorded and the current outcome of a rule.
+type Diff struct {
+	// The line number (starting at 1) and ID of the record
+	Line     int
+	RecordID string
+
+	// The ID of the rule whose outcome changed; blank if the verdict changed
+	RuleID string
+
+	// The recorded and current outcome: "pass" or "fail" for rules, the verdict for
+	// verdicts, or "missing" if the rule is not in the results
+	Was string
+	Now string
+}
+
+// String describes the difference.
+func (d Diff) String() string {
+	what := "verdict"
+	if d.RuleID != "" {
+		what = "rule " + d.RuleID
+	}
+	return fmt.Sprintf("line %d (%s): %s was %s, now %s", d.Line, d.RecordID, what, d.Was, d.Now)
+}
+
+// String summarizes the report, listing the differences.
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d records replayed, %d changed, %d errors", r.Records, r.Changed, len(r.Errors))
+	if r.Skipped > 0 {
+		fmt.Fprintf(&b, ", %d skipped", r.Skipped)
+	}
+	fmt.Fprintln(&b)
+	for _, d := range r.Diffs {
+		fmt.Fprintln(&b, d)
+	}
+	for _, err := range r.Errors {
+		fmt.Fprintln(&b, err)
+	}
+	return b.String()
+}
+
+// Replay evaluates the compiled rule r with the evaluator e against the input of each
+// record read from in, and compares the outcome with the recorded outcome.
+// Inputs are decoded with stream.JSONDecoder using the schema of the rule.
+// Records of another rule are skipped (see Record.RuleID), and records that cannot
+// be decoded or evaluated are reported in Report.Errors;
+// Replay only returns an error if the records cannot be read, or the context is canceled.
+func Replay(ctx context.Context, e indigo.Evaluator, r *indigo.Rule, in io.Reader, opts ...indigo.EvalOption) (*Report, error) {
+	if e == nil {
+		return nil, indigo.ErrNilEngine
+	}
+
+	if r == nil {
+		return nil, indigo.ErrNilRule
+	}
+
+	decode := stream.JSONDecoder(r.Schema)
+	rep := &Report{}
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for line := 1; sc.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return rep, err
+		}
+
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+
+		rec := Record{}
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			rep.Records++
+			rep.Errors = append(rep.Errors, fmt.Errorf("line %d: decoding record: %w", line, err))
+			continue
+		}
+
+		if rec.RuleID != "" && rec.RuleID != r.ID {
+			rep.Skipped++
+			continue
+		}
+		rep.Records++
+
+		d, err := decode(stream.Message{Value: rec.Input})
+		if err != nil {
+			rep.Errors = append(rep.Errors, fmt.Errorf("line %d (%s): decoding input: %w", line, rec.ID, err))
+			continue
+		}
+
+		u, err := e.Eval(ctx, r, d, opts...)
+		if err != nil {
+			rep.Errors = append(rep.Errors, fmt.Errorf("line %d (%s): %w", line, rec.ID, err))
+			continue
+		}
+
+		diffs := compare(rec.Outcome, NewOutcome(u))
+		for i := range diffs {
+			diffs[i].Line = line
+			diffs[i].RecordID = rec.ID
+		}
+		if len(diffs) > 0 {
+			rep.Changed++
+			rep.Diffs = append(rep.Diffs, diffs...)
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return rep, fmt.Errorf("reading records: %w", err)
+	}
+	return rep, nil
+}
+
+// compare returns the differences between the recorded and current outcomes,
+// sorted by rule ID, with the verdict first
+func compare(was, now Outcome) []Diff {
+	var diffs []Diff
+	if was.Verdict != now.Verdict {
+		diffs = append(diffs, Diff{Was: verdictString(was.Verdict), Now: verdictString(now.Verdict)})
+	}
+
+	ids := map[string]bool{}
+	for id := range was.Pass {
+		ids[id] = true
+	}
+	for id := range now.Pass {
+		ids[id] = true
+	}
+
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+
+	for _, id := range sorted {
+		w, n := passString(was.Pass, id), passString(now.Pass, id)
+		if w != n {
+			diffs = append(diffs, Diff{RuleID: id, Was: w, Now: n})
+		}
+	}
+	return diffs
+}
+
+// passString describes the outcome of the rule with the id
+func passString(m map[string]bool, id string) string {
+	pass, ok := m[id]
+	switch {
+	case !ok:
+		return "missing"
+	case pass:
+		return "pass"
+	default:
+		return "fail"
+	}
+}
+
+// verdictString describes a verdict
+func verdictString(v indigo.Severity) string {
+	if v == "" {
+		return "none"
+	}
+	return string(v)
+}
@@ -0,0 +1,152 @@
+package replay_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/cel"
+	"github.com/ezachrisen/indigo/replay"
+	"github.com/matryer/is"
+)
+
+func makeRule(threshold string) *indigo.Rule {
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{{Name: "amount", Type: indigo.Int{}}},
+	}
+	return &indigo.Rule{
+		ID:     "root",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"large": {
+				ID:       "large",
+				Schema:   schema,
+				Expr:     "amount > " + threshold,
+				Metadata: indigo.RuleMetadata{Severity: indigo.SeverityDeny},
+			},
+		},
+	}
+}
+
+func TestReplay(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	old := makeRule("1000")
+	is.NoErr(e.Compile(old))
+
+	// Record evaluations with the old rules
+	var rec bytes.Buffer
+	for i, input := range []string{`{"amount":500}`, `{"amount":5000}`, `{"amount":50000}`} {
+		d := map[string]interface{}{}
+		is.NoErr(json.Unmarshal([]byte(input), &d))
+		d["amount"] = int64(d["amount"].(float64))
+		u, err := e.Eval(context.Background(), old, d)
+		is.NoErr(err)
+		is.NoErr(json.NewEncoder(&rec).Encode(replay.Record{
+			ID:      string(rune('a' + i)),
+			Input:   json.RawMessage(input),
+			Outcome: replay.NewOutcome(u),
+		}))
+	}
+	rec.WriteString("\n{not json}\n")
+
+	// Replay them against the new rules
+	r := makeRule("10000")
+	is.NoErr(e.Compile(r))
+	rep, err := replay.Replay(context.Background(), e, r, strings.NewReader(rec.String()))
+	is.NoErr(err)
+	is.Equal(rep.Records, 4)
+	is.Equal(rep.Changed, 1)
+	is.Equal(len(rep.Errors), 1)
+	is.Equal(len(rep.Diffs), 2)
+	is.Equal(rep.Diffs[0].String(), "line 2 (b): verdict was deny, now none")
+	is.Equal(rep.Diffs[1].String(), "line 2 (b): rule large was pass, now fail")
+	is.True(strings.HasPrefix(rep.String(), "4 records replayed, 1 changed, 1 errors\n"))
+}
+
+func TestRecorder(t *testing.T) {
+	is := is.New(t)
+
+	var rec bytes.Buffer
+	e := indigo.NewEngine(cel.NewEvaluator())
+	e.Use(replay.Recorder(&rec, replay.RecordID(func(_ context.Context, _ *indigo.Rule, d map[string]interface{}) string {
+		return fmt.Sprint(d["amount"])
+	})))
+
+	old := makeRule("1000")
+	other := makeRule("1000")
+	other.ID = "other"
+	is.NoErr(e.Compile(old))
+	is.NoErr(e.Compile(other))
+
+	for _, amount := range []int64{500, 5000, 50000} {
+		_, err := e.Eval(context.Background(), old, map[string]interface{}{"amount": amount})
+		is.NoErr(err)
+	}
+	_, err := e.Eval(context.Background(), other, map[string]interface{}{"amount": int64(5000)})
+	is.NoErr(err)
+
+	// Replay the recorded traffic against the new rules
+	r := makeRule("10000")
+	is.NoErr(e.Compile(r))
+	rep, err := replay.Replay(context.Background(), e, r, strings.NewReader(rec.String()))
+	is.NoErr(err)
+	is.Equal(rep.Records, 3)
+	is.Equal(rep.Skipped, 1) // recorded for another rule
+	is.Equal(len(rep.Errors), 0)
+	is.Equal(rep.Changed, 1)
+	is.Equal(rep.Diffs[0].RecordID, "5000")
+
+	// Nothing is recorded with a sample rate of 0
+	var none bytes.Buffer
+	e = indigo.NewEngine(cel.NewEvaluator())
+	e.Use(replay.Recorder(&none, replay.SampleRate(0)))
+	is.NoErr(e.Compile(old))
+	_, err = e.Eval(context.Background(), old, map[string]interface{}{"amount": int64(5000)})
+	is.NoErr(err)
+	is.Equal(none.Len(), 0)
+}
+
+func TestRecorderRedaction(t *testing.T) {
+	is := is.New(t)
+
+	r := &indigo.Rule{
+		ID: "r",
+		Schema: indigo.Schema{Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "card", Type: indigo.String{}, Sensitivity: indigo.SensitivitySecret},
+		}},
+		Expr: `amount > 10 && card != ""`,
+	}
+	d := map[string]interface{}{"amount": int64(50), "card": "4111111111111111"}
+
+	for _, unredacted := range []bool{false, true} {
+		var rec bytes.Buffer
+		var opts []replay.RecorderOption
+		if unredacted {
+			opts = append(opts, replay.Unredacted())
+		}
+		e := indigo.NewEngine(cel.NewEvaluator())
+		e.Use(replay.Recorder(&rec, opts...))
+		is.NoErr(e.Compile(r))
+		_, err := e.Eval(context.Background(), r, d)
+		is.NoErr(err)
+
+		var got replay.Record
+		is.NoErr(json.Unmarshal(rec.Bytes(), &got))
+		var in map[string]interface{}
+		is.NoErr(json.Unmarshal(got.Input, &in))
+		is.Equal(in["amount"], 50.0)
+		if unredacted {
+			is.Equal(in["card"], "4111111111111111")
+		} else {
+			is.Equal(in["card"], indigo.Redacted)
+		}
+	}
+	is.Equal(d["card"], "4111111111111111") // not modified
+}
@@ -0,0 +1,171 @@
+package indigo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// BundleReport describes the outcome of compiling every rule in a bundle
+// without saving the results. Use it to vet a bundle before the rules are put into use.
+type BundleReport struct {
+	// One entry per rule, in depth-first order, with child rules sorted by ID,
+	// followed by the else rules. A nil child rule is reported, under its key,
+	// with an error wrapping ErrNilRule.
+	Rules []RuleReport
+
+	// Number of rules that failed to compile
+	Failed int
+
+	// Sum of the estimated maximum cost of evaluating all rules
+	TotalMaxCost int64
+
+	// Approximate size in bytes of all compiled rules
+	TotalSize int
+}
+
+// RuleReport describes the outcome of compiling a single rule.
+type RuleReport struct {
+	// The ID of the rule
+	RuleID string
+
+	// The depth of the rule in the tree; the root rule is 0
+	Depth int
+
+	// The compilation error, if the rule failed to compile
+	Err error
+
+	// Information about the compiled expression. Only available if the
+	// engine's evaluator implements ExpressionAnalyzer.
+	Info ExpressionInfo
+}
+
+// CheckBundle compiles all the rules in the bundle, without storing the results,
+// and reports the compilation status and resource use of each rule.
+// Unlike Compile, CheckBundle does not stop at the first rule that fails to compile.
+// An error is only returned if the bundle itself is invalid.
+func (e *DefaultEngine) CheckBundle(b RuleBundle) (*BundleReport, error) {
+	r, err := Import(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateCompileArguments(r, e); err != nil {
+		return nil, err
+	}
+
+	rep := &BundleReport{}
+	e.checkRule(r, 0, rep)
+	return rep, nil
+}
+
+// checkRule adds the report for r and its children to rep
+func (e *DefaultEngine) checkRule(r *Rule, depth int, rep *BundleReport) {
+	rr := RuleReport{
+		RuleID: r.ID,
+		Depth:  depth,
+	}
+
+	schema, err := e.exprSchemaOf(r)
+	a, analyze := e.e.(ExpressionAnalyzer)
+	switch {
+	case err != nil:
+		rr.Err = err
+	case analyze:
+		rr.Info, rr.Err = a.Analyze(r.Expr, schema, defaultResultType(r))
+	default:
+		_, rr.Err = e.e.Compile(r.Expr, schema, defaultResultType(r), false, true)
+	}
+
+	if rr.Err != nil {
+		rep.Failed++
+	}
+	rep.TotalMaxCost += rr.Info.MaxCost
+	rep.TotalSize += rr.Info.Size
+	rep.Rules = append(rep.Rules, rr)
+
+	for _, m := range []map[string]*Rule{r.Rules, r.ElseRules} {
+		for _, k := range sortedKeys(m) {
+			c := m[k]
+			if c == nil {
+				rep.Failed++
+				rep.Rules = append(rep.Rules, RuleReport{
+					RuleID: k,
+					Depth:  depth + 1,
+					Err:    fmt.Errorf("child %s of rule %s: %w", k, r.ID, ErrNilRule),
+				})
+				continue
+			}
+			e.checkRule(c, depth+1, rep)
+		}
+	}
+}
+
+// sortedKeys returns the keys of the rules in m, sorted by the IDs of the rules,
+// or by key for nil rules
+func sortedKeys(m map[string]*Rule) []string {
+	id := func(k string) string {
+		if m[k] == nil {
+			return k
+		}
+		return m[k].ID
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return id(keys[i]) < id(keys[j])
+	})
+	return keys
+}
+
+// String produces a table listing the compilation status and resource use of each rule.
+func (rep *BundleReport) String() string {
+	tw := table.NewWriter()
+	tw.SetTitle("\nINDIGO BUNDLE REPORT\n")
+	tw.AppendHeader(table.Row{"\nRule", "\nStatus", "Max\nCost", "Size\n(bytes)", "\nComplexity", "\nVariables"})
+
+	for _, rr := range rep.Rules {
+		status := "OK"
+		switch {
+		case rr.Err != nil:
+			status = "ERROR: " + strings.ReplaceAll(rr.Err.Error(), "\n", " ")
+		case rr.Info.AlwaysTrue:
+			status = "WARNING: always true"
+		case rr.Info.AlwaysFalse:
+			status = "WARNING: always false"
+		}
+		tw.AppendRow(table.Row{
+			fmt.Sprintf("%s%s", strings.Repeat("  ", rr.Depth), rr.RuleID),
+			status,
+			rr.Info.MaxCost,
+			rr.Info.Size,
+			rr.Info.Complexity.Score(),
+			strings.Join(rr.Info.ReferencedVariables, ", "),
+		})
+	}
+
+	tw.AppendFooter(table.Row{
+		fmt.Sprintf("%d rules", len(rep.Rules)),
+		fmt.Sprintf("%d failed", rep.Failed),
+		rep.TotalMaxCost,
+		rep.TotalSize,
+		"",
+		"",
+	})
+
+	tw.SetColumnConfigs([]table.ColumnConfig{
+		{Number: 2, WidthMax: 60},
+	})
+
+	style := table.StyleLight
+	style.Format.Header = text.FormatDefault
+	style.Format.Footer = text.FormatDefault
+	tw.SetStyle(style)
+	return tw.Render()
+}
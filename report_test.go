@@ -0,0 +1,68 @@
+package indigo_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/matryer/is"
+)
+
+// Test that all rules are checked, even after a compilation failure,
+// and that nothing is stored on the rules
+func TestCheckBundle(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := makeRule()
+	r.Rules["B"].Rules["b2"].Expr = "invalid"
+	r.Rules["E"].Expr = "invalid"
+
+	b, err := indigo.Export(r)
+	is.NoErr(err)
+
+	rep, err := e.CheckBundle(b)
+	is.NoErr(err)
+	is.Equal(len(rep.Rules), 16)
+	is.Equal(rep.Failed, 2)
+	is.Equal(rep.Rules[0].RuleID, "rule1")
+	is.Equal(rep.Rules[1].RuleID, "B")
+	is.Equal(rep.Rules[1].Depth, 1)
+
+	for _, rr := range rep.Rules {
+		is.Equal(rr.Err != nil, rr.RuleID == "b2" || rr.RuleID == "E")
+	}
+	_ = rep.String()
+
+	is.Equal(b.Rule.Program, nil) // dry run; nothing is saved
+}
+
+// Test that nil child rules are reported, and else rules are checked
+func TestCheckBundleNilChild(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	r := &indigo.Rule{
+		ID:   "root",
+		Expr: "true",
+		Rules: map[string]*indigo.Rule{
+			"a": {ID: "a", Expr: "true"},
+		},
+		ElseRules: map[string]*indigo.Rule{
+			"z": {ID: "z", Expr: "invalid"},
+		},
+	}
+	b, err := indigo.Export(r)
+	is.NoErr(err)
+	b.Rule.Rules["missing"] = nil
+
+	rep, err := e.CheckBundle(b)
+	is.NoErr(err)
+	is.Equal(len(rep.Rules), 4)
+	is.Equal(rep.Failed, 2)
+	is.Equal(rep.Rules[2].RuleID, "missing")
+	is.True(errors.Is(rep.Rules[2].Err, indigo.ErrNilRule))
+	is.Equal(rep.Rules[3].RuleID, "z") // else rules are checked
+	is.True(rep.Rules[3].Err != nil)
+	_ = rep.String()
+}
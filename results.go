@@ -3,6 +3,7 @@ package indigo
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/jedib0t/go-pretty/v6/text"
@@ -13,6 +14,24 @@ type Result struct {
 	// The Rule that was evaluated
 	Rule *Rule
 
+	// The metadata of the rule that was evaluated
+	Metadata *RuleMetadata
+
+	// The highest severity (see RuleMetadata) of this rule and its descendants
+	// whose expressions passed. For example, if Verdict is SeverityDeny,
+	// at least one rule with SeverityDeny passed. Rules discarded from the results
+	// (see DiscardPass) are included.
+	Verdict Severity
+
+	// The outcome of the evaluation. Unlike Pass, Status distinguishes rules
+	// that failed from rules that could not be evaluated, were skipped, or
+	// yielded a value that is neither true nor false.
+	Status Status
+
+	// The ID of the child rule selected as the variant of the rule's
+	// experiment (see Rule.Experiment); blank if no variant was selected.
+	Variant string
+
 	// Whether the rule yielded a TRUE logical value.
 	// The default is TRUE
 	// By default, this is the result of evaluating THIS rule only.
@@ -26,15 +45,70 @@ type Result struct {
 	// This value is never affected by child rules, even if the RollupChildResults option is set.
 	Value interface{}
 
+	// The values of the rule's output expressions (see Rule.Outputs), by name.
+	// Nil if the rule did not pass, or has no outputs.
+	Outputs map[string]interface{}
+
+	// The rule's message (see Rule.Messages) rendered with the input data,
+	// in the locale requested with the Locale evaluation option.
+	// Blank if the rule has no message for the locale.
+	Message string
+
+	// The error encountered when evaluating the rule, if any.
+	// Only set when the ContinueOnError option is in effect on the parent rule,
+	// or when the rule's Fallback was used; otherwise errors are returned from Eval.
+	// A rule with an error has Pass = false, unless its Fallback passes.
+	Error error
+
+	// Fallback is true if the rule could not be evaluated, and the result is
+	// the rule's Fallback
+	Fallback bool
+
 	// Results of evaluating the child rules.
+	// Nil if the rule has no child rules.
+	// For a rule with ForEach, the results of evaluating the rule for each
+	// element of the list, by the element's index ("0", "1", ...).
 	Results map[string]*Result
 
-	// Diagnostic data; only available if you turn on diagnostics for the evaluation
+	// The numbers of child rules evaluated that passed, and that failed or could not
+	// be evaluated, including results discarded (see DiscardPass). Child rules that do
+	// not apply are not counted.
+	ChildrenPassed int
+	ChildrenFailed int
+
+	// The element of the list the rule was evaluated for, if this is the result
+	// of evaluating a rule with ForEach for one element.
+	Item interface{}
+
+	// Skipped is true if the rule was not evaluated because its parent
+	// failed and StopIfParentNegative was set. Skipped results are only
+	// returned with the RecordSkipped option.
+	Skipped bool
+
+	// Truncated is true if the child rules of this rule were not evaluated
+	// because the maximum depth (MaxDepth) was reached.
+	Truncated bool
+
+	// Results of evaluating the child rules, in the order they were evaluated.
+	// Contains the same results as the Results map; use it when a stable
+	// iteration order is needed, for example when rendering or comparing results.
+	OrderedResults []*Result
+
+	// Diagnostic data; only available if you turn on diagnostics for the evaluation.
+	// The values of sensitive data elements (see DataElement.Sensitivity) are redacted.
 	Diagnostics *Diagnostics
 
 	// The evaluation options used
 	EvalOptions EvalOptions
 
+	// Time taken to evaluate this rule and its children.
+	// Only available if you turn on timing for the evaluation (ReturnTiming).
+	Duration time.Duration
+
+	// The number of rules evaluated to produce this result: this rule plus all
+	// child rules evaluated, including those discarded from the results.
+	EvaluationCount int
+
 	// A list of the rules evaluated, in the order they were evaluated
 	// Only available if you turn on diagnostics for the evaluation
 	// This may be different from the rules represented in Results, if
@@ -66,10 +140,7 @@ func (u *Result) String() string {
 func (u *Result) resultsToRows(n int) []table.Row {
 	rows := []table.Row{}
 	indent := strings.Repeat("  ", n)
-	boolString := "PASS"
-	if !u.Pass {
-		boolString = "FAIL"
-	}
+	boolString := strings.ToUpper(u.Status.String())
 
 	diag := false
 	if u.Diagnostics != nil {
@@ -90,7 +161,7 @@ func (u *Result) resultsToRows(n int) []table.Row {
 	}
 
 	rows = append(rows, row)
-	for _, cd := range u.Results {
+	for _, cd := range u.OrderedResults {
 		rows = append(rows, cd.resultsToRows(n+1)...)
 	}
 	return rows
@@ -0,0 +1,95 @@
+package indigo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Route selects the engine that compiles and evaluates the rules matching the route
+// (see Router). A route with both a Prefix and a Tag matches rules with both.
+type Route struct {
+	// The prefix of the IDs of the rules matching the route, such as "tenant-a/"
+	Prefix string
+
+	// A tag of the rules matching the route (see RuleMetadata.Tags)
+	Tag string
+
+	// The engine of the rules matching the route
+	Engine Engine
+}
+
+// matches reports whether the rule r matches the route
+func (rt Route) matches(r *Rule) bool {
+	if rt.Prefix == "" && rt.Tag == "" {
+		return false
+	}
+	if rt.Prefix != "" && !strings.HasPrefix(r.ID, rt.Prefix) {
+		return false
+	}
+	return rt.Tag == "" || contains(r.Metadata.Tags, rt.Tag)
+}
+
+// Router is an Engine that sends each rule to one of several engines, such as
+// engines with different evaluators, backends or tenants, based on the rule's ID
+// or tags, presenting one engine to callers.
+//
+// The rule passed to Compile or Eval is routed as a whole: its child rules are
+// compiled and evaluated by the same engine. Since a rule is compiled for the
+// engine that evaluates it, the rule's ID and tags must not change between
+// compilation and evaluation.
+type Router struct {
+	routes   []Route
+	fallback Engine
+}
+
+// NewRouter returns a router that sends each rule to the engine of the first of the
+// routes that the rule matches, or to the fallback engine if it matches none.
+// If fallback is nil, rules that match no route fail with ErrNoRoute.
+func NewRouter(fallback Engine, routes ...Route) (*Router, error) {
+	for i, rt := range routes {
+		switch {
+		case rt.Engine == nil:
+			return nil, fmt.Errorf("route %d: %w", i, ErrNilEngine)
+		case rt.Prefix == "" && rt.Tag == "":
+			return nil, fmt.Errorf("route %d: no prefix or tag", i)
+		}
+	}
+	return &Router{routes: routes, fallback: fallback}, nil
+}
+
+// Compile compiles the rule and its children with the engine the rule is routed to.
+func (rt *Router) Compile(r *Rule, opts ...CompilationOption) error {
+	e, err := rt.Route(r)
+	if err != nil {
+		return err
+	}
+	return e.Compile(r, opts...)
+}
+
+// Eval evaluates the rule and its children with the engine the rule is routed to.
+func (rt *Router) Eval(ctx context.Context, r *Rule, d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+	e, err := rt.Route(r)
+	if err != nil {
+		return nil, err
+	}
+	return e.Eval(ctx, r, d, opts...)
+}
+
+// Route returns the engine the rule is routed to.
+func (rt *Router) Route(r *Rule) (Engine, error) {
+	if r == nil {
+		return nil, ErrNilRule
+	}
+
+	for _, route := range rt.routes {
+		if route.matches(r) {
+			return route.Engine, nil
+		}
+	}
+
+	if rt.fallback == nil {
+		return nil, fmt.Errorf("rule %s: %w", r.ID, ErrNoRoute)
+	}
+	return rt.fallback, nil
+}
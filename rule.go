@@ -1,6 +1,7 @@
 package indigo
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
@@ -47,42 +48,200 @@ type Rule struct {
 	// If the expression is blank, the result will be true.
 	Expr string `json:"expr"`
 
+	// Disabled turns the rule off: it is not evaluated, and it is left out of
+	// the results of its parent, along with its children.
+	// Use it to switch rules off without removing them.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// The environments (such as "dev", "stage" and "prod") in which the rule is
+	// evaluated, selected with the Environment evaluation option.
+	// If the list is empty, the rule is evaluated in all environments.
+	// Like a disabled rule, a rule that is not evaluated is left out of the results.
+	Environments []string `json:"environments,omitempty"`
+
+	// Rollout limits the evaluation of the rule to a percentage of the input data (optional).
+	// Like a disabled rule, a rule that is not evaluated is left out of the results.
+	Rollout *Rollout `json:"rollout,omitempty"`
+
+	// Experiment selects one of the child rules to evaluate, as the variant of
+	// an A/B experiment (optional). See Experiment.
+	Experiment *Experiment `json:"experiment,omitempty"`
+
+	// Quorum makes the rule pass only if at least, or at most, a number of its
+	// child rules pass (optional). See Quorum.
+	Quorum *Quorum `json:"quorum,omitempty"`
+
+	// Fallback is the result of the rule if it cannot be evaluated (optional).
+	// Without a fallback, an error evaluating the rule fails the evaluation,
+	// unless ContinueOnError is set on the parent. See Fallback.
+	Fallback *Fallback `json:"fallback,omitempty"`
+
+	// An expression that determines whether the rule applies to the input data (optional).
+	// The guard is evaluated before the expression, and must yield a boolean.
+	// If it is false, neither the expression nor the child rules are evaluated, and
+	// the rule's result has the status StatusNotApplicable, which is not counted as a
+	// failure by RollupChildResults or StopFirstNegativeChild.
+	// Use it to tell rules that did not apply from rules that were violated.
+	Guard string `json:"guard,omitempty"`
+
 	// The output type of the expression. Evaluators with the ability to check
 	// whether an expression produces the desired output should return an error
 	// if the expression does not.
 	// If no type is provided, evaluation and compilation will default to Bool
 	ResultType Type `json:"result_type,omitempty"`
 
+	// Output is the name of a fact the rule derives when evaluated with Chain.
+	// The rule's result (Pass for boolean rules, otherwise Value) is added to
+	// the input data under this name, so that other rules can refer to it.
+	// Not used by Eval.
+	Output string `json:"output,omitempty"`
+
+	// Named expressions computed when the rule passes, such as a fee, a tier and
+	// a reason, so that one rule can produce a complete decision. The values are
+	// returned in the Outputs field of the rule's result. The expressions may yield
+	// values of any type, and refer to the same data as the rule's expression.
+	Outputs map[string]string `json:"outputs,omitempty"`
+
 	// The schema describing the data provided in the Evaluate input. (optional)
 	// Some implementations of Evaluator require a schema.
 	Schema Schema `json:"schema,omitempty"`
 
-	// A reference to an object whose values can be used in the rule expression.
-	// Add the corresponding object in the data with the reserved key name selfKey
-	// (see constants).
-	// Child rules do not inherit the self value.
-	// See example for usage. TODO: example
+	// The ID of a schema registered with the engine (see DefaultEngine.RegisterSchema).
+	// If set, the registered schema replaces Schema when the rule is compiled, so
+	// that a change to the schema only requires registering it again and recompiling.
+	SchemaID string `json:"schema_id,omitempty"`
+
+	// A reference to an object whose values can be used in the rule's expression,
+	// guard and messages under the reserved name SelfKey.
+	//
+	// To give self a type, declare an element named SelfKey in the schema; the rule
+	// is then compiled with that type, and compilation fails with ErrSelfType if Self
+	// does not have it. If the schema does not declare SelfKey, self is declared as Any
+	// for the rules that have a Self value.
+	//
+	// During evaluation, each rule sees only its own Self: the engine evaluates the
+	// rule with a copy of the input data that holds Self under SelfKey, or that leaves
+	// SelfKey out if the rule has no Self. The input data is not modified, so the
+	// rule's parent, siblings and children, which do not inherit the value, never see it.
 	Self interface{} `json:"-"`
 
+	// The name of a list in the input data. If set, the rule is evaluated once for
+	// each element of the list, with the element available to the rule's expression,
+	// guard, messages and child rules under the reserved name ItemKey. The rule's
+	// Result holds the result for each element in Results, by the element's index,
+	// and passes if the rule passes for every element; an empty list passes.
+	//
+	// The rule's expression and guard are compiled with ItemKey declared as the value
+	// type of the list, if the schema declares it, or as Any. Child rules that refer to
+	// the element must declare ItemKey in their schemas.
+	// Use ForEach instead of generating one rule per element.
+	ForEach string `json:"for_each,omitempty"`
+
 	// A set of child rules.
 	Rules map[string]*Rule `json:"rules,omitempty"`
 
+	// A set of child rules evaluated only if the rule's expression is false,
+	// after the child rules in Rules (if they are evaluated). Together with
+	// StopIfParentNegative, use them to model if/else logic without repeating
+	// negated expressions in sibling rules. The results of the else rules are
+	// returned with the results of the other child rules, so the IDs of the rules
+	// in Rules and ElseRules must be distinct.
+	// A Vault treats else rules as part of their parent: to change them, replace the parent.
+	ElseRules map[string]*Rule `json:"else_rules,omitempty"`
+
+	// IndexBy names a data element used to select which child rules to evaluate.
+	// Use it when many child rules each require the element to have a
+	// particular value, such as country == "SE": only the child rules whose
+	// IndexKey matches the value in the input data, and the child rules without
+	// an IndexKey, are evaluated. The index is built when the rule is compiled.
+	// A dotted name, such as "customer.country", selects a map key or message field
+	// of an element.
+	IndexBy string `json:"index_by,omitempty"`
+
+	// IndexKey is the value (formatted with fmt.Sprint) that the parent's IndexBy
+	// element must have for this rule to be evaluated.
+	// If blank, and the evaluator implements ExpressionIndexer, the key is
+	// derived from the expression when the parent is compiled.
+	IndexKey string `json:"index_key,omitempty"`
+
+	// The names of enrichers (see DefaultEngine.RegisterEnricher) that add derived
+	// values to the input data before the rule is evaluated, in order. The rule's
+	// child rules are evaluated with the enriched data.
+	Enrich []string `json:"enrich,omitempty"`
+
 	// Reference to intermediate compilation / evaluation data.
 	Program interface{} `json:"-"`
 
+	// The compiled guard
+	guardProgram interface{}
+
+	// The compiled output expressions (see Outputs), by name
+	outputPrograms map[string]interface{}
+
+	// Whether the rule has been compiled (see DefaultEngine.Ready)
+	compiled bool
+
+	// Whether the expression refers to the outcomes of the child rules (see ChildrenKey)
+	aggregate bool
+
+	// Whether the rule is the root of a shard of a ShardedVault, whose Quorum is
+	// applied to the results of all the shards
+	shardRoot bool
+
+	// Whether the rule was not approved when it was compiled (see DefaultEngine.SetApprovalPolicy)
+	unapproved bool
+
+	// The inputs to compiling the rule's expressions (see compilationKey)
+	compilation string
+
+	// The key of the shared program of the rule's expression (see SharePrograms);
+	// blank if the program is not shared
+	programKey string
+
+	// The index of child rules, built by the engine if IndexBy is set
+	index *childIndex
+
+	// Groups of child rules evaluated by combined programs (see CombineSiblings)
+	combined []combinedGroup
+
 	// A reference to any object.
 	// Not used by the rules engine.
 	Meta interface{} `json:"-"`
 
-	// Options determining how the child rules should be handled.
+	// Descriptive information about the rule, such as its owner and severity.
+	// Unlike Meta, Metadata is serialized with the rule (see RuleMetadata).
+	Metadata RuleMetadata `json:"metadata"`
+
+	// Options determining how the rule and its child rules should be handled.
+	// The options apply to this rule only, unless InheritOptions is set.
+	// Options passed to Eval apply to every rule, and take precedence over
+	// the options of the rules.
 	EvalOptions EvalOptions `json:"eval_options"`
+
+	// InheritOptions passes the rule's EvalOptions (including any options it inherited)
+	// on to all its descendants. A descendant's options are added to the inherited
+	// options: a boolean option is on if it is on in either, and the other options are
+	// inherited if the descendant does not set them.
+	InheritOptions bool `json:"inherit_options,omitempty"`
+
+	// OverrideInherited makes the rule ignore the options inherited from its ancestors,
+	// so that only its own EvalOptions (and the options passed to Eval) apply.
+	// The rule's descendants do not inherit the ancestors' options either, but they
+	// inherit the rule's options if InheritOptions is set.
+	OverrideInherited bool `json:"override_inherited,omitempty"`
+
+	// Message templates describing the outcome of the rule to users, keyed by locale
+	// (such as "en" or "sv-SE"). The message with the key "" is the default,
+	// used if there is no message for the locale requested with the Locale evaluation option.
+	// Templates may refer to elements of the input data with placeholders, as in
+	// "Your order of {{amount}} exceeds the limit". The rendered message is returned in
+	// Result.Message.
+	Messages map[string]string `json:"messages,omitempty"`
 }
 
-const (
-	// If the rule includes a Self object, it will be made available in the input
-	// data with this key name.
-	selfKey = "self"
-)
+// SelfKey is the name under which a rule's Self value is available to its
+// expression (see Rule.Self).
+const SelfKey = "self"
 
 // NewRule initializes a rule with the given ID
 func NewRule(id string) *Rule {
@@ -92,6 +251,44 @@ func NewRule(id string) *Rule {
 	}
 }
 
+// MarshalJSON encodes the rule and its children, representing the result type
+// as a string (see ParseType).
+// The Self, Program and Meta fields, and the SortFunc evaluation option, are not encoded;
+// use Metadata for information that should be encoded with the rule.
+func (r Rule) MarshalJSON() ([]byte, error) {
+	type alias Rule
+	return json.Marshal(struct {
+		alias
+		ResultType string `json:"result_type,omitempty"`
+	}{
+		alias:      alias(r),
+		ResultType: typeString(r.ResultType),
+	})
+}
+
+// UnmarshalJSON decodes a rule encoded by MarshalJSON.
+// The rule must be compiled before it is evaluated.
+func (r *Rule) UnmarshalJSON(b []byte) error {
+	type alias Rule
+	x := struct {
+		*alias
+		ResultType string `json:"result_type,omitempty"`
+	}{
+		alias: (*alias)(r),
+	}
+
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+
+	t, err := parseTypeString(x.ResultType)
+	if err != nil {
+		return fmt.Errorf("rule %s: result type: %w", r.ID, err)
+	}
+	r.ResultType = t
+	return nil
+}
+
 // ApplyToRule applies the function f to the rule r and its children recursively.
 func ApplyToRule(r *Rule, f func(r *Rule) error) error {
 	err := f(r)
@@ -104,11 +301,77 @@ func ApplyToRule(r *Rule, f func(r *Rule) error) error {
 			return err
 		}
 	}
+	for _, c := range r.ElseRules {
+		err := ApplyToRule(c, f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Walk calls f for the rule r and its descendants, depth first, visiting child
+// rules in alphabetical order by ID. depth is the depth of the rule relative to r,
+// which is depth 0. If f returns false, Walk stops and returns false.
+// Unlike ApplyToRule, the order of the walk is stable.
+func Walk(r *Rule, f func(r *Rule, depth int) bool) bool {
+	return walk(r, 0, f)
+}
+
+func walk(r *Rule, depth int, f func(r *Rule, depth int) bool) bool {
+	if r == nil {
+		return true
+	}
+
+	if !f(r, depth) {
+		return false
+	}
+
+	for _, c := range r.sortChildKeys(EvalOptions{}) {
+		if !walk(c, depth+1, f) {
+			return false
+		}
+	}
+	for _, c := range sortRules(r.ElseRules, EvalOptions{}) {
+		if !walk(c, depth+1, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkCycles returns an error if the rule r appears among its own descendants.
+// path is the list of rules from the top of the tree down to r's parent.
+// The error describes the path of rule IDs forming the cycle.
+func checkCycles(r *Rule, path []*Rule) error {
+	if r == nil {
+		return nil
+	}
+
+	for i := range path {
+		if path[i] == r {
+			ids := make([]string, 0, len(path)-i+1)
+			for _, p := range path[i:] {
+				ids = append(ids, p.ID)
+			}
+			ids = append(ids, r.ID)
+			return fmt.Errorf("%w: %s", ErrRuleCycle, strings.Join(ids, " -> "))
+		}
+	}
+
+	path = append(path, r)
+	for _, m := range []map[string]*Rule{r.Rules, r.ElseRules} {
+		for _, c := range m {
+			if err := checkCycles(c, path); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
 // String returns a list of all the rules in hierarchy, with
-// child rules sorted in evaluation order.
+// child rules sorted alphabetically by ID.
 func (r *Rule) String() string {
 	tw := table.NewWriter()
 	tw.SetTitle("\nINDIGO RULES\n")
@@ -153,7 +416,7 @@ func (r *Rule) rulesToRows(n int) ([]table.Row, int) {
 	rows = append(rows, row)
 	maxExprLength := len(r.Expr)
 
-	for _, c := range r.Rules {
+	for _, c := range r.sortChildKeys(EvalOptions{}) {
 		cr, max := c.rulesToRows(n + 1)
 		if max > maxExprLength {
 			maxExprLength = max
@@ -163,30 +426,40 @@ func (r *Rule) rulesToRows(n int) ([]table.Row, int) {
 	return rows, maxExprLength
 }
 
-// sortChildKeys sorts the IDs of the child rules according to the
-// SortFunc set in evaluation options. If no SortFunc is set, the evaluation
-// order is not specified.
+// sortChildKeys sorts the child rules according to the
+// SortFunc set in evaluation options. If no SortFunc is set, the
+// child rules are sorted alphabetically by ID.
 func (r *Rule) sortChildKeys(o EvalOptions) []*Rule {
-	keys := make([]*Rule, 0, len(r.Rules))
-	for k := range r.Rules {
-		keys = append(keys, r.Rules[k])
+	return sortRules(r.Rules, o)
+}
+
+// sortRules sorts the rules in the map m according to the SortFunc
+// set in evaluation options, or alphabetically by ID
+func sortRules(m map[string]*Rule, o EvalOptions) []*Rule {
+	keys := make([]*Rule, 0, len(m))
+	for k := range m {
+		keys = append(keys, m[k])
 	}
 
-	if o.SortFunc != nil && sortOrderMatters(o) {
-		sort.Slice(keys, func(i, j int) bool {
-			return o.SortFunc(keys, i, j)
-		})
+	sortFunc := o.SortFunc
+	if sortFunc == nil {
+		sortFunc = sortByID
 	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return sortFunc(keys, i, j)
+	})
 	return keys
 }
 
-// Based on the evaluation options, determine if the order of evaluation matters
-func sortOrderMatters(o EvalOptions) bool {
-
-	if o.StopFirstNegativeChild || o.StopFirstPositiveChild {
+// sortByID is the default sort order of child rules
+func sortByID(rules []*Rule, i, j int) bool {
+	switch {
+	case rules[i] == nil:
+		return false
+	case rules[j] == nil:
 		return true
+	default:
+		return rules[i].ID < rules[j].ID
 	}
-
-	return false
-
 }
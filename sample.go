@@ -0,0 +1,52 @@
+package indigo
+
+import (
+	"context"
+	"math/rand"
+)
+
+// SamplePolicy selects the evaluations given the options of the SampleDiagnostics
+// middleware.
+type SamplePolicy struct {
+	// The fraction of evaluations selected at random, between 0 and 1
+	Rate float64
+
+	// Selects an evaluation regardless of Rate if it returns true, such as when a
+	// debug flag or header is set in the context values. Optional.
+	Force func(ctx context.Context, r *Rule, d map[string]interface{}) bool
+}
+
+// SampleDiagnostics returns middleware (see DefaultEngine.Use) that adds the options
+// opts to the evaluations selected by the policy p, so that expensive diagnostics are
+// only collected for a sample of the evaluations. For example, to return diagnostics
+// and timing for 1% of the evaluations, and for every evaluation with "debug" set in
+// the context values (see WithContextValues):
+//
+//     e.Use(indigo.SampleDiagnostics(indigo.SamplePolicy{
+//         Rate: 0.01,
+//         Force: func(ctx context.Context, _ *indigo.Rule, _ map[string]interface{}) bool {
+//             debug, _ := indigo.ContextValues(ctx)["debug"].(bool)
+//             return debug
+//         },
+//     }, indigo.ReturnDiagnostics(true), indigo.ReturnTiming(true)))
+//
+// The options of a selected evaluation are set in Result.EvalOptions. Diagnostics
+// are only returned for rules compiled with CollectDiagnostics.
+func SampleDiagnostics(p SamplePolicy, opts ...EvalOption) Middleware {
+	return func(next Evaluator) Evaluator {
+		return EvaluatorFunc(func(ctx context.Context, r *Rule, d map[string]interface{}, evalOpts ...EvalOption) (*Result, error) {
+			if p.selects(ctx, r, d) {
+				evalOpts = append(append([]EvalOption{}, evalOpts...), opts...)
+			}
+			return next.Eval(ctx, r, d, evalOpts...)
+		})
+	}
+}
+
+// selects reports whether the evaluation of r with the data d is selected
+func (p SamplePolicy) selects(ctx context.Context, r *Rule, d map[string]interface{}) bool {
+	if p.Force != nil && p.Force(ctx, r, d) {
+		return true
+	}
+	return p.Rate >= 1 || (p.Rate > 0 && rand.Float64() < p.Rate)
+}
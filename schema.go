@@ -1,6 +1,7 @@
 package indigo
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -19,6 +20,9 @@ type Schema struct {
 	Name string `json:"name,omitempty"`
 	// A user-friendly description of the schema
 	Description string `json:"description,omitempty"`
+	// The version of the schema, such as "v2". Not used by Indigo internally;
+	// see CheckSchema to find the rules broken by a new version.
+	Version string `json:"version,omitempty"`
 	// User-defined value
 	Meta interface{} `json:"-"`
 	// List of data elements supported by this schema
@@ -41,13 +45,23 @@ func (s *Schema) String() string {
 	return x.String()
 }
 
+// element returns the element with the name, if the schema has one
+func (s *Schema) element(name string) (DataElement, bool) {
+	for _, el := range s.Elements {
+		if el.Name == name {
+			return el, true
+		}
+	}
+	return DataElement{}, false
+}
+
 // DataElement defines a named variable in a schema
 type DataElement struct {
 	// Short, user-friendly name of the variable. This is the name
 	// that will be used in rules to refer to data passed in.
 	//
 	// RESERVED NAMES:
-	//   selfKey (see const)
+	//   SelfKey (see Rule.Self)
 	Name string `json:"name"`
 
 	// One of the Type interface defined.
@@ -55,6 +69,13 @@ type DataElement struct {
 
 	// Optional description of the type.
 	Description string `json:"description"`
+
+	// Whether the element holds sensitive data, such as personally identifiable
+	// information. The values of sensitive elements are replaced by Redacted in the
+	// diagnostics of results and reports, in the errors logged by the engine, in the
+	// evaluations recorded by replay.Recorder, and by Schema.Redact. Blank if the data
+	// is not sensitive.
+	Sensitivity Sensitivity `json:"sensitivity,omitempty"`
 }
 
 // String returns a human-readable representation of the element
@@ -62,6 +83,58 @@ func (e *DataElement) String() string {
 	return fmt.Sprintf("  %s (%s)", e.Name, e.Type)
 }
 
+// MarshalJSON encodes the data element, representing the type as a string
+// (see ParseType).
+func (e DataElement) MarshalJSON() ([]byte, error) {
+	type alias DataElement
+	return json.Marshal(struct {
+		alias
+		Type string `json:"type"`
+	}{
+		alias: alias(e),
+		Type:  typeString(e.Type),
+	})
+}
+
+// UnmarshalJSON decodes a data element encoded by MarshalJSON.
+// Protocol buffer types must be available in the global registry (see ParseType).
+func (e *DataElement) UnmarshalJSON(b []byte) error {
+	type alias DataElement
+	x := struct {
+		*alias
+		Type string `json:"type"`
+	}{
+		alias: (*alias)(e),
+	}
+
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+
+	t, err := parseTypeString(x.Type)
+	if err != nil {
+		return fmt.Errorf("element %s: %w", e.Name, err)
+	}
+	e.Type = t
+	return nil
+}
+
+// typeString returns the string representation of t, or an empty string if t is nil.
+func typeString(t Type) string {
+	if t == nil {
+		return ""
+	}
+	return t.String()
+}
+
+// parseTypeString parses a type string produced by typeString.
+func parseTypeString(s string) (Type, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return ParseType(s)
+}
+
 // Type defines a type in the Indigo type system.
 // These types are used to define schemas and define required
 // evaluation results.
@@ -2,6 +2,7 @@ package indigo_test
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/ezachrisen/indigo"
@@ -142,3 +143,95 @@ func TestParser(t *testing.T) {
 		}
 	}
 }
+
+func TestRedact(t *testing.T) {
+	is := is.New(t)
+
+	s := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "name", Type: indigo.String{}, Sensitivity: indigo.SensitivityPII},
+			{Name: "password", Type: indigo.String{}, Sensitivity: indigo.SensitivitySecret},
+			{Name: "amount", Type: indigo.Int{}},
+		},
+	}
+	is.True(s.Sensitive("name"))
+	is.True(!s.Sensitive("amount"))
+
+	d := map[string]interface{}{"name": "Alice", "password": "hunter2", "amount": 10}
+	x := s.Redact(d)
+	is.Equal(x["name"], indigo.Redacted)
+	is.Equal(x["password"], indigo.Redacted)
+	is.Equal(x["amount"], 10)
+	is.Equal(d["name"], "Alice") // not modified
+
+	r := &indigo.Rule{ID: "r", Schema: s, Expr: `name == "Alice"`}
+	u := &indigo.Result{
+		Rule: r,
+		Pass: true,
+		Diagnostics: &indigo.Diagnostics{
+			Expr:      `name == "Alice"`,
+			Interface: true,
+			Source:    indigo.Evaluated,
+			Children: []indigo.Diagnostics{
+				{Expr: "name", Interface: "Alice", Source: indigo.Input},
+			},
+		},
+	}
+	rep := indigo.DiagnosticsReport(u, d)
+	is.True(!strings.Contains(rep, "│ Alice") && !strings.Contains(rep, "hunter2"))
+	is.True(strings.Contains(rep, indigo.Redacted))
+	is.Equal(u.Diagnostics.Children[0].Interface, "Alice") // not modified
+}
+
+func TestJSONSchema(t *testing.T) {
+	is := is.New(t)
+
+	s := indigo.Schema{
+		ID:   "orders",
+		Name: "Orders",
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}, Description: "in cents"},
+			{Name: "card", Type: indigo.String{}, Sensitivity: indigo.SensitivitySecret},
+			{Name: "counts", Type: indigo.Map{KeyType: indigo.Int{}, ValueType: indigo.Float{}}},
+			{Name: "placed", Type: indigo.Timestamp{}},
+			{Name: "student", Type: indigo.Proto{Message: &school.Student{}}},
+			{Name: "tags", Type: indigo.List{ValueType: indigo.String{}}},
+			{Name: "wait", Type: indigo.Duration{}},
+		},
+	}
+
+	b, err := s.JSONSchema()
+	is.NoErr(err)
+	is.True(strings.Contains(string(b), `"format": "date-time"`))
+
+	s2, err := indigo.SchemaFromJSONSchema(b)
+	is.NoErr(err)
+	is.Equal(s2.ID, s.ID)
+	is.Equal(s2.Name, s.Name)
+	is.Equal(len(s2.Elements), len(s.Elements))
+	for i := range s.Elements {
+		is.Equal(s2.Elements[i].String(), s.Elements[i].String())
+		is.Equal(s2.Elements[i].Description, s.Elements[i].Description)
+		is.Equal(s2.Elements[i].Sensitivity, s.Elements[i].Sensitivity)
+	}
+
+	// A schema written by hand
+	s3, err := indigo.SchemaFromJSONSchema([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": ["string", "null"]},
+			"scores": {"type": "array", "items": {"type": "number"}},
+			"attributes": {"type": "object", "additionalProperties": {"type": "boolean"}},
+			"anything": {}
+		}
+	}`))
+	is.NoErr(err)
+	got := []string{}
+	for _, e := range s3.Elements {
+		got = append(got, e.Name+" "+e.Type.String())
+	}
+	is.Equal(got, []string{"anything any", "attributes map[string]bool", "name string", "scores []float"})
+
+	_, err = indigo.SchemaFromJSONSchema([]byte(`{"type": "string"}`))
+	is.True(err != nil)
+}
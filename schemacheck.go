@@ -0,0 +1,250 @@
+package indigo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// ElementChange describes a difference between two versions of a schema.
+type ElementChange struct {
+	// The name of the data element
+	Name string
+
+	// "added", "removed" or "retyped"
+	Change string
+
+	// The type of the element in the old and new schema; nil if the element
+	// is not in the schema
+	Was Type
+	Now Type
+
+	// For removed elements, the names of added elements with the same type,
+	// which the element may have been renamed to
+	RenamedTo []string
+}
+
+// String describes the change.
+func (c ElementChange) String() string {
+	switch c.Change {
+	case "added":
+		return fmt.Sprintf("%s added (%v)", c.Name, c.Now)
+	case "retyped":
+		return fmt.Sprintf("%s changed from %v to %v", c.Name, c.Was, c.Now)
+	}
+
+	s := fmt.Sprintf("%s removed (%v)", c.Name, c.Was)
+	if len(c.RenamedTo) > 0 {
+		s += ", renamed to " + strings.Join(c.RenamedTo, " or ") + "?"
+	}
+	return s
+}
+
+// CompareSchemas returns the data elements added, removed or given a different type
+// in the schema to, compared to the schema from, sorted by name.
+func CompareSchemas(from, to Schema) []ElementChange {
+	was := map[string]Type{}
+	for _, e := range from.Elements {
+		was[e.Name] = e.Type
+	}
+	now := map[string]Type{}
+	for _, e := range to.Elements {
+		now[e.Name] = e.Type
+	}
+
+	var changes []ElementChange
+	var added []ElementChange
+	for _, e := range to.Elements {
+		t, ok := was[e.Name]
+		switch {
+		case !ok:
+			added = append(added, ElementChange{Name: e.Name, Change: "added", Now: e.Type})
+		case typeString(t) != typeString(e.Type):
+			changes = append(changes, ElementChange{Name: e.Name, Change: "retyped", Was: t, Now: e.Type})
+		}
+	}
+
+	for _, e := range from.Elements {
+		if _, ok := now[e.Name]; ok {
+			continue
+		}
+		c := ElementChange{Name: e.Name, Change: "removed", Was: e.Type}
+		for _, a := range added {
+			if typeString(a.Now) == typeString(e.Type) {
+				c.RenamedTo = append(c.RenamedTo, a.Name)
+			}
+		}
+		changes = append(changes, c)
+	}
+
+	changes = append(changes, added...)
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// SchemaReport describes the effect of changing a schema on the rules using it.
+type SchemaReport struct {
+	// The versions of the schema before and after the change
+	From string
+	To   string
+
+	// The differences between the schemas
+	Changes []ElementChange
+
+	// The number of rules using the schema
+	Checked int
+
+	// The rules that fail to compile with the new schema, in depth-first order,
+	// with child rules sorted by ID
+	Broken []BrokenRule
+}
+
+// BrokenRule is a rule that fails to compile with a new version of its schema.
+type BrokenRule struct {
+	// The ID of the rule
+	RuleID string
+
+	// The changed data elements (see ElementChange) referenced by the rule.
+	// Only available if the engine's evaluator implements ExpressionAnalyzer.
+	Elements []string
+
+	// The compilation error
+	Err error
+}
+
+// CheckSchema reports which of the rules in the tree r would fail to compile if the
+// schema they use was replaced by the schema s, before the change is made.
+// Rules use the schema if their schema, or the schema they refer to (see Rule.SchemaID),
+// has the same ID as s; if s has no ID, all rules are checked. The rules are not modified, and need not be compiled.
+// An error is only returned if the rules cannot be checked.
+func (e *DefaultEngine) CheckSchema(r *Rule, s Schema) (*SchemaReport, error) {
+	if err := validateCompileArguments(r, e); err != nil {
+		return nil, err
+	}
+
+	rep := &SchemaReport{To: s.Version}
+	if err := e.checkSchemaRule(r, s, rep); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// checkSchemaRule adds the rule r and its descendants, if they use the schema s, to rep
+func (e *DefaultEngine) checkSchemaRule(r *Rule, s Schema, rep *SchemaReport) error {
+	if r == nil {
+		return ErrNilRule
+	}
+
+	if s.ID == "" || r.Schema.ID == s.ID || r.SchemaID == s.ID {
+		old, err := e.schemaOf(r)
+		if err != nil {
+			old = r.Schema
+		}
+		if rep.Checked == 0 {
+			rep.From = old.Version
+			rep.Changes = CompareSchemas(old, s)
+		}
+		rep.Checked++
+
+		if br, broken := e.checkRuleSchema(r, old, s, rep.Changes); broken {
+			rep.Broken = append(rep.Broken, br)
+		}
+	}
+
+	for _, c := range append(r.sortChildKeys(EvalOptions{}), sortRules(r.ElseRules, EvalOptions{})...) {
+		if err := e.checkSchemaRule(c, s, rep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRuleSchema compiles the expressions of the rule with the schema s replacing
+// the schema old, reporting whether the rule is broken
+func (e *DefaultEngine) checkRuleSchema(r *Rule, old, s Schema, changes []ElementChange) (BrokenRule, bool) {
+	br := BrokenRule{RuleID: r.ID}
+
+	// The expressions are checked with the reserved names the rule uses declared
+	if x, err := reservedSchema(r, old); err == nil {
+		old = x
+	}
+	s, err := reservedSchema(r, s)
+	if err != nil {
+		br.Err = err
+		return br, true
+	}
+
+	changed := map[string]bool{}
+	for _, c := range changes {
+		if c.Change != "added" {
+			changed[c.Name] = true
+		}
+	}
+
+	for _, x := range ruleExprs(r) {
+		if a, ok := e.e.(ExpressionAnalyzer); ok {
+			if info, err := a.Analyze(x.expr, old, x.t); err == nil {
+				for _, v := range info.ReferencedVariables {
+					if changed[v] && !contains(br.Elements, v) {
+						br.Elements = append(br.Elements, v)
+					}
+				}
+			}
+		}
+
+		if _, err := e.e.Compile(x.expr, s, x.t, false, true); err != nil && br.Err == nil {
+			br.Err = fmt.Errorf("%s%w", x.name, err)
+		}
+	}
+
+	sort.Strings(br.Elements)
+	return br, br.Err != nil
+}
+
+// String produces a list of the schema changes, and a table of the rules broken by them.
+func (rep *SchemaReport) String() string {
+	s := strings.Builder{}
+	fmt.Fprintf(&s, "\nINDIGO SCHEMA CHANGE REPORT: %s -> %s\n\n", versionString(rep.From), versionString(rep.To))
+	for _, c := range rep.Changes {
+		s.WriteString("  " + c.String() + "\n")
+	}
+	s.WriteString("\n")
+
+	tw := table.NewWriter()
+	tw.AppendHeader(table.Row{"Rule", "Elements", "Error"})
+	for _, br := range rep.Broken {
+		tw.AppendRow(table.Row{
+			br.RuleID,
+			strings.Join(br.Elements, ", "),
+			strings.ReplaceAll(br.Err.Error(), "\n", " "),
+		})
+	}
+	tw.AppendFooter(table.Row{
+		fmt.Sprintf("%d rules", rep.Checked),
+		fmt.Sprintf("%d broken", len(rep.Broken)),
+		"",
+	})
+	tw.SetColumnConfigs([]table.ColumnConfig{
+		{Number: 3, WidthMax: 60},
+	})
+
+	style := table.StyleLight
+	style.Format.Header = text.FormatDefault
+	style.Format.Footer = text.FormatDefault
+	tw.SetStyle(style)
+	s.WriteString(tw.Render())
+	return s.String()
+}
+
+// versionString describes a schema version
+func versionString(v string) string {
+	if v == "" {
+		return "(no version)"
+	}
+	return v
+}
@@ -0,0 +1,84 @@
+package indigo
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// selfData returns the input data seen by a rule with the Self value self: a copy of d
+// with self under SelfKey, or without SelfKey if self is nil. If d has no SelfKey and
+// self is nil, d itself is returned.
+func selfData(self interface{}, d map[string]interface{}) map[string]interface{} {
+	if d == nil {
+		return nil
+	}
+	if _, ok := d[SelfKey]; !ok && self == nil {
+		return d
+	}
+
+	x := make(map[string]interface{}, len(d)+1)
+	for k, v := range d {
+		x[k] = v
+	}
+	if self != nil {
+		x[SelfKey] = self
+	} else {
+		delete(x, SelfKey)
+	}
+	return x
+}
+
+// selfSchema returns the schema to compile the rule's expressions with: s, with
+// SelfKey declared as Any if the rule has a Self value and s does not declare it.
+// If s declares SelfKey, the rule's Self value must have the declared type.
+func selfSchema(r *Rule, s Schema) (Schema, error) {
+	if r.Self == nil {
+		return s, nil
+	}
+
+	if el, ok := s.element(SelfKey); ok {
+		if !hasType(r.Self, el.Type) {
+			return Schema{}, fmt.Errorf("%w: %T is not %v", ErrSelfType, r.Self, el.Type)
+		}
+		return s, nil
+	}
+
+	s.Elements = append(append([]DataElement{}, s.Elements...), DataElement{Name: SelfKey, Type: Any{}})
+	return s, nil
+}
+
+// hasType reports whether the Go value v has the indigo type t. Values of
+// types that cannot be checked, such as the elements of lists, are accepted.
+func hasType(v interface{}, t Type) bool {
+	k := reflect.ValueOf(v).Kind()
+	switch t := t.(type) {
+	case String:
+		return k == reflect.String
+	case Int:
+		return k >= reflect.Int && k <= reflect.Uint64
+	case Float:
+		return k == reflect.Float32 || k == reflect.Float64
+	case Bool:
+		return k == reflect.Bool
+	case Duration:
+		_, ok := v.(time.Duration)
+		return ok
+	case Timestamp:
+		_, ok := v.(time.Time)
+		return ok
+	case List:
+		return k == reflect.Slice || k == reflect.Array
+	case Map:
+		return k == reflect.Map
+	case Proto:
+		m, ok := v.(proto.Message)
+		if !ok || t.Message == nil {
+			return ok
+		}
+		return m.ProtoReflect().Descriptor().FullName() == t.Message.ProtoReflect().Descriptor().FullName()
+	}
+	return true
+}
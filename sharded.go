@@ -0,0 +1,387 @@
+package indigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// ShardedVault partitions the child rules of a root rule across a number of
+// vaults (shards), each with its own engine, and evaluates the shards in parallel.
+// Use it for very large rule sets, where evaluating all the child rules of the
+// root in one goroutine, or compiling them with one engine, is too slow.
+//
+// Each shard holds a copy of the root rule with a part of the root's child rules,
+// chosen by a ShardFunc. Evaluating the root rule evaluates the root in each shard,
+// and merges the results into one result, as if the root had been evaluated in one vault.
+// Options that stop the evaluation of child rules early, such as StopFirstPositiveChild,
+// apply within each shard. The counts of child rules that passed and failed are the
+// sums over the shards, and the root's Quorum, if any, is applied to them.
+// The Rule of the merged result is the root of the first shard.
+//
+// Rule IDs must be unique across the shards. The expression of the root rule must not
+// refer to the outcomes of its children (see ChildrenKey), which are in different shards.
+type ShardedVault struct {
+	shards  []*Vault
+	shardOf ShardFunc
+
+	// Held while making changes, to keep rule IDs unique across shards
+	mu sync.Mutex
+}
+
+// ShardFunc returns the shard, between 0 and n-1, that a child rule of the root
+// rule is assigned to.
+type ShardFunc func(r *Rule, n int) int
+
+// ShardByID assigns rules to shards by a hash of their IDs.
+// It spreads rules evenly across the shards.
+func ShardByID(r *Rule, n int) int {
+	return shardHash(r.ID, n)
+}
+
+// ShardByCategory assigns rules to shards by a hash of their metadata category,
+// so that all rules in a category are kept in the same shard.
+func ShardByCategory(r *Rule, n int) int {
+	return shardHash(r.Metadata.Category, n)
+}
+
+// shardHash hashes the string s to a shard between 0 and n-1
+func shardHash(s string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return int(h.Sum32() % uint32(n))
+}
+
+// NewShardedVault partitions the child rules of the root across n shards with the function f,
+// and compiles each shard with a new engine returned by newEngine. If f is nil, ShardByID is used.
+// The compilation options are used to compile the shards, and rules added later.
+// The root rule itself is not modified.
+func NewShardedVault(newEngine func() Engine, root *Rule, n int, f ShardFunc, opts ...CompilationOption) (*ShardedVault, error) {
+	if root == nil {
+		return nil, ErrNilRule
+	}
+
+	if n < 1 {
+		return nil, fmt.Errorf("number of shards must be at least 1, got %d", n)
+	}
+
+	if f == nil {
+		f = ShardByID
+	}
+
+	if err := checkUniqueIDs(root, map[string]bool{}); err != nil {
+		return nil, err
+	}
+
+	parts := make([]*Rule, n)
+	for i := range parts {
+		p := *root
+		p.Rules = map[string]*Rule{}
+		p.shardRoot = true
+		parts[i] = &p
+	}
+
+	for id, c := range root.Rules {
+		parts[shardIndex(f, c, n)].Rules[id] = c
+	}
+
+	sv := &ShardedVault{
+		shards:  make([]*Vault, n),
+		shardOf: f,
+	}
+
+	for i, p := range parts {
+		v, err := NewVault(newEngine(), p, opts...)
+		if err != nil {
+			return nil, err
+		}
+		sv.shards[i] = v
+	}
+
+	snaps := make([]*Snapshot, n)
+	for i, v := range sv.shards {
+		snaps[i] = v.Snapshot()
+	}
+	if err := checkShardRoots(snaps); err != nil {
+		return nil, err
+	}
+	return sv, nil
+}
+
+// checkShardRoots returns an error if the expression of the root rule, in any of the
+// snapshots of the shards, refers to the outcomes of its children, which cannot be
+// evaluated in a single shard
+func checkShardRoots(snaps []*Snapshot) error {
+	for _, s := range snaps {
+		if s.root.aggregate {
+			return fmt.Errorf("rule %s: the root rule of a sharded vault cannot refer to %s", s.root.ID, ChildrenKey)
+		}
+	}
+	return nil
+}
+
+// shardIndex calls f, and checks that the shard it returns exists
+func shardIndex(f ShardFunc, r *Rule, n int) int {
+	i := f(r, n)
+	if i < 0 || i >= n {
+		i = shardHash(r.ID, n)
+	}
+	return i
+}
+
+// Shards returns the number of shards.
+func (sv *ShardedVault) Shards() int {
+	return len(sv.shards)
+}
+
+// Shard returns the vault holding the shard with index i.
+// Use it to inspect a shard; make changes through the ShardedVault. The root's
+// Quorum is not applied when evaluating the root in a single shard.
+func (sv *ShardedVault) Shard(i int) *Vault {
+	return sv.shards[i]
+}
+
+// rootID returns the ID of the root rule, which is the same in all shards
+func (sv *ShardedVault) rootID() string {
+	return sv.shards[0].Snapshot().root.ID
+}
+
+// find returns the shard holding the rule with the id, other than the root rule
+func (sv *ShardedVault) find(id string) (*Vault, error) {
+	for _, v := range sv.shards {
+		if _, err := v.Rule(id); err == nil {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("rule %s: %w", id, ErrRuleNotFound)
+}
+
+// Add compiles the rule r and adds it as a child of the rule with parentID.
+// Rules added to the root rule are assigned to a shard by the ShardFunc;
+// other rules are added to the shard holding their parent.
+func (sv *ShardedVault) Add(parentID string, r *Rule) error {
+	if r == nil {
+		return ErrNilRule
+	}
+
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	for id := range ruleIDs(r) {
+		if _, err := sv.find(id); err == nil || id == sv.rootID() {
+			return fmt.Errorf("rule %s: %w", id, ErrDuplicateRuleID)
+		}
+	}
+
+	if parentID == sv.rootID() {
+		return sv.shards[shardIndex(sv.shardOf, r, len(sv.shards))].Add(parentID, r)
+	}
+
+	v, err := sv.find(parentID)
+	if err != nil {
+		return fmt.Errorf("parent %s: %w", parentID, ErrRuleNotFound)
+	}
+	return v.Add(parentID, r)
+}
+
+// Replace compiles the rule r and replaces the rule with the same ID in the shard holding it.
+// The root rule cannot be replaced.
+func (sv *ShardedVault) Replace(r *Rule) error {
+	if r == nil {
+		return ErrNilRule
+	}
+
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if r.ID == sv.rootID() {
+		return fmt.Errorf("rule %s: cannot replace the root rule of a sharded vault", r.ID)
+	}
+
+	v, err := sv.find(r.ID)
+	if err != nil {
+		return err
+	}
+
+	for id := range ruleIDs(r) {
+		if o, err := sv.find(id); err == nil && o != v {
+			return fmt.Errorf("rule %s: %w", id, ErrDuplicateRuleID)
+		}
+	}
+	return v.Replace(r)
+}
+
+// Remove removes the rule with the id, and its children, from the shard holding it.
+// The root rule cannot be removed.
+func (sv *ShardedVault) Remove(id string) error {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if id == sv.rootID() {
+		return fmt.Errorf("rule %s: cannot remove the root rule", id)
+	}
+
+	v, err := sv.find(id)
+	if err != nil {
+		return err
+	}
+	return v.Remove(id)
+}
+
+// Rule returns the rule with the id. For the root rule, the root of the
+// first shard is returned.
+// The rule is owned by the vault; you must not modify it.
+func (sv *ShardedVault) Rule(id string) (*Rule, error) {
+	if id == sv.rootID() {
+		return sv.shards[0].Rule(id)
+	}
+
+	v, err := sv.find(id)
+	if err != nil {
+		return nil, err
+	}
+	return v.Rule(id)
+}
+
+// RuleCount returns the number of rules in the shards, counting the root rule once.
+func (sv *ShardedVault) RuleCount() int {
+	n := 1
+	for _, v := range sv.shards {
+		n += v.RuleCount() - 1
+	}
+	return n
+}
+
+// Eval evaluates the rule with the id, and its children, against the data.
+// The root rule is evaluated in all shards in parallel; the OnResult function,
+// if any, may therefore be called concurrently. Other rules are evaluated in
+// the shard holding them.
+func (sv *ShardedVault) Eval(ctx context.Context, id string, d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+	if d == nil {
+		return nil, ErrNilData
+	}
+
+	if id != sv.rootID() {
+		v, err := sv.find(id)
+		if err != nil {
+			return nil, err
+		}
+		return v.Eval(ctx, id, d, opts...)
+	}
+
+	snaps := make([]*Snapshot, len(sv.shards))
+	for i, v := range sv.shards {
+		snaps[i] = v.Snapshot()
+	}
+
+	if err := checkShardRoots(snaps); err != nil {
+		return nil, err
+	}
+
+	o := snaps[0].root.EvalOptions
+	applyEvaluatorOptions(&o, opts...)
+
+	// The shards report the results of the root rule; the merged result is reported below
+	shardOpts := opts
+	if o.OnResult != nil {
+		onResult := o.OnResult
+		shardOpts = append(append([]EvalOption{}, opts...), OnResult(func(u *Result) {
+			if u.Rule.ID != id {
+				onResult(u)
+			}
+		}))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*Result, len(snaps))
+	errs := make([]error, len(snaps))
+	var wg sync.WaitGroup
+	for i, s := range snaps {
+		wg.Add(1)
+		go func(i int, s *Snapshot) {
+			defer wg.Done()
+			results[i], errs[i] = s.Eval(ctx, id, d, shardOpts...)
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	// Report the error that canceled the other shards, rather than the cancelation
+	var firstErr error
+	for _, err := range errs {
+		if err != nil && (firstErr == nil || errors.Is(firstErr, context.Canceled)) {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	u := mergeResults(results, o)
+	if q := snaps[0].root.Quorum; q != nil {
+		q.apply(u)
+	}
+	if o.OnResult != nil {
+		o.OnResult(u)
+	}
+	return u, nil
+}
+
+// mergeResults combines the results of evaluating the root rule in each shard
+func mergeResults(results []*Result, o EvalOptions) *Result {
+	u := *results[0]
+	u.Results = make(map[string]*Result, len(u.Results)*len(results))
+	u.OrderedResults = make([]*Result, 0, len(u.OrderedResults)*len(results))
+	u.EvaluationCount = 1
+	u.ChildrenPassed = 0
+	u.ChildrenFailed = 0
+
+	for _, r := range results {
+		for id, c := range r.Results {
+			u.Results[id] = c
+		}
+		u.OrderedResults = append(u.OrderedResults, r.OrderedResults...)
+		u.EvaluationCount += r.EvaluationCount - 1
+		u.ChildrenPassed += r.ChildrenPassed
+		u.ChildrenFailed += r.ChildrenFailed
+		u.Verdict = maxSeverity(u.Verdict, r.Verdict)
+
+		if !r.Pass {
+			u.Pass = false
+			u.Status = r.Status
+		}
+	}
+
+	sortFunc := o.SortFunc
+	if sortFunc == nil {
+		sortFunc = sortByID
+	}
+	rules := make([]*Rule, len(u.OrderedResults))
+	for i, c := range u.OrderedResults {
+		rules[i] = c.Rule
+	}
+	sort.Sort(resultSorter{rules: rules, results: u.OrderedResults, less: sortFunc})
+	return &u
+}
+
+// resultSorter sorts results by their rules, using a sort function
+// for child rules (see SortFunc)
+type resultSorter struct {
+	rules   []*Rule
+	results []*Result
+	less    func(rules []*Rule, i, j int) bool
+}
+
+func (s resultSorter) Len() int           { return len(s.rules) }
+func (s resultSorter) Less(i, j int) bool { return s.less(s.rules, i, j) }
+func (s resultSorter) Swap(i, j int) {
+	s.rules[i], s.rules[j] = s.rules[j], s.rules[i]
+	s.results[i], s.results[j] = s.results[j], s.results[i]
+}
@@ -0,0 +1,108 @@
+package indigo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Stats reports the number of rules in a rule tree and the memory used by
+// their compiled programs.
+type Stats struct {
+	// The number of rules, including the root rule
+	Rules int
+
+	// The number of rules with a compiled program
+	Compiled int
+
+	// The number of distinct compiled programs. If programs are shared
+	// (see SharePrograms), this is less than Compiled.
+	Programs int
+
+	// The approximate size in bytes of the distinct compiled programs, as reported by
+	// the evaluator's ExpressionAnalyzer. Zero if the evaluator is not an ExpressionAnalyzer.
+	ProgramBytes int
+
+	// The number of programs held by the engine for sharing
+	SharedPrograms int
+}
+
+// Stats returns statistics about the rule r and its children, compiled by the engine.
+// Calculating the size of programs requires analyzing each distinct expression;
+// Stats is intended for monitoring and troubleshooting, not for frequent use.
+func (e *DefaultEngine) Stats(r *Rule) (Stats, error) {
+	if err := validateCompileArguments(r, e); err != nil {
+		return Stats{}, err
+	}
+
+	st, err := ruleStats(r, e.e)
+	if err != nil {
+		return st, err
+	}
+
+	e.mu.Lock()
+	st.SharedPrograms = len(e.programs)
+	e.mu.Unlock()
+	return st, nil
+}
+
+// Stats returns statistics about the rules in the vault (see DefaultEngine.Stats).
+// The size of programs is only reported if the vault uses a DefaultEngine.
+func (v *Vault) Stats() (Stats, error) {
+	root := v.Snapshot().root
+	if de, ok := v.engine.(*DefaultEngine); ok {
+		return de.Stats(root)
+	}
+	return ruleStats(root, nil)
+}
+
+// ruleStats counts the rules and distinct programs in r, and measures the programs
+// with the evaluator, if it is an ExpressionAnalyzer
+func ruleStats(r *Rule, ev interface{}) (Stats, error) {
+	st := Stats{}
+	a, _ := ev.(ExpressionAnalyzer)
+
+	// Programs are told apart by identity where possible. Programs of types that
+	// cannot be compared are all counted as distinct.
+	seen := map[interface{}]bool{}
+	var err error
+	Walk(r, func(c *Rule, _ int) bool {
+		st.Rules++
+		if c.Program == nil {
+			return true
+		}
+		st.Compiled++
+
+		if t := reflect.TypeOf(c.Program); t.Comparable() {
+			if seen[c.Program] {
+				return true
+			}
+			seen[c.Program] = true
+		}
+		st.Programs++
+
+		if a != nil {
+			info, aerr := a.Analyze(c.Expr, c.Schema, defaultResultType(c))
+			if aerr != nil {
+				err = &CompileError{RuleID: c.ID, Err: aerr}
+				return false
+			}
+			st.ProgramBytes += info.Size
+		}
+		return true
+	})
+	return st, err
+}
+
+// String returns a summary of the statistics.
+func (s Stats) String() string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "rules: %d, compiled: %d, programs: %d", s.Rules, s.Compiled, s.Programs)
+	if s.ProgramBytes > 0 {
+		fmt.Fprintf(&b, " (~%d bytes)", s.ProgramBytes)
+	}
+	if s.SharedPrograms > 0 {
+		fmt.Fprintf(&b, ", shared: %d", s.SharedPrograms)
+	}
+	return b.String()
+}
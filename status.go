@@ -0,0 +1,32 @@
+package indigo
+
+//go:generate stringer -type=Status -trimprefix=Status
+
+// Status is the outcome of evaluating a rule.
+type Status int
+
+const (
+	// StatusPass means the rule's expression yielded true, or a non-boolean
+	// value for a rule whose ResultType is not boolean.
+	StatusPass Status = iota
+
+	// StatusFail means the rule's expression yielded false, or the rule failed
+	// because of its child rules (see RollupChildResults).
+	StatusFail
+
+	// StatusError means the rule could not be evaluated; see Result.Error.
+	StatusError
+
+	// StatusSkipped means the rule was not evaluated because its parent
+	// failed (see StopIfParentNegative and RecordSkipped).
+	StatusSkipped
+
+	// StatusIndeterminate means the rule was evaluated, but it could not be
+	// determined whether it passed or failed, such as when a boolean rule
+	// yields a value that is not a boolean.
+	StatusIndeterminate
+
+	// StatusNotApplicable means the rule was not evaluated because its
+	// guard (see Rule.Guard) was false. The rule's children were not evaluated either.
+	StatusNotApplicable
+)
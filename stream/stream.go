@@ -0,0 +1,310 @@
+// Package stream uses Indigo as a streaming decision processor: it receives
+// messages from a message broker such as Kafka or NATS, evaluates a rule tree
+// against each message, and publishes the results to an output topic.
+//
+// The package does not depend on any broker's client library. Instead, you
+// connect a Processor to your broker by implementing the small Source and Sink
+// interfaces. For example, with the NATS client:
+//
+//     type natsSource struct{ sub *nats.Subscription }
+//
+//     func (n natsSource) Receive(ctx context.Context) (stream.Message, error) {
+//         m, err := n.sub.NextMsg(time.Minute)
+//         if err != nil {
+//             return stream.Message{}, err
+//         }
+//         return stream.Message{Value: m.Data}, nil
+//     }
+//
+//     type natsSink struct{ conn *nats.Conn; subject string }
+//
+//     func (n natsSink) Publish(ctx context.Context, m stream.Message) error {
+//         return n.conn.Publish(n.subject, m.Value)
+//     }
+//
+// A Kafka consumer that commits offsets after the results have been published
+// should also implement the Acknowledger interface.
+package stream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ezachrisen/indigo"
+)
+
+// Message is a message received from, or published to, a message broker.
+type Message struct {
+	// The key of the message, such as a Kafka message key. Optional.
+	Key []byte
+
+	// The payload of the message
+	Value []byte
+
+	// Message headers. Optional.
+	Headers map[string]string
+}
+
+// Source is the interface that wraps the Receive method.
+//
+// Receive blocks until a message is available and returns it.
+// It returns io.EOF when there are no more messages, which stops the processor
+// without an error.
+type Source interface {
+	Receive(ctx context.Context) (Message, error)
+}
+
+// Sink is the interface that wraps the Publish method.
+//
+// Publish sends a message to the output topic.
+type Sink interface {
+	Publish(ctx context.Context, m Message) error
+}
+
+// Acknowledger is implemented by sources that need to be told when a message
+// has been processed, such as a Kafka consumer committing offsets.
+// If the source implements Acknowledger, Ack is called after the results
+// of a message have been published, or after the error handler has skipped it.
+type Acknowledger interface {
+	Ack(ctx context.Context, m Message) error
+}
+
+// Decoder converts the payload of a message to the input data of an evaluation.
+type Decoder func(m Message) (map[string]interface{}, error)
+
+// Encoder converts the result of an evaluation to the message to publish.
+// If publish is false, no message is published for the input message.
+type Encoder func(in Message, u *indigo.Result) (out Message, publish bool, err error)
+
+// ErrorHandler is called when a message cannot be decoded, evaluated, encoded
+// or published. Return nil to skip the message and continue processing, or
+// an error to stop the processor and return the error from Run.
+type ErrorHandler func(m Message, err error) error
+
+// Processor evaluates a rule tree against each message received from a source,
+// and publishes the results to a sink.
+type Processor struct {
+	engine  indigo.Engine
+	rule    *indigo.Rule
+	source  Source
+	sink    Sink
+	decode  Decoder
+	encode  Encoder
+	onError ErrorHandler
+	evalOps []indigo.EvalOption
+}
+
+// Option is a functional option to specify the behavior of a processor.
+type Option func(p *Processor)
+
+// Decode specifies the function used to convert messages to input data.
+// Default: JSONDecoder with the schema of the rule
+func Decode(d Decoder) Option {
+	return func(p *Processor) {
+		p.decode = d
+	}
+}
+
+// Encode specifies the function used to convert results to messages.
+// Default: JSONEncoder
+func Encode(e Encoder) Option {
+	return func(p *Processor) {
+		p.encode = e
+	}
+}
+
+// OnError specifies the function called when a message cannot be processed.
+// Default: the processor stops and Run returns the error
+func OnError(h ErrorHandler) Option {
+	return func(p *Processor) {
+		p.onError = h
+	}
+}
+
+// EvalOptions specifies the options passed to the engine for each evaluation.
+func EvalOptions(opts ...indigo.EvalOption) Option {
+	return func(p *Processor) {
+		p.evalOps = opts
+	}
+}
+
+// NewProcessor returns a processor that evaluates the rule r, using the engine e,
+// against the messages received from the source, and publishes the results to the sink.
+// The rule must already be compiled.
+func NewProcessor(e indigo.Engine, r *indigo.Rule, src Source, sink Sink, opts ...Option) (*Processor, error) {
+	switch {
+	case e == nil:
+		return nil, indigo.ErrNilEngine
+	case r == nil:
+		return nil, indigo.ErrNilRule
+	case src == nil:
+		return nil, fmt.Errorf("source is nil")
+	case sink == nil:
+		return nil, fmt.Errorf("sink is nil")
+	}
+
+	p := &Processor{
+		engine: e,
+		rule:   r,
+		source: src,
+		sink:   sink,
+		decode: JSONDecoder(r.Schema),
+		encode: JSONEncoder,
+	}
+
+	for _, o := range opts {
+		o(p)
+	}
+	return p, nil
+}
+
+// Run receives and processes messages until the source returns io.EOF,
+// the context is canceled, or an error stops the processor.
+func (p *Processor) Run(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		m, err := p.source.Receive(ctx)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("receiving message: %w", err)
+		}
+
+		if err := p.process(ctx, m); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if p.onError == nil {
+				return err
+			}
+			if err := p.onError(m, err); err != nil {
+				return err
+			}
+		}
+
+		if a, ok := p.source.(Acknowledger); ok {
+			if err := a.Ack(ctx, m); err != nil {
+				return fmt.Errorf("acknowledging message: %w", err)
+			}
+		}
+	}
+}
+
+// process decodes, evaluates, encodes and publishes a single message
+func (p *Processor) process(ctx context.Context, m Message) error {
+	d, err := p.decode(m)
+	if err != nil {
+		return fmt.Errorf("decoding message: %w", err)
+	}
+
+	u, err := p.engine.Eval(ctx, p.rule, d, p.evalOps...)
+	if err != nil {
+		return fmt.Errorf("evaluating message: %w", err)
+	}
+
+	out, publish, err := p.encode(m, u)
+	if err != nil {
+		return fmt.Errorf("encoding result: %w", err)
+	}
+
+	if !publish {
+		return nil
+	}
+
+	if err := p.sink.Publish(ctx, out); err != nil {
+		return fmt.Errorf("publishing result: %w", err)
+	}
+	return nil
+}
+
+// JSONDecoder returns a decoder for messages with a JSON object as the payload.
+// Each field of the object becomes an entry in the input data.
+// Numbers, and strings representing timestamps (RFC 3339) and durations, are
+// converted to the types of the schema elements with the same name as the fields
+// (see indigo.Schema.Coerce). Other numbers are converted to float64.
+func JSONDecoder(s indigo.Schema) Decoder {
+	return func(m Message) (map[string]interface{}, error) {
+		d := map[string]interface{}{}
+		dec := json.NewDecoder(bytes.NewReader(m.Value))
+		dec.UseNumber()
+		if err := dec.Decode(&d); err != nil {
+			return nil, err
+		}
+		return s.Coerce(d)
+	}
+}
+
+// Decision is the message published by JSONEncoder.
+type Decision struct {
+	// The ID of the rule evaluated
+	RuleID string `json:"rule_id"`
+
+	// The Pass and Status of the rule's result
+	Pass   bool   `json:"pass"`
+	Status string `json:"status"`
+
+	// The verdict (highest severity of passing rules) of the result
+	Verdict indigo.Severity `json:"verdict,omitempty"`
+
+	// The IDs of the child rules (at any depth) in the results that passed,
+	// in the order they were evaluated
+	Passed []string `json:"passed,omitempty"`
+
+	// The rendered messages of the rules in the results, by rule ID
+	Messages map[string]string `json:"messages,omitempty"`
+}
+
+// JSONEncoder publishes a Decision, encoded as JSON, for every message.
+// The message key and headers of the input message are copied to the output message.
+func JSONEncoder(in Message, u *indigo.Result) (Message, bool, error) {
+	dec := Decision{
+		RuleID:  u.Rule.ID,
+		Pass:    u.Pass,
+		Status:  u.Status.String(),
+		Verdict: u.Verdict,
+	}
+	collect(u, &dec)
+
+	b, err := json.Marshal(dec)
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	out := Message{
+		Key:     in.Key,
+		Value:   b,
+		Headers: map[string]string{},
+	}
+	for k, v := range in.Headers {
+		out.Headers[k] = v
+	}
+	out.Headers["indigo-pass"] = strconv.FormatBool(u.Pass)
+	return out, true, nil
+}
+
+// collect adds the passing descendants of u, and the messages of u
+// and its descendants, to the decision
+func collect(u *indigo.Result, dec *Decision) {
+	if u.Message != "" {
+		if dec.Messages == nil {
+			dec.Messages = map[string]string{}
+		}
+		dec.Messages[u.Rule.ID] = u.Message
+	}
+
+	for _, c := range u.OrderedResults {
+		if c.Pass && c.Status == indigo.StatusPass {
+			dec.Passed = append(dec.Passed, c.Rule.ID)
+		}
+		collect(c, dec)
+	}
+}
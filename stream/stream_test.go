@@ -0,0 +1,144 @@
+package stream_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/cel"
+	"github.com/ezachrisen/indigo/stream"
+	"github.com/matryer/is"
+)
+
+// sliceSource returns messages from a slice, then io.EOF
+type sliceSource struct {
+	messages []stream.Message
+	acked    int
+}
+
+func (s *sliceSource) Receive(ctx context.Context) (stream.Message, error) {
+	if len(s.messages) == 0 {
+		return stream.Message{}, io.EOF
+	}
+	m := s.messages[0]
+	s.messages = s.messages[1:]
+	return m, nil
+}
+
+func (s *sliceSource) Ack(ctx context.Context, m stream.Message) error {
+	s.acked++
+	return nil
+}
+
+// sliceSink collects the published messages
+type sliceSink struct {
+	messages []stream.Message
+}
+
+func (s *sliceSink) Publish(ctx context.Context, m stream.Message) error {
+	s.messages = append(s.messages, m)
+	return nil
+}
+
+func makePaymentRule() *indigo.Rule {
+	schema := indigo.Schema{
+		Elements: []indigo.DataElement{
+			{Name: "amount", Type: indigo.Int{}},
+			{Name: "country", Type: indigo.String{}},
+		},
+	}
+
+	return &indigo.Rule{
+		ID:     "payments",
+		Schema: schema,
+		Rules: map[string]*indigo.Rule{
+			"large": {
+				ID:       "large",
+				Schema:   schema,
+				Expr:     `amount > 1000`,
+				Metadata: indigo.RuleMetadata{Severity: indigo.SeverityWarn},
+				Messages: map[string]string{"": "large payment of {{amount}}"},
+			},
+			"blocked": {
+				ID:       "blocked",
+				Schema:   schema,
+				Expr:     `country == "XX"`,
+				Metadata: indigo.RuleMetadata{Severity: indigo.SeverityDeny},
+			},
+		},
+	}
+}
+
+func TestProcessor(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	r := makePaymentRule()
+	is.NoErr(e.Compile(r))
+
+	src := &sliceSource{
+		messages: []stream.Message{
+			{Key: []byte("1"), Value: []byte(`{"amount": 5000, "country": "SE"}`)},
+			{Key: []byte("2"), Value: []byte(`{"amount": 10, "country": "XX"}`)},
+			{Key: []byte("3"), Value: []byte(`not json`)},
+			{Key: []byte("4"), Value: []byte(`{"amount": 10, "country": "SE", "extra": {"n": 1}}`)},
+		},
+	}
+	sink := &sliceSink{}
+
+	skipped := 0
+	p, err := stream.NewProcessor(e, r, src, sink, stream.OnError(func(m stream.Message, err error) error {
+		skipped++
+		return nil
+	}))
+	is.NoErr(err)
+	is.NoErr(p.Run(context.Background()))
+
+	is.Equal(skipped, 1)
+	is.Equal(src.acked, 4)
+	is.Equal(len(sink.messages), 3)
+
+	decisions := []stream.Decision{}
+	for _, m := range sink.messages {
+		d := stream.Decision{}
+		is.NoErr(json.Unmarshal(m.Value, &d))
+		decisions = append(decisions, d)
+	}
+
+	is.Equal(string(sink.messages[0].Key), "1")
+	is.Equal(decisions[0].Passed, []string{"large"})
+	is.Equal(decisions[0].Verdict, indigo.SeverityWarn)
+	is.Equal(decisions[0].Messages["large"], "large payment of 5000")
+	is.Equal(decisions[1].Passed, []string{"blocked"})
+	is.Equal(decisions[1].Verdict, indigo.SeverityDeny)
+	is.Equal(len(decisions[2].Passed), 0)
+}
+
+func TestProcessorStopsOnError(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(cel.NewEvaluator())
+	r := makePaymentRule()
+	is.NoErr(e.Compile(r))
+
+	src := &sliceSource{
+		messages: []stream.Message{
+			{Value: []byte(`{"amount": "a string"}`)},
+			{Value: []byte(`{"amount": 10, "country": "SE"}`)},
+		},
+	}
+	sink := &sliceSink{}
+
+	p, err := stream.NewProcessor(e, r, src, sink)
+	is.NoErr(err)
+	err = p.Run(context.Background())
+	is.True(err != nil)
+	is.Equal(len(sink.messages), 0)
+	is.Equal(src.acked, 0)
+
+	_, err = stream.NewProcessor(nil, r, src, sink)
+	is.True(errors.Is(err, indigo.ErrNilEngine))
+}
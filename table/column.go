@@ -0,0 +1,271 @@
+package table
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/dsl"
+)
+
+type columnKind int
+
+const (
+	kindInvalid columnKind = iota // a column whose header is not valid
+	kindID
+	kindCondition
+	kindOutput
+)
+
+// column describes a column of a table
+type column struct {
+	kind columnKind
+	name string // the name of the output, or the header of a condition
+
+	// The CEL expression of the element tested by a condition, and its type
+	cel string
+	typ indigo.Type
+
+	// The type inferred for the values of an output
+	out indigo.Type
+}
+
+// newColumn returns the column with the header h
+func newColumn(h string, s indigo.Schema) (column, error) {
+	h = strings.TrimSpace(h)
+	switch {
+	case strings.EqualFold(h, IDHeader):
+		return column{kind: kindID}, nil
+	case strings.HasPrefix(h, OutputPrefix):
+		name := strings.TrimSpace(strings.TrimPrefix(h, OutputPrefix))
+		if name == "" {
+			return column{}, fmt.Errorf("missing output name")
+		}
+		return column{kind: kindOutput, name: name}, nil
+	}
+
+	x, t, err := dsl.Name(h, s)
+	if err != nil {
+		return column{}, fmt.Errorf("header %q: %w", h, err)
+	}
+	return column{kind: kindCondition, name: h, cel: x, typ: t}, nil
+}
+
+// operators are the comparison operators of condition cells, longest first
+var operators = []struct {
+	symbol, op string
+}{
+	{">=", ">="},
+	{"<=", "<="},
+	{"!=", "!="},
+	{"<>", "!="},
+	{">", ">"},
+	{"<", "<"},
+	{"=", "=="},
+}
+
+// dslWords are the words that start a condition of package dsl in a cell
+var dslWords = []string{"is", "equals", "contains", "does", "starts", "ends", "matches"}
+
+// condition returns the CEL expression of the condition of the cell of the column,
+// or a blank expression if the cell matches any value
+func (c column) condition(cell string, s indigo.Schema) (string, error) {
+	switch strings.ToLower(cell) {
+	case "", "-", "*", "any":
+		return "", nil
+	}
+
+	for _, o := range operators {
+		if strings.HasPrefix(cell, o.symbol) {
+			v, err := c.value(strings.TrimSpace(strings.TrimPrefix(cell, o.symbol)))
+			if err != nil {
+				return "", err
+			}
+			return c.cel + " " + o.op + " " + v, nil
+		}
+	}
+
+	if strings.HasPrefix(cell, "[") && strings.HasSuffix(cell, "]") {
+		var vs []string
+		for _, item := range strings.Split(cell[1:len(cell)-1], ",") {
+			v, err := c.value(strings.TrimSpace(item))
+			if err != nil {
+				return "", err
+			}
+			vs = append(vs, v)
+		}
+		return c.cel + " in [" + strings.Join(vs, ", ") + "]", nil
+	}
+
+	if i := strings.Index(cell, ".."); i >= 0 {
+		l, err := c.value(strings.TrimSpace(cell[:i]))
+		if err != nil {
+			return "", err
+		}
+		h, err := c.value(strings.TrimSpace(cell[i+2:]))
+		if err != nil {
+			return "", err
+		}
+		return l + " <= " + c.cel + " && " + c.cel + " <= " + h, nil
+	}
+
+	first := strings.ToLower(strings.Fields(cell)[0])
+	for _, w := range dslWords {
+		if first == w {
+			x, err := dsl.Translate(c.name+" "+cell, s)
+			if err != nil {
+				return "", fmt.Errorf("condition %q: %w", cell, err)
+			}
+			return x, nil
+		}
+	}
+
+	v, err := c.value(cell)
+	if err != nil {
+		return "", err
+	}
+	return c.cel + " == " + v, nil
+}
+
+// value returns the CEL literal of the value v, converted to the type of the
+// element of the column
+func (c column) value(v string) (string, error) {
+	if v == "" {
+		return "", fmt.Errorf("missing value")
+	}
+	switch c.typ.(type) {
+	case indigo.String:
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		return strconv.Quote(v), nil
+	case indigo.Int:
+		n, ok := number(v)
+		if !ok || strings.Contains(n, ".") {
+			return "", fmt.Errorf("%q is not an integer", v)
+		}
+		return n, nil
+	case indigo.Float:
+		n, ok := number(v)
+		if !ok {
+			return "", fmt.Errorf("%q is not a number", v)
+		}
+		return float(n), nil
+	case indigo.Bool:
+		b, ok := boolean(v)
+		if !ok {
+			return "", fmt.Errorf("%q is not true or false", v)
+		}
+		return strconv.FormatBool(b), nil
+	case indigo.Timestamp:
+		t, err := timestamp(v)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("timestamp(%q)", t.Format(time.RFC3339)), nil
+	case indigo.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a duration", v)
+		}
+		return fmt.Sprintf("duration(%q)", d), nil
+	default:
+		return "", fmt.Errorf("the values of %s cannot be written in a table; use a condition such as \"is ...\"", c.name)
+	}
+}
+
+// infer infers the type of the values of the output column at the index i
+// of the rows
+func (c *column) infer(rows [][]string, i int) {
+	isInt, isFloat, isBool, seen := true, true, true, false
+	for _, row := range rows {
+		if i >= len(row) {
+			continue
+		}
+		v := strings.TrimSpace(row[i])
+		if v == "" || strings.HasPrefix(v, "=") {
+			continue
+		}
+		seen = true
+		n, ok := number(v)
+		isInt = isInt && ok && !strings.Contains(n, ".")
+		isFloat = isFloat && ok
+		_, ok = boolean(v)
+		isBool = isBool && ok
+	}
+
+	switch {
+	case !seen:
+		c.out = indigo.String{}
+	case isInt:
+		c.out = indigo.Int{}
+	case isFloat:
+		c.out = indigo.Float{}
+	case isBool:
+		c.out = indigo.Bool{}
+	default:
+		c.out = indigo.String{}
+	}
+}
+
+// output returns the CEL expression of the output cell of the column, or a blank
+// expression if the cell is blank
+func (c column) output(cell string) (string, error) {
+	if cell == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(cell, "=") {
+		x := strings.TrimSpace(strings.TrimPrefix(cell, "="))
+		if x == "" {
+			return "", fmt.Errorf("missing expression after =")
+		}
+		return x, nil
+	}
+	return column{name: c.name, typ: c.out}.value(cell)
+}
+
+// numberPattern matches numbers, with optional thousands separators
+var numberPattern = regexp.MustCompile(`^-?(\d{1,3}(,\d{3})+|\d+)(\.\d+)?$`)
+
+// number returns the number v without thousands separators, and false if v is
+// not a number
+func number(v string) (string, bool) {
+	if !numberPattern.MatchString(v) {
+		return "", false
+	}
+	return strings.ReplaceAll(v, ",", ""), true
+}
+
+// float returns the number n as a CEL double literal
+func float(n string) string {
+	if strings.Contains(n, ".") {
+		return n
+	}
+	return n + ".0"
+}
+
+// boolean returns the boolean value of v, and false if v is not a boolean
+func boolean(v string) (bool, bool) {
+	switch strings.ToLower(v) {
+	case "true", "yes":
+		return true, true
+	case "false", "no":
+		return false, true
+	}
+	return false, false
+}
+
+// timestamp parses v as an RFC 3339 time or a date
+func timestamp(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return t, fmt.Errorf("%q is not a timestamp or date", v)
+	}
+	return t, nil
+}
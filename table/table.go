@@ -0,0 +1,263 @@
+// Package table imports decision tables maintained in spreadsheets as rule trees,
+// so that analysts can keep logic such as pricing in a spreadsheet while services
+// evaluate it with the same engine as other rules.
+//
+// A table has a header row, followed by a row for each rule:
+//
+//     ID      | Amount       | Country   | => Fee           | => Tier
+//     small   | < 1,000      | SE        | 0                | basic
+//     large   | >= 1,000     | [SE, NO]  | = amount * 0.01  | premium
+//     other   | -            |           | 25               | basic
+//
+// A column whose header is ID holds the IDs of the rules (optional). A header
+// starting with => names an output of the rules (see indigo.Rule.Outputs). The
+// other headers are the names of the elements of the schema that the conditions
+// of their columns test, ignoring case, spaces and underscores, or the fields of
+// elements, as in "Student.GPA" (see package dsl).
+//
+// A condition cell holds one of:
+//
+//     (blank), -, *, any        any value
+//     value                     equal to the value
+//     = v, != v, <> v           equal, not equal to v
+//     > v, >= v, < v, <= v      compared with v
+//     a..b                      between a and b, inclusive
+//     [a, b, c]                 one of the values
+//     is ..., contains ...      a condition of package dsl, as in "starts with SE"
+//
+// Values are converted to the type of the element of their column, so that a cell
+// with a value of the wrong type, such as a word in a number column, is reported.
+// Numbers may have thousands separators, as in 1,000; timestamps are RFC 3339 times
+// or dates, as in 2024-01-31; durations are as in 1h30m.
+//
+// An output cell holds a value, or a CEL expression after =, as in = amount * 0.01.
+// Blank cells leave the output out. The values of an output column are integers,
+// floats or booleans if all of them are, and strings otherwise.
+//
+// A row passes if all its conditions are true. The rule returned by Import has the
+// rules of the rows as its child rules. Without an ID column, they are ordered as in
+// the table, so that EvalFirstMatch returns the first row that passes, as in a
+// first-hit decision table; Eval returns the results of all rows.
+//
+// ImportCSV reads CSV files. To import other spreadsheet formats, such as XLSX,
+// read the cells of the sheet with a spreadsheet library and pass them to Import.
+package table
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ezachrisen/indigo"
+)
+
+// IDHeader is the header of the column holding the IDs of the rules.
+const IDHeader = "ID"
+
+// OutputPrefix starts the headers of output columns.
+const OutputPrefix = "=>"
+
+// CellError is a problem in a cell of a table.
+type CellError struct {
+	// The row and column of the cell, starting at 1; the header row is row 1
+	Row    int
+	Column int
+
+	Err error
+}
+
+// Cell returns the reference of the cell in the A1 notation of spreadsheets,
+// as in C4.
+func (e *CellError) Cell() string {
+	col := ""
+	for c := e.Column; c > 0; c = (c - 1) / 26 {
+		col = string(rune('A'+(c-1)%26)) + col
+	}
+	return col + strconv.Itoa(e.Row)
+}
+
+func (e *CellError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Cell(), e.Err)
+}
+
+func (e *CellError) Unwrap() error {
+	return e.Err
+}
+
+// CellErrors are the problems in the cells of a table, in the order of the cells.
+type CellErrors []*CellError
+
+func (es CellErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Option is a functional option to specify the behavior of the importer.
+type Option func(im *importer)
+
+// CompileWith compiles the condition and output expression of each cell with c,
+// such as a cel.Evaluator, so that expressions that do not compile are reported
+// for their cell, rather than when the rule tree is compiled.
+func CompileWith(c indigo.ExpressionCompiler) Option {
+	return func(im *importer) {
+		im.compiler = c
+	}
+}
+
+// importer holds the options of an import
+type importer struct {
+	compiler indigo.ExpressionCompiler
+}
+
+// ImportCSV imports the decision table in CSV format read from r, as Import does.
+func ImportCSV(id string, r io.Reader, s indigo.Schema, opts ...Option) (*indigo.Rule, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading table %s: %w", id, err)
+	}
+	return Import(id, records, s, opts...)
+}
+
+// Import converts the decision table with the rows of cells records, following the
+// layout described in the package documentation, to a rule with the id, whose
+// child rules are the rows of the table, using the schema s. If cells are not
+// valid, the error is CellErrors, holding all the problems found.
+func Import(id string, records [][]string, s indigo.Schema, opts ...Option) (*indigo.Rule, error) {
+	im := &importer{}
+	for _, o := range opts {
+		o(im)
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("table %s: missing header row", id)
+	}
+
+	var errs CellErrors
+	cols := make([]column, len(records[0]))
+	for i, h := range records[0] {
+		c, err := newColumn(h, s)
+		if err != nil {
+			errs = append(errs, &CellError{Row: 1, Column: i + 1, Err: err})
+		}
+		cols[i] = c
+	}
+
+	rows := records[1:]
+	for i := range cols {
+		if cols[i].kind == kindOutput {
+			cols[i].infer(rows, i)
+		}
+	}
+
+	root := &indigo.Rule{ID: id, Schema: s, Rules: map[string]*indigo.Rule{}}
+	width := len(strconv.Itoa(len(records)))
+	for i, row := range rows {
+		if blank(row) {
+			continue
+		}
+		n := i + 2 // the row number in the spreadsheet
+
+		r := &indigo.Rule{ID: fmt.Sprintf("%s-row%0*d", id, width, n), Schema: s}
+		var conds []string
+		for j, cell := range row {
+			if j >= len(cols) {
+				if strings.TrimSpace(cell) != "" {
+					errs = append(errs, &CellError{Row: n, Column: j + 1, Err: fmt.Errorf("cell outside the table")})
+				}
+				continue
+			}
+			if err := im.cell(r, &conds, cols[j], strings.TrimSpace(cell)); err != nil {
+				errs = append(errs, &CellError{Row: n, Column: j + 1, Err: err})
+			}
+		}
+		r.Expr = strings.Join(conds, " && ")
+
+		if r.ID == "" {
+			errs = append(errs, &CellError{Row: n, Column: idColumn(cols), Err: fmt.Errorf("missing rule ID")})
+			continue
+		}
+		if _, ok := root.Rules[r.ID]; ok {
+			errs = append(errs, &CellError{Row: n, Column: idColumn(cols), Err: fmt.Errorf("duplicate rule ID %s", r.ID)})
+			continue
+		}
+		root.Rules[r.ID] = r
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return root, nil
+}
+
+// cell adds the condition or output of the cell of the column c to the rule r
+// of its row
+func (im *importer) cell(r *indigo.Rule, conds *[]string, c column, cell string) error {
+	switch c.kind {
+	case kindInvalid:
+		return nil // reported for the header
+
+	case kindID:
+		r.ID = cell
+		return nil
+
+	case kindOutput:
+		x, err := c.output(cell)
+		if err != nil || x == "" {
+			return err
+		}
+		if err := im.compile(x, r.Schema, indigo.Any{}); err != nil {
+			return err
+		}
+		if r.Outputs == nil {
+			r.Outputs = map[string]string{}
+		}
+		r.Outputs[c.name] = x
+		return nil
+	}
+
+	x, err := c.condition(cell, r.Schema)
+	if err != nil || x == "" {
+		return err
+	}
+	if err := im.compile(x, r.Schema, indigo.Bool{}); err != nil {
+		return err
+	}
+	*conds = append(*conds, x)
+	return nil
+}
+
+// compile compiles the expression x, if the importer has a compiler
+func (im *importer) compile(x string, s indigo.Schema, t indigo.Type) error {
+	if im.compiler == nil {
+		return nil
+	}
+	_, err := im.compiler.Compile(x, s, t, false, true)
+	return err
+}
+
+// idColumn returns the column number of the ID column, or 1 if there is none
+func idColumn(cols []column) int {
+	for i, c := range cols {
+		if c.kind == kindID {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// blank reports whether all the cells of the row are blank
+func blank(row []string) bool {
+	for _, c := range row {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
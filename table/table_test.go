@@ -0,0 +1,90 @@
+package table_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/ezachrisen/indigo/cel"
+	"github.com/ezachrisen/indigo/table"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/matryer/is"
+)
+
+var schema = indigo.Schema{
+	Elements: []indigo.DataElement{
+		{Name: "amount", Type: indigo.Float{}},
+		{Name: "country", Type: indigo.String{}},
+		{Name: "item_count", Type: indigo.Int{}},
+		{Name: "signed_up", Type: indigo.Timestamp{}},
+	},
+}
+
+func TestImportCSV(t *testing.T) {
+	is := is.New(t)
+
+	src := `Amount,Country,Item Count,Signed Up,=> Fee,=> Tier
+"< 1,000",SE,-,,0,basic
+"1,000..5,000","[SE, NO]",>= 2,< 2024-01-01,= amount * 0.01,premium
+,,,,,
+-,starts with D,,,25.5,basic
+`
+	e := indigo.NewEngine(cel.NewEvaluator())
+	r, err := table.ImportCSV("pricing", strings.NewReader(src), schema, table.CompileWith(cel.NewEvaluator()))
+	is.NoErr(err)
+	is.Equal(len(r.Rules), 3) // the blank row is skipped
+
+	row := r.Rules["pricing-row2"]
+	is.Equal(row.Expr, `amount < 1000.0 && country == "SE"`)
+	is.Equal(row.Outputs, map[string]string{"Fee": "0.0", "Tier": `"basic"`})
+
+	row = r.Rules["pricing-row3"]
+	is.Equal(row.Expr, `1000.0 <= amount && amount <= 5000.0 && country in ["SE", "NO"] && item_count >= 2 && signed_up < timestamp("2024-01-01T00:00:00Z")`)
+	is.Equal(row.Outputs["Fee"], "amount * 0.01")
+
+	row = r.Rules["pricing-row5"]
+	is.Equal(row.Expr, `country.startsWith("D")`)
+
+	is.NoErr(e.Compile(r))
+	u, err := indigo.EvalFirstMatch(context.Background(), e, r, map[string]interface{}{
+		"amount":     2000.0,
+		"country":    "NO",
+		"item_count": 3,
+		"signed_up":  &timestamp.Timestamp{},
+	})
+	is.NoErr(err)
+	is.Equal(u.Rule.ID, "pricing-row3")
+	is.Equal(u.Outputs["Fee"], 20.0)
+	is.Equal(u.Outputs["Tier"], "premium")
+}
+
+func TestImportErrors(t *testing.T) {
+	is := is.New(t)
+
+	records := [][]string{
+		{"ID", "Amount", "Price", "Country", "=> Fee"},
+		{"a", "> lots", "", "SE", "1"},
+		{"b", "", "", "> 3", "= amount +"},
+		{"a", "1", "", "", "2"},
+		{"", "1", "", "", "2", "extra"},
+	}
+	_, err := table.Import("t", records, schema, table.CompileWith(cel.NewEvaluator()))
+
+	var errs table.CellErrors
+	is.True(errors.As(err, &errs))
+
+	var cells []string
+	for _, e := range errs {
+		cells = append(cells, e.Cell())
+	}
+	is.Equal(cells, []string{
+		"C1", // unknown element
+		"B2", // not a number
+		"E3", // does not compile
+		"A4", // duplicate ID
+		"F5", // outside the table
+		"A5", // missing ID
+	})
+}
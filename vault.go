@@ -0,0 +1,706 @@
+package indigo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Vault holds a tree of rules and provides concurrency-safe methods
+// to add, replace, remove and evaluate the rules.
+//
+// As described in the package documentation, the calling application is
+// responsible for the lifecycle of rules it evaluates directly with an Engine.
+// A Vault takes on that responsibility: rules are compiled before they are
+// added, and rules are never modified while they are being evaluated.
+//
+// Changes are made copy-on-write: a change copies the rules on the path from the
+// root to the changed rule, and then publishes the new tree atomically. Evaluations
+// do not take any locks, and always see a consistent tree of rules, either from
+// before or after a change (see Snapshot). Changes are serialized.
+//
+// Rule IDs must be unique within a vault. After a rule has been added to
+// a vault, you must not modify it; use Replace instead.
+type Vault struct {
+	// The current root rule; always a *Rule
+	root atomic.Value
+
+	// Held while making changes
+	mu sync.Mutex
+
+	engine      Engine
+	compileOpts []CompilationOption
+
+	// Earlier versions of the rules (see KeepHistory)
+	histMu   sync.RWMutex
+	keepFor  time.Duration
+	versions []version
+
+	subMu       sync.Mutex
+	subscribers []func(ChangeEvent)
+
+	// Checks whether changes are allowed (see Authorize); guarded by mu
+	authorizer Authorizer
+}
+
+//go:generate stringer -type=ChangeType
+
+// ChangeType indicates the kind of change made to the rules in a vault.
+type ChangeType int
+
+const (
+	// Added means that a new rule (and its children) was added
+	Added ChangeType = iota
+
+	// Replaced means that an existing rule (and its children) was replaced
+	// by a rule with the same ID
+	Replaced
+
+	// Removed means that a rule (and its children) was removed
+	Removed
+)
+
+// ChangeEvent describes a change made to the rules in a vault.
+type ChangeEvent struct {
+	// The kind of change
+	Type ChangeType
+
+	// The ID of the rule added, replaced or removed
+	RuleID string
+
+	// The ID of the parent of the rule; blank for the root rule
+	ParentID string
+
+	// The rule after the change; nil if the rule was removed
+	Rule *Rule
+
+	// The rule before the change; nil if the rule was added
+	Previous *Rule
+}
+
+// NewVault compiles the root rule and its children, and returns a vault holding them.
+// The engine and compilation options are used to compile rules as they are
+// added to the vault, and to evaluate rules.
+func NewVault(e Engine, root *Rule, opts ...CompilationOption) (*Vault, error) {
+	if e == nil {
+		return nil, ErrNilEngine
+	}
+
+	if root == nil {
+		return nil, ErrNilRule
+	}
+
+	if err := checkUniqueIDs(root, map[string]bool{}); err != nil {
+		return nil, err
+	}
+
+	if err := e.Compile(root, opts...); err != nil {
+		return nil, err
+	}
+
+	v := &Vault{
+		engine:      e,
+		compileOpts: opts,
+	}
+	v.root.Store(root)
+	return v, nil
+}
+
+// Snapshot is an immutable view of the rules in a vault at a point in time.
+// Changes made to the vault after the snapshot was taken are not visible in the snapshot.
+// Taking a snapshot is cheap, and a snapshot is safe for concurrent use.
+//
+// Use a snapshot to evaluate several rules against the same version of the rules,
+// or to keep evaluating the current rules while the next set of rules is prepared.
+type Snapshot struct {
+	root   *Rule
+	engine Engine
+}
+
+// Snapshot returns a snapshot of the current rules in the vault.
+func (v *Vault) Snapshot() *Snapshot {
+	return &Snapshot{
+		root:   v.root.Load().(*Rule),
+		engine: v.engine,
+	}
+}
+
+// Subscribe registers a function that is called after each change to the rules in the vault.
+// Functions are called in the order they were registered, after the change is complete.
+// The functions are called synchronously by the goroutine making the change,
+// so they should return quickly.
+func (v *Vault) Subscribe(f func(ChangeEvent)) {
+	v.subMu.Lock()
+	defer v.subMu.Unlock()
+	v.subscribers = append(v.subscribers, f)
+}
+
+// Add compiles the rule r and adds it as a child of the rule with parentID.
+// The IDs of r and its children must not already exist in the vault.
+func (v *Vault) Add(parentID string, r *Rule) error {
+	return v.add("", parentID, r)
+}
+
+// add adds the rule r as a child of the rule with parentID, on behalf of the principal
+func (v *Vault) add(principal, parentID string, r *Rule) error {
+	if r == nil {
+		return ErrNilRule
+	}
+
+	if err := checkUniqueIDs(r, map[string]bool{}); err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	err := v.authorize(ChangeRequest{Principal: principal, Type: Added, ParentID: parentID, RuleIDs: sortedIDs(r)})
+	v.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := v.engine.Compile(r, v.compileOpts...); err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	root := v.root.Load().(*Rule)
+	if err := checkUniqueIDs(root, ruleIDs(r)); err != nil {
+		v.mu.Unlock()
+		v.releasePrograms(r, nil)
+		return err
+	}
+
+	newRoot, parent := copyPath(root, parentID)
+	if parent == nil {
+		v.mu.Unlock()
+		v.releasePrograms(r, nil)
+		return fmt.Errorf("parent %s: %w", parentID, ErrRuleNotFound)
+	}
+
+	parent.Rules[r.ID] = r
+	v.reindex(parent)
+	v.publish(newRoot)
+	v.mu.Unlock()
+
+	v.notify(ChangeEvent{Type: Added, RuleID: r.ID, ParentID: parentID, Rule: r})
+	return nil
+}
+
+// Replace compiles the rule r and replaces the rule in the vault with the same ID.
+// The children of the existing rule are replaced by the children of r.
+func (v *Vault) Replace(r *Rule) error {
+	return v.replace("", r)
+}
+
+// replace replaces the rule with the ID of r, on behalf of the principal,
+// compiling r with the vault's compilation options and the options opts
+func (v *Vault) replace(principal string, r *Rule, opts ...CompilationOption) error {
+	if r == nil {
+		return ErrNilRule
+	}
+
+	if err := checkUniqueIDs(r, map[string]bool{}); err != nil {
+		return err
+	}
+
+	if err := v.engine.Compile(r, append(v.compileOpts[:len(v.compileOpts):len(v.compileOpts)], opts...)...); err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	root := v.root.Load().(*Rule)
+	old, oldParent := findRule(root, nil, r.ID)
+	if old == nil {
+		v.mu.Unlock()
+		v.releasePrograms(r, nil)
+		return fmt.Errorf("rule %s: %w", r.ID, ErrRuleNotFound)
+	}
+
+	// The new rule's children may only reuse IDs from the rule being replaced
+	existing := ruleIDs(root)
+	for id := range ruleIDs(old) {
+		delete(existing, id)
+	}
+	if err := checkUniqueIDs(r, existing); err != nil {
+		v.mu.Unlock()
+		v.releasePrograms(r, nil)
+		return err
+	}
+
+	parentID := ""
+	if oldParent != nil {
+		parentID = oldParent.ID
+	}
+	if err := v.authorize(ChangeRequest{Principal: principal, Type: Replaced, ParentID: parentID,
+		RuleIDs: sortedIDs(old, r)}); err != nil {
+		v.mu.Unlock()
+		v.releasePrograms(r, nil)
+		return err
+	}
+
+	if oldParent == nil {
+		v.publish(r)
+	} else {
+		newRoot, parent := copyPath(root, oldParent.ID)
+		parent.Rules[r.ID] = r
+		v.reindex(parent)
+		v.publish(newRoot)
+	}
+	v.mu.Unlock()
+
+	// The rules of the old tree that are not in the new one no longer use their programs
+	v.releasePrograms(old, rulesOf(r))
+	v.notify(ChangeEvent{Type: Replaced, RuleID: r.ID, ParentID: parentID, Rule: r, Previous: old})
+	return nil
+}
+
+// RuleChanges lists the rules changed by Vault.Update, by ID, sorted.
+type RuleChanges struct {
+	// The rules that were not in the vault
+	Added []string
+
+	// The rules that are no longer in the vault
+	Removed []string
+
+	// The rules whose expressions, guards, outputs, schemas or result types
+	// changed, which were recompiled
+	Changed []string
+}
+
+// Update replaces the rule in the vault with the same ID by r, like Replace, but
+// only compiles the rules of r whose expressions, guards, outputs, schemas or
+// result types differ from the rule with the same ID in the vault; the other rules
+// reuse the programs of the rules they replace. This makes small edits to large
+// trees cheap. It returns the rules added, removed and changed.
+//
+// Only rules compiled by a DefaultEngine are reused; with other engines, all the
+// rules of r are compiled, and reported as changed if they were in the vault.
+func (v *Vault) Update(r *Rule) (*RuleChanges, error) {
+	return v.update("", r)
+}
+
+// update updates the rule with the ID of r, on behalf of the principal
+func (v *Vault) update(principal string, r *Rule) (*RuleChanges, error) {
+	if r == nil {
+		return nil, ErrNilRule
+	}
+
+	old, err := v.Rule(r.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := map[string]*Rule{}
+	_ = ApplyToRule(old, func(c *Rule) error {
+		previous[c.ID] = c
+		return nil
+	})
+
+	var recompiled []string
+	if err := v.replace(principal, r, func(o *compileOptions) {
+		o.previous = previous
+		o.recompiled = &recompiled
+	}); err != nil {
+		return nil, err
+	}
+	if _, ok := v.engine.(*DefaultEngine); !ok {
+		recompiled = sortedIDs(r)
+	}
+
+	changes := &RuleChanges{}
+	current := ruleIDs(r)
+	for _, id := range recompiled {
+		if previous[id] != nil {
+			changes.Changed = append(changes.Changed, id)
+		}
+	}
+	for id := range current {
+		if previous[id] == nil {
+			changes.Added = append(changes.Added, id)
+		}
+	}
+	for id := range previous {
+		if !current[id] {
+			changes.Removed = append(changes.Removed, id)
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Changed)
+	return changes, nil
+}
+
+// Remove removes the rule with the id, and its children, from the vault.
+// The root rule cannot be removed.
+func (v *Vault) Remove(id string) error {
+	return v.remove("", id)
+}
+
+// remove removes the rule with the id, on behalf of the principal
+func (v *Vault) remove(principal, id string) error {
+	return v.removeIDs(principal, []string{id})
+}
+
+// RemoveRules removes the rules with the ids, and their children, from the vault,
+// in a single change: either all the rules are removed, or, if one of them cannot
+// be removed, none is. The root rule cannot be removed.
+func (v *Vault) RemoveRules(ids ...string) error {
+	return v.removeIDs("", ids)
+}
+
+// removeIDs removes the rules with the ids, on behalf of the principal
+func (v *Vault) removeIDs(principal string, ids []string) error {
+	return v.removeRules(principal, func(*Rule) ([]string, error) {
+		return ids, nil
+	})
+}
+
+// RemoveByTag removes the rules with the tag in their metadata (see
+// RuleMetadata.Tags), and their children, from the vault, in a single change,
+// returning the IDs of the rules with the tag, sorted. The root rule cannot be removed.
+func (v *Vault) RemoveByTag(tag string) ([]string, error) {
+	return v.removeByTag("", tag)
+}
+
+// removeByTag removes the rules with the tag, on behalf of the principal
+func (v *Vault) removeByTag(principal, tag string) ([]string, error) {
+	var removed []string
+	err := v.removeRules(principal, func(root *Rule) ([]string, error) {
+		removed = taggedIDs(root, tag)
+		return removed, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// removeRules removes the rules with the ids returned by selectIDs, which is called
+// with the current root rule while the vault is locked, on behalf of the principal.
+// Each rule removed is authorized as a separate change.
+func (v *Vault) removeRules(principal string, selectIDs func(root *Rule) ([]string, error)) error {
+	v.mu.Lock()
+	root := v.root.Load().(*Rule)
+	ids, err := selectIDs(root)
+	if err != nil {
+		v.mu.Unlock()
+		return err
+	}
+
+	type removal struct {
+		old, parent *Rule
+	}
+	var removals []removal
+	removing := map[string]bool{}
+	for _, id := range ids {
+		removing[id] = true
+	}
+	for _, id := range ids {
+		old, oldParent := findRule(root, nil, id)
+		if old == nil {
+			v.mu.Unlock()
+			return fmt.Errorf("rule %s: %w", id, ErrRuleNotFound)
+		}
+
+		if oldParent == nil {
+			v.mu.Unlock()
+			return fmt.Errorf("rule %s: cannot remove the root rule", id)
+		}
+
+		// Rules removed with one of their ancestors need not be removed themselves
+		if removingAncestor(root, id, removing) {
+			continue
+		}
+
+		if err := v.authorize(ChangeRequest{Principal: principal, Type: Removed, ParentID: oldParent.ID,
+			RuleIDs: sortedIDs(old)}); err != nil {
+			v.mu.Unlock()
+			return err
+		}
+		removals = append(removals, removal{old: old, parent: oldParent})
+	}
+	if len(removals) == 0 {
+		v.mu.Unlock()
+		return nil
+	}
+
+	newRoot := root
+	parents := map[string]bool{}
+	for _, rm := range removals {
+		var parent *Rule
+		newRoot, parent = copyPath(newRoot, rm.parent.ID)
+		delete(parent.Rules, rm.old.ID)
+		parents[parent.ID] = true
+	}
+	// Parents are reindexed once all their children are removed, since later
+	// removals may have copied them again
+	for id := range parents {
+		p, _ := findRule(newRoot, nil, id)
+		v.reindex(p)
+	}
+	v.publish(newRoot)
+	v.mu.Unlock()
+
+	for _, rm := range removals {
+		v.releasePrograms(rm.old, nil)
+		v.notify(ChangeEvent{Type: Removed, RuleID: rm.old.ID, ParentID: rm.parent.ID, Previous: rm.old})
+	}
+	return nil
+}
+
+// releasePrograms releases the shared programs (see SharePrograms) of the rule r
+// and its descendants, except the rules in keep, which are no longer in the vault
+func (v *Vault) releasePrograms(r *Rule, keep map[*Rule]bool) {
+	if e, ok := v.engine.(*DefaultEngine); ok {
+		e.releasePrograms(r, keep)
+	}
+}
+
+// rulesOf returns the rule r and its descendants
+func rulesOf(r *Rule) map[*Rule]bool {
+	rules := map[*Rule]bool{}
+	_ = ApplyToRule(r, func(c *Rule) error {
+		rules[c] = true
+		return nil
+	})
+	return rules
+}
+
+// removingAncestor reports whether an ancestor of the rule with the id is being removed
+func removingAncestor(root *Rule, id string, removing map[string]bool) bool {
+	path := findPath(root, id)
+	for _, r := range path[:len(path)-1] {
+		if removing[r.ID] {
+			return true
+		}
+	}
+	return false
+}
+
+// taggedIDs returns the IDs of the rules with the tag, sorted
+func taggedIDs(root *Rule, tag string) []string {
+	var ids []string
+	Walk(root, func(r *Rule, _ int) bool {
+		if contains(r.Metadata.Tags, tag) {
+			ids = append(ids, r.ID)
+		}
+		return true
+	})
+	sort.Strings(ids)
+	return ids
+}
+
+// Eval evaluates the rule with the id, and its children, against the data,
+// using the current rules in the vault, or the rules at the time given with the AsOf option.
+func (v *Vault) Eval(ctx context.Context, id string, d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+	s, err := v.snapshotFor(opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.Eval(ctx, id, d, opts...)
+}
+
+// snapshotFor returns the current snapshot, or the snapshot at the time given
+// with the AsOf option
+func (v *Vault) snapshotFor(opts []EvalOption) (*Snapshot, error) {
+	o := EvalOptions{}
+	applyEvaluatorOptions(&o, opts...)
+	if o.AsOf.IsZero() {
+		return v.Snapshot(), nil
+	}
+	return v.SnapshotAt(o.AsOf)
+}
+
+// Rule returns the rule with the id.
+// The rule is owned by the vault; you must not modify it.
+func (v *Vault) Rule(id string) (*Rule, error) {
+	return v.Snapshot().Rule(id)
+}
+
+// RuleCount returns the number of rules in the vault, including the root rule.
+func (v *Vault) RuleCount() int {
+	return v.Snapshot().RuleCount()
+}
+
+// Walk calls f for each rule in the vault (see the Walk function).
+// Changes made to the vault while Walk is in progress are not visible to f.
+func (v *Vault) Walk(f func(r *Rule, depth int) bool) {
+	v.Snapshot().Walk(f)
+}
+
+// Eval evaluates the rule with the id, and its children, against the data.
+func (s *Snapshot) Eval(ctx context.Context, id string, d map[string]interface{}, opts ...EvalOption) (*Result, error) {
+	r, _ := findRule(s.root, nil, id)
+	if r == nil {
+		return nil, fmt.Errorf("rule %s: %w", id, ErrRuleNotFound)
+	}
+	return s.engine.Eval(ctx, r, d, opts...)
+}
+
+// Rule returns the rule with the id.
+// The rule is owned by the vault; you must not modify it.
+func (s *Snapshot) Rule(id string) (*Rule, error) {
+	r, _ := findRule(s.root, nil, id)
+	if r == nil {
+		return nil, fmt.Errorf("rule %s: %w", id, ErrRuleNotFound)
+	}
+	return r, nil
+}
+
+// RuleCount returns the number of rules in the snapshot, including the root rule.
+func (s *Snapshot) RuleCount() int {
+	n := 0
+	Walk(s.root, func(*Rule, int) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Walk calls f for each rule in the snapshot (see the Walk function).
+func (s *Snapshot) Walk(f func(r *Rule, depth int) bool) {
+	Walk(s.root, f)
+}
+
+// reindex rebuilds the index (see Rule.IndexBy) and the combined programs
+// (see CombineSiblings) of the child rules of r after its children have changed.
+// If they cannot be rebuilt, they are removed, and all child rules are evaluated
+// one by one. r must be a copy that is not yet visible to readers.
+func (v *Vault) reindex(r *Rule) {
+	if r.index == nil && r.combined == nil && r.IndexBy == "" {
+		return
+	}
+
+	r.index, r.combined = nil, nil
+	if de, ok := v.engine.(*DefaultEngine); ok && de.e != nil {
+		o := compileOptions{}
+		applyCompilerOptions(&o, v.compileOpts...)
+		o.dryRun = false
+		if err := de.prepareChildren(r, o); err != nil {
+			r.index, r.combined = nil, nil
+		}
+	}
+}
+
+// notify logs the change, and calls the subscribers with the event
+func (v *Vault) notify(ev ChangeEvent) {
+	if e, ok := v.engine.(*DefaultEngine); ok {
+		e.logChange(ev)
+	}
+
+	v.subMu.Lock()
+	subs := v.subscribers
+	v.subMu.Unlock()
+
+	for _, f := range subs {
+		f(ev)
+	}
+}
+
+// copyPath copies the rules on the path from the root to the rule with the id,
+// returning the copy of the root and the copy of the rule, which the caller may
+// modify. The rules that are not on the path are shared with the original tree.
+// Returns a nil rule if no rule was found.
+func copyPath(root *Rule, id string) (*Rule, *Rule) {
+	path := findPath(root, id)
+	if path == nil {
+		return nil, nil
+	}
+
+	newRoot := shallowCopy(path[0])
+	cur := newRoot
+	for _, r := range path[1:] {
+		c := shallowCopy(r)
+		cur.Rules[c.ID] = c
+		cur = c
+	}
+	return newRoot, cur
+}
+
+// findPath returns the rules on the path from r to the rule with the id,
+// or nil if the rule was not found
+func findPath(r *Rule, id string) []*Rule {
+	if r == nil {
+		return nil
+	}
+
+	if r.ID == id {
+		return []*Rule{r}
+	}
+
+	for _, c := range r.Rules {
+		if p := findPath(c, id); p != nil {
+			return append([]*Rule{r}, p...)
+		}
+	}
+	return nil
+}
+
+// shallowCopy returns a copy of the rule with a copy of its map of child rules
+func shallowCopy(r *Rule) *Rule {
+	c := *r
+	c.Rules = make(map[string]*Rule, len(r.Rules)+1)
+	for k, v := range r.Rules {
+		c.Rules[k] = v
+	}
+	return &c
+}
+
+// findRule searches the rule r and its descendants for the rule with the id,
+// returning the rule and its parent. parent is the parent of r.
+// Returns a nil rule if no rule was found.
+func findRule(r, parent *Rule, id string) (*Rule, *Rule) {
+	if r == nil {
+		return nil, nil
+	}
+
+	if r.ID == id {
+		return r, parent
+	}
+
+	for _, c := range r.Rules {
+		if f, p := findRule(c, r, id); f != nil {
+			return f, p
+		}
+	}
+	return nil, nil
+}
+
+// checkUniqueIDs returns an error if the IDs of r and its descendants
+// are not unique, or are among the ids already seen. It also checks that
+// child rules are stored in their parent's map under their own ID.
+func checkUniqueIDs(r *Rule, seen map[string]bool) error {
+	if r == nil {
+		return ErrNilRule
+	}
+
+	if seen[r.ID] {
+		return fmt.Errorf("rule %s: %w", r.ID, ErrDuplicateRuleID)
+	}
+	seen[r.ID] = true
+
+	for k, c := range r.Rules {
+		if c != nil && c.ID != k {
+			return fmt.Errorf("rule %s is stored with key %s in parent %s", c.ID, k, r.ID)
+		}
+		if err := checkUniqueIDs(c, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ruleIDs returns the set of IDs of r and its descendants
+func ruleIDs(r *Rule) map[string]bool {
+	ids := map[string]bool{}
+	_ = ApplyToRule(r, func(c *Rule) error {
+		if c != nil {
+			ids[c.ID] = true
+		}
+		return nil
+	})
+	return ids
+}
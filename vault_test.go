@@ -0,0 +1,523 @@
+package indigo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ezachrisen/indigo"
+	"github.com/matryer/is"
+)
+
+// Test adding, replacing and removing rules in a vault
+func TestVaultMutations(t *testing.T) {
+	is := is.New(t)
+
+	v, err := indigo.NewVault(indigo.NewEngine(newMockEvaluator()), makeRule())
+	is.NoErr(err)
+
+	d := map[string]interface{}{}
+	u, err := v.Eval(context.Background(), "rule1", d)
+	is.NoErr(err)
+	is.Equal(len(flattenResults(u)), 16)
+
+	// Add
+	is.NoErr(v.Add("D", &indigo.Rule{ID: "d4", Expr: "true"}))
+	u, err = v.Eval(context.Background(), "D", d)
+	is.NoErr(err)
+	is.True(u.Results["d4"].Pass)
+
+	err = v.Add("D", &indigo.Rule{ID: "b1"})
+	is.True(errors.Is(err, indigo.ErrDuplicateRuleID))
+
+	err = v.Add("nope", &indigo.Rule{ID: "x"})
+	is.True(errors.Is(err, indigo.ErrRuleNotFound))
+
+	err = v.Add("D", &indigo.Rule{ID: "x", Expr: "invalid"})
+	var ce *indigo.CompileError
+	is.True(errors.As(err, &ce))
+
+	// Replace
+	is.NoErr(v.Replace(&indigo.Rule{ID: "B", Expr: "true", Rules: map[string]*indigo.Rule{
+		"b1": {ID: "b1", Expr: "false"}, // reuse an ID from the replaced rule
+	}}))
+	u, err = v.Eval(context.Background(), "B", d)
+	is.NoErr(err)
+	is.True(u.Pass)
+	is.Equal(len(u.Results), 1)
+	is.True(!u.Results["b1"].Pass)
+
+	err = v.Replace(&indigo.Rule{ID: "B", Rules: map[string]*indigo.Rule{"d1": {ID: "d1"}}})
+	is.True(errors.Is(err, indigo.ErrDuplicateRuleID)) // d1 is a child of D
+
+	// Remove
+	is.NoErr(v.Remove("E"))
+	_, err = v.Eval(context.Background(), "e1", d)
+	is.True(errors.Is(err, indigo.ErrRuleNotFound))
+	is.True(v.Remove("rule1") != nil) // cannot remove the root
+}
+
+// Test that subscribers are notified of changes to the vault
+func TestVaultSubscribe(t *testing.T) {
+	is := is.New(t)
+
+	v, err := indigo.NewVault(indigo.NewEngine(newMockEvaluator()), makeRule())
+	is.NoErr(err)
+
+	events := []indigo.ChangeEvent{}
+	v.Subscribe(func(ev indigo.ChangeEvent) {
+		events = append(events, ev)
+	})
+
+	is.NoErr(v.Add("D", &indigo.Rule{ID: "d4"}))
+	is.NoErr(v.Replace(&indigo.Rule{ID: "d4", Expr: "true"}))
+	is.NoErr(v.Remove("d4"))
+	is.True(v.Remove("d4") != nil) // failed changes are not published
+
+	is.Equal(len(events), 3)
+	is.Equal(events[0].Type, indigo.Added)
+	is.Equal(events[0].ParentID, "D")
+	is.Equal(events[1].Type, indigo.Replaced)
+	is.Equal(events[1].Rule.Expr, "true")
+	is.Equal(events[1].Previous.Expr, "")
+	is.Equal(events[2].Type.String(), "Removed")
+	is.Equal(events[2].Rule, nil)
+	is.Equal(events[2].RuleID, "d4")
+}
+
+// Test listing and iterating the rules in a vault
+func TestVaultWalk(t *testing.T) {
+	is := is.New(t)
+
+	v, err := indigo.NewVault(indigo.NewEngine(newMockEvaluator()), makeRule())
+	is.NoErr(err)
+	is.Equal(v.RuleCount(), 16)
+
+	r, err := v.Rule("b4-2")
+	is.NoErr(err)
+	is.Equal(r.Expr, "false")
+
+	_, err = v.Rule("nope")
+	is.True(errors.Is(err, indigo.ErrRuleNotFound))
+
+	ids := []string{}
+	v.Walk(func(r *indigo.Rule, depth int) bool {
+		ids = append(ids, fmt.Sprintf("%d:%s", depth, r.ID))
+		return r.ID != "b4-1" // stop the walk
+	})
+	is.Equal(ids, []string{"0:rule1", "1:B", "2:b1", "2:b2", "2:b3", "2:b4", "3:b4-1"})
+}
+
+// Test that snapshots are not affected by later changes to the vault,
+// and that the vault can be changed while rules are being evaluated
+func TestVaultSnapshot(t *testing.T) {
+	is := is.New(t)
+
+	v, err := indigo.NewVault(indigo.NewEngine(newMockEvaluator()), makeRule())
+	is.NoErr(err)
+
+	s := v.Snapshot()
+	is.NoErr(v.Add("D", &indigo.Rule{ID: "d4", Expr: "true"}))
+	is.NoErr(v.Replace(&indigo.Rule{ID: "b1", Expr: "changed"}))
+	is.NoErr(v.Remove("E"))
+
+	is.Equal(v.RuleCount(), 13)
+	is.Equal(s.RuleCount(), 16)
+
+	_, err = s.Rule("d4")
+	is.True(errors.Is(err, indigo.ErrRuleNotFound))
+	r, err := s.Rule("b1")
+	is.NoErr(err)
+	is.True(r.Expr != "changed")
+	u, err := s.Eval(context.Background(), "E", map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(u.Rule.ID, "E")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_, err := v.Eval(context.Background(), "rule1", map[string]interface{}{})
+			is.NoErr(err)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("x%d", i)
+		is.NoErr(v.Add("D", &indigo.Rule{ID: id}))
+		is.NoErr(v.Remove(id))
+	}
+	<-done
+}
+
+// Test evaluating the rules in a vault as they were at an earlier time
+func TestVaultHistory(t *testing.T) {
+	is := is.New(t)
+
+	v, err := indigo.NewVault(indigo.NewEngine(newMockEvaluator()), makeRule())
+	is.NoErr(err)
+
+	_, err = v.SnapshotAt(time.Now())
+	is.True(errors.Is(err, indigo.ErrHistoryUnavailable))
+
+	v.KeepHistory(time.Hour)
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+	is.NoErr(v.Add("D", &indigo.Rule{ID: "d4", Expr: "true"}))
+
+	_, err = v.SnapshotAt(before.Add(-time.Minute))
+	is.True(errors.Is(err, indigo.ErrHistoryUnavailable))
+
+	u, err := v.Eval(context.Background(), "D", map[string]interface{}{}, indigo.AsOf(before))
+	is.NoErr(err)
+	is.Equal(len(u.Results), 3)
+
+	u, err = v.Eval(context.Background(), "D", map[string]interface{}{})
+	is.NoErr(err)
+	is.Equal(len(u.Results), 4)
+
+	s, err := v.SnapshotAt(time.Now())
+	is.NoErr(err)
+	is.Equal(s.RuleCount(), v.RuleCount())
+
+	v.KeepHistory(0)
+	_, err = v.Eval(context.Background(), "D", map[string]interface{}{}, indigo.AsOf(before))
+	is.True(errors.Is(err, indigo.ErrHistoryUnavailable))
+}
+
+// Test partitioning rules across shards, and evaluating the shards
+func TestShardedVault(t *testing.T) {
+	is := is.New(t)
+
+	newEngine := func() indigo.Engine { return indigo.NewEngine(newMockEvaluator()) }
+	v, err := indigo.NewShardedVault(newEngine, makeRule(), 3, nil)
+	is.NoErr(err)
+	is.Equal(v.Shards(), 3)
+	is.Equal(v.RuleCount(), 16)
+
+	d := map[string]interface{}{}
+	want, err := indigo.NewEngine(newMockEvaluator()).Eval(context.Background(), compiled(t, makeRule()), d)
+	is.NoErr(err)
+
+	var reported int32 // OnResult is called concurrently by the shards
+	u, err := v.Eval(context.Background(), "rule1", d, indigo.OnResult(func(*indigo.Result) { atomic.AddInt32(&reported, 1) }))
+	is.NoErr(err)
+	is.Equal(len(flattenResults(u)), 16)
+	is.Equal(atomic.LoadInt32(&reported), int32(16))
+	is.Equal(u.EvaluationCount, want.EvaluationCount)
+	is.Equal(u.Pass, want.Pass)
+	for i := range want.OrderedResults {
+		is.Equal(u.OrderedResults[i].Rule.ID, want.OrderedResults[i].Rule.ID)
+	}
+
+	u, err = v.Eval(context.Background(), "D", d)
+	is.NoErr(err)
+	is.Equal(len(u.Results), 3)
+
+	is.NoErr(v.Add("rule1", &indigo.Rule{ID: "F", Expr: "true"}))
+	is.NoErr(v.Add("D", &indigo.Rule{ID: "d4", Expr: "true"}))
+	is.True(errors.Is(v.Add("rule1", &indigo.Rule{ID: "b1"}), indigo.ErrDuplicateRuleID))
+	is.NoErr(v.Replace(&indigo.Rule{ID: "F", Expr: "false"}))
+	is.True(v.Replace(&indigo.Rule{ID: "rule1"}) != nil)
+	is.NoErr(v.Remove("E"))
+	is.Equal(v.RuleCount(), 14)
+
+	r, err := v.Rule("F")
+	is.NoErr(err)
+	is.Equal(r.Expr, "false")
+
+	u, err = v.Eval(context.Background(), "rule1", d, indigo.RollupChildResults(true))
+	is.NoErr(err)
+	is.True(!u.Pass)
+	is.True(!u.Results["F"].Pass)
+}
+
+// Test that the root's quorum counts the children that pass in all the shards
+func TestShardedVaultQuorum(t *testing.T) {
+	is := is.New(t)
+
+	root := &indigo.Rule{
+		ID:     "root",
+		Expr:   "true",
+		Quorum: indigo.AtLeast(2),
+		Rules: map[string]*indigo.Rule{
+			"a": {ID: "a", Expr: "true"},
+			"b": {ID: "b", Expr: "false"},
+			"c": {ID: "c", Expr: "true"},
+			"d": {ID: "d", Expr: "false"},
+		},
+	}
+	// a and b in shard 0, c and d in shard 1, so that each shard has one child passing
+	split := func(r *indigo.Rule, n int) int {
+		if r.ID == "a" || r.ID == "b" {
+			return 0
+		}
+		return 1
+	}
+
+	newEngine := func() indigo.Engine { return indigo.NewEngine(newMockEvaluator()) }
+	v, err := indigo.NewShardedVault(newEngine, root, 2, split)
+	is.NoErr(err)
+
+	u, err := v.Eval(context.Background(), "root", map[string]interface{}{})
+	is.NoErr(err)
+	is.True(u.Pass)
+	is.Equal(u.ChildrenPassed, 2)
+	is.Equal(u.ChildrenFailed, 2)
+
+	is.NoErr(v.Replace(&indigo.Rule{ID: "c", Expr: "false"}))
+	u, err = v.Eval(context.Background(), "root", map[string]interface{}{})
+	is.NoErr(err)
+	is.True(!u.Pass)
+	is.Equal(u.Status, indigo.StatusFail)
+	is.Equal(u.ChildrenPassed, 1)
+
+	root.Quorum = indigo.AtMost(1)
+	v, err = indigo.NewShardedVault(newEngine, root, 2, split)
+	is.NoErr(err)
+	u, err = v.Eval(context.Background(), "root", map[string]interface{}{})
+	is.NoErr(err)
+	is.True(!u.Pass) // 2 pass, 1 in each shard
+}
+
+// compiled compiles the rule with the mock evaluator
+func compiled(t *testing.T, r *indigo.Rule) *indigo.Rule {
+	t.Helper()
+	if err := indigo.NewEngine(newMockEvaluator()).Compile(r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// Test that the first matching child rule is returned
+func TestEvalFirstMatch(t *testing.T) {
+	is := is.New(t)
+
+	var evaluated []string
+	root := &indigo.Rule{
+		ID:   "policies",
+		Expr: "true",
+		Rules: map[string]*indigo.Rule{
+			"a": {ID: "a", Expr: "false"},
+			"b": {ID: "b", Expr: "true"},
+			"c": {ID: "c", Expr: "true"},
+		},
+	}
+	v, err := indigo.NewVault(indigo.NewEngine(newMockEvaluator()), root)
+	is.NoErr(err)
+
+	onResult := indigo.OnResult(func(u *indigo.Result) {
+		evaluated = append(evaluated, u.Rule.ID)
+	})
+	u, err := v.EvalFirstMatch(context.Background(), "policies", map[string]interface{}{}, onResult, indigo.DiscardPass(true))
+	is.NoErr(err)
+	is.Equal(u.Rule.ID, "b")
+	is.Equal(evaluated, []string{"a", "b", "policies"}) // c is not evaluated
+
+	is.NoErr(v.Replace(&indigo.Rule{ID: "b", Expr: "false"}))
+	is.NoErr(v.Replace(&indigo.Rule{ID: "c", Expr: "false"}))
+	u, err = v.EvalFirstMatch(context.Background(), "policies", map[string]interface{}{})
+	is.NoErr(err)
+	is.True(u == nil) // no match
+
+	_, err = v.EvalFirstMatch(context.Background(), "nope", map[string]interface{}{})
+	is.True(errors.Is(err, indigo.ErrRuleNotFound))
+}
+
+// Test that changes to a vault are authorized by principal and rule IDs
+func TestVaultAuthorize(t *testing.T) {
+	is := is.New(t)
+
+	v, err := indigo.NewVault(indigo.NewEngine(newMockEvaluator()), makeRule())
+	is.NoErr(err)
+
+	errDenied := errors.New("denied")
+	var requests []indigo.ChangeRequest
+	v.Authorize(func(req indigo.ChangeRequest) error {
+		requests = append(requests, req)
+		if req.Principal == "" {
+			return errDenied
+		}
+		for _, id := range req.RuleIDs {
+			if req.Principal != "admin" && !strings.HasPrefix(id, req.Principal) {
+				return errDenied
+			}
+		}
+		return nil
+	})
+
+	is.NoErr(v.As("d").Add("D", &indigo.Rule{ID: "d4", Expr: "true"}))
+	is.True(errors.Is(v.As("d").Add("D", &indigo.Rule{ID: "x", Expr: "true"}), errDenied))
+	is.True(errors.Is(v.Add("D", &indigo.Rule{ID: "d5", Expr: "true"}), errDenied)) // no principal
+	is.True(errors.Is(v.As("d").Remove("E"), errDenied))
+	is.NoErr(v.As("admin").Replace(&indigo.Rule{ID: "E", Expr: "true"}))
+	is.NoErr(v.As("admin").Remove("E"))
+
+	is.Equal(requests[0], indigo.ChangeRequest{Principal: "d", Type: indigo.Added, ParentID: "D", RuleIDs: []string{"d4"}})
+	is.Equal(requests[3].RuleIDs, []string{"E", "e1", "e2", "e3"})
+	is.Equal(requests[4].Type, indigo.Replaced)
+	is.Equal(requests[4].ParentID, "rule1")
+
+	_, err = v.Rule("x")
+	is.True(errors.Is(err, indigo.ErrRuleNotFound)) // not added
+}
+
+// Test removing several rules at once, and releasing their shared programs
+func TestVaultRemoveRules(t *testing.T) {
+	is := is.New(t)
+
+	beta := indigo.RuleMetadata{Tags: []string{"beta"}}
+	root := &indigo.Rule{
+		ID:   "root",
+		Expr: "true",
+		Rules: map[string]*indigo.Rule{
+			"a": {ID: "a", Expr: "x > 1", Metadata: beta},
+			"b": {ID: "b", Expr: "b > 1", Metadata: beta, Rules: map[string]*indigo.Rule{
+				"b1": {ID: "b1", Expr: "b1 > 1", Metadata: beta},
+			}},
+			"c": {ID: "c", Expr: "x > 1"}, // shares the program of a
+			"d": {ID: "d", Expr: "d > 1"},
+			"e": {ID: "e", Expr: "e > 1"},
+		},
+	}
+
+	v, err := indigo.NewVault(indigo.NewEngine(newMockEvaluator()), root, indigo.SharePrograms(true))
+	is.NoErr(err)
+	st, err := v.Stats()
+	is.NoErr(err)
+	is.Equal(st.SharedPrograms, 6)
+
+	var removed []string
+	v.Subscribe(func(ev indigo.ChangeEvent) { removed = append(removed, ev.RuleID) })
+
+	ids, err := v.RemoveByTag("beta")
+	is.NoErr(err)
+	is.Equal(ids, []string{"a", "b", "b1"})
+	is.Equal(removed, []string{"a", "b"}) // b1 is removed with b
+	is.Equal(v.RuleCount(), 4)
+
+	st, err = v.Stats()
+	is.NoErr(err)
+	is.Equal(st.SharedPrograms, 4) // the program of a is still used by c
+
+	// Either all the rules are removed, or none is
+	err = v.RemoveRules("c", "nope")
+	is.True(errors.Is(err, indigo.ErrRuleNotFound))
+	is.Equal(v.RuleCount(), 4)
+
+	is.NoErr(v.RemoveRules("c", "d"))
+	is.Equal(v.RuleCount(), 2)
+	st, err = v.Stats()
+	is.NoErr(err)
+	is.Equal(st.SharedPrograms, 2)
+}
+
+// Test that the shared programs of the rules replaced are released
+func TestVaultReplacePrograms(t *testing.T) {
+	is := is.New(t)
+
+	e := indigo.NewEngine(newMockEvaluator())
+	root := &indigo.Rule{
+		ID:   "root",
+		Expr: "true",
+		Rules: map[string]*indigo.Rule{
+			"a": {ID: "a", Expr: "true", Rules: map[string]*indigo.Rule{
+				"a1": {ID: "a1", Expr: "a1 > 1"},
+				"a2": {ID: "a2", Expr: "a2 > 1"},
+			}},
+		},
+	}
+	v, err := indigo.NewVault(e, root, indigo.SharePrograms(true))
+	is.NoErr(err)
+
+	shared := func() int {
+		st, err := v.Stats()
+		is.NoErr(err)
+		return st.SharedPrograms
+	}
+	is.Equal(shared(), 3) // the root and a share a program
+
+	// Recompiling a rule does not count its programs twice
+	is.NoErr(e.Compile(root, indigo.SharePrograms(true)))
+
+	is.NoErr(v.Replace(&indigo.Rule{ID: "a", Expr: "true", Rules: map[string]*indigo.Rule{
+		"a1": {ID: "a1", Expr: "a1 > 2"},
+		"a3": {ID: "a3", Expr: "a3 > 1"},
+	}}))
+	is.Equal(shared(), 3)
+
+	// A replacement that is rejected releases its programs
+	err = v.Replace(&indigo.Rule{ID: "nope", Expr: "nope > 1"})
+	is.True(errors.Is(err, indigo.ErrRuleNotFound))
+	is.Equal(shared(), 3)
+
+	is.NoErr(v.Replace(&indigo.Rule{ID: "a", Expr: "a > 1"}))
+	is.Equal(shared(), 2)
+}
+
+// countingEvaluator counts the expressions compiled
+type countingEvaluator struct {
+	*mockEvaluator
+	compiled []string
+}
+
+func (c *countingEvaluator) Compile(expr string, s indigo.Schema, resultType indigo.Type, collectDiagnostics, dryRun bool) (interface{}, error) {
+	c.compiled = append(c.compiled, expr)
+	return c.mockEvaluator.Compile(expr, s, resultType, collectDiagnostics, dryRun)
+}
+
+// Test that updating a rule only compiles the rules that changed
+func TestVaultUpdate(t *testing.T) {
+	is := is.New(t)
+
+	makeTree := func() *indigo.Rule {
+		return &indigo.Rule{
+			ID:   "root",
+			Expr: "true",
+			Rules: map[string]*indigo.Rule{
+				"a": {ID: "a", Expr: "true"},
+				"b": {ID: "b", Expr: "true", Rules: map[string]*indigo.Rule{
+					"b1": {ID: "b1", Expr: "true"},
+					"b2": {ID: "b2", Expr: "false"},
+				}},
+			},
+		}
+	}
+
+	ev := &countingEvaluator{mockEvaluator: newMockEvaluator()}
+	v, err := indigo.NewVault(indigo.NewEngine(ev), makeTree())
+	is.NoErr(err)
+
+	root := makeTree()
+	root.Rules["a"].Metadata.Description = "not compiled"
+	root.Rules["b"].Rules["b1"].Expr = "false"
+	root.Rules["b"].Rules["b1"].Guard = "true"
+	delete(root.Rules["b"].Rules, "b2")
+	root.Rules["b"].Rules["b3"] = &indigo.Rule{ID: "b3", Expr: "true"}
+
+	ev.compiled = nil
+	changes, err := v.Update(root)
+	is.NoErr(err)
+	is.Equal(changes, &indigo.RuleChanges{Added: []string{"b3"}, Removed: []string{"b2"}, Changed: []string{"b1"}})
+	sort.Strings(ev.compiled)
+	is.Equal(ev.compiled, []string{"false", "true", "true"}) // b1's expression and guard, and b3
+
+	u, err := v.Eval(context.Background(), "root", map[string]interface{}{})
+	is.NoErr(err)
+	is.True(!u.Results["b"].Results["b1"].Pass)
+	is.True(u.Results["b"].Results["b3"].Pass)
+
+	// Without changes, nothing is compiled
+	ev.compiled = nil
+	changes, err = v.Update(root)
+	is.NoErr(err)
+	is.Equal(changes, &indigo.RuleChanges{})
+	is.Equal(len(ev.compiled), 0)
+
+	_, err = v.Update(&indigo.Rule{ID: "nope"})
+	is.True(errors.Is(err, indigo.ErrRuleNotFound))
+}
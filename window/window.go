@@ -0,0 +1,195 @@
+// Package window keeps a history of events in order to calculate aggregates,
+// such as counts, sums and distinct counts, over sliding time windows.
+//
+// Rules are stateless: a rule only sees the data passed to the evaluation.
+// Rules that need temporal state, such as "more than 10 logins for the user in
+// the last 5 minutes", can use a Store to keep that state. The application
+// records each event in the store before evaluating the rules, and the
+// expressions query the store, for example with the CEL functions
+// provided by cel.Windows:
+//
+//     store.Record("logins", userID, time.Now(), nil)
+//     ...
+//     rule.Expr = `window_count("logins", user_id, duration("5m")) > 10`
+package window
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store holds events for a number of series, each identified by
+// a name (such as "logins") and a key (such as a user ID).
+// Store is safe for concurrent use.
+type Store struct {
+	mu     sync.Mutex
+	maxAge time.Duration
+	now    func() time.Time
+	series map[seriesKey][]event
+}
+
+// seriesKey identifies a series of events
+type seriesKey struct {
+	name string
+	key  string
+}
+
+// event is a single recorded event
+type event struct {
+	t     time.Time
+	value interface{}
+}
+
+// Option is a functional option to specify the behavior of a store.
+type Option func(s *Store)
+
+// Clock specifies the function used to obtain the current time,
+// which is the end of every window.
+// Default: time.Now
+func Clock(now func() time.Time) Option {
+	return func(s *Store) {
+		s.now = now
+	}
+}
+
+// NewStore returns a store that keeps events for maxAge, which must be
+// at least as long as the longest window queried.
+func NewStore(maxAge time.Duration, opts ...Option) *Store {
+	s := &Store{
+		maxAge: maxAge,
+		now:    time.Now,
+		series: map[seriesKey][]event{},
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Record adds an event that happened at time t to the series with the name and key.
+// The value is used by Sum (if it is a number) and Distinct; it may be nil.
+// Events older than the store's maximum age are discarded.
+func (s *Store) Record(name, key string, t time.Time, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := seriesKey{name: name, key: key}
+	events := s.prune(k)
+
+	if t.Before(s.now().Add(-s.maxAge)) {
+		return
+	}
+
+	// Events usually arrive in order; insert late events in their place
+	i := sort.Search(len(events), func(i int) bool {
+		return events[i].t.After(t)
+	})
+	events = append(events, event{})
+	copy(events[i+1:], events[i:])
+	events[i] = event{t: t, value: value}
+	s.series[k] = events
+}
+
+// Count returns the number of events in the series with the name and key
+// that happened within the duration d before now.
+func (s *Store) Count(name, key string, d time.Duration) int {
+	n := 0
+	s.each(name, key, d, func(interface{}) {
+		n++
+	})
+	return n
+}
+
+// Sum returns the sum of the numeric values of the events in the series with
+// the name and key that happened within the duration d before now.
+// Values that are not numbers are ignored.
+func (s *Store) Sum(name, key string, d time.Duration) float64 {
+	sum := 0.0
+	s.each(name, key, d, func(v interface{}) {
+		if f, ok := toFloat(v); ok {
+			sum += f
+		}
+	})
+	return sum
+}
+
+// Distinct returns the number of distinct values of the events in the series
+// with the name and key that happened within the duration d before now.
+// Values are compared by their string representation.
+func (s *Store) Distinct(name, key string, d time.Duration) int {
+	seen := map[string]bool{}
+	s.each(name, key, d, func(v interface{}) {
+		seen[fmt.Sprint(v)] = true
+	})
+	return len(seen)
+}
+
+// Purge removes all events older than the store's maximum age,
+// and series without any events. Call it periodically if many series
+// stop receiving events.
+func (s *Store) Purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k := range s.series {
+		if len(s.prune(k)) == 0 {
+			delete(s.series, k)
+		}
+	}
+}
+
+// each calls f with the value of each event in the window
+func (s *Store) each(name, key string, d time.Duration, f func(v interface{})) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	start := now.Add(-d)
+	for _, e := range s.series[seriesKey{name: name, key: key}] {
+		if e.t.After(start) && !e.t.After(now) {
+			f(e.value)
+		}
+	}
+}
+
+// prune removes events older than the maximum age from the series,
+// returning the remaining events. The caller must hold the lock.
+func (s *Store) prune(k seriesKey) []event {
+	events := s.series[k]
+	cutoff := s.now().Add(-s.maxAge)
+	i := 0
+	for i < len(events) && events[i].t.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		events = append(events[:0], events[i:]...)
+		s.series[k] = events
+	}
+	return events
+}
+
+// toFloat converts a numeric value to a float64
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint:
+		return float64(x), true
+	case uint32:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case float32:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
@@ -0,0 +1,43 @@
+package window_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ezachrisen/indigo/window"
+	"github.com/matryer/is"
+)
+
+func TestStore(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := window.NewStore(10*time.Minute, window.Clock(func() time.Time { return now }))
+
+	s.Record("logins", "u1", now.Add(-1*time.Minute), "a")
+	s.Record("logins", "u1", now.Add(-6*time.Minute), "b")
+	s.Record("logins", "u1", now.Add(-3*time.Minute), "a")  // out of order
+	s.Record("logins", "u1", now.Add(-20*time.Minute), "c") // older than the maximum age
+	s.Record("logins", "u2", now, "a")
+
+	is.Equal(s.Count("logins", "u1", 5*time.Minute), 2)
+	is.Equal(s.Count("logins", "u1", time.Hour), 3)
+	is.Equal(s.Distinct("logins", "u1", 5*time.Minute), 1)
+	is.Equal(s.Distinct("logins", "u1", 10*time.Minute), 2)
+	is.Equal(s.Count("logins", "u2", time.Minute), 1)
+	is.Equal(s.Count("logins", "u3", time.Minute), 0)
+
+	s.Record("amounts", "u1", now, 10)
+	s.Record("amounts", "u1", now.Add(-time.Minute), 2.5)
+	s.Record("amounts", "u1", now.Add(-time.Minute), "not a number")
+	is.Equal(s.Sum("amounts", "u1", 5*time.Minute), 12.5)
+
+	// Move the clock forward; old events fall out of the windows
+	now = now.Add(5 * time.Minute)
+	is.Equal(s.Count("logins", "u1", 10*time.Minute), 2)
+	s.Purge()
+	is.Equal(s.Count("logins", "u1", time.Hour), 2)
+	now = now.Add(time.Hour)
+	s.Purge()
+	is.Equal(s.Count("logins", "u1", time.Hour), 0)
+}